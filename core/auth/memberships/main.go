@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"net/url"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 
@@ -696,6 +697,7 @@ func (s *Server) Start() error {
 		r.HandleFunc("/api/user/{username}", s.apiMemberOfHandler)
 		r.HandleFunc("/api/users", s.apiGetAllUsers).Methods(http.MethodGet)
 		r.HandleFunc("/api/users", s.apiCreateUser).Methods(http.MethodPost)
+		r.HandleFunc("/api/groups", s.apiGetAllGroups).Methods(http.MethodGet)
 		e <- http.ListenAndServe(fmt.Sprintf(":%d", *apiPort), r)
 	}()
 	return <-e
@@ -850,6 +852,7 @@ func (s *Server) createGroupHandler(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, redirectURL, http.StatusFound)
 		return
 	}
+	s.pingAllSyncAddresses()
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
@@ -973,6 +976,7 @@ func (s *Server) removeChildGroupHandler(w http.ResponseWriter, r *http.Request)
 		http.Redirect(w, r, redirectURL, http.StatusFound)
 		return
 	}
+	s.pingAllSyncAddresses()
 	http.Redirect(w, r, "/group/"+parentGroup, http.StatusSeeOther)
 }
 
@@ -1029,6 +1033,7 @@ func (s *Server) removeMemberFromGroupHandler(w http.ResponseWriter, r *http.Req
 		http.Redirect(w, r, redirectURL, http.StatusFound)
 		return
 	}
+	s.pingAllSyncAddresses()
 	http.Redirect(w, r, "/group/"+groupName, http.StatusSeeOther)
 }
 
@@ -1073,6 +1078,7 @@ func (s *Server) addUserToGroupHandler(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, redirectURL, http.StatusFound)
 		return
 	}
+	s.pingAllSyncAddresses()
 	http.Redirect(w, r, "/group/"+groupName, http.StatusSeeOther)
 }
 
@@ -1104,6 +1110,7 @@ func (s *Server) addChildGroupHandler(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, redirectURL, http.StatusFound)
 		return
 	}
+	s.pingAllSyncAddresses()
 	http.Redirect(w, r, "/group/"+parentGroup, http.StatusSeeOther)
 }
 
@@ -1288,6 +1295,54 @@ func (s *Server) apiGetAllUsers(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+type groupMembers struct {
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+}
+
+// apiGetAllGroups returns all groups together with their transitive members,
+// user belonging to the child group is a member of its parent groups as well.
+func (s *Server) apiGetAllGroups(w http.ResponseWriter, r *http.Request) {
+	s.addSyncAddress(r.FormValue("selfAddress"))
+	groups, err := s.store.GetAllGroups()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	members := make(map[string][]string)
+	for _, g := range groups {
+		members[g.Name] = []string{}
+	}
+	users, err := s.store.GetUsers(nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, u := range users {
+		transitiveGroups, err := s.store.GetAllTransitiveGroupsForUser(u.Username)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, g := range transitiveGroups {
+			members[g.Name] = append(members[g.Name], u.Username)
+		}
+	}
+	ret := make([]groupMembers, 0, len(members))
+	for name, m := range members {
+		sort.Strings(m)
+		ret = append(ret, groupMembers{name, m})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(ret); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
 type createUserRequest struct {
 	User  string `json:"user"`
 	Email string `json:"email"`
@@ -397,3 +397,63 @@ func TestFilterUsersByGroupHandler(t *testing.T) {
 		t.Errorf("handler returned unexpected body: got %v want %v", actual, expected)
 	}
 }
+
+func TestGetAllGroupsHandler(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewSQLiteStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`
+        INSERT INTO groups (name, description)
+        VALUES
+            ('family', 'xxx'),
+            ('kids', 'yyy'),
+            ('media-servers', 'zzz');
+        INSERT INTO group_to_group (child_group, parent_group)
+        VALUES
+            ('kids', 'family');
+        INSERT INTO user_to_group (username, group_name)
+        VALUES
+            ('u1', 'family'),
+            ('u2', 'kids');
+        INSERT INTO users (username, email)
+        VALUES
+            ('u1', 'u1@d.d'),
+            ('u2', 'u2@d.d');
+        `)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{
+		store:         store,
+		syncAddresses: make(map[string]struct{}),
+		mu:            sync.Mutex{},
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/api/groups", server.apiGetAllGroups).Methods(http.MethodGet)
+	req, err := http.NewRequest("GET", "/api/groups?selfAddress=http://headscale-api/sync-users", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	expected := []groupMembers{
+		{"family", []string{"u1", "u2"}},
+		{"kids", []string{"u2"}},
+		{"media-servers", []string{}},
+	}
+	var actual []groupMembers
+	if err := json.NewDecoder(rr.Body).Decode(&actual); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("handler returned unexpected body: got %v want %v", actual, expected)
+	}
+	if _, ok := server.syncAddresses["http://headscale-api/sync-users"]; !ok {
+		t.Error("expected sync address to be registered")
+	}
+}
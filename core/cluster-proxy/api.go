@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+type apiServer struct {
+	s      *http.Server
+	r      *http.ServeMux
+	router *router
+	health *healthChecker
+}
+
+func newAPIServer(port int, router *router, health *healthChecker) *apiServer {
+	r := http.NewServeMux()
+	s := &http.Server{
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: r,
+	}
+	return &apiServer{s, r, router, health}
+}
+
+func (s *apiServer) Start() error {
+	s.registerHandlers()
+	if err := s.s.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func (s *apiServer) Close() error {
+	return s.s.Close()
+}
+
+func (s *apiServer) registerHandlers() {
+	s.r.HandleFunc("GET /api/routes", s.handleRoutes)
+	s.r.HandleFunc("GET /api/health", s.handleHealth)
+	s.r.HandleFunc("POST /api/clusters/{name}/ips", s.handleClusterIPAdd)
+	s.r.HandleFunc("DELETE /api/clusters/{name}/ips/{ip}", s.handleClusterIPRemove)
+	s.r.HandleFunc("POST /api/http-routes", s.handleHTTPRouteAdd)
+	s.r.HandleFunc("DELETE /api/http-routes/{host}", s.handleHTTPRouteRemove)
+	s.r.HandleFunc("POST /api/stream-routes", s.handleStreamRouteAdd)
+	s.r.HandleFunc("DELETE /api/stream-routes/{protocol}/{port}", s.handleStreamRouteRemove)
+}
+
+func (s *apiServer) handleRoutes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.router.Routes()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (s *apiServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.health.Status()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+type clusterIPReq struct {
+	IP string `json:"ip"`
+}
+
+func (s *apiServer) handleClusterIPAdd(w http.ResponseWriter, r *http.Request) {
+	var req clusterIPReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ip := net.ParseIP(req.IP)
+	if ip == nil {
+		http.Error(w, fmt.Sprintf("invalid ip: %s", req.IP), http.StatusBadRequest)
+		return
+	}
+	if err := s.router.AddClusterIP(r.PathValue("name"), ip); err != nil {
+		writeError(w, err)
+		return
+	}
+	// NOTE(gio): Check new IP right away, so that broken one does not get
+	// any traffic until next periodic check.
+	go s.health.Check([]net.IP{ip})
+}
+
+func (s *apiServer) handleClusterIPRemove(w http.ResponseWriter, r *http.Request) {
+	ip := net.ParseIP(r.PathValue("ip"))
+	if ip == nil {
+		http.Error(w, fmt.Sprintf("invalid ip: %s", r.PathValue("ip")), http.StatusBadRequest)
+		return
+	}
+	if err := s.router.RemoveClusterIP(r.PathValue("name"), ip); err != nil {
+		writeError(w, err)
+		return
+	}
+}
+
+func (s *apiServer) handleHTTPRouteAdd(w http.ResponseWriter, r *http.Request) {
+	var req HTTPRoute
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.router.AddHTTPRoute(req); err != nil {
+		writeError(w, err)
+		return
+	}
+}
+
+func (s *apiServer) handleHTTPRouteRemove(w http.ResponseWriter, r *http.Request) {
+	if err := s.router.RemoveHTTPRoute(r.PathValue("host")); err != nil {
+		writeError(w, err)
+		return
+	}
+}
+
+func (s *apiServer) handleStreamRouteAdd(w http.ResponseWriter, r *http.Request) {
+	var req StreamRoute
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.router.AddStreamRoute(req); err != nil {
+		writeError(w, err)
+		return
+	}
+}
+
+func (s *apiServer) handleStreamRouteRemove(w http.ResponseWriter, r *http.Request) {
+	port, err := strconv.Atoi(r.PathValue("port"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.router.RemoveStreamRoute(r.PathValue("protocol"), port); err != nil {
+		writeError(w, err)
+		return
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrorNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrorConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
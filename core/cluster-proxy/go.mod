@@ -0,0 +1,3 @@
+module github.com/giolekva/pcloud/core/cluster-proxy
+
+go 1.22.0
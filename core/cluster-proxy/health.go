@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// healthChecker considers ingress IP healthy if it accepts TCP connections on
+// the given port. IPs which were never checked are assumed to be healthy.
+type healthChecker struct {
+	l       sync.Locker
+	port    int
+	timeout time.Duration
+	healthy map[string]bool
+}
+
+func newHealthChecker(port int, timeout time.Duration) *healthChecker {
+	return &healthChecker{
+		l:       &sync.Mutex{},
+		port:    port,
+		timeout: timeout,
+		healthy: map[string]bool{},
+	}
+}
+
+func (h *healthChecker) IsHealthy(ip net.IP) bool {
+	h.l.Lock()
+	defer h.l.Unlock()
+	healthy, ok := h.healthy[ip.String()]
+	return !ok || healthy
+}
+
+func (h *healthChecker) Set(ip net.IP, healthy bool) {
+	h.l.Lock()
+	defer h.l.Unlock()
+	if prev, ok := h.healthy[ip.String()]; !ok || prev != healthy {
+		log.Printf("%s: healthy=%t\n", ip, healthy)
+	}
+	h.healthy[ip.String()] = healthy
+}
+
+func (h *healthChecker) Check(ips []net.IP) {
+	var wg sync.WaitGroup
+	for _, ip := range ips {
+		wg.Add(1)
+		go func(ip net.IP) {
+			defer wg.Done()
+			conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip.String(), fmt.Sprint(h.port)), h.timeout)
+			if err == nil {
+				conn.Close()
+			}
+			h.Set(ip, err == nil)
+		}(ip)
+	}
+	wg.Wait()
+}
+
+// Status returns health of all checked IPs.
+func (h *healthChecker) Status() map[string]bool {
+	h.l.Lock()
+	defer h.l.Unlock()
+	ret := make(map[string]bool, len(h.healthy))
+	for ip, healthy := range h.healthy {
+		ret[ip] = healthy
+	}
+	return ret
+}
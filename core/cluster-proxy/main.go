@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+var port = flag.Int("port", 8080, "Port to listen on for proxied HTTP traffic")
+var apiPort = flag.Int("api-port", 8081, "Port to listen on for API requests")
+var storePath = flag.String("store", "/data/routes.json", "Path to the file where routes are persisted")
+var healthCheckInterval = flag.Duration("health-check-interval", 10*time.Second, "How often to check health of cluster ingress IPs")
+var ingressHTTPPort = flag.Int("ingress-http-port", 80, "Port HTTP requests are sent to on cluster ingress IPs")
+var healthCheckPort = flag.Int("health-check-port", 80, "Port on cluster ingress IPs used to check their health")
+var dialTimeout = flag.Duration("dial-timeout", 5*time.Second, "Timeout for connecting to cluster ingress IPs")
+
+func main() {
+	flag.Parse()
+	health := newHealthChecker(*healthCheckPort, *dialTimeout)
+	r, err := newRouter(NewFileRouteStore(*storePath), health, *ingressHTTPPort, *dialTimeout)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		for {
+			health.Check(r.IngressIPs())
+			time.Sleep(*healthCheckInterval)
+		}
+	}()
+	go func() {
+		s := &http.Server{
+			Addr:    fmt.Sprintf(":%d", *port),
+			Handler: r,
+		}
+		log.Fatal(s.ListenAndServe())
+	}()
+	log.Fatal(newAPIServer(*apiPort, r, health).Start())
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTestRouter(t *testing.T, store RouteStore, httpPort int) (*router, *healthChecker) {
+	health := newHealthChecker(httpPort, time.Second)
+	r, err := newRouter(store, health, httpPort, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, health
+}
+
+func newUpstream(t *testing.T) (*httptest.Server, int) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Host)
+	}))
+	t.Cleanup(s.Close)
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, port
+}
+
+func get(t *testing.T, h http.Handler, host string) (int, string) {
+	req := httptest.NewRequest("GET", "http://"+host+"/", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	body, err := io.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rec.Code, string(body)
+}
+
+func TestFileRouteStore(t *testing.T) {
+	store := NewFileRouteStore(filepath.Join(t.TempDir(), "routes.json"))
+	routes, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(routes, Routes{}) {
+		t.Fatalf("expected empty routes, got %+v", routes)
+	}
+	expected := Routes{
+		Clusters: []Cluster{{"foo", []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")}}},
+		HTTP:     []HTTPRoute{{"app.example.com", "foo", "app.foo.cluster.example.com"}},
+		Stream:   []StreamRoute{{"tcp", 2222, "foo"}},
+	}
+	if err := store.Save(expected); err != nil {
+		t.Fatal(err)
+	}
+	routes, err = store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(routes, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, routes)
+	}
+}
+
+func TestHTTPRouteFallsBackToHealthyIP(t *testing.T) {
+	_, port := newUpstream(t)
+	store := NewFileRouteStore(filepath.Join(t.TempDir(), "routes.json"))
+	r, health := newTestRouter(t, store, port)
+	// Nothing listens on 127.0.0.2, so requests have to end up on 127.0.0.1.
+	for _, ip := range []string{"127.0.0.2", "127.0.0.1"} {
+		if err := r.AddClusterIP("foo", net.ParseIP(ip)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.AddHTTPRoute(HTTPRoute{"app.example.com", "foo", "app.foo.cluster.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if code, body := get(t, r, "app.example.com"); code != http.StatusOK || body != "app.foo.cluster.example.com" {
+		t.Fatalf("unexpected response: %d %s", code, body)
+	}
+	if health.IsHealthy(net.ParseIP("127.0.0.2")) {
+		t.Fatal("expected 127.0.0.2 to be marked unhealthy")
+	}
+	health.Check(r.IngressIPs())
+	expected := map[string]bool{"127.0.0.1": true, "127.0.0.2": false}
+	if status := health.Status(); !reflect.DeepEqual(status, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, status)
+	}
+	if code, _ := get(t, r, "unknown.example.com"); code != http.StatusNotFound {
+		t.Fatalf("expected not found, got %d", code)
+	}
+	// Routes must survive restart.
+	restarted, _ := newTestRouter(t, store, port)
+	if code, body := get(t, restarted, "app.example.com"); code != http.StatusOK || body != "app.foo.cluster.example.com" {
+		t.Fatalf("unexpected response after restart: %d %s", code, body)
+	}
+}
+
+func TestAPI(t *testing.T) {
+	_, port := newUpstream(t)
+	store := NewFileRouteStore(filepath.Join(t.TempDir(), "routes.json"))
+	r, health := newTestRouter(t, store, port)
+	s := newAPIServer(0, r, health)
+	s.registerHandlers()
+	do := func(method, path string, body any) int {
+		var buf bytes.Buffer
+		if body != nil {
+			if err := json.NewEncoder(&buf).Encode(body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		rec := httptest.NewRecorder()
+		s.r.ServeHTTP(rec, httptest.NewRequest(method, path, &buf))
+		return rec.Code
+	}
+	if code := do("POST", "/api/http-routes", HTTPRoute{"app.example.com", "foo", "app.foo"}); code != http.StatusNotFound {
+		t.Fatalf("expected unknown cluster to be rejected, got %d", code)
+	}
+	if code := do("POST", "/api/clusters/foo/ips", clusterIPReq{"127.0.0.1"}); code != http.StatusOK {
+		t.Fatalf("adding cluster ip failed: %d", code)
+	}
+	if code := do("POST", "/api/http-routes", HTTPRoute{"app.example.com", "foo", "app.foo"}); code != http.StatusOK {
+		t.Fatalf("adding http route failed: %d", code)
+	}
+	if code := do("POST", "/api/http-routes", HTTPRoute{"app.example.com", "foo", "other.foo"}); code != http.StatusConflict {
+		t.Fatalf("expected conflict, got %d", code)
+	}
+	if code, body := get(t, r, "app.example.com"); code != http.StatusOK || body != "app.foo" {
+		t.Fatalf("unexpected response: %d %s", code, body)
+	}
+	if code := do("DELETE", "/api/clusters/foo/ips/127.0.0.1", nil); code != http.StatusConflict {
+		t.Fatalf("expected cluster in use to be kept, got %d", code)
+	}
+	if code := do("DELETE", "/api/http-routes/app.example.com", nil); code != http.StatusOK {
+		t.Fatalf("removing http route failed: %d", code)
+	}
+	if code, _ := get(t, r, "app.example.com"); code != http.StatusNotFound {
+		t.Fatalf("expected removed route to be gone, got %d", code)
+	}
+	if code := do("DELETE", "/api/clusters/foo/ips/127.0.0.1", nil); code != http.StatusOK {
+		t.Fatalf("removing cluster ip failed: %d", code)
+	}
+	if routes := r.Routes(); len(routes.Clusters) != 0 || len(routes.HTTP) != 0 {
+		t.Fatalf("expected no routes, got %+v", routes)
+	}
+}
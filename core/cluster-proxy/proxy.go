@@ -0,0 +1,479 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const udpIdleTimeout = 2 * time.Minute
+
+var (
+	ErrorNotFound = errors.New("not found")
+	ErrorConflict = errors.New("conflict")
+)
+
+type routeKey struct{}
+
+// router proxies HTTP requests and streams according to the routes it keeps
+// in the store. Routes are applied right away, without restarting anything.
+type router struct {
+	l           sync.Locker
+	store       RouteStore
+	routes      Routes
+	health      *healthChecker
+	httpPort    int
+	dialTimeout time.Duration
+	streams     map[string]io.Closer
+	proxy       *httputil.ReverseProxy
+}
+
+func newRouter(store RouteStore, health *healthChecker, httpPort int, dialTimeout time.Duration) (*router, error) {
+	routes, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	r := &router{
+		l:           &sync.Mutex{},
+		store:       store,
+		routes:      routes,
+		health:      health,
+		httpPort:    httpPort,
+		dialTimeout: dialTimeout,
+		streams:     map[string]io.Closer{},
+	}
+	r.proxy = &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			route := pr.In.Context().Value(routeKey{}).(HTTPRoute)
+			pr.SetXForwarded()
+			// NOTE(gio): Host of the outgoing URL only names the cluster,
+			// actual address is picked when dialing it.
+			pr.Out.URL.Scheme = "http"
+			pr.Out.URL.Host = net.JoinHostPort(route.Cluster, strconv.Itoa(r.httpPort))
+			pr.Out.Host = route.Upstream
+		},
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				cluster, port, err := net.SplitHostPort(addr)
+				if err != nil {
+					return nil, err
+				}
+				p, err := strconv.Atoi(port)
+				if err != nil {
+					return nil, err
+				}
+				return r.dialCluster(ctx, network, cluster, p)
+			},
+			MaxIdleConnsPerHost: 16,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+	for _, s := range routes.Stream {
+		if err := r.startStream(s); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (r *router) Routes() Routes {
+	r.l.Lock()
+	defer r.l.Unlock()
+	return r.routes
+}
+
+// IngressIPs returns ingress IPs of all known clusters.
+func (r *router) IngressIPs() []net.IP {
+	r.l.Lock()
+	defer r.l.Unlock()
+	ret := []net.IP{}
+	for _, c := range r.routes.Clusters {
+		ret = append(ret, c.IngressIPs...)
+	}
+	return ret
+}
+
+func (r *router) AddClusterIP(name string, ip net.IP) error {
+	r.l.Lock()
+	defer r.l.Unlock()
+	routes := r.copyRoutes()
+	i := findCluster(routes.Clusters, name)
+	if i == -1 {
+		routes.Clusters = append(routes.Clusters, Cluster{Name: name})
+		i = len(routes.Clusters) - 1
+	}
+	for _, j := range routes.Clusters[i].IngressIPs {
+		if j.Equal(ip) {
+			return nil
+		}
+	}
+	routes.Clusters[i].IngressIPs = append(routes.Clusters[i].IngressIPs, ip)
+	return r.save(routes)
+}
+
+// RemoveClusterIP forgets cluster altogether once its last ingress IP is
+// removed, given no routes point to it anymore.
+func (r *router) RemoveClusterIP(name string, ip net.IP) error {
+	r.l.Lock()
+	defer r.l.Unlock()
+	routes := r.copyRoutes()
+	i := findCluster(routes.Clusters, name)
+	if i == -1 {
+		return fmt.Errorf("cluster %s: %w", name, ErrorNotFound)
+	}
+	ips := []net.IP{}
+	for _, j := range routes.Clusters[i].IngressIPs {
+		if !j.Equal(ip) {
+			ips = append(ips, j)
+		}
+	}
+	if len(ips) == len(routes.Clusters[i].IngressIPs) {
+		return fmt.Errorf("cluster %s ingress ip %s: %w", name, ip, ErrorNotFound)
+	}
+	if len(ips) > 0 {
+		routes.Clusters[i].IngressIPs = ips
+		return r.save(routes)
+	}
+	for _, h := range routes.HTTP {
+		if h.Cluster == name {
+			return fmt.Errorf("cluster %s is still used by %s: %w", name, h.Host, ErrorConflict)
+		}
+	}
+	for _, s := range routes.Stream {
+		if s.Cluster == name {
+			return fmt.Errorf("cluster %s is still used by %s port %d: %w", name, s.Protocol, s.Port, ErrorConflict)
+		}
+	}
+	routes.Clusters = append(routes.Clusters[:i], routes.Clusters[i+1:]...)
+	return r.save(routes)
+}
+
+func (r *router) AddHTTPRoute(route HTTPRoute) error {
+	r.l.Lock()
+	defer r.l.Unlock()
+	route.Host = strings.ToLower(route.Host)
+	if route.Host == "" || route.Upstream == "" {
+		return fmt.Errorf("host and upstream are required")
+	}
+	if findCluster(r.routes.Clusters, route.Cluster) == -1 {
+		return fmt.Errorf("cluster %s: %w", route.Cluster, ErrorNotFound)
+	}
+	routes := r.copyRoutes()
+	for _, h := range routes.HTTP {
+		if h.Host == route.Host {
+			if h == route {
+				return nil
+			}
+			return fmt.Errorf("%s is already routed to %s: %w", route.Host, h.Upstream, ErrorConflict)
+		}
+	}
+	routes.HTTP = append(routes.HTTP, route)
+	return r.save(routes)
+}
+
+func (r *router) RemoveHTTPRoute(host string) error {
+	r.l.Lock()
+	defer r.l.Unlock()
+	host = strings.ToLower(host)
+	routes := r.copyRoutes()
+	for i, h := range routes.HTTP {
+		if h.Host == host {
+			routes.HTTP = append(routes.HTTP[:i], routes.HTTP[i+1:]...)
+			return r.save(routes)
+		}
+	}
+	return fmt.Errorf("%s: %w", host, ErrorNotFound)
+}
+
+func (r *router) AddStreamRoute(route StreamRoute) error {
+	r.l.Lock()
+	defer r.l.Unlock()
+	route.Protocol = strings.ToLower(route.Protocol)
+	if route.Protocol != "tcp" && route.Protocol != "udp" {
+		return fmt.Errorf("invalid protocol: %s", route.Protocol)
+	}
+	if findCluster(r.routes.Clusters, route.Cluster) == -1 {
+		return fmt.Errorf("cluster %s: %w", route.Cluster, ErrorNotFound)
+	}
+	routes := r.copyRoutes()
+	for _, s := range routes.Stream {
+		if s.Protocol == route.Protocol && s.Port == route.Port {
+			if s == route {
+				return nil
+			}
+			return fmt.Errorf("%s port %d is already routed to %s: %w", s.Protocol, s.Port, s.Cluster, ErrorConflict)
+		}
+	}
+	if err := r.startStream(route); err != nil {
+		return err
+	}
+	routes.Stream = append(routes.Stream, route)
+	if err := r.save(routes); err != nil {
+		r.stopStream(route.Protocol, route.Port)
+		return err
+	}
+	return nil
+}
+
+func (r *router) RemoveStreamRoute(protocol string, port int) error {
+	r.l.Lock()
+	defer r.l.Unlock()
+	protocol = strings.ToLower(protocol)
+	routes := r.copyRoutes()
+	for i, s := range routes.Stream {
+		if s.Protocol == protocol && s.Port == port {
+			routes.Stream = append(routes.Stream[:i], routes.Stream[i+1:]...)
+			if err := r.save(routes); err != nil {
+				return err
+			}
+			r.stopStream(protocol, port)
+			return nil
+		}
+	}
+	return fmt.Errorf("%s port %d: %w", protocol, port, ErrorNotFound)
+}
+
+func (r *router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	route, ok := r.findHTTPRoute(strings.ToLower(host))
+	if !ok {
+		http.Error(w, "unknown host", http.StatusNotFound)
+		return
+	}
+	r.proxy.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), routeKey{}, route)))
+}
+
+func (r *router) findHTTPRoute(host string) (HTTPRoute, bool) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	for _, h := range r.routes.HTTP {
+		if h.Host == host {
+			return h, true
+		}
+	}
+	return HTTPRoute{}, false
+}
+
+// dialCluster tries ingress IPs of the cluster one by one, healthy ones first,
+// and returns the first connection which succeeds.
+func (r *router) dialCluster(ctx context.Context, network, cluster string, port int) (net.Conn, error) {
+	var ips []net.IP
+	func() {
+		r.l.Lock()
+		defer r.l.Unlock()
+		if i := findCluster(r.routes.Clusters, cluster); i != -1 {
+			ips = append(ips, r.routes.Clusters[i].IngressIPs...)
+		}
+	}()
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("cluster %s: %w", cluster, ErrorNotFound)
+	}
+	sort.SliceStable(ips, func(i, j int) bool {
+		return r.health.IsHealthy(ips[i]) && !r.health.IsHealthy(ips[j])
+	})
+	d := net.Dialer{Timeout: r.dialTimeout}
+	var errs []error
+	for _, ip := range ips {
+		conn, err := d.DialContext(ctx, network, net.JoinHostPort(ip.String(), strconv.Itoa(port)))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+		// NOTE(gio): Dialing UDP never fails for unreachable hosts, so only
+		// TCP failures say anything about health of the IP.
+		if strings.HasPrefix(network, "tcp") {
+			r.health.Set(ip, false)
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Expects router state to be locked by caller.
+func (r *router) copyRoutes() Routes {
+	ret := Routes{
+		Clusters: make([]Cluster, 0, len(r.routes.Clusters)),
+		HTTP:     append([]HTTPRoute{}, r.routes.HTTP...),
+		Stream:   append([]StreamRoute{}, r.routes.Stream...),
+	}
+	for _, c := range r.routes.Clusters {
+		ret.Clusters = append(ret.Clusters, Cluster{c.Name, append([]net.IP{}, c.IngressIPs...)})
+	}
+	return ret
+}
+
+// Expects router state to be locked by caller.
+func (r *router) save(routes Routes) error {
+	if err := r.store.Save(routes); err != nil {
+		return err
+	}
+	r.routes = routes
+	return nil
+}
+
+func streamKey(protocol string, port int) string {
+	return fmt.Sprintf("%s/%d", protocol, port)
+}
+
+// Expects router state to be locked by caller.
+func (r *router) startStream(route StreamRoute) error {
+	addr := fmt.Sprintf(":%d", route.Port)
+	switch route.Protocol {
+	case "tcp":
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		r.streams[streamKey(route.Protocol, route.Port)] = l
+		go r.serveTCP(l, route)
+	case "udp":
+		pc, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return err
+		}
+		p := &udpProxy{
+			l:        &sync.Mutex{},
+			pc:       pc,
+			r:        r,
+			route:    route,
+			sessions: map[string]net.Conn{},
+		}
+		r.streams[streamKey(route.Protocol, route.Port)] = p
+		go p.serve()
+	default:
+		return fmt.Errorf("invalid protocol: %s", route.Protocol)
+	}
+	return nil
+}
+
+// Expects router state to be locked by caller.
+func (r *router) stopStream(protocol string, port int) {
+	key := streamKey(protocol, port)
+	if s, ok := r.streams[key]; ok {
+		s.Close()
+		delete(r.streams, key)
+	}
+}
+
+func (r *router) serveTCP(l net.Listener, route StreamRoute) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			upstream, err := r.dialCluster(context.Background(), "tcp", route.Cluster, route.Port)
+			if err != nil {
+				log.Printf("tcp port %d: %s\n", route.Port, err)
+				return
+			}
+			defer upstream.Close()
+			done := make(chan struct{}, 2)
+			go func() {
+				io.Copy(upstream, conn)
+				done <- struct{}{}
+			}()
+			go func() {
+				io.Copy(conn, upstream)
+				done <- struct{}{}
+			}()
+			<-done
+		}()
+	}
+}
+
+// udpProxy keeps separate upstream connection for every client, so that
+// replies can be sent back to the right one.
+type udpProxy struct {
+	l        sync.Locker
+	pc       net.PacketConn
+	r        *router
+	route    StreamRoute
+	sessions map[string]net.Conn
+}
+
+func (p *udpProxy) serve() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, addr, err := p.pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		upstream, err := p.session(addr)
+		if err != nil {
+			log.Printf("udp port %d: %s\n", p.route.Port, err)
+			continue
+		}
+		if _, err := upstream.Write(buf[:n]); err != nil {
+			log.Printf("udp port %d: %s\n", p.route.Port, err)
+		}
+	}
+}
+
+func (p *udpProxy) session(addr net.Addr) (net.Conn, error) {
+	p.l.Lock()
+	defer p.l.Unlock()
+	if s, ok := p.sessions[addr.String()]; ok {
+		return s, nil
+	}
+	upstream, err := p.r.dialCluster(context.Background(), "udp", p.route.Cluster, p.route.Port)
+	if err != nil {
+		return nil, err
+	}
+	p.sessions[addr.String()] = upstream
+	go p.reply(addr, upstream)
+	return upstream, nil
+}
+
+// reply relays datagrams from upstream back to the client until session is
+// idle for too long.
+func (p *udpProxy) reply(addr net.Addr, upstream net.Conn) {
+	defer func() {
+		p.l.Lock()
+		defer p.l.Unlock()
+		upstream.Close()
+		delete(p.sessions, addr.String())
+	}()
+	buf := make([]byte, 64*1024)
+	for {
+		upstream.SetReadDeadline(time.Now().Add(udpIdleTimeout))
+		n, err := upstream.Read(buf)
+		if err != nil {
+			return
+		}
+		if _, err := p.pc.WriteTo(buf[:n], addr); err != nil {
+			return
+		}
+	}
+}
+
+func (p *udpProxy) Close() error {
+	p.l.Lock()
+	defer p.l.Unlock()
+	for _, s := range p.sessions {
+		s.Close()
+	}
+	return p.pc.Close()
+}
+
+func findCluster(clusters []Cluster, name string) int {
+	for i, c := range clusters {
+		if c.Name == name {
+			return i
+		}
+	}
+	return -1
+}
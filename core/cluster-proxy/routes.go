@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// Cluster is reachable through any of its ingress IPs, which are tried in
+// order until a healthy one is found.
+type Cluster struct {
+	Name       string   `json:"name"`
+	IngressIPs []net.IP `json:"ingressIPs"`
+}
+
+// HTTPRoute sends requests for Host to the ingress of Cluster, rewriting Host
+// header to Upstream.
+type HTTPRoute struct {
+	Host     string `json:"host"`
+	Cluster  string `json:"cluster"`
+	Upstream string `json:"upstream"`
+}
+
+// StreamRoute passes TCP connections or UDP datagrams received on Port to the
+// same port on the ingress of Cluster.
+type StreamRoute struct {
+	Protocol string `json:"protocol"`
+	Port     int    `json:"port"`
+	Cluster  string `json:"cluster"`
+}
+
+type Routes struct {
+	Clusters []Cluster     `json:"clusters"`
+	HTTP     []HTTPRoute   `json:"http"`
+	Stream   []StreamRoute `json:"stream"`
+}
+
+type RouteStore interface {
+	Load() (Routes, error)
+	Save(routes Routes) error
+}
+
+type fileRouteStore struct {
+	path string
+}
+
+func NewFileRouteStore(path string) RouteStore {
+	return &fileRouteStore{path}
+}
+
+func (s *fileRouteStore) Load() (Routes, error) {
+	f, err := os.Open(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Routes{}, nil
+	} else if err != nil {
+		return Routes{}, err
+	}
+	defer f.Close()
+	var ret Routes
+	if err := json.NewDecoder(f).Decode(&ret); err != nil {
+		return Routes{}, err
+	}
+	return ret, nil
+}
+
+// Save replaces routes atomically, so that crash in the middle of it never
+// leaves store corrupted.
+func (s *fileRouteStore) Save(routes Routes) error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".routes-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := json.NewEncoder(tmp).Encode(routes); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
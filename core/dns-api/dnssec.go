@@ -0,0 +1,412 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	keysFile       = "dns-sec-keys.json"
+	legacyKeysFile = "dns-sec-key.json"
+)
+
+const (
+	RoleKSK = "ksk"
+	RoleZSK = "zsk"
+)
+
+const (
+	// Key is part of the DNSKEY set while resolvers learn about it, old key
+	// is still used as well.
+	KeyStatePublished = "published"
+	// Key signs the zone, DNSKEY set only in case of KSK.
+	KeyStateActive = "active"
+	// Key is replaced by the new one but is kept in the DNSKEY set until
+	// signatures made with it expire from caches.
+	KeyStateRetired = "retired"
+)
+
+// KeyPolicy controls how often keys are rolled over and how long each step
+// of the rollover takes.
+type KeyPolicy struct {
+	ZSKLifetime time.Duration
+	KSKLifetime time.Duration
+	// PublishDelay must be longer than DNSKEY TTL plus maximum TTL of the
+	// zone records, so that resolvers have seen new DNSKEY set before any
+	// signature made with the new key, and stop using signatures made with
+	// the retired key before it is removed.
+	PublishDelay time.Duration
+	// DSDelay is the time it takes for the old DS to expire from caches once
+	// the new one is seen in the parent zone.
+	DSDelay time.Duration
+}
+
+// DSLookup returns DS records of the zone as published in its parent zone.
+type DSLookup func(zone string) ([]*dns.DS, error)
+
+type ManagedKey struct {
+	DNSSecKey
+	Role      string    `json:"role"`
+	State     string    `json:"state"`
+	Created   time.Time `json:"created"`
+	Activated time.Time `json:"activated,omitempty"`
+	Retired   time.Time `json:"retired,omitempty"`
+	// DSPublished is when DS of the KSK was first seen in the parent zone.
+	DSPublished time.Time `json:"dsPublished,omitempty"`
+}
+
+// KeyManager rolls zone signing keys over using pre-publish method and key
+// signing keys using double signature method, as described in RFC 6781. Old
+// KSK keeps signing until DS of the new one shows up in the parent zone,
+// which is only checked once DS lookup is configured.
+type KeyManager struct {
+	l        sync.Locker
+	fs       FS
+	zone     string
+	policy   KeyPolicy
+	keys     []ManagedKey
+	onChange func(keys []ManagedKey) error
+	lookupDS DSLookup
+}
+
+func NewKeyManager(fs FS, zone string, policy KeyPolicy) (*KeyManager, error) {
+	ret := &KeyManager{
+		l:      &sync.Mutex{},
+		fs:     fs,
+		zone:   zone,
+		policy: policy,
+		keys:   []ManagedKey{},
+	}
+	if ok, err := fs.Exists(keysFile); err != nil {
+		return nil, err
+	} else if ok {
+		d, err := fs.Read(keysFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(d), &ret.keys); err != nil {
+			return nil, err
+		}
+	} else if ok, err := fs.Exists(legacyKeysFile); err != nil {
+		return nil, err
+	} else if ok {
+		// NOTE(gio): Single key generated by older versions signs the whole
+		// zone, it becomes the KSK and ZSK gets introduced by the next step.
+		d, err := fs.Read(legacyKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		var k DNSSecKey
+		if err := json.Unmarshal([]byte(d), &k); err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		ret.keys = append(ret.keys, ManagedKey{k, RoleKSK, KeyStateActive, now, now, time.Time{}, time.Time{}})
+	}
+	if _, err := ret.step(time.Now()); err != nil {
+		return nil, err
+	}
+	if err := ret.save(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// OnChange registers function called with the new set of keys every time it
+// changes.
+func (m *KeyManager) OnChange(fn func(keys []ManagedKey) error) {
+	m.l.Lock()
+	defer m.l.Unlock()
+	m.onChange = fn
+}
+
+// SetDSLookup registers function used to check whether DS of the new KSK
+// got published in the parent zone.
+func (m *KeyManager) SetDSLookup(fn DSLookup) {
+	m.l.Lock()
+	defer m.l.Unlock()
+	m.lookupDS = fn
+}
+
+func (m *KeyManager) Keys() []ManagedKey {
+	m.l.Lock()
+	defer m.l.Unlock()
+	return append([]ManagedKey{}, m.keys...)
+}
+
+// DS returns DS records of the key signing keys in use, all of them must be
+// published in the parent zone.
+func (m *KeyManager) DS() []string {
+	m.l.Lock()
+	defer m.l.Unlock()
+	ret := []string{}
+	for _, k := range m.keys {
+		if k.Role == RoleKSK && k.State == KeyStateActive {
+			ret = append(ret, string(k.DS))
+		}
+	}
+	return ret
+}
+
+// Step advances rollovers in progress and starts new ones when keys reach
+// the end of their lifetime.
+func (m *KeyManager) Step(now time.Time) error {
+	m.l.Lock()
+	defer m.l.Unlock()
+	changed, err := m.step(now)
+	if err != nil || !changed {
+		return err
+	}
+	return m.commit()
+}
+
+// Rollover starts rollover of the key with given role right away, regardless
+// of its age, for example when key was compromised.
+func (m *KeyManager) Rollover(role string, now time.Time) error {
+	m.l.Lock()
+	defer m.l.Unlock()
+	switch role {
+	case RoleZSK:
+		if m.find(RoleZSK, KeyStatePublished) != -1 {
+			return fmt.Errorf("zsk rollover is already in progress: %w", ErrorConflict)
+		}
+		if err := m.addKey(RoleZSK, KeyStatePublished, now); err != nil {
+			return err
+		}
+	case RoleKSK:
+		if m.count(RoleKSK, KeyStateActive) > 1 {
+			return fmt.Errorf("ksk rollover is already in progress: %w", ErrorConflict)
+		}
+		if err := m.addKey(RoleKSK, KeyStateActive, now); err != nil {
+			return err
+		}
+	default:
+		return invalidf("unknown key role: %s", role)
+	}
+	return m.commit()
+}
+
+// Expects manager state to be locked by caller.
+func (m *KeyManager) step(now time.Time) (bool, error) {
+	changed := false
+	// Keys of the new zone are activated right away, as there is nothing
+	// cached to roll over from.
+	fresh := len(m.keys) == 0
+	// KSK: new key signs DNSKEY set together with the old one until new DS
+	// is seen in the parent zone and old one expires from caches.
+	if m.count(RoleKSK, KeyStateActive) == 0 {
+		if err := m.addKey(RoleKSK, KeyStateActive, now); err != nil {
+			return false, err
+		}
+		changed = true
+	} else if m.count(RoleKSK, KeyStateActive) > 1 {
+		newest := m.newest(RoleKSK, KeyStateActive)
+		if m.keys[newest].DSPublished.IsZero() {
+			if ok, err := m.parentHasDS(m.keys[newest]); err != nil {
+				log.Printf("Could not check parent DS records: %s\n", err)
+			} else if ok {
+				m.keys[newest].DSPublished = now
+				changed = true
+			}
+		}
+		if !m.keys[newest].DSPublished.IsZero() && !now.Before(m.keys[newest].DSPublished.Add(m.policy.DSDelay)) {
+			keys := []ManagedKey{}
+			for i, k := range m.keys {
+				if k.Role != RoleKSK || i == newest {
+					keys = append(keys, k)
+				}
+			}
+			m.keys = keys
+			changed = true
+		}
+	} else if k := m.keys[m.find(RoleKSK, KeyStateActive)]; !now.Before(k.Activated.Add(m.policy.KSKLifetime)) {
+		if err := m.addKey(RoleKSK, KeyStateActive, now); err != nil {
+			return false, err
+		}
+		changed = true
+	}
+	// ZSK: new key is introduced first and replaces the old one once whole
+	// world knows about it, old one stays in DNSKEY set until its signatures
+	// expire. Only the active one signs the zone, KSK does so until there is
+	// one.
+	if i := m.find(RoleZSK, KeyStatePublished); i != -1 {
+		if !now.Before(m.keys[i].Created.Add(m.policy.PublishDelay)) {
+			for j := range m.keys {
+				if m.keys[j].Role == RoleZSK && m.keys[j].State == KeyStateActive {
+					m.keys[j].State = KeyStateRetired
+					m.keys[j].Retired = now
+				}
+			}
+			m.keys[i].State = KeyStateActive
+			m.keys[i].Activated = now
+			changed = true
+		}
+	} else if fresh {
+		if err := m.addKey(RoleZSK, KeyStateActive, now); err != nil {
+			return false, err
+		}
+		changed = true
+	} else if i := m.find(RoleZSK, KeyStateActive); i == -1 || !now.Before(m.keys[i].Activated.Add(m.policy.ZSKLifetime)) {
+		if err := m.addKey(RoleZSK, KeyStatePublished, now); err != nil {
+			return false, err
+		}
+		changed = true
+	}
+	keys := []ManagedKey{}
+	for _, k := range m.keys {
+		if k.State == KeyStateRetired && !now.Before(k.Retired.Add(m.policy.PublishDelay)) {
+			changed = true
+			continue
+		}
+		keys = append(keys, k)
+	}
+	m.keys = keys
+	return changed, nil
+}
+
+// parentHasDS reports whether DS of the given key is published in the parent
+// zone. Expects manager state to be locked by caller.
+func (m *KeyManager) parentHasDS(k ManagedKey) (bool, error) {
+	if m.lookupDS == nil {
+		return false, nil
+	}
+	rr, err := dns.NewRR(string(k.DS))
+	if err != nil {
+		return false, err
+	}
+	want, ok := rr.(*dns.DS)
+	if !ok {
+		return false, fmt.Errorf("not a DS record: %s", k.DS)
+	}
+	published, err := m.lookupDS(m.zone)
+	if err != nil {
+		return false, err
+	}
+	for _, ds := range published {
+		if ds.KeyTag == want.KeyTag && ds.Algorithm == want.Algorithm && ds.DigestType == want.DigestType && strings.EqualFold(ds.Digest, want.Digest) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Expects manager state to be locked by caller.
+func (m *KeyManager) addKey(role string, state string, now time.Time) error {
+	var flags uint16 = 256
+	if role == RoleKSK {
+		flags = 257
+	}
+	k, err := newDNSSecKey(m.zone, flags)
+	if err != nil {
+		return err
+	}
+	mk := ManagedKey{DNSSecKey: k, Role: role, State: state, Created: now}
+	if state == KeyStateActive {
+		mk.Activated = now
+	}
+	m.keys = append(m.keys, mk)
+	return nil
+}
+
+// Expects manager state to be locked by caller.
+func (m *KeyManager) find(role, state string) int {
+	for i, k := range m.keys {
+		if k.Role == role && k.State == state {
+			return i
+		}
+	}
+	return -1
+}
+
+// Expects manager state to be locked by caller.
+func (m *KeyManager) count(role, state string) int {
+	ret := 0
+	for _, k := range m.keys {
+		if k.Role == role && k.State == state {
+			ret++
+		}
+	}
+	return ret
+}
+
+// Expects manager state to be locked by caller.
+func (m *KeyManager) newest(role, state string) int {
+	ret := -1
+	for i, k := range m.keys {
+		if k.Role == role && k.State == state && (ret == -1 || k.Activated.After(m.keys[ret].Activated)) {
+			ret = i
+		}
+	}
+	return ret
+}
+
+// Expects manager state to be locked by caller.
+func (m *KeyManager) save() error {
+	d, err := json.MarshalIndent(m.keys, "", "\t")
+	if err != nil {
+		return err
+	}
+	return m.fs.Write(keysFile, string(d))
+}
+
+// Expects manager state to be locked by caller.
+func (m *KeyManager) commit() error {
+	if err := m.save(); err != nil {
+		return err
+	}
+	if m.onChange != nil {
+		return m.onChange(append([]ManagedKey{}, m.keys...))
+	}
+	return nil
+}
+
+// NewResolverDSLookup looks DS records up through the given resolver, or
+// the ones from /etc/resolv.conf if empty. Resolver must not be authoritative
+// for the zone itself, as DS records are served by the parent.
+func NewResolverDSLookup(resolver string) (DSLookup, error) {
+	servers := []string{}
+	if resolver != "" {
+		servers = append(servers, resolver)
+	} else {
+		cfg, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range cfg.Servers {
+			servers = append(servers, net.JoinHostPort(s, cfg.Port))
+		}
+	}
+	return func(zone string) ([]*dns.DS, error) {
+		q := new(dns.Msg)
+		q.SetQuestion(dns.Fqdn(zone), dns.TypeDS)
+		q.SetEdns0(4096, true)
+		var errs []error
+		for _, s := range servers {
+			r, _, err := new(dns.Client).Exchange(q, s)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if r.Rcode != dns.RcodeSuccess {
+				errs = append(errs, fmt.Errorf("%s: %s", s, dns.RcodeToString[r.Rcode]))
+				continue
+			}
+			ret := []*dns.DS{}
+			for _, rr := range r.Answer {
+				if ds, ok := rr.(*dns.DS); ok {
+					ret = append(ret, ds)
+				}
+			}
+			return ret, nil
+		}
+		return nil, errors.Join(errs...)
+	}, nil
+}
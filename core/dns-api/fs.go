@@ -14,6 +14,10 @@ type FS interface {
 	Read(path string) (string, error)
 	Write(path string, data string) error
 	AbsolutePath(path string) string
+	MkdirAll(path string) error
+	// ReadDir returns names of the entries of the directory.
+	ReadDir(path string) ([]string, error)
+	Remove(path string) error
 }
 
 type osFS struct {
@@ -65,3 +69,23 @@ func (f osFS) Write(path string, data string) error {
 	_, err = io.WriteString(w, data)
 	return err
 }
+
+func (f osFS) MkdirAll(path string) error {
+	return os.MkdirAll(f.AbsolutePath(path), 0755)
+}
+
+func (f osFS) ReadDir(path string) ([]string, error) {
+	entries, err := os.ReadDir(f.AbsolutePath(path))
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]string, 0, len(entries))
+	for _, e := range entries {
+		ret = append(ret, e.Name())
+	}
+	return ret, nil
+}
+
+func (f osFS) Remove(path string) error {
+	return os.Remove(f.AbsolutePath(path))
+}
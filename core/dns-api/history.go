@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const historyDir = "history"
+
+// ZoneDiff lists records added and removed between two versions of the zone,
+// in zone file format. SOA record is left out as its serial differs between
+// any two versions.
+type ZoneDiff struct {
+	From    uint32   `json:"from"`
+	To      uint32   `json:"to"`
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+func versionPath(serial uint32) string {
+	return fmt.Sprintf("%s/%d.db", historyDir, serial)
+}
+
+// saveVersion copies current zone file into history and drops the oldest
+// versions exceeding history size.
+func (s *fsRecordStore) saveVersion(serial uint32) error {
+	if s.historySize <= 0 {
+		return nil
+	}
+	current, err := s.fs.Read(s.db)
+	if err != nil {
+		return err
+	}
+	if err := s.fs.Write(versionPath(serial), current); err != nil {
+		return err
+	}
+	versions, err := s.history()
+	if err != nil {
+		return err
+	}
+	for i := s.historySize; i < len(versions); i++ {
+		if err := s.fs.Remove(versionPath(versions[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fsRecordStore) History() ([]uint32, error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.history()
+}
+
+func (s *fsRecordStore) history() ([]uint32, error) {
+	names, err := s.fs.ReadDir(historyDir)
+	if err != nil {
+		return nil, err
+	}
+	ret := []uint32{}
+	for _, n := range names {
+		serial, err := strconv.ParseUint(strings.TrimSuffix(n, ".db"), 10, 32)
+		if err != nil {
+			continue
+		}
+		ret = append(ret, uint32(serial))
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i] > ret[j]
+	})
+	return ret, nil
+}
+
+// readVersion reads zone version with given serial, zero stands for the
+// current one.
+func (s *fsRecordStore) readVersion(serial uint32) (*RecordsFile, error) {
+	if serial == 0 {
+		return s.read()
+	}
+	ok, err := s.fs.Exists(versionPath(serial))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if z, err := s.read(); err == nil && z.Serial() == serial {
+			return z, nil
+		}
+		return nil, fmt.Errorf("zone version %d: %w", serial, ErrorNotFound)
+	}
+	r, err := s.fs.Reader(versionPath(serial))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return NewRecordsFile(r)
+}
+
+func (s *fsRecordStore) Diff(from, to uint32) (ZoneDiff, error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	fromZ, err := s.readVersion(from)
+	if err != nil {
+		return ZoneDiff{}, err
+	}
+	toZ, err := s.readVersion(to)
+	if err != nil {
+		return ZoneDiff{}, err
+	}
+	fromRRs := rrStrings(fromZ)
+	toRRs := rrStrings(toZ)
+	ret := ZoneDiff{
+		From:    fromZ.Serial(),
+		To:      toZ.Serial(),
+		Added:   []string{},
+		Removed: []string{},
+	}
+	for rr := range toRRs {
+		if _, ok := fromRRs[rr]; !ok {
+			ret.Added = append(ret.Added, rr)
+		}
+	}
+	for rr := range fromRRs {
+		if _, ok := toRRs[rr]; !ok {
+			ret.Removed = append(ret.Removed, rr)
+		}
+	}
+	sort.Strings(ret.Added)
+	sort.Strings(ret.Removed)
+	return ret, nil
+}
+
+// Revert makes given version of the zone current one. Reverted zone gets new
+// serial, so that change propagates same way as any other one.
+func (s *fsRecordStore) Revert(serial uint32) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	z, err := s.readVersion(serial)
+	if err != nil {
+		return err
+	}
+	current, err := s.read()
+	if err != nil {
+		return err
+	}
+	z.SetSerial(current.Serial())
+	return s.write(z)
+}
+
+func rrStrings(z *RecordsFile) map[string]struct{} {
+	ret := map[string]struct{}{}
+	for _, rr := range z.Records() {
+		if rr.Header().Rrtype == dns.TypeSOA {
+			continue
+		}
+		ret[rr.String()] = struct{}{}
+	}
+	return ret
+}
@@ -1,9 +1,9 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 
@@ -12,14 +12,34 @@ import (
 )
 
 const coreDNSConfigTmpl = `
+{{- if .views.Enabled }}
 {{ .zone }}:53 {
+	view private {
+		expr {{ range $i, $c := .views.PrivateCIDRs }}{{ if $i }} || {{ end }}incidr(client_ip(), '{{ $c }}'){{ end }}
+	}
 	file {{ .dbFile }} {
 		reload 1s
 	}
 	errors
-	{{- if .dnsSecBasename }}
-	dnssec {
-		key file {{ .dnsSecBasename}}
+	log
+	cache 30
+	loadbalance
+}
+{{- end }}
+{{ .zone }}:53 {
+	file {{ .publicDBFile }} {
+		reload 1s
+	}
+	errors
+	{{- if .transfer.Secondaries }}
+	transfer {
+		to{{ range .transfer.Secondaries }} {{ . }}{{ end }}
+	}
+	{{- end }}
+	{{- with .transfer.TSIG }}
+	tsig {
+		secret {{ .Name }} {{ .Secret }}
+		require AXFR IXFR
 	}
 	{{- end }}
 	log
@@ -36,7 +56,7 @@ const coreDNSConfigTmpl = `
 
 const recordsDBTmpl = `
 {{- $zone := .zone }}
-{{ $zone }}.   IN SOA ns1.{{ $zone }}. hostmaster.{{ $zone }}. {{ .nowUnix }} 7200 3600 1209600 3600
+{{ $zone }}.   IN SOA ns1.{{ $zone }}. hostmaster.{{ $zone }}. {{ .serial }} 7200 3600 1209600 3600
 {{- range $i, $ns := .nameserverIP }}
 ns{{ add1 $i }}.{{ $zone }}. 10800 IN A {{ $ns }}
 {{- end }}
@@ -48,27 +68,13 @@ ns{{ add1 $i }}.{{ $zone }}. 10800 IN A {{ $ns }}
 *.p.{{ $zone }}. 10800 IN A {{ .privateIP }}
 `
 
-func NewStore(fs FS, config string, db string, zone string, publicIP []string, privateIP string, nameserverIP []string) (RecordStore, string, error) {
-	dnsSec, err := getDNSSecKey(fs, zone)
-	if err != nil {
-		return nil, "", err
-	}
-	if err := fs.Write(dnsSec.Basename+".key", string(dnsSec.Key)); err != nil {
-		return nil, "", err
-	}
-	if err := fs.Write(dnsSec.Basename+".private", string(dnsSec.Private)); err != nil {
-		return nil, "", err
-	}
-	if err := executeTemplate(fs, config, coreDNSConfigTmpl, map[string]any{
-		"zone":           zone,
-		"dbFile":         fs.AbsolutePath(db),
-		"dnsSecBasename": fs.AbsolutePath(dnsSec.Basename),
-	}); err != nil {
-		return nil, "", err
+func NewStore(fs FS, config string, db string, zone string, publicIP []string, privateIP string, nameserverIP []string, historySize int, keys []ManagedKey, transfer TransferConfig, views ViewConfig) (RecordStore, error) {
+	if err := fs.MkdirAll(historyDir); err != nil {
+		return nil, err
 	}
 	ok, err := fs.Exists(db)
 	if err != nil {
-		return nil, "", err
+		return nil, err
 	}
 	if !ok {
 		if err := executeTemplate(fs, db, recordsDBTmpl, map[string]any{
@@ -76,43 +82,58 @@ func NewStore(fs FS, config string, db string, zone string, publicIP []string, p
 			"publicIP":     publicIP,
 			"privateIP":    privateIP,
 			"nameserverIP": nameserverIP,
-			"nowUnix":      NowUnix(),
+			"serial":       NextSerial(0, time.Now()),
 		}); err != nil {
-			return nil, "", err
+			return nil, err
 		}
 	}
-	return &fsRecordStore{zone, publicIP, fs, db}, string(dnsSec.DS), nil
-}
-
-func getDNSSecKey(fs FS, zone string) (DNSSecKey, error) {
-	const configFile = "dns-sec-key.json"
-	ok, err := fs.Exists(configFile)
-	if err != nil {
-		return DNSSecKey{}, err
-	}
-	if ok {
-		d, err := fs.Read(configFile)
-		if err != nil {
-			return DNSSecKey{}, err
-		}
-		var k DNSSecKey
-		if err := json.Unmarshal([]byte(d), &k); err != nil {
-			return DNSSecKey{}, err
-		}
-		return k, nil
+	ret := &fsRecordStore{zone, publicIP, fs, config, db, historySize, transfer, views, keys, time.Time{}, &sync.Mutex{}}
+	if err := writeCoreDNSConfig(fs, config, db, zone, keys, transfer, views); err != nil {
+		return nil, err
 	}
-	k, err := newDNSSecKey(zone)
+	z, err := ret.read()
 	if err != nil {
-		return DNSSecKey{}, err
+		return nil, err
 	}
-	d, err := json.MarshalIndent(k, "", "\t")
+	// NOTE(gio): Zone is signed again only if it changed while dns-api was
+	// down or was signed with a different set of keys, otherwise every
+	// restart would bump the serial.
+	dropped, err := dropDNSKEYs(z, zone)
 	if err != nil {
-		return DNSSecKey{}, err
+		return nil, err
 	}
-	if err := fs.Write(configFile, string(d)); err != nil {
-		return DNSSecKey{}, err
+	if ret.signedAt, err = ret.signedAtIfCurrent(z); err != nil {
+		return nil, err
 	}
-	return k, nil
+	if dropped || (len(keys) > 0 && ret.signedAt.IsZero()) {
+		if err := ret.write(z); err != nil {
+			return nil, err
+		}
+	} else if err := ret.writePublicView(z); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// writeCoreDNSConfig configures CoreDNS to serve signed copy of the zone when
+// DNSSEC keys are given, to serve transfers to secondaries and to pick the
+// zone view based on the client address. Reload plugin picks up the changes.
+func writeCoreDNSConfig(fs FS, config string, db string, zone string, keys []ManagedKey, transfer TransferConfig, views ViewConfig) error {
+	publicDB := db
+	if views.Enabled() {
+		publicDB = publicViewPath(db)
+	}
+	if len(keys) > 0 {
+		db = signedPath(db)
+		publicDB = signedPath(publicDB)
+	}
+	return executeTemplate(fs, config, coreDNSConfigTmpl, map[string]any{
+		"zone":         zone,
+		"dbFile":       fs.AbsolutePath(db),
+		"publicDBFile": fs.AbsolutePath(publicDB),
+		"transfer":     transfer,
+		"views":        views,
+	})
 }
 
 type DNSSecKey struct {
@@ -122,10 +143,10 @@ type DNSSecKey struct {
 	DS       []byte `json:"ds,omitempty"`
 }
 
-func newDNSSecKey(zone string) (DNSSecKey, error) {
+func newDNSSecKey(zone string, flags uint16) (DNSSecKey, error) {
 	key := &dns.DNSKEY{
 		Hdr:       dns.RR_Header{Name: dns.Fqdn(zone), Class: dns.ClassINET, Ttl: 3600, Rrtype: dns.TypeDNSKEY},
-		Algorithm: dns.ECDSAP256SHA256, Flags: 257, Protocol: 3,
+		Algorithm: dns.ECDSAP256SHA256, Flags: flags, Protocol: 3,
 	}
 	priv, err := key.Generate(256)
 	if err != nil {
@@ -139,10 +160,17 @@ func newDNSSecKey(zone string) (DNSSecKey, error) {
 	}, nil
 }
 
-// TODO(gio): not going to work in 15 years?
-// TODO(gio): remove 10 *
-func NowUnix() uint32 {
-	return 10 * uint32(time.Now().Unix())
+// NextSerial returns SOA serial following prev in YYYYMMDDnn format, nn
+// counting changes made during the day. Serial is only ever incremented, so
+// zones with serial ahead of the current date, for example ones which used
+// unix timestamp based serials before, keep counting from it.
+func NextSerial(prev uint32, now time.Time) uint32 {
+	y, m, d := now.UTC().Date()
+	today := uint32(y*1000000 + int(m)*10000 + d*100)
+	if prev < today {
+		return today
+	}
+	return prev + 1
 }
 
 func executeTemplate(fs FS, path string, contents string, values map[string]any) error {
@@ -2,7 +2,9 @@ package main
 
 import (
 	"flag"
+	"log"
 	"strings"
+	"time"
 )
 
 var port = flag.Int("port", 8080, "Port to listen on")
@@ -13,19 +15,75 @@ var zone = flag.String("zone", "", "Zone domain")
 var publicIPs = flag.String("public-ip", "", "Comma separated list of public IPs of the pcloud environment")
 var privateIP = flag.String("private-ip", "", "Private IP of the pcloud environment")
 var nameserverIPs = flag.String("nameserver-ip", "", "Comma separated list of nameserver IPs")
+var historySize = flag.Int("history-size", 100, "Number of previous zone versions to keep")
+var zskLifetime = flag.Duration("zsk-lifetime", 30*24*time.Hour, "How long zone signing key is used before being rolled over")
+var kskLifetime = flag.Duration("ksk-lifetime", 365*24*time.Hour, "How long key signing key is used before being rolled over")
+var keyPublishDelay = flag.Duration("key-publish-delay", 24*time.Hour, "How long new zone signing key is published before signing with it, and old one is kept after")
+var dsDelay = flag.Duration("ds-delay", 72*time.Hour, "How long old key signing key keeps signing after new DS is seen in the parent zone")
+var dsResolver = flag.String("ds-resolver", "", "Resolver, as host:port, used to check DS records of the parent zone, defaults to the ones from /etc/resolv.conf")
+var keyCheckInterval = flag.Duration("key-check-interval", time.Hour, "How often to check whether DNSSEC keys need to be rolled over")
+var secondaryIPs = flag.String("secondary-ip", "", "Comma separated list of secondary nameserver addresses allowed to transfer the zone")
+var transferTSIG = flag.Bool("transfer-tsig", true, "Require zone transfers to be signed with TSIG key")
+var updatePort = flag.Int("update-port", 0, "Port to accept RFC 2136 dynamic updates on, disabled if zero")
+var privateCIDRs = flag.String("private-cidr", "", "Comma separated list of CIDRs of the private networks, such as VPN, served the private view of the zone")
+var privateDomains = flag.String("private-domain", "", "Comma separated list of domains hidden from the public view of the zone")
 
 func main() {
 	flag.Parse()
 	publicIP := strings.Split(*publicIPs, ",")
 	nameserverIP := strings.Split(*nameserverIPs, ",")
 	fs := osFS{*rootDir}
-	store, ds, err := NewStore(fs, *config, *db, *zone, publicIP, *privateIP, nameserverIP)
+	keys, err := NewKeyManager(fs, *zone, KeyPolicy{
+		ZSKLifetime:  *zskLifetime,
+		KSKLifetime:  *kskLifetime,
+		PublishDelay: *keyPublishDelay,
+		DSDelay:      *dsDelay,
+	})
 	if err != nil {
 		panic(err)
 	}
+	lookupDS, err := NewResolverDSLookup(*dsResolver)
+	if err != nil {
+		panic(err)
+	}
+	keys.SetDSLookup(lookupDS)
+	transfer, err := NewTransferConfig(fs, *zone, strings.Split(*secondaryIPs, ","), *transferTSIG)
+	if err != nil {
+		panic(err)
+	}
+	updateKey, err := NewUpdateTSIGKey(fs, *zone)
+	if err != nil {
+		panic(err)
+	}
+	views, err := NewViewConfig(*zone, strings.Split(*privateCIDRs, ","), strings.Split(*privateDomains, ","))
+	if err != nil {
+		panic(err)
+	}
+	store, err := NewStore(fs, *config, *db, *zone, publicIP, *privateIP, nameserverIP, *historySize, keys.Keys(), transfer, views)
+	if err != nil {
+		panic(err)
+	}
+	keys.OnChange(store.SetKeys)
+	go func() {
+		for range time.Tick(*keyCheckInterval) {
+			if err := keys.Step(time.Now()); err != nil {
+				log.Printf("DNSSEC key rollover failed: %s\n", err)
+			}
+			if err := store.Resign(time.Now()); err != nil {
+				log.Printf("Could not sign zone: %s\n", err)
+			}
+		}
+	}()
 	if err := store.Log(); err != nil {
 		panic(err)
 	}
-	server := NewServer(*port, *zone, ds, store, nameserverIP)
+	if *updatePort != 0 {
+		go func() {
+			if err := NewUpdateHandler(*zone, updateKey, store).ListenAndServe(*updatePort); err != nil {
+				panic(err)
+			}
+		}()
+	}
+	server := NewServer(*port, *zone, keys, transfer, updateKey, store, nameserverIP)
 	server.Start()
 }
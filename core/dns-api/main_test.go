@@ -1,13 +1,780 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/miekg/dns"
 )
 
-func TestDNSSecKey(t *testing.T) {
-	k, err := getDNSSecKey(osFS{"/tmp"}, "foo.bar.ge")
+var testKeyPolicy = KeyPolicy{
+	ZSKLifetime:  30 * 24 * time.Hour,
+	KSKLifetime:  365 * 24 * time.Hour,
+	PublishDelay: 24 * time.Hour,
+	DSDelay:      72 * time.Hour,
+}
+
+func newTestServer(t *testing.T) (*Server, RecordStore) {
+	fs := osFS{t.TempDir()}
+	keys, err := NewKeyManager(fs, "foo.bar.ge", testKeyPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transfer, err := NewTransferConfig(fs, "foo.bar.ge", []string{"3.3.3.3", "4.4.4.4:5353"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updateKey, err := NewUpdateTSIGKey(fs, "foo.bar.ge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewStore(fs, "Corefile", "zone.db", "foo.bar.ge", []string{"1.1.1.1"}, "10.0.0.1", []string{"2.2.2.2"}, 3, keys.Keys(), transfer, ViewConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys.OnChange(store.SetKeys)
+	return NewServer(0, "foo.bar.ge", keys, transfer, updateKey, store, []string{"2.2.2.2"}), store
+}
+
+func keyStates(keys []ManagedKey) map[string][]string {
+	ret := map[string][]string{}
+	for _, k := range keys {
+		ret[k.Role] = append(ret[k.Role], k.State)
+	}
+	return ret
+}
+
+// signatures signs test zone with given keys and returns number of DNSKEY
+// records and tags of the keys signing SOA and DNSKEY sets.
+func signatures(t *testing.T, keys []ManagedKey) (int, []uint16, []uint16) {
+	t.Helper()
+	soa, err := dns.NewRR("foo.bar.ge. 3600 IN SOA ns1.foo.bar.ge. hostmaster.foo.bar.ge. 1 7200 3600 1209600 3600")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := signZone("foo.bar.ge", []dns.RR{soa}, keys, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dnskeys := []dns.RR{}
+	tags := map[uint16]*dns.DNSKEY{}
+	for _, rr := range signed {
+		if k, ok := rr.(*dns.DNSKEY); ok {
+			dnskeys = append(dnskeys, k)
+			tags[k.KeyTag()] = k
+		}
+	}
+	var zsk, ksk []uint16
+	for _, rr := range signed {
+		sig, ok := rr.(*dns.RRSIG)
+		if !ok {
+			continue
+		}
+		set := []dns.RR{soa}
+		if sig.TypeCovered == dns.TypeDNSKEY {
+			set = dnskeys
+			ksk = append(ksk, sig.KeyTag)
+		} else if sig.TypeCovered == dns.TypeSOA {
+			zsk = append(zsk, sig.KeyTag)
+		} else {
+			continue
+		}
+		if err := sig.Verify(tags[sig.KeyTag], set); err != nil {
+			t.Fatalf("invalid signature of %s: %s", dns.TypeToString[sig.TypeCovered], err)
+		}
+	}
+	return len(dnskeys), zsk, ksk
+}
+
+func keyTag(t *testing.T, k ManagedKey) uint16 {
+	t.Helper()
+	rr, err := dns.NewRR(string(k.Key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rr.(*dns.DNSKEY).KeyTag()
+}
+
+func TestKeyRollover(t *testing.T) {
+	fs := osFS{t.TempDir()}
+	legacy, err := newDNSSecKey("foo.bar.ge", 257)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := json.Marshal(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Write(legacyKeysFile, string(d)); err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewKeyManager(fs, "foo.bar.ge", testKeyPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ds := m.DS(); len(ds) != 1 || ds[0] != string(legacy.DS) {
+		t.Fatalf("expected legacy key to become KSK, got %+v", ds)
+	}
+	var changes [][]ManagedKey
+	m.OnChange(func(keys []ManagedKey) error {
+		changes = append(changes, keys)
+		return nil
+	})
+	expect := func(ksk, zsk []string) {
+		t.Helper()
+		states := keyStates(m.Keys())
+		if !reflect.DeepEqual(states[RoleKSK], ksk) || !reflect.DeepEqual(states[RoleZSK], zsk) {
+			t.Fatalf("expected ksk %v zsk %v, got %+v", ksk, zsk, states)
+		}
+	}
+	// ZSK introduced by migration is published, but not used yet.
+	expect([]string{"active"}, []string{"published"})
+	start := m.Keys()[1].Created
+	if err := m.Step(start.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Fatal("expected no changes before publish delay")
+	}
+	if err := m.Step(start.Add(24 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	expect([]string{"active"}, []string{"active"})
+	// ZSK rollover: pre-publish, activate and retire the old one, remove it.
+	now := start.Add(24*time.Hour + testKeyPolicy.ZSKLifetime)
+	if err := m.Step(now); err != nil {
+		t.Fatal(err)
+	}
+	expect([]string{"active"}, []string{"active", "published"})
+	if n, zsk, ksk := signatures(t, m.Keys()); n != 3 || len(zsk) != 1 || zsk[0] != keyTag(t, m.Keys()[1]) || len(ksk) != 1 {
+		t.Fatalf("expected published ZSK to be in DNSKEY set without signing, got %d %v %v", n, zsk, ksk)
+	}
+	now = now.Add(testKeyPolicy.PublishDelay)
+	if err := m.Step(now); err != nil {
+		t.Fatal(err)
+	}
+	expect([]string{"active"}, []string{"retired", "active"})
+	if n, zsk, _ := signatures(t, m.Keys()); n != 3 || len(zsk) != 1 || zsk[0] != keyTag(t, m.Keys()[2]) {
+		t.Fatalf("expected retired ZSK to stay in DNSKEY set without signing, got %d %v", n, zsk)
+	}
+	now = now.Add(testKeyPolicy.PublishDelay)
+	if err := m.Step(now); err != nil {
+		t.Fatal(err)
+	}
+	expect([]string{"active"}, []string{"active"})
+	if n, zsk, ksk := signatures(t, m.Keys()); n != 2 || len(zsk) != 1 || len(ksk) != 1 {
+		t.Fatalf("expected KSK and new ZSK to sign, got %d %v %v", n, zsk, ksk)
+	}
+	// Emergency KSK rollover: both keys sign until new DS shows up in the
+	// parent zone and old one expires from caches.
+	var parentDS []*dns.DS
+	m.SetDSLookup(func(zone string) ([]*dns.DS, error) {
+		return parentDS, nil
+	})
+	if err := m.Rollover(RoleKSK, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Rollover(RoleKSK, now); !errors.Is(err, ErrorConflict) {
+		t.Fatalf("expected conflict, got %v", err)
+	}
+	expect([]string{"active", "active"}, []string{"active"})
+	if ds := m.DS(); len(ds) != 2 {
+		t.Fatalf("expected both DS records during rollover, got %+v", ds)
+	}
+	if err := m.Step(now.Add(testKeyPolicy.DSDelay)); err != nil {
+		t.Fatal(err)
+	}
+	expect([]string{"active", "active"}, []string{"active"})
+	for _, ds := range m.DS() {
+		if ds == string(legacy.DS) {
+			continue
+		}
+		rr, err := dns.NewRR(ds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parentDS = append(parentDS, rr.(*dns.DS))
+	}
+	now = now.Add(2 * testKeyPolicy.DSDelay)
+	if err := m.Step(now); err != nil {
+		t.Fatal(err)
+	}
+	expect([]string{"active", "active"}, []string{"active"})
+	if err := m.Step(now.Add(testKeyPolicy.DSDelay)); err != nil {
+		t.Fatal(err)
+	}
+	expect([]string{"active"}, []string{"active"})
+	if ds := m.DS(); len(ds) != 1 || ds[0] == string(legacy.DS) {
+		t.Fatalf("expected only new DS, got %+v", ds)
+	}
+	if len(changes) != 7 {
+		t.Fatalf("expected 7 changes, got %d", len(changes))
+	}
+	// Key ring survives restarts.
+	r, err := NewKeyManager(fs, "foo.bar.ge", testKeyPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r.DS(), m.DS()) {
+		t.Fatalf("expected %+v, got %+v", m.DS(), r.DS())
+	}
+}
+
+func TestDNSSecAPI(t *testing.T) {
+	s, _ := newTestServer(t)
+	rec := do(t, s, "GET", "/records-to-publish", nil)
+	if strings.Count(rec.Body.String(), "\tDS\t") != 1 {
+		t.Fatalf("expected single DS record, got %s", rec.Body.String())
+	}
+	if rec := do(t, s, "POST", "/api/v1/dnssec/rollover/ksk", nil); rec.Code != http.StatusOK {
+		t.Fatalf("rollover failed: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(t, s, "POST", "/api/v1/dnssec/rollover/foo", nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected bad request, got %d", rec.Code)
+	}
+	var ds []string
+	if err := json.NewDecoder(do(t, s, "GET", "/api/v1/dnssec/ds", nil).Body).Decode(&ds); err != nil {
+		t.Fatal(err)
+	}
+	if len(ds) != 2 {
+		t.Fatalf("expected two DS records, got %+v", ds)
+	}
+	rec = do(t, s, "GET", "/records-to-publish", nil)
+	if strings.Count(rec.Body.String(), "\tDS\t") != 2 {
+		t.Fatalf("expected two DS records, got %s", rec.Body.String())
+	}
+	fs := s.store.(*fsRecordStore).fs
+	config, err := fs.Read("Corefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(config, "dnssec") || !strings.Contains(config, fmt.Sprintf("file %s {", fs.AbsolutePath("zone.db.signed"))) {
+		t.Fatalf("expected signed zone to be served, got %s", config)
+	}
+	// Both KSKs sign DNSKEY set, ZSK signs the rest.
+	signed, err := fs.Read("zone.db.signed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(signed, "\tDNSKEY\t") != 3 || strings.Count(signed, "\tRRSIG\tDNSKEY ") != 2 || strings.Count(signed, "\tRRSIG\tSOA ") != 1 {
+		t.Fatalf("unexpected signed zone: %s", signed)
+	}
+	var keys []dnssecKey
+	if err := json.NewDecoder(do(t, s, "GET", "/api/v1/dnssec/keys", nil).Body).Decode(&keys); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 {
+		t.Fatalf("expected three keys, got %+v", keys)
+	}
+	if rec := do(t, s, "POST", "/api/v1/dnssec/rollover/zsk", nil); rec.Code != http.StatusOK {
+		t.Fatalf("rollover failed: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(t, s, "POST", "/api/v1/dnssec/rollover/zsk", nil); rec.Code != http.StatusConflict {
+		t.Fatalf("expected conflict while ZSK is being published, got %d", rec.Code)
+	}
+	// Published ZSK is part of DNSKEY set only.
+	if signed, err = fs.Read("zone.db.signed"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(signed, "\tDNSKEY\t") != 4 || strings.Count(signed, "\tRRSIG\tSOA ") != 1 {
+		t.Fatalf("unexpected signed zone: %s", signed)
+	}
+}
+
+func do(t *testing.T, s *Server, method, path string, body any) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rec := httptest.NewRecorder()
+	s.m.ServeHTTP(rec, httptest.NewRequest(method, path, &buf))
+	return rec
+}
+
+func TestRecordsAPI(t *testing.T) {
+	s, store := newTestServer(t)
+	dkim := "v=DKIM1; k=rsa; p=" + strings.Repeat("A", 400)
+	for _, r := range []Record{
+		{Name: "@", Type: "MX", Preference: 10, Target: "mail"},
+		{Name: "_submission._tcp", Type: "SRV", Priority: 0, Weight: 1, Port: 587, Target: "mail.foo.bar.ge."},
+		{Name: "default._domainkey", Type: "TXT", Text: dkim},
+		{Name: "mail", Type: "AAAA", Address: "2001:db8::1"},
+		{Name: "@", Type: "CAA", Tag: "issue", Value: "letsencrypt.org"},
+		{Name: "www", Type: "CNAME", Target: "foo.bar.ge."},
+	} {
+		if rec := do(t, s, "POST", "/api/v1/records", r); rec.Code != http.StatusCreated {
+			t.Fatalf("creating %+v failed: %d %s", r, rec.Code, rec.Body.String())
+		}
+	}
+	for _, i := range []struct {
+		r    Record
+		code int
+	}{
+		{Record{Name: "www", Type: "A", Address: "1.2.3.4"}, http.StatusConflict},
+		{Record{Name: "mail", Type: "AAAA", Address: "2001:db8::1"}, http.StatusConflict},
+		{Record{Name: "mail", Type: "A", Address: "2001:db8::1"}, http.StatusBadRequest},
+		{Record{Name: "mail", Type: "NS", Target: "ns1"}, http.StatusBadRequest},
+		{Record{Name: "foo.example.com.", Type: "A", Address: "1.2.3.4"}, http.StatusBadRequest},
+	} {
+		if rec := do(t, s, "POST", "/api/v1/records", i.r); rec.Code != i.code {
+			t.Fatalf("expected %d for %+v, got %d %s", i.code, i.r, rec.Code, rec.Body.String())
+		}
+	}
+	rec := do(t, s, "GET", "/api/v1/records/default._domainkey/TXT", nil)
+	var records []Record
+	if err := json.NewDecoder(rec.Body).Decode(&records); err != nil {
+		t.Fatal(err)
+	}
+	expected := []Record{{Name: "default._domainkey", Type: "TXT", TTL: defaultTTL, Text: dkim}}
+	if !reflect.DeepEqual(records, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, records)
+	}
+	if rec := do(t, s, "PUT", "/api/v1/records/@/MX", []Record{
+		{Preference: 10, Target: "mx1.foo.bar.ge."},
+		{Preference: 20, Target: "mx2.foo.bar.ge."},
+	}); rec.Code != http.StatusOK {
+		t.Fatalf("replacing MX failed: %d %s", rec.Code, rec.Body.String())
+	}
+	records, err := store.List("@", "MX")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Target != "mx1.foo.bar.ge." || records[1].Target != "mx2.foo.bar.ge." {
+		t.Fatalf("unexpected MX records: %+v", records)
+	}
+	if rec := do(t, s, "DELETE", "/api/v1/records/www/CNAME", nil); rec.Code != http.StatusOK {
+		t.Fatalf("deleting CNAME failed: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(t, s, "GET", "/api/v1/records/www/CNAME", nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected deleted record to be gone, got %d", rec.Code)
+	}
+	if rec := do(t, s, "DELETE", "/api/v1/records/www/CNAME", nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected not found, got %d", rec.Code)
+	}
+	rec = do(t, s, "GET", "/api/v1/records?type=A", nil)
+	if err := json.NewDecoder(rec.Body).Decode(&records); err != nil {
+		t.Fatal(err)
+	}
+	// Records generated during initialization: ns1, apex, two wildcards and the private one.
+	if len(records) != 5 {
+		t.Fatalf("expected 5 A records, got %+v", records)
+	}
+}
+
+func TestNextSerial(t *testing.T) {
+	now := time.Date(2024, 10, 3, 12, 0, 0, 0, time.UTC)
+	for _, i := range []struct {
+		prev     uint32
+		expected uint32
+	}{
+		{0, 2024100300},
+		{2024100217, 2024100300},
+		{2024100300, 2024100301},
+		{3456789012, 3456789013},
+	} {
+		if actual := NextSerial(i.prev, now); actual != i.expected {
+			t.Errorf("expected %d after %d, got %d", i.expected, i.prev, actual)
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	s, store := newTestServer(t)
+	initial, err := store.(*fsRecordStore).read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ip := range []string{"1.2.3.4", "1.2.3.5", "1.2.3.6", "1.2.3.7"} {
+		if err := store.Create(Record{Name: "app", Type: "A", Address: ip}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	current, err := store.(*fsRecordStore).read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.Serial() != initial.Serial()+4 {
+		t.Fatalf("expected serial to be bumped on every change: %d %d", initial.Serial(), current.Serial())
+	}
+	versions, err := store.History()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []uint32{current.Serial() - 1, current.Serial() - 2, current.Serial() - 3}
+	if !reflect.DeepEqual(versions, expected) {
+		t.Fatalf("expected versions %v, got %v", expected, versions)
+	}
+	rec := do(t, s, "GET", fmt.Sprintf("/api/v1/history/%d/diff", versions[2]), nil)
+	var d ZoneDiff
+	if err := json.NewDecoder(rec.Body).Decode(&d); err != nil {
+		t.Fatal(err)
+	}
+	if d.To != current.Serial() || len(d.Removed) != 0 || len(d.Added) != 3 ||
+		!strings.HasSuffix(d.Added[0], "1.2.3.5") || !strings.HasSuffix(d.Added[2], "1.2.3.7") {
+		t.Fatalf("unexpected diff: %+v", d)
+	}
+	if rec := do(t, s, "POST", fmt.Sprintf("/api/v1/history/%d/revert", versions[2]), nil); rec.Code != http.StatusOK {
+		t.Fatalf("revert failed: %d %s", rec.Code, rec.Body.String())
+	}
+	records, err := store.List("app", "A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Address != "1.2.3.4" {
+		t.Fatalf("expected reverted zone to have single record, got %+v", records)
+	}
+	reverted, err := store.(*fsRecordStore).read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reverted.Serial() != current.Serial()+1 {
+		t.Fatalf("expected revert to bump serial, got %d", reverted.Serial())
+	}
+	if rec := do(t, s, "POST", "/api/v1/history/123/revert", nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected unknown version to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	if _, err := NewTransferConfig(osFS{t.TempDir()}, "foo.bar.ge", []string{"ns.foo.bar.ge"}, false); err == nil {
+		t.Fatal("expected secondary address to be rejected")
+	}
+	s, _ := newTestServer(t)
+	var c transferConfig
+	if err := json.NewDecoder(do(t, s, "GET", "/api/v1/transfer", nil).Body).Decode(&c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Zone != "foo.bar.ge." || c.TSIG == nil || c.TSIG.Name != "transfer.foo.bar.ge." || c.TSIG.Secret != "" {
+		t.Fatalf("unexpected transfer config: %+v", c)
+	}
+	if s.transfer.TSIG.Secret == "" {
+		t.Fatal("expected transfer TSIG key to be generated")
+	}
+	config, err := s.store.(*fsRecordStore).fs.Read("Corefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"to 3.3.3.3 4.4.4.4:5353",
+		fmt.Sprintf("secret transfer.foo.bar.ge. %s", s.transfer.TSIG.Secret),
+		"require AXFR IXFR",
+	} {
+		if !strings.Contains(config, expected) {
+			t.Fatalf("expected %q in %s", expected, config)
+		}
+	}
+	// Key is shared with secondaries, so it must survive restarts.
+	transfer, err := NewTransferConfig(s.store.(*fsRecordStore).fs, "foo.bar.ge", nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *transfer.TSIG != *s.transfer.TSIG {
+		t.Fatalf("expected %+v, got %+v", s.transfer.TSIG, transfer.TSIG)
+	}
+}
+
+func TestConcurrentChanges(t *testing.T) {
+	s, store := newTestServer(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r := Record{Name: fmt.Sprintf("app-%d", i), Type: "A", Address: "1.2.3.4"}
+			if rec := do(t, s, "POST", "/api/v1/records", r); rec.Code != http.StatusCreated {
+				t.Errorf("creating %+v failed: %d %s", r, rec.Code, rec.Body.String())
+			}
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < 10; i++ {
+		if records, err := store.List(fmt.Sprintf("app-%d", i), "A"); err != nil || len(records) != 1 {
+			t.Fatalf("expected app-%d to be created, got %+v %v", i, records, err)
+		}
+	}
+}
+
+func TestUpdateConfig(t *testing.T) {
+	s, _ := newTestServer(t)
+	var c updateConfig
+	if err := json.NewDecoder(do(t, s, "GET", "/api/v1/update", nil).Body).Decode(&c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Zone != "foo.bar.ge." || c.TSIG.Name != s.updateKey.Name || c.TSIG.Secret != "" {
+		t.Fatalf("unexpected update config: %+v", c)
+	}
+}
+
+func TestDynamicUpdate(t *testing.T) {
+	s, store := newTestServer(t)
+	key := s.updateKey
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	srv := &dns.Server{
+		PacketConn:        pc,
+		Handler:           NewUpdateHandler("foo.bar.ge", key, store),
+		TsigSecret:        map[string]string{key.Name: key.Secret},
+		MsgAcceptFunc:     acceptUpdates,
+		NotifyStartedFunc: func() { close(started) },
+	}
+	go srv.ActivateAndServe()
+	defer srv.Shutdown()
+	<-started
+	send := func(m *dns.Msg, sign bool) int {
+		t.Helper()
+		c := &dns.Client{TsigSecret: map[string]string{key.Name: key.Secret}}
+		if sign {
+			m.SetTsig(key.Name, key.Algorithm, 300, time.Now().Unix())
+		}
+		resp, _, err := c.Exchange(m, pc.LocalAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Rcode
+	}
+	rr := func(s string) dns.RR {
+		ret, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ret
+	}
+	m := new(dns.Msg)
+	m.SetUpdate("foo.bar.ge.")
+	m.Insert([]dns.RR{rr("_acme-challenge.foo.bar.ge. 60 IN TXT \"token\"")})
+	if rcode := send(m, false); rcode != dns.RcodeNotAuth {
+		t.Fatalf("expected unsigned update to be rejected, got %s", dns.RcodeToString[rcode])
+	}
+	if rcode := send(m, true); rcode != dns.RcodeSuccess {
+		t.Fatalf("update failed: %s", dns.RcodeToString[rcode])
+	}
+	records, err := store.List("_acme-challenge", "TXT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Text != "token" {
+		t.Fatalf("expected challenge record, got %+v", records)
+	}
+	// Prerequisite fails, so nothing gets changed.
+	m = new(dns.Msg)
+	m.SetUpdate("foo.bar.ge.")
+	m.RRsetNotUsed([]dns.RR{rr("_acme-challenge.foo.bar.ge. 0 IN TXT")})
+	m.Insert([]dns.RR{rr("app.foo.bar.ge. 60 IN A 1.2.3.4")})
+	if rcode := send(m, true); rcode != dns.RcodeYXRrset {
+		t.Fatalf("expected YXRRSET, got %s", dns.RcodeToString[rcode])
+	}
+	if records, err := store.List("app", "A"); err != nil || len(records) != 0 {
+		t.Fatalf("expected no records, got %+v %v", records, err)
+	}
+	m = new(dns.Msg)
+	m.SetUpdate("foo.bar.ge.")
+	m.Used([]dns.RR{rr("_acme-challenge.foo.bar.ge. 0 IN TXT \"token\"")})
+	m.Remove([]dns.RR{rr("_acme-challenge.foo.bar.ge. 0 IN TXT \"token\"")})
+	m.Insert([]dns.RR{rr("app.foo.bar.ge. 60 IN A 1.2.3.4")})
+	if rcode := send(m, true); rcode != dns.RcodeSuccess {
+		t.Fatalf("update failed: %s", dns.RcodeToString[rcode])
+	}
+	if records, err := store.List("_acme-challenge", "TXT"); err != nil || len(records) != 0 {
+		t.Fatalf("expected challenge record to be removed, got %+v %v", records, err)
+	}
+	if records, err := store.List("app", "A"); err != nil || len(records) != 1 {
+		t.Fatalf("expected single A record, got %+v %v", records, err)
+	}
+	for _, i := range []struct {
+		rr    dns.RR
+		rcode int
+	}{
+		{rr("foo.bar.ge. 3600 IN NS ns.example.com."), dns.RcodeRefused},
+		{rr("app.example.com. 60 IN A 1.2.3.4"), dns.RcodeNotZone},
+	} {
+		m = new(dns.Msg)
+		m.SetUpdate("foo.bar.ge.")
+		m.Insert([]dns.RR{i.rr})
+		if rcode := send(m, true); rcode != i.rcode {
+			t.Fatalf("expected %s for %s, got %s", dns.RcodeToString[i.rcode], i.rr, dns.RcodeToString[rcode])
+		}
+	}
+	m = new(dns.Msg)
+	m.SetUpdate("foo.bar.ge.")
+	m.RemoveName([]dns.RR{rr("app.foo.bar.ge. 0 IN A 0.0.0.0")})
+	if rcode := send(m, true); rcode != dns.RcodeSuccess {
+		t.Fatalf("update failed: %s", dns.RcodeToString[rcode])
+	}
+	if records, err := store.List("app", ""); err != nil || len(records) != 0 {
+		t.Fatalf("expected name to be removed, got %+v %v", records, err)
+	}
+}
+
+func TestRecordOwners(t *testing.T) {
+	s, store := newTestServer(t)
+	for _, r := range []Record{
+		{Name: "app", Type: "A", Address: "1.2.3.4", Owner: "app-1"},
+		{Name: "app", Type: "TXT", Text: "verification", Owner: "app-1"},
+		{Name: "other", Type: "A", Address: "1.2.3.5", Owner: "app-2"},
+		{Name: "www", Type: "CNAME", Target: "app"},
+	} {
+		if rec := do(t, s, "POST", "/api/v1/records", r); rec.Code != http.StatusCreated {
+			t.Fatalf("failed to create %+v: %d %s", r, rec.Code, rec.Body.String())
+		}
+	}
+	if rec := do(t, s, "POST", "/create-a-record", record{Entry: "*.foo.cluster.p", Text: "10.0.0.2", Owner: "cluster:foo"}); rec.Code != http.StatusOK {
+		t.Fatalf("failed to create cluster record: %s", rec.Body.String())
+	}
+	var owners map[string][]Record
+	if err := json.NewDecoder(do(t, s, "GET", "/api/v1/owners", nil).Body).Decode(&owners); err != nil {
+		t.Fatal(err)
+	}
+	if len(owners) != 3 || len(owners["app-1"]) != 2 || len(owners["app-2"]) != 1 || len(owners["cluster:foo"]) != 1 {
+		t.Fatalf("unexpected owners: %+v", owners)
+	}
+	if rec := do(t, s, "DELETE", "/api/v1/owners/app-1/records", nil); rec.Code != http.StatusOK {
+		t.Fatalf("failed to delete owned records: %s", rec.Body.String())
+	}
+	if rec := do(t, s, "DELETE", "/api/v1/owners/app-1/records", nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected not found, got %d", rec.Code)
+	}
+	if records, err := store.List("app", ""); err != nil || len(records) != 0 {
+		t.Fatalf("expected owned records to be removed, got %+v %v", records, err)
+	}
+	if records, err := store.List("www", "CNAME"); err != nil || len(records) != 1 || records[0].Owner != "" {
+		t.Fatalf("expected unowned record to stay, got %+v %v", records, err)
+	}
+	// Deleting record by other means drops its tag too.
+	if err := store.DeleteRRSet("other", "A"); err != nil {
+		t.Fatal(err)
+	}
+	if owners, err := store.Owners(); err != nil || len(owners) != 1 || len(owners["cluster:foo"]) != 1 {
+		t.Fatalf("expected only cluster records to be owned, got %+v %v", owners, err)
+	}
+}
+
+func TestResign(t *testing.T) {
+	fs := osFS{t.TempDir()}
+	keys, err := NewKeyManager(fs, "foo.bar.ge", testKeyPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newStore := func() *fsRecordStore {
+		store, err := NewStore(fs, "Corefile", "zone.db", "foo.bar.ge", []string{"1.1.1.1"}, "10.0.0.1", []string{"2.2.2.2"}, 3, keys.Keys(), TransferConfig{}, ViewConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store.(*fsRecordStore)
+	}
+	store := newStore()
+	z, err := store.read()
 	if err != nil {
 		t.Fatal(err)
 	}
-	t.Log(k)
+	signed := store.signedAt
+	if signed.IsZero() {
+		t.Fatal("expected zone to be signed")
+	}
+	// Restart keeps signatures made with the same keys.
+	store = newStore()
+	if r, err := store.read(); err != nil {
+		t.Fatal(err)
+	} else if r.Serial() != z.Serial() {
+		t.Fatalf("expected restart not to bump serial: %d %d", z.Serial(), r.Serial())
+	}
+	if err := store.Resign(signed.Add(signatureValidity / 4)); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := store.read(); err != nil {
+		t.Fatal(err)
+	} else if r.Serial() != z.Serial() {
+		t.Fatal("expected fresh signatures to be kept")
+	}
+	if err := store.Resign(signed.Add(signatureValidity / 2)); err != nil {
+		t.Fatal(err)
+	}
+	r, err := store.read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Serial() != z.Serial()+1 || !store.signedAt.After(signed) {
+		t.Fatalf("expected zone to be signed again: %d %d", z.Serial(), r.Serial())
+	}
+	d, err := fs.Read("zone.db.signed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(d, fmt.Sprintf(" %d ", r.Serial())) || !strings.Contains(d, "\tNSEC\t") {
+		t.Fatalf("expected signed zone with new serial: %s", d)
+	}
+}
+
+func TestViews(t *testing.T) {
+	if _, err := NewViewConfig("foo.bar.ge", []string{"100.64.0.0/10"}, []string{"p.example.com"}); err == nil {
+		t.Fatal("expected private domain out of zone to be rejected")
+	}
+	views, err := NewViewConfig("foo.bar.ge", []string{"100.64.0.0/10", "10.0.0.0/8"}, []string{"p.foo.bar.ge"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := osFS{t.TempDir()}
+	keys, err := NewKeyManager(fs, "foo.bar.ge", testKeyPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewStore(fs, "Corefile", "zone.db", "foo.bar.ge", []string{"1.1.1.1"}, "10.0.0.1", []string{"2.2.2.2"}, 3, keys.Keys(), TransferConfig{}, views)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := fs.Read("Corefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"expr incidr(client_ip(), '100.64.0.0/10') || incidr(client_ip(), '10.0.0.0/8')",
+		fmt.Sprintf("file %s {", fs.AbsolutePath("zone.db.signed")),
+		fmt.Sprintf("file %s {", fs.AbsolutePath("zone.db.public.signed")),
+	} {
+		if !strings.Contains(config, expected) {
+			t.Fatalf("expected %q in %s", expected, config)
+		}
+	}
+	if err := store.Create(Record{Name: "*.foo.cluster.p", Type: "A", Address: "10.0.0.2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Create(Record{Name: "app", Type: "A", Address: "1.2.3.4"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Add("_acme-challenge.p", "token", ""); err != nil {
+		t.Fatal(err)
+	}
+	private, err := fs.Read("zone.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := fs.Read("zone.db.public")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"*.p.foo.bar.ge.", "*.foo.cluster.p.foo.bar.ge."} {
+		if !strings.Contains(private, name) {
+			t.Fatalf("expected %s in private view: %s", name, private)
+		}
+		if strings.Contains(public, name) {
+			t.Fatalf("expected %s to be left out of public view: %s", name, public)
+		}
+	}
+	if !strings.Contains(public, "app.foo.bar.ge.") || !strings.Contains(public, "_acme-challenge.p.foo.bar.ge.") || !strings.Contains(public, "SOA") {
+		t.Fatalf("expected public records in public view: %s", public)
+	}
 }
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+const ownersFile = "owners.json"
+
+// Zone file has no room for metadata, so owners of the records are kept
+// separately, keyed by record name, type and data.
+type ownersIndex map[string]string
+
+func (s *fsRecordStore) readOwners() (ownersIndex, error) {
+	ok, err := s.fs.Exists(ownersFile)
+	if err != nil {
+		return nil, err
+	}
+	ret := ownersIndex{}
+	if !ok {
+		return ret, nil
+	}
+	d, err := s.fs.Read(ownersFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(d), &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (s *fsRecordStore) writeOwners(owners ownersIndex) error {
+	d, err := json.MarshalIndent(owners, "", "\t")
+	if err != nil {
+		return err
+	}
+	return s.fs.Write(ownersFile, string(d))
+}
+
+// setOwner tags given records with the owner, empty owner removes the tag.
+func (s *fsRecordStore) setOwner(rrs []dns.RR, owner string) error {
+	owners, err := s.readOwners()
+	if err != nil {
+		return err
+	}
+	for _, rr := range rrs {
+		if owner == "" {
+			delete(owners, rrKey(rr))
+		} else {
+			owners[rrKey(rr)] = owner
+		}
+	}
+	return s.writeOwners(owners)
+}
+
+// pruneOwners drops tags of the records which are not part of the zone
+// anymore.
+func (s *fsRecordStore) pruneOwners(z *RecordsFile) error {
+	owners, err := s.readOwners()
+	if err != nil {
+		return err
+	}
+	if len(owners) == 0 {
+		return nil
+	}
+	existing := map[string]struct{}{}
+	for _, rr := range z.Records() {
+		existing[rrKey(rr)] = struct{}{}
+	}
+	changed := false
+	for k := range owners {
+		if _, ok := existing[k]; !ok {
+			delete(owners, k)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return s.writeOwners(owners)
+}
+
+// Owners returns owned records grouped by their owner.
+func (s *fsRecordStore) Owners() (map[string][]Record, error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	owners, err := s.readOwners()
+	if err != nil {
+		return nil, err
+	}
+	z, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	ret := map[string][]Record{}
+	for _, rr := range z.Records() {
+		owner, ok := owners[rrKey(rr)]
+		if !ok {
+			continue
+		}
+		if r, ok := recordFromRR(rr, s.zone); ok {
+			r.Owner = owner
+			ret[owner] = append(ret[owner], r)
+		}
+	}
+	return ret, nil
+}
+
+// DeleteOwned deletes all records owned by the given owner.
+func (s *fsRecordStore) DeleteOwned(owner string) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	owners, err := s.readOwners()
+	if err != nil {
+		return err
+	}
+	z, err := s.read()
+	if err != nil {
+		return err
+	}
+	deleted := false
+	for _, rr := range z.Records() {
+		if owners[rrKey(rr)] == owner && z.DeleteRR(rr) {
+			deleted = true
+		}
+	}
+	if !deleted {
+		return fmt.Errorf("records owned by %s: %w", owner, ErrorNotFound)
+	}
+	return s.write(z)
+}
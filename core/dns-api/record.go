@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const defaultTTL = 300
+
+// Maximum length of a single character string within TXT record.
+const maxTxtChunkLen = 255
+
+var supportedTypes = map[string]uint16{
+	"A":     dns.TypeA,
+	"AAAA":  dns.TypeAAAA,
+	"CNAME": dns.TypeCNAME,
+	"MX":    dns.TypeMX,
+	"SRV":   dns.TypeSRV,
+	"CAA":   dns.TypeCAA,
+	"TXT":   dns.TypeTXT,
+}
+
+// Record is a JSON friendly representation of a single resource record. Name
+// is relative to the zone, @ stands for the zone apex. Only fields relevant to
+// the record type are set.
+type Record struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	TTL        uint32 `json:"ttl,omitempty"`
+	Address    string `json:"address,omitempty"`    // A, AAAA
+	Target     string `json:"target,omitempty"`     // CNAME, MX, SRV
+	Text       string `json:"text,omitempty"`       // TXT
+	Preference uint16 `json:"preference,omitempty"` // MX
+	Priority   uint16 `json:"priority,omitempty"`   // SRV
+	Weight     uint16 `json:"weight,omitempty"`     // SRV
+	Port       uint16 `json:"port,omitempty"`       // SRV
+	Flag       uint8  `json:"flag,omitempty"`       // CAA
+	Tag        string `json:"tag,omitempty"`        // CAA
+	Value      string `json:"value,omitempty"`      // CAA
+	// App instance or component which created the record.
+	Owner string `json:"owner,omitempty"`
+}
+
+// invalidf reports request which can not be fulfilled as it carries invalid
+// record.
+func invalidf(format string, a ...any) error {
+	return fmt.Errorf("%w: %s", ErrorInvalid, fmt.Sprintf(format, a...))
+}
+
+func parseType(t string) (uint16, error) {
+	if ret, ok := supportedTypes[strings.ToUpper(t)]; ok {
+		return ret, nil
+	}
+	return 0, invalidf("unsupported record type: %s", t)
+}
+
+// fqdn converts name relative to the zone into fully qualified one. Already
+// fully qualified names must belong to the zone.
+func fqdn(name, zone string) (string, error) {
+	zone = dns.Fqdn(zone)
+	if name == "" || name == "@" {
+		return zone, nil
+	}
+	if dns.IsFqdn(name) {
+		if !dns.IsSubDomain(zone, name) {
+			return "", invalidf("%s is out of %s zone", name, zone)
+		}
+		return strings.ToLower(name), nil
+	}
+	ret := strings.ToLower(fmt.Sprintf("%s.%s", name, zone))
+	if _, ok := dns.IsDomainName(ret); !ok {
+		return "", invalidf("invalid name: %s", name)
+	}
+	return ret, nil
+}
+
+func relativeName(name, zone string) string {
+	zone = dns.Fqdn(zone)
+	if name == zone {
+		return "@"
+	}
+	return strings.TrimSuffix(name, "."+zone)
+}
+
+// targetFqdn qualifies target names relative to the zone, same as zone files
+// do, while keeping fully qualified ones as is.
+func targetFqdn(target, zone string) string {
+	if target == "@" {
+		return dns.Fqdn(zone)
+	}
+	if dns.IsFqdn(target) {
+		return target
+	}
+	return fmt.Sprintf("%s.%s", target, dns.Fqdn(zone))
+}
+
+func (r Record) ToRR(zone string) (dns.RR, error) {
+	rrtype, err := parseType(r.Type)
+	if err != nil {
+		return nil, err
+	}
+	name, err := fqdn(r.Name, zone)
+	if err != nil {
+		return nil, err
+	}
+	ttl := r.TTL
+	if ttl == 0 {
+		ttl = defaultTTL
+	}
+	hdr := dns.RR_Header{Name: name, Rrtype: rrtype, Class: dns.ClassINET, Ttl: ttl}
+	switch rrtype {
+	case dns.TypeA:
+		ip := net.ParseIP(r.Address)
+		if ip == nil || ip.To4() == nil {
+			return nil, invalidf("invalid IPv4 address: %s", r.Address)
+		}
+		return &dns.A{Hdr: hdr, A: ip.To4()}, nil
+	case dns.TypeAAAA:
+		ip := net.ParseIP(r.Address)
+		if ip == nil || ip.To4() != nil {
+			return nil, invalidf("invalid IPv6 address: %s", r.Address)
+		}
+		return &dns.AAAA{Hdr: hdr, AAAA: ip}, nil
+	case dns.TypeCNAME:
+		if r.Target == "" {
+			return nil, invalidf("target is required")
+		}
+		return &dns.CNAME{Hdr: hdr, Target: targetFqdn(r.Target, zone)}, nil
+	case dns.TypeMX:
+		if r.Target == "" {
+			return nil, invalidf("target is required")
+		}
+		return &dns.MX{Hdr: hdr, Preference: r.Preference, Mx: targetFqdn(r.Target, zone)}, nil
+	case dns.TypeSRV:
+		if r.Target == "" {
+			return nil, invalidf("target is required")
+		}
+		return &dns.SRV{
+			Hdr:      hdr,
+			Priority: r.Priority,
+			Weight:   r.Weight,
+			Port:     r.Port,
+			Target:   targetFqdn(r.Target, zone),
+		}, nil
+	case dns.TypeCAA:
+		if r.Tag == "" {
+			return nil, invalidf("tag is required")
+		}
+		return &dns.CAA{Hdr: hdr, Flag: r.Flag, Tag: r.Tag, Value: r.Value}, nil
+	case dns.TypeTXT:
+		return &dns.TXT{Hdr: hdr, Txt: splitTxt(r.Text)}, nil
+	default:
+		panic("MUST NOT REACH")
+	}
+}
+
+// recordFromRR converts resource record into its JSON representation,
+// returns false if record type is not supported.
+func recordFromRR(rr dns.RR, zone string) (Record, bool) {
+	hdr := rr.Header()
+	ret := Record{
+		Name: relativeName(hdr.Name, zone),
+		Type: dns.TypeToString[hdr.Rrtype],
+		TTL:  hdr.Ttl,
+	}
+	switch r := rr.(type) {
+	case *dns.A:
+		ret.Address = r.A.String()
+	case *dns.AAAA:
+		ret.Address = r.AAAA.String()
+	case *dns.CNAME:
+		ret.Target = r.Target
+	case *dns.MX:
+		ret.Preference = r.Preference
+		ret.Target = r.Mx
+	case *dns.SRV:
+		ret.Priority = r.Priority
+		ret.Weight = r.Weight
+		ret.Port = r.Port
+		ret.Target = r.Target
+	case *dns.CAA:
+		ret.Flag = r.Flag
+		ret.Tag = r.Tag
+		ret.Value = r.Value
+	case *dns.TXT:
+		ret.Text = strings.Join(r.Txt, "")
+	default:
+		return Record{}, false
+	}
+	return ret, true
+}
+
+// splitTxt splits long values, for example DKIM keys, into multiple
+// character strings as single one can not be longer than 255 bytes.
+func splitTxt(text string) []string {
+	ret := []string{}
+	for len(text) > maxTxtChunkLen {
+		ret = append(ret, text[:maxTxtChunkLen])
+		text = text[maxTxtChunkLen:]
+	}
+	return append(ret, text)
+}
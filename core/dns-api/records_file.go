@@ -1,15 +1,23 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/miekg/dns"
 )
 
+var (
+	ErrorNotFound = errors.New("not found")
+	ErrorConflict = errors.New("conflict")
+	ErrorInvalid  = errors.New("invalid")
+)
+
 type RecordsFile struct {
 	lock sync.Locker
 	rrs  []dns.RR
@@ -70,7 +78,7 @@ func (z *RecordsFile) DeleteARecord(name, value string) error {
 // 	z.rrs = rrs
 // }
 
-func (z *RecordsFile) CreateOrReplaceTxtRecord(name, value string) {
+func (z *RecordsFile) CreateOrReplaceTxtRecord(name, value string) dns.RR {
 	z.lock.Lock()
 	defer z.lock.Unlock()
 	for i, rr := range z.rrs {
@@ -79,11 +87,11 @@ func (z *RecordsFile) CreateOrReplaceTxtRecord(name, value string) {
 				txt.Txt = []string{value}
 				z.rrs = append(z.rrs[:i], z.rrs[i+1:]...)
 				z.rrs = append(z.rrs, txt)
-				return
+				return txt
 			}
 		}
 	}
-	z.rrs = append(z.rrs, &dns.TXT{
+	ret := &dns.TXT{
 		Hdr: dns.RR_Header{
 			Name:   name,
 			Rrtype: dns.TypeTXT,
@@ -91,13 +99,15 @@ func (z *RecordsFile) CreateOrReplaceTxtRecord(name, value string) {
 			Ttl:    300,
 		},
 		Txt: []string{value},
-	})
+	}
+	z.rrs = append(z.rrs, ret)
+	return ret
 }
 
-func (z *RecordsFile) CreateARecord(name, value string) {
+func (z *RecordsFile) CreateARecord(name, value string) dns.RR {
 	z.lock.Lock()
 	defer z.lock.Unlock()
-	z.rrs = append(z.rrs, &dns.A{
+	ret := &dns.A{
 		Hdr: dns.RR_Header{
 			Name:   name,
 			Rrtype: dns.TypeA,
@@ -105,16 +115,142 @@ func (z *RecordsFile) CreateARecord(name, value string) {
 			Ttl:    300,
 		},
 		A: net.ParseIP(value),
-	})
+	}
+	z.rrs = append(z.rrs, ret)
+	return ret
 }
 
-func (z *RecordsFile) Write(w io.Writer) error {
+// Serial returns serial of the zone SOA record, zero if there is none.
+func (z *RecordsFile) Serial() uint32 {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	for _, rr := range z.rrs {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return soa.Serial
+		}
+	}
+	return 0
+}
+
+// BumpSerial increments serial of the zone SOA record so that secondaries and
+// caches notice the change.
+func (z *RecordsFile) BumpSerial(now time.Time) {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	for _, rr := range z.rrs {
+		if soa, ok := rr.(*dns.SOA); ok {
+			soa.Serial = NextSerial(soa.Serial, now)
+		}
+	}
+}
+
+func (z *RecordsFile) SetSerial(serial uint32) {
 	z.lock.Lock()
 	defer z.lock.Unlock()
 	for _, rr := range z.rrs {
 		if soa, ok := rr.(*dns.SOA); ok {
-			soa.Serial = NowUnix()
+			soa.Serial = serial
+		}
+	}
+}
+
+// Records returns all resource records of the zone.
+func (z *RecordsFile) Records() []dns.RR {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	return append([]dns.RR{}, z.rrs...)
+}
+
+// AddRR appends record to the existing set of records with the same name and
+// type.
+func (z *RecordsFile) AddRR(rr dns.RR) error {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	if err := z.checkCNAMEConflict(rr.Header().Name, rr.Header().Rrtype); err != nil {
+		return err
+	}
+	for _, i := range z.rrs {
+		if dns.IsDuplicate(i, rr) {
+			return fmt.Errorf("%s: %w", rr.String(), ErrorConflict)
+		}
+	}
+	z.rrs = append(z.rrs, rr)
+	return nil
+}
+
+// ReplaceRRSet replaces all records with given name and type.
+func (z *RecordsFile) ReplaceRRSet(name string, rrtype uint16, rrs []dns.RR) error {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	if err := z.checkCNAMEConflict(name, rrtype); err != nil {
+		return err
+	}
+	if rrtype == dns.TypeCNAME && len(rrs) > 1 {
+		return fmt.Errorf("%w: %s can have only one CNAME record", ErrorInvalid, name)
+	}
+	z.deleteRRSet(name, rrtype)
+	z.rrs = append(z.rrs, rrs...)
+	return nil
+}
+
+// DeleteRRSet deletes all records with given name and type.
+func (z *RecordsFile) DeleteRRSet(name string, rrtype uint16) error {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	if !z.deleteRRSet(name, rrtype) {
+		return fmt.Errorf("%s %s: %w", name, dns.TypeToString[rrtype], ErrorNotFound)
+	}
+	return nil
+}
+
+// DeleteRR deletes single record matching given one, TTL is ignored.
+func (z *RecordsFile) DeleteRR(rr dns.RR) bool {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	rrs := make([]dns.RR, 0, len(z.rrs))
+	for _, i := range z.rrs {
+		if !dns.IsDuplicate(i, rr) {
+			rrs = append(rrs, i)
+		}
+	}
+	deleted := len(rrs) != len(z.rrs)
+	z.rrs = rrs
+	return deleted
+}
+
+// Expects records to be locked by caller.
+func (z *RecordsFile) deleteRRSet(name string, rrtype uint16) bool {
+	rrs := make([]dns.RR, 0, len(z.rrs))
+	for _, rr := range z.rrs {
+		if !strings.EqualFold(rr.Header().Name, name) || rr.Header().Rrtype != rrtype {
+			rrs = append(rrs, rr)
+		}
+	}
+	deleted := len(rrs) != len(z.rrs)
+	z.rrs = rrs
+	return deleted
+}
+
+// checkCNAMEConflict makes sure CNAME record never coexists with records of
+// other types with the same name, records of rrtype itself are ignored.
+// Expects records to be locked by caller.
+func (z *RecordsFile) checkCNAMEConflict(name string, rrtype uint16) error {
+	for _, rr := range z.rrs {
+		hdr := rr.Header()
+		if !strings.EqualFold(hdr.Name, name) || hdr.Rrtype == rrtype {
+			continue
 		}
+		if rrtype == dns.TypeCNAME || hdr.Rrtype == dns.TypeCNAME {
+			return fmt.Errorf("%s already has %s record, CNAME can not coexist with other records: %w", name, dns.TypeToString[hdr.Rrtype], ErrorConflict)
+		}
+	}
+	return nil
+}
+
+func (z *RecordsFile) Write(w io.Writer) error {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	for _, rr := range z.rrs {
 		if _, err := fmt.Fprintf(w, "%s\n", rr.String()); err != nil {
 			return err
 		}
@@ -2,9 +2,14 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/miekg/dns"
 )
 
 type Server struct {
@@ -12,11 +17,13 @@ type Server struct {
 	m          *http.ServeMux
 	store      RecordStore
 	zone       string
-	ds         string
+	keys       *KeyManager
+	transfer   TransferConfig
+	updateKey  TSIGKey
 	nameserver []string
 }
 
-func NewServer(port int, zone string, ds string, store RecordStore, nameserver []string) *Server {
+func NewServer(port int, zone string, keys *KeyManager, transfer TransferConfig, updateKey TSIGKey, store RecordStore, nameserver []string) *Server {
 	m := http.NewServeMux()
 	s := &Server{
 		s: &http.Server{
@@ -26,7 +33,9 @@ func NewServer(port int, zone string, ds string, store RecordStore, nameserver [
 		m:          m,
 		store:      store,
 		zone:       zone,
-		ds:         ds,
+		keys:       keys,
+		transfer:   transfer,
+		updateKey:  updateKey,
 		nameserver: nameserver,
 	}
 	m.HandleFunc("/records-to-publish", s.recordsToPublish)
@@ -34,6 +43,22 @@ func NewServer(port int, zone string, ds string, store RecordStore, nameserver [
 	m.HandleFunc("/create-a-record", s.createARecord)
 	m.HandleFunc("/delete-a-record", s.deleteARecord)
 	m.HandleFunc("/delete-txt-record", s.deleteTxtRecord)
+	m.HandleFunc("GET /api/v1/records", s.listRecords)
+	m.HandleFunc("POST /api/v1/records", s.createRecord)
+	m.HandleFunc("GET /api/v1/records/{name}/{type}", s.getRecords)
+	m.HandleFunc("PUT /api/v1/records/{name}/{type}", s.replaceRecords)
+	m.HandleFunc("DELETE /api/v1/records/{name}/{type}", s.deleteRecords)
+	m.HandleFunc("GET /api/v1/owners", s.owners)
+	m.HandleFunc("GET /api/v1/owners/{owner}/records", s.ownedRecords)
+	m.HandleFunc("DELETE /api/v1/owners/{owner}/records", s.deleteOwnedRecords)
+	m.HandleFunc("GET /api/v1/history", s.history)
+	m.HandleFunc("GET /api/v1/history/{serial}/diff", s.diff)
+	m.HandleFunc("POST /api/v1/history/{serial}/revert", s.revert)
+	m.HandleFunc("GET /api/v1/dnssec/keys", s.dnssecKeys)
+	m.HandleFunc("GET /api/v1/dnssec/ds", s.dnssecDS)
+	m.HandleFunc("POST /api/v1/dnssec/rollover/{role}", s.dnssecRollover)
+	m.HandleFunc("GET /api/v1/transfer", s.transferConfig)
+	m.HandleFunc("GET /api/v1/update", s.updateConfig)
 	return s
 }
 
@@ -45,13 +70,16 @@ type record struct {
 	Domain string `json:"domain,omitempty"`
 	Entry  string `json:"entry,omitempty"`
 	Text   string `json:"text,omitempty"`
+	Owner  string `json:"owner,omitempty"`
 }
 
 func (s *Server) recordsToPublish(w http.ResponseWriter, r *http.Request) {
 	subdomain := strings.Split(s.zone, ".")[0]
-	if _, err := fmt.Fprintln(w, s.ds); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	for _, ds := range s.keys.DS() {
+		if _, err := fmt.Fprintln(w, ds); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 	}
 	for i, ip := range s.nameserver {
 		if _, err := fmt.Fprintf(w, "ns%d.%s. 10800 IN A %s\n", i+1, s.zone, ip); err != nil {
@@ -71,7 +99,7 @@ func (s *Server) createTxtRecord(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if err := s.store.Add(req.Entry, req.Text); err != nil {
+	if err := s.store.Add(req.Entry, req.Text, req.Owner); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -83,7 +111,7 @@ func (s *Server) createARecord(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if err := s.store.AddARecord(req.Entry, req.Text); err != nil {
+	if err := s.store.AddARecord(req.Entry, req.Text, req.Owner); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -112,3 +140,226 @@ func (s *Server) deleteARecord(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+func (s *Server) listRecords(w http.ResponseWriter, r *http.Request) {
+	records, err := s.store.List(r.URL.Query().Get("name"), r.URL.Query().Get("type"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, records)
+}
+
+func (s *Server) createRecord(w http.ResponseWriter, r *http.Request) {
+	var req Record
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.store.Create(req); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) getRecords(w http.ResponseWriter, r *http.Request) {
+	records, err := s.store.List(r.PathValue("name"), r.PathValue("type"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if len(records) == 0 {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, records)
+}
+
+// replaceRecords replaces the whole record set, name and type of the given
+// records are taken from the path.
+func (s *Server) replaceRecords(w http.ResponseWriter, r *http.Request) {
+	var req []Record
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.store.Replace(r.PathValue("name"), r.PathValue("type"), req); err != nil {
+		writeError(w, err)
+		return
+	}
+}
+
+func (s *Server) deleteRecords(w http.ResponseWriter, r *http.Request) {
+	if err := s.store.DeleteRRSet(r.PathValue("name"), r.PathValue("type")); err != nil {
+		writeError(w, err)
+		return
+	}
+}
+
+func (s *Server) owners(w http.ResponseWriter, r *http.Request) {
+	owners, err := s.store.Owners()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, owners)
+}
+
+func (s *Server) ownedRecords(w http.ResponseWriter, r *http.Request) {
+	owners, err := s.store.Owners()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	records, ok := owners[r.PathValue("owner")]
+	if !ok {
+		records = []Record{}
+	}
+	writeJSON(w, records)
+}
+
+// deleteOwnedRecords is called when app instance or component owning the
+// records is removed.
+func (s *Server) deleteOwnedRecords(w http.ResponseWriter, r *http.Request) {
+	if err := s.store.DeleteOwned(r.PathValue("owner")); err != nil {
+		writeError(w, err)
+		return
+	}
+}
+
+func (s *Server) history(w http.ResponseWriter, r *http.Request) {
+	versions, err := s.store.History()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, versions)
+}
+
+// diff compares version with given serial to the one given by to query
+// parameter, current version if it is missing.
+func (s *Server) diff(w http.ResponseWriter, r *http.Request) {
+	from, err := parseSerial(r.PathValue("serial"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var to uint32
+	if t := r.URL.Query().Get("to"); t != "" {
+		if to, err = parseSerial(t); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	d, err := s.store.Diff(from, to)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, d)
+}
+
+func (s *Server) revert(w http.ResponseWriter, r *http.Request) {
+	serial, err := parseSerial(r.PathValue("serial"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := s.store.Revert(serial); err != nil {
+		writeError(w, err)
+		return
+	}
+}
+
+type dnssecKey struct {
+	Role      string    `json:"role"`
+	State     string    `json:"state"`
+	Key       string    `json:"key"`
+	DS        string    `json:"ds,omitempty"`
+	Created   time.Time `json:"created"`
+	Activated time.Time `json:"activated,omitempty"`
+	Retired   time.Time `json:"retired,omitempty"`
+}
+
+// dnssecKeys lists keys together with their rollover state, private keys
+// never leave the server.
+func (s *Server) dnssecKeys(w http.ResponseWriter, r *http.Request) {
+	ret := []dnssecKey{}
+	for _, k := range s.keys.Keys() {
+		ret = append(ret, dnssecKey{k.Role, k.State, string(k.Key), string(k.DS), k.Created, k.Activated, k.Retired})
+	}
+	writeJSON(w, ret)
+}
+
+// dnssecDS returns DS records which must be present in the parent zone,
+// during KSK rollover both old and new ones are returned.
+func (s *Server) dnssecDS(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.keys.DS())
+}
+
+func (s *Server) dnssecRollover(w http.ResponseWriter, r *http.Request) {
+	if err := s.keys.Rollover(r.PathValue("role"), time.Now()); err != nil {
+		writeError(w, err)
+		return
+	}
+}
+
+type transferConfig struct {
+	Zone      string   `json:"zone"`
+	Primaries []string `json:"primaries"`
+	TransferConfig
+}
+
+// transferConfig returns everything secondary nameserver needs to be
+// configured with: zone, primaries to transfer it from and TSIG key name and
+// algorithm. API is not authenticated, so TSIG secret is never returned and
+// must be copied from tsig-key.json instead.
+func (s *Server) transferConfig(w http.ResponseWriter, r *http.Request) {
+	t := s.transfer
+	if t.TSIG != nil {
+		k := t.TSIG.WithoutSecret()
+		t.TSIG = &k
+	}
+	writeJSON(w, transferConfig{dns.Fqdn(s.zone), s.nameserver, t})
+}
+
+type updateConfig struct {
+	Zone string  `json:"zone"`
+	TSIG TSIGKey `json:"tsig"`
+}
+
+// updateConfig returns name and algorithm of TSIG key RFC 2136 clients, such
+// as nsupdate or cert-manager rfc2136 solver, must sign updates with. Secret
+// is never returned and must be copied from update-tsig-key.json instead.
+func (s *Server) updateConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, updateConfig{dns.Fqdn(s.zone), s.updateKey.WithoutSecret()})
+}
+
+func parseSerial(s string) (uint32, error) {
+	ret, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, invalidf("invalid serial: %s", s)
+	}
+	return uint32(ret), nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrorInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrorNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrorConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
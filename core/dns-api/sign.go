@@ -0,0 +1,296 @@
+package main
+
+import (
+	"crypto"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Zone is signed by dns-api itself, instead of CoreDNS dnssec plugin, as the
+// latter puts every key it is given into DNSKEY set and signs with each of
+// them, which makes pre-publishing keys impossible. Signed copy of every zone
+// file is served by the CoreDNS file plugin instead.
+
+const (
+	// How long signatures stay valid, zone is signed again once half of it
+	// passes.
+	signatureValidity = 14 * 24 * time.Hour
+	// Inception is set in the past to account for validators with clocks
+	// running behind.
+	signatureInceptionOffset = time.Hour
+)
+
+// signedPath returns path of the signed copy of the given zone file.
+func signedPath(db string) string {
+	return fmt.Sprintf("%s.signed", db)
+}
+
+type zoneSigner struct {
+	key  *dns.DNSKEY
+	priv crypto.Signer
+}
+
+// signZone returns records of the zone together with DNSKEY set made of all
+// the given keys, NSEC chain and signatures. DNSKEY set is signed by active
+// KSKs and everything else by active ZSKs, or KSKs if there are none yet, for
+// example while ZSK of the zone migrated from single key setup is being
+// published. Published and retired keys are part of DNSKEY set only. DNSSEC
+// records present in rrs are replaced.
+func signZone(zone string, rrs []dns.RR, keys []ManagedKey, now time.Time) ([]dns.RR, error) {
+	origin := dns.CanonicalName(zone)
+	sets := map[string]map[uint16][]dns.RR{}
+	add := func(rr dns.RR) {
+		name := dns.CanonicalName(rr.Header().Name)
+		if _, ok := sets[name]; !ok {
+			sets[name] = map[uint16][]dns.RR{}
+		}
+		sets[name][rr.Header().Rrtype] = append(sets[name][rr.Header().Rrtype], rr)
+	}
+	var soa *dns.SOA
+	for _, rr := range rrs {
+		switch rr.Header().Rrtype {
+		case dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3, dns.TypeNSEC3PARAM, dns.TypeDNSKEY:
+			continue
+		case dns.TypeSOA:
+			soa = rr.(*dns.SOA)
+		}
+		add(dns.Copy(rr))
+	}
+	if soa == nil {
+		return nil, fmt.Errorf("%s has no SOA record", zone)
+	}
+	var ksks, zsks []zoneSigner
+	for _, k := range keys {
+		rr, err := dns.NewRR(string(k.Key))
+		if err != nil {
+			return nil, err
+		}
+		key, ok := rr.(*dns.DNSKEY)
+		if !ok {
+			return nil, fmt.Errorf("not a DNSKEY record: %s", k.Key)
+		}
+		add(key)
+		if k.State != KeyStateActive {
+			continue
+		}
+		priv, err := key.NewPrivateKey(string(k.Private))
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := priv.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("%s: unsupported private key", k.Basename)
+		}
+		if k.Role == RoleKSK {
+			ksks = append(ksks, zoneSigner{key, signer})
+		} else {
+			zsks = append(zsks, zoneSigner{key, signer})
+		}
+	}
+	if len(zsks) == 0 {
+		zsks = ksks
+	}
+	names := make([]string, 0, len(sets))
+	for name := range sets {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return canonicalLess(names[i], names[j])
+	})
+	if names[0] != origin {
+		return nil, fmt.Errorf("%s has no records at the apex", zone)
+	}
+	nsecTTL := min(soa.Minttl, soa.Hdr.Ttl)
+	for i, name := range names {
+		types := []uint16{dns.TypeRRSIG, dns.TypeNSEC}
+		for t := range sets[name] {
+			types = append(types, t)
+		}
+		sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+		add(&dns.NSEC{
+			Hdr:        dns.RR_Header{Name: name, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: nsecTTL},
+			NextDomain: names[(i+1)%len(names)],
+			TypeBitMap: types,
+		})
+	}
+	inception := uint32(now.Add(-signatureInceptionOffset).Unix())
+	expiration := uint32(now.Add(signatureValidity).Unix())
+	ret := []dns.RR{}
+	for _, name := range names {
+		types := make([]uint16, 0, len(sets[name]))
+		for t := range sets[name] {
+			types = append(types, t)
+		}
+		// NOTE(gio): SOA goes first, as zone file starts with it.
+		sort.Slice(types, func(i, j int) bool {
+			if types[i] == dns.TypeSOA || types[j] == dns.TypeSOA {
+				return types[i] == dns.TypeSOA
+			}
+			return types[i] < types[j]
+		})
+		for _, t := range types {
+			set := sets[name][t]
+			ret = append(ret, set...)
+			signers := zsks
+			if t == dns.TypeDNSKEY {
+				signers = ksks
+			}
+			for _, s := range signers {
+				sig := &dns.RRSIG{
+					Hdr:        dns.RR_Header{Ttl: set[0].Header().Ttl},
+					KeyTag:     s.key.KeyTag(),
+					SignerName: origin,
+					Algorithm:  s.key.Algorithm,
+					Inception:  inception,
+					Expiration: expiration,
+				}
+				if err := sig.Sign(s.priv, set); err != nil {
+					return nil, err
+				}
+				ret = append(ret, sig)
+			}
+		}
+	}
+	return ret, nil
+}
+
+// canonicalLess orders domain names as described in RFC 4034, section 6.1.
+func canonicalLess(a, b string) bool {
+	la := dns.SplitDomainName(strings.ToLower(a))
+	lb := dns.SplitDomainName(strings.ToLower(b))
+	for i, j := len(la)-1, len(lb)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if la[i] != lb[j] {
+			return la[i] < lb[j]
+		}
+	}
+	return len(la) < len(lb)
+}
+
+// writeSigned signs private and, when views are enabled, public view of the
+// zone and writes them next to the unsigned ones. Does nothing if there are
+// no keys to sign with.
+// Expects store to be locked by caller.
+func (s *fsRecordStore) writeSigned(z *RecordsFile, now time.Time) error {
+	if len(s.keys) == 0 {
+		return nil
+	}
+	views := map[string][]dns.RR{s.db: z.Records()}
+	if s.views.Enabled() {
+		views[publicViewPath(s.db)] = s.publicRecords(z)
+	}
+	for db, rrs := range views {
+		signed, err := signZone(s.zone, rrs, s.keys, now)
+		if err != nil {
+			return err
+		}
+		if err := writeRecords(s.fs, signedPath(db), signed); err != nil {
+			return err
+		}
+	}
+	s.signedAt = now
+	return nil
+}
+
+// signedAtIfCurrent returns when zone was signed, if signed copy of the zone
+// file matches its current version and is signed with the current set of
+// keys. Otherwise zero time is returned.
+// Expects store to be locked by caller.
+func (s *fsRecordStore) signedAtIfCurrent(z *RecordsFile) (time.Time, error) {
+	if ok, err := s.fs.Exists(signedPath(s.db)); err != nil || !ok {
+		return time.Time{}, err
+	}
+	r, err := s.fs.Reader(signedPath(s.db))
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer r.Close()
+	signed, err := NewRecordsFile(r)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if signed.Serial() != z.Serial() {
+		return time.Time{}, nil
+	}
+	want := map[uint16]bool{}
+	wantSigners := map[string]map[uint16]bool{RoleKSK: {}, RoleZSK: {}}
+	for _, k := range s.keys {
+		rr, err := dns.NewRR(string(k.Key))
+		if err != nil {
+			return time.Time{}, err
+		}
+		tag := rr.(*dns.DNSKEY).KeyTag()
+		want[tag] = true
+		if k.State == KeyStateActive {
+			wantSigners[k.Role][tag] = true
+		}
+	}
+	zoneSigners := wantSigners[RoleZSK]
+	if len(zoneSigners) == 0 {
+		zoneSigners = wantSigners[RoleKSK]
+	}
+	got := map[uint16]bool{}
+	gotSigners := map[uint16]bool{}
+	var inception time.Time
+	for _, rr := range signed.Records() {
+		switch rr := rr.(type) {
+		case *dns.DNSKEY:
+			got[rr.KeyTag()] = true
+		case *dns.RRSIG:
+			if rr.TypeCovered == dns.TypeSOA {
+				gotSigners[rr.KeyTag] = true
+				inception = time.Unix(int64(rr.Inception), 0).Add(signatureInceptionOffset)
+			}
+		}
+	}
+	if !equalTags(want, got) || !equalTags(zoneSigners, gotSigners) {
+		return time.Time{}, nil
+	}
+	return inception, nil
+}
+
+func equalTags(a, b map[uint16]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for t := range a {
+		if !b[t] {
+			return false
+		}
+	}
+	return true
+}
+
+// Resign signs the zone again once half of the signature validity period
+// passes, bumping its serial so that CoreDNS and secondaries pick up fresh
+// signatures.
+func (s *fsRecordStore) Resign(now time.Time) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if len(s.keys) == 0 || now.Before(s.signedAt.Add(signatureValidity/2)) {
+		return nil
+	}
+	z, err := s.read()
+	if err != nil {
+		return err
+	}
+	return s.write(z)
+}
+
+func writeRecords(fs FS, path string, rrs []dns.RR) error {
+	w, err := fs.Writer(path)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	for _, rr := range rrs {
+		if _, err := io.WriteString(w, rr.String()+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
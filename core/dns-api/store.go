@@ -1,27 +1,67 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
 )
 
 type RecordStore interface {
 	Log() error
-	Add(entry, txt string) error
-	AddARecord(entry, ip string) error
+	// Add and AddARecord tag created record with the owner, unless it is
+	// empty.
+	Add(entry, txt, owner string) error
+	AddARecord(entry, ip, owner string) error
 	Delete(entry, txt string) error
 	DeleteARecord(entry, ip string) error
+	// List returns records of the supported types, optionally filtered by
+	// name and type.
+	List(name, rrtype string) ([]Record, error)
+	Create(r Record) error
+	Replace(name, rrtype string, records []Record) error
+	DeleteRRSet(name, rrtype string) error
+	// Owners returns owned records grouped by their owner.
+	Owners() (map[string][]Record, error)
+	DeleteOwned(owner string) error
+	// History returns serials of the previous zone versions kept, latest
+	// first.
+	History() ([]uint32, error)
+	Diff(from, to uint32) (ZoneDiff, error)
+	Revert(serial uint32) error
+	// Update applies RFC 2136 dynamic update.
+	Update(prereq []dns.RR, updates []dns.RR) error
+	// SetKeys reconfigures zone signing with given set of DNSSEC keys.
+	SetKeys(keys []ManagedKey) error
+	// Resign refreshes zone signatures before they expire.
+	Resign(now time.Time) error
 }
 
 type fsRecordStore struct {
-	zone     string
-	publicIP []string
-	fs       FS
-	db       string
+	zone        string
+	publicIP    []string
+	fs          FS
+	config      string
+	db          string
+	historySize int
+	transfer    TransferConfig
+	views       ViewConfig
+	keys        []ManagedKey
+	// When zone was last signed, zero if it is not signed.
+	signedAt time.Time
+	// Guards zone file and its metadata, which are read-modify-written by
+	// the API handlers, dynamic updates and key rollover concurrently.
+	l sync.Locker
 }
 
 func (s *fsRecordStore) Log() error {
+	s.l.Lock()
+	defer s.l.Unlock()
 	r, err := s.fs.Reader(s.db)
 	if err != nil {
 		return err
@@ -40,42 +80,69 @@ func (s *fsRecordStore) read() (*RecordsFile, error) {
 	return NewRecordsFile(io.TeeReader(r, os.Stdout))
 }
 
+// write keeps current version of the zone in history and replaces it with
+// the given one, bumping its serial.
 func (s *fsRecordStore) write(z *RecordsFile) error {
+	if err := s.saveVersion(z.Serial()); err != nil {
+		return err
+	}
+	z.BumpSerial(time.Now())
 	w, err := s.fs.Writer(s.db)
 	if err != nil {
 		return err
 	}
 	defer w.Close()
-	return z.Write(io.MultiWriter(w, os.Stdout))
+	if err := z.Write(io.MultiWriter(w, os.Stdout)); err != nil {
+		return err
+	}
+	if err := s.writePublicView(z); err != nil {
+		return err
+	}
+	if err := s.writeSigned(z, time.Now()); err != nil {
+		return err
+	}
+	return s.pruneOwners(z)
 }
 
-func (s *fsRecordStore) Add(entry, txt string) error {
+func (s *fsRecordStore) Add(entry, txt, owner string) error {
+	s.l.Lock()
+	defer s.l.Unlock()
 	z, err := s.read()
 	if err != nil {
 		return err
 	}
 	fqdn := fmt.Sprintf("%s.%s.", entry, s.zone)
-	z.CreateOrReplaceTxtRecord(fqdn, txt)
+	rr := z.CreateOrReplaceTxtRecord(fqdn, txt)
 	// for _, ip := range s.publicIP {
 	// 	z.CreateARecord(fqdn, ip)
 	// }
-	return s.write(z)
+	if err := s.write(z); err != nil {
+		return err
+	}
+	return s.setOwner([]dns.RR{rr}, owner)
 }
 
-func (s *fsRecordStore) AddARecord(entry, ip string) error {
+func (s *fsRecordStore) AddARecord(entry, ip, owner string) error {
+	s.l.Lock()
+	defer s.l.Unlock()
 	z, err := s.read()
 	if err != nil {
 		return err
 	}
 	fqdn := fmt.Sprintf("%s.%s.", entry, s.zone)
-	z.CreateARecord(fqdn, ip)
+	rr := z.CreateARecord(fqdn, ip)
 	// for _, ip := range s.publicIP {
 	// 	z.CreateARecord(fqdn, ip)
 	// }
-	return s.write(z)
+	if err := s.write(z); err != nil {
+		return err
+	}
+	return s.setOwner([]dns.RR{rr}, owner)
 }
 
 func (s *fsRecordStore) Delete(entry, txt string) error {
+	s.l.Lock()
+	defer s.l.Unlock()
 	z, err := s.read()
 	if err != nil {
 		return err
@@ -87,6 +154,8 @@ func (s *fsRecordStore) Delete(entry, txt string) error {
 }
 
 func (s *fsRecordStore) DeleteARecord(entry, ip string) error {
+	s.l.Lock()
+	defer s.l.Unlock()
 	z, err := s.read()
 	if err != nil {
 		return err
@@ -98,3 +167,161 @@ func (s *fsRecordStore) DeleteARecord(entry, ip string) error {
 	// z.DeleteRecordsFor(fqdn)
 	return s.write(z)
 }
+
+func (s *fsRecordStore) List(name, rrtype string) ([]Record, error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	var fqdnFilter string
+	if name != "" {
+		var err error
+		if fqdnFilter, err = fqdn(name, s.zone); err != nil {
+			return nil, err
+		}
+	}
+	var typeFilter uint16
+	if rrtype != "" {
+		var err error
+		if typeFilter, err = parseType(rrtype); err != nil {
+			return nil, err
+		}
+	}
+	z, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	owners, err := s.readOwners()
+	if err != nil {
+		return nil, err
+	}
+	ret := []Record{}
+	for _, rr := range z.Records() {
+		hdr := rr.Header()
+		if fqdnFilter != "" && !strings.EqualFold(hdr.Name, fqdnFilter) {
+			continue
+		}
+		if typeFilter != 0 && hdr.Rrtype != typeFilter {
+			continue
+		}
+		if r, ok := recordFromRR(rr, s.zone); ok {
+			r.Owner = owners[rrKey(rr)]
+			ret = append(ret, r)
+		}
+	}
+	return ret, nil
+}
+
+func (s *fsRecordStore) Create(r Record) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	rr, err := r.ToRR(s.zone)
+	if err != nil {
+		return err
+	}
+	z, err := s.read()
+	if err != nil {
+		return err
+	}
+	if err := z.AddRR(rr); err != nil {
+		return err
+	}
+	if err := s.write(z); err != nil {
+		return err
+	}
+	return s.setOwner([]dns.RR{rr}, r.Owner)
+}
+
+// Replace replaces all records with given name and type, records are expected
+// to have the same name and type.
+func (s *fsRecordStore) Replace(name, rrtype string, records []Record) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	fqdnName, err := fqdn(name, s.zone)
+	if err != nil {
+		return err
+	}
+	t, err := parseType(rrtype)
+	if err != nil {
+		return err
+	}
+	rrs := make([]dns.RR, 0, len(records))
+	owners := map[string][]dns.RR{}
+	for _, r := range records {
+		r.Name = name
+		r.Type = rrtype
+		rr, err := r.ToRR(s.zone)
+		if err != nil {
+			return err
+		}
+		rrs = append(rrs, rr)
+		owners[r.Owner] = append(owners[r.Owner], rr)
+	}
+	z, err := s.read()
+	if err != nil {
+		return err
+	}
+	if err := z.ReplaceRRSet(fqdnName, t, rrs); err != nil {
+		return err
+	}
+	if err := s.write(z); err != nil {
+		return err
+	}
+	for owner, rrs := range owners {
+		if err := s.setOwner(rrs, owner); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fsRecordStore) DeleteRRSet(name, rrtype string) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	fqdnName, err := fqdn(name, s.zone)
+	if err != nil {
+		return err
+	}
+	t, err := parseType(rrtype)
+	if err != nil {
+		return err
+	}
+	z, err := s.read()
+	if err != nil {
+		return err
+	}
+	if err := z.DeleteRRSet(fqdnName, t); err != nil {
+		return err
+	}
+	return s.write(z)
+}
+
+// SetKeys signs zone with the active keys of the given set, all of which are
+// part of DNSKEY set. Zone gets a new serial so that CoreDNS and secondaries
+// pick up new signatures.
+func (s *fsRecordStore) SetKeys(keys []ManagedKey) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.keys = keys
+	if err := writeCoreDNSConfig(s.fs, s.config, s.db, s.zone, keys, s.transfer, s.views); err != nil {
+		return err
+	}
+	z, err := s.read()
+	if err != nil {
+		return err
+	}
+	if _, err := dropDNSKEYs(z, s.zone); err != nil {
+		return err
+	}
+	return s.write(z)
+}
+
+// dropDNSKEYs deletes DNSKEY records served from the zone file by older
+// versions, DNSKEY set is added to the signed copy of the zone instead.
+func dropDNSKEYs(z *RecordsFile, zone string) (bool, error) {
+	if err := z.DeleteRRSet(dns.Fqdn(zone), dns.TypeDNSKEY); err != nil {
+		if errors.Is(err, ErrorNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
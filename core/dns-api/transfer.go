@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+const tsigKeyFile = "tsig-key.json"
+
+// TransferConfig lists secondary nameservers allowed to transfer the zone.
+// CoreDNS sends NOTIFY to each of them whenever it reloads zone with the new
+// serial, which happens on every change made through the API.
+type TransferConfig struct {
+	Secondaries []string `json:"secondaries"`
+	// When set, transfers must be signed with the key.
+	TSIG *TSIGKey `json:"tsig,omitempty"`
+}
+
+type TSIGKey struct {
+	Name      string `json:"name"`
+	Algorithm string `json:"algorithm"`
+	Secret    string `json:"secret,omitempty"`
+}
+
+// WithoutSecret returns the key stripped of its secret, which is only shared
+// with secondaries and clients out of band through the key file.
+func (k TSIGKey) WithoutSecret() TSIGKey {
+	k.Secret = ""
+	return k
+}
+
+// NewTransferConfig validates secondary addresses, optionally generating
+// TSIG key shared with secondaries on the first run.
+func NewTransferConfig(fs FS, zone string, secondaries []string, tsig bool) (TransferConfig, error) {
+	ret := TransferConfig{Secondaries: []string{}}
+	for _, s := range secondaries {
+		if s == "" {
+			continue
+		}
+		host := s
+		if h, _, err := net.SplitHostPort(s); err == nil {
+			host = h
+		}
+		if net.ParseIP(host) == nil {
+			return TransferConfig{}, fmt.Errorf("invalid secondary nameserver address: %s", s)
+		}
+		ret.Secondaries = append(ret.Secondaries, s)
+	}
+	if tsig {
+		k, err := getTSIGKey(fs, tsigKeyFile, fmt.Sprintf("transfer.%s", dns.Fqdn(zone)))
+		if err != nil {
+			return TransferConfig{}, err
+		}
+		ret.TSIG = &k
+	}
+	return ret, nil
+}
+
+// getTSIGKey reads key from the given file, generating new one on the first
+// run.
+func getTSIGKey(fs FS, path string, name string) (TSIGKey, error) {
+	ok, err := fs.Exists(path)
+	if err != nil {
+		return TSIGKey{}, err
+	}
+	if ok {
+		d, err := fs.Read(path)
+		if err != nil {
+			return TSIGKey{}, err
+		}
+		var k TSIGKey
+		if err := json.Unmarshal([]byte(d), &k); err != nil {
+			return TSIGKey{}, err
+		}
+		return k, nil
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return TSIGKey{}, err
+	}
+	k := TSIGKey{
+		Name:      name,
+		Algorithm: dns.HmacSHA256,
+		Secret:    base64.StdEncoding.EncodeToString(secret),
+	}
+	d, err := json.MarshalIndent(k, "", "\t")
+	if err != nil {
+		return TSIGKey{}, err
+	}
+	if err := fs.Write(path, string(d)); err != nil {
+		return TSIGKey{}, err
+	}
+	return k, nil
+}
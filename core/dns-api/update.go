@@ -0,0 +1,319 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const updateTSIGKeyFile = "update-tsig-key.json"
+
+// UpdateError makes dynamic update fail with given response code.
+type UpdateError struct {
+	Rcode int
+	msg   string
+}
+
+func (e *UpdateError) Error() string {
+	return fmt.Sprintf("%s: %s", dns.RcodeToString[e.Rcode], e.msg)
+}
+
+func updateErrorf(rcode int, format string, a ...any) error {
+	return &UpdateError{rcode, fmt.Sprintf(format, a...)}
+}
+
+func NewUpdateTSIGKey(fs FS, zone string) (TSIGKey, error) {
+	return getTSIGKey(fs, updateTSIGKeyFile, fmt.Sprintf("update.%s", dns.Fqdn(zone)))
+}
+
+// Update processes prerequisite and update sections of RFC 2136 UPDATE
+// message. Either all updates are applied or none of them, only record types
+// supported by the JSON API can be changed.
+func (s *fsRecordStore) Update(prereq []dns.RR, updates []dns.RR) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	z, err := s.read()
+	if err != nil {
+		return err
+	}
+	if err := s.checkPrerequisites(z, prereq); err != nil {
+		return err
+	}
+	if err := s.checkUpdates(updates); err != nil {
+		return err
+	}
+	changed := false
+	for _, rr := range updates {
+		hdr := rr.Header()
+		switch hdr.Class {
+		case dns.ClassINET:
+			if err := z.checkCNAMEConflict(hdr.Name, hdr.Rrtype); err != nil {
+				// RFC 2136 3.4.2.2: such updates are silently ignored.
+				continue
+			}
+			if hdr.Rrtype == dns.TypeCNAME {
+				// Name can have single CNAME only, new one replaces it.
+				if err := z.ReplaceRRSet(hdr.Name, hdr.Rrtype, []dns.RR{rr}); err != nil {
+					return err
+				}
+			} else {
+				// Duplicate replaces existing record, updating its TTL.
+				z.DeleteRR(rr)
+				if err := z.AddRR(rr); err != nil {
+					return err
+				}
+			}
+			changed = true
+		case dns.ClassANY:
+			types := []uint16{hdr.Rrtype}
+			if hdr.Rrtype == dns.TypeANY {
+				types = supportedTypeList()
+			}
+			for _, t := range types {
+				if err := z.DeleteRRSet(hdr.Name, t); err == nil {
+					changed = true
+				}
+			}
+		case dns.ClassNONE:
+			del := dns.Copy(rr)
+			del.Header().Class = dns.ClassINET
+			if z.DeleteRR(del) {
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return s.write(z)
+}
+
+type rrSetPrereq struct {
+	name   string
+	rrtype uint16
+	rrs    map[string]struct{}
+}
+
+// RFC 2136 3.2
+func (s *fsRecordStore) checkPrerequisites(z *RecordsFile, prereq []dns.RR) error {
+	// Value dependent prerequisites, grouped by RRset.
+	expected := map[string]*rrSetPrereq{}
+	for _, rr := range prereq {
+		hdr := rr.Header()
+		if hdr.Ttl != 0 {
+			return updateErrorf(dns.RcodeFormatError, "prerequisite must have zero TTL: %s", rr)
+		}
+		if !s.inZone(hdr.Name) {
+			return updateErrorf(dns.RcodeNotZone, "%s is out of %s zone", hdr.Name, s.zone)
+		}
+		set := z.rrSet(hdr.Name, hdr.Rrtype)
+		switch hdr.Class {
+		case dns.ClassANY:
+			if hdr.Rdlength != 0 {
+				return updateErrorf(dns.RcodeFormatError, "prerequisite must not have data: %s", rr)
+			}
+			if len(set) == 0 {
+				if hdr.Rrtype == dns.TypeANY {
+					return updateErrorf(dns.RcodeNameError, "%s does not exist", hdr.Name)
+				}
+				return updateErrorf(dns.RcodeNXRrset, "%s %s does not exist", hdr.Name, dns.TypeToString[hdr.Rrtype])
+			}
+		case dns.ClassNONE:
+			if hdr.Rdlength != 0 {
+				return updateErrorf(dns.RcodeFormatError, "prerequisite must not have data: %s", rr)
+			}
+			if len(set) != 0 {
+				if hdr.Rrtype == dns.TypeANY {
+					return updateErrorf(dns.RcodeYXDomain, "%s exists", hdr.Name)
+				}
+				return updateErrorf(dns.RcodeYXRrset, "%s %s exists", hdr.Name, dns.TypeToString[hdr.Rrtype])
+			}
+		case dns.ClassINET:
+			if hdr.Rrtype == dns.TypeANY {
+				return updateErrorf(dns.RcodeFormatError, "invalid prerequisite: %s", rr)
+			}
+			k := fmt.Sprintf("%s/%d", strings.ToLower(hdr.Name), hdr.Rrtype)
+			if _, ok := expected[k]; !ok {
+				expected[k] = &rrSetPrereq{hdr.Name, hdr.Rrtype, map[string]struct{}{}}
+			}
+			expected[k].rrs[rrKey(rr)] = struct{}{}
+		default:
+			return updateErrorf(dns.RcodeFormatError, "invalid prerequisite class: %s", rr)
+		}
+	}
+	for _, p := range expected {
+		actual := map[string]struct{}{}
+		for _, rr := range z.rrSet(p.name, p.rrtype) {
+			actual[rrKey(rr)] = struct{}{}
+		}
+		match := len(actual) == len(p.rrs)
+		for k := range p.rrs {
+			if _, ok := actual[k]; !ok {
+				match = false
+			}
+		}
+		if !match {
+			return updateErrorf(dns.RcodeNXRrset, "%s %s does not match", p.name, dns.TypeToString[p.rrtype])
+		}
+	}
+	return nil
+}
+
+// RFC 2136 3.4.1
+func (s *fsRecordStore) checkUpdates(updates []dns.RR) error {
+	for _, rr := range updates {
+		hdr := rr.Header()
+		if !s.inZone(hdr.Name) {
+			return updateErrorf(dns.RcodeNotZone, "%s is out of %s zone", hdr.Name, s.zone)
+		}
+		switch hdr.Class {
+		case dns.ClassINET:
+			if !isSupportedType(hdr.Rrtype) {
+				return updateErrorf(dns.RcodeRefused, "%s records can not be updated", dns.TypeToString[hdr.Rrtype])
+			}
+		case dns.ClassANY:
+			if hdr.Ttl != 0 || hdr.Rdlength != 0 {
+				return updateErrorf(dns.RcodeFormatError, "invalid delete: %s", rr)
+			}
+			if hdr.Rrtype != dns.TypeANY && !isSupportedType(hdr.Rrtype) {
+				return updateErrorf(dns.RcodeRefused, "%s records can not be updated", dns.TypeToString[hdr.Rrtype])
+			}
+		case dns.ClassNONE:
+			if hdr.Ttl != 0 {
+				return updateErrorf(dns.RcodeFormatError, "invalid delete: %s", rr)
+			}
+			if !isSupportedType(hdr.Rrtype) {
+				return updateErrorf(dns.RcodeRefused, "%s records can not be updated", dns.TypeToString[hdr.Rrtype])
+			}
+		default:
+			return updateErrorf(dns.RcodeFormatError, "invalid update class: %s", rr)
+		}
+	}
+	return nil
+}
+
+func (s *fsRecordStore) inZone(name string) bool {
+	return dns.IsSubDomain(dns.Fqdn(s.zone), name)
+}
+
+func isSupportedType(rrtype uint16) bool {
+	for _, t := range supportedTypes {
+		if t == rrtype {
+			return true
+		}
+	}
+	return false
+}
+
+func supportedTypeList() []uint16 {
+	ret := make([]uint16, 0, len(supportedTypes))
+	for _, t := range supportedTypes {
+		ret = append(ret, t)
+	}
+	return ret
+}
+
+// rrSet returns records with given name and type, ANY matches all types.
+func (z *RecordsFile) rrSet(name string, rrtype uint16) []dns.RR {
+	ret := []dns.RR{}
+	for _, rr := range z.Records() {
+		hdr := rr.Header()
+		if strings.EqualFold(hdr.Name, name) && (rrtype == dns.TypeANY || hdr.Rrtype == rrtype) {
+			ret = append(ret, rr)
+		}
+	}
+	return ret
+}
+
+// rrKey identifies record by its name, type and data, ignoring TTL and
+// class.
+func rrKey(rr dns.RR) string {
+	ret := dns.Copy(rr)
+	hdr := ret.Header()
+	hdr.Name = strings.ToLower(hdr.Name)
+	hdr.Class = dns.ClassINET
+	hdr.Ttl = 0
+	return ret.String()
+}
+
+// UpdateHandler serves RFC 2136 UPDATE messages signed with the TSIG key.
+type UpdateHandler struct {
+	zone  string
+	key   TSIGKey
+	store RecordStore
+}
+
+func NewUpdateHandler(zone string, key TSIGKey, store RecordStore) *UpdateHandler {
+	return &UpdateHandler{dns.Fqdn(zone), key, store}
+}
+
+// ListenAndServe serves updates on both UDP and TCP.
+func (h *UpdateHandler) ListenAndServe(port int) error {
+	errCh := make(chan error, 2)
+	for _, n := range []string{"udp", "tcp"} {
+		srv := &dns.Server{
+			Addr:          fmt.Sprintf(":%d", port),
+			Net:           n,
+			Handler:       h,
+			TsigSecret:    map[string]string{h.key.Name: h.key.Secret},
+			MsgAcceptFunc: acceptUpdates,
+		}
+		go func() {
+			errCh <- srv.ListenAndServe()
+		}()
+	}
+	return <-errCh
+}
+
+// acceptUpdates lets UPDATE messages through, default accept function
+// rejects them as not implemented.
+func acceptUpdates(dh dns.Header) dns.MsgAcceptAction {
+	ret := dns.DefaultMsgAcceptFunc(dh)
+	if opcode := int(dh.Bits>>11) & 0xF; ret == dns.MsgRejectNotImplemented && opcode == dns.OpcodeUpdate {
+		return dns.MsgAccept
+	}
+	return ret
+}
+
+func (h *UpdateHandler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	resp := new(dns.Msg)
+	resp.SetReply(r)
+	defer func() {
+		if err := w.WriteMsg(resp); err != nil {
+			log.Printf("Failed to respond to dynamic update: %s\n", err)
+		}
+	}()
+	if r.Opcode != dns.OpcodeUpdate {
+		resp.Rcode = dns.RcodeNotImplemented
+		return
+	}
+	tsig := r.IsTsig()
+	if tsig == nil || w.TsigStatus() != nil || !strings.EqualFold(tsig.Hdr.Name, h.key.Name) {
+		resp.Rcode = dns.RcodeNotAuth
+		return
+	}
+	resp.SetTsig(tsig.Hdr.Name, tsig.Algorithm, 300, time.Now().Unix())
+	if len(r.Question) != 1 || r.Question[0].Qtype != dns.TypeSOA {
+		resp.Rcode = dns.RcodeFormatError
+		return
+	}
+	if !strings.EqualFold(r.Question[0].Name, h.zone) {
+		resp.Rcode = dns.RcodeNotAuth
+		return
+	}
+	if err := h.store.Update(r.Answer, r.Ns); err != nil {
+		var uerr *UpdateError
+		if errors.As(err, &uerr) {
+			resp.Rcode = uerr.Rcode
+		} else if errors.Is(err, ErrorInvalid) {
+			resp.Rcode = dns.RcodeFormatError
+		} else {
+			log.Printf("Dynamic update failed: %s\n", err)
+			resp.Rcode = dns.RcodeServerFailure
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const acmeChallengeLabel = "_acme-challenge"
+
+// ViewConfig splits the zone into private and public views. Clients from the
+// private networks, such as VPN or cluster pods, see the whole zone, while
+// everyone else is served the public view, which omits names under the
+// private domains. Names present in both views get the same answers, private
+// ingress addresses are only reachable through the private domain names.
+type ViewConfig struct {
+	PrivateCIDRs   []string
+	PrivateDomains []string
+}
+
+func NewViewConfig(zone string, privateCIDRs, privateDomains []string) (ViewConfig, error) {
+	ret := ViewConfig{[]string{}, []string{}}
+	for _, c := range privateCIDRs {
+		if c == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(c); err != nil {
+			return ViewConfig{}, err
+		}
+		ret.PrivateCIDRs = append(ret.PrivateCIDRs, c)
+	}
+	for _, d := range privateDomains {
+		if d == "" {
+			continue
+		}
+		d = dns.Fqdn(d)
+		if !dns.IsSubDomain(dns.Fqdn(zone), d) || d == dns.Fqdn(zone) {
+			return ViewConfig{}, fmt.Errorf("private domain %s must be a subdomain of %s", d, zone)
+		}
+		ret.PrivateDomains = append(ret.PrivateDomains, d)
+	}
+	if len(ret.PrivateDomains) > 0 && len(ret.PrivateCIDRs) == 0 {
+		return ViewConfig{}, fmt.Errorf("private domains require private CIDRs to serve them to")
+	}
+	return ret, nil
+}
+
+func (v ViewConfig) Enabled() bool {
+	return len(v.PrivateCIDRs) > 0
+}
+
+// IsPrivate returns true if record must be hidden from the public view. ACME
+// challenges are always public, as certificate authorities validate
+// certificates of the private domains from the internet.
+func (v ViewConfig) IsPrivate(rr dns.RR) bool {
+	name := rr.Header().Name
+	if rr.Header().Rrtype == dns.TypeTXT && strings.HasPrefix(strings.ToLower(name), acmeChallengeLabel+".") {
+		return false
+	}
+	for _, d := range v.PrivateDomains {
+		if dns.IsSubDomain(d, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// publicViewPath returns path of the zone file served to public clients when
+// views are enabled.
+func publicViewPath(db string) string {
+	return fmt.Sprintf("%s.public", db)
+}
+
+// writePublicView renders public view of the zone, leaving private records
+// out. SOA is copied as is, so that serial of both views changes together.
+func (s *fsRecordStore) writePublicView(z *RecordsFile) error {
+	if !s.views.Enabled() {
+		return nil
+	}
+	return writeRecords(s.fs, publicViewPath(s.db), s.publicRecords(z))
+}
+
+func (s *fsRecordStore) publicRecords(z *RecordsFile) []dns.RR {
+	ret := []dns.RR{}
+	for _, rr := range z.Records() {
+		if !s.views.IsPrivate(rr) {
+			ret = append(ret, rr)
+		}
+	}
+	return ret
+}
@@ -4,6 +4,7 @@ go 1.18
 
 require (
 	github.com/cert-manager/cert-manager v1.13.2
+	github.com/miekg/dns v1.1.55
 	k8s.io/apiextensions-apiserver v0.28.4
 	k8s.io/client-go v0.28.4
 )
@@ -111,6 +111,12 @@ type pcloudDNSProviderSolver struct {
 type pcloudDNSProviderConfig struct {
 	CreateAddress string `json:"createTXTAddr,omitempty"`
 	DeleteAddress string `json:"deleteTXTAddr,omitempty"`
+	// Zone managed by the addresses above, defaults to the zone cert-manager
+	// resolved for the challenge.
+	Zone string `json:"zone,omitempty"`
+	// Zones of other environments, challenges are routed to the most
+	// specific zone the challenge record belongs to.
+	Zones []zoneConfig `json:"zones,omitempty"`
 }
 
 // Name is used as the name for this DNS solver when referencing it on the ACME
@@ -135,8 +141,11 @@ func (c *pcloudDNSProviderSolver) Present(ch *v1alpha1.ChallengeRequest) error {
 		return err
 	}
 	fmt.Printf("API config: %+v\n", cfg)
-	zm := &zoneControllerManager{cfg.CreateAddress, cfg.DeleteAddress}
-	domain, entry := getDomainAndEntry(ch)
+	zm, domain, entry, err := resolveChallenge(ch, cfg, lookupCNAME)
+	if err != nil {
+		fmt.Printf("Failed to resolve challenge zone: %s\n", err.Error())
+		return err
+	}
 	fmt.Printf("%s %s\n", domain, entry)
 	err = zm.CreateTextRecord(domain, entry, ch.Key)
 	if err != nil {
@@ -158,8 +167,11 @@ func (c *pcloudDNSProviderSolver) CleanUp(ch *v1alpha1.ChallengeRequest) error {
 		return err
 	}
 	fmt.Printf("API config: %+v\n", cfg)
-	zm := &zoneControllerManager{cfg.CreateAddress, cfg.DeleteAddress}
-	domain, entry := getDomainAndEntry(ch)
+	zm, domain, entry, err := resolveChallenge(ch, cfg, lookupCNAME)
+	if err != nil {
+		fmt.Printf("Failed to resolve challenge zone: %s\n", err.Error())
+		return err
+	}
 	err = zm.DeleteTextRecord(domain, entry, ch.Key)
 	if err != nil {
 		fmt.Printf("Failed to delete TXT record: %s\n", err.Error())
@@ -202,11 +214,3 @@ func loadConfig(cfgJSON *extapi.JSON) (pcloudDNSProviderConfig, error) {
 
 	return cfg, nil
 }
-
-func getDomainAndEntry(ch *v1alpha1.ChallengeRequest) (string, string) {
-	// Both ch.ResolvedZone and ch.ResolvedFQDN end with a dot: '.'
-	resolvedFQDN := strings.TrimSuffix(ch.ResolvedFQDN, ".")
-	domain := strings.Join(strings.Split(strings.TrimSuffix(ch.DNSName, "."), ".")[1:], ".")
-	entry := strings.TrimSuffix(resolvedFQDN, domain)
-	return strings.TrimSuffix(domain, "."), strings.TrimSuffix(entry, ".")
-}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"github.com/cert-manager/cert-manager/pkg/issuer/acme/dns/util"
+)
+
+// Upper bound on the CNAME chain length, protects from loops.
+const maxCNAMEHops = 8
+
+// zoneConfig points to the dns-api managing the zone.
+type zoneConfig struct {
+	Zone          string `json:"zone"`
+	CreateAddress string `json:"createTXTAddr"`
+	DeleteAddress string `json:"deleteTXTAddr"`
+}
+
+// zones returns all explicitly configured zones, top level addresses are
+// treated as one more zone if it is set.
+func (c pcloudDNSProviderConfig) zones() []zoneConfig {
+	ret := []zoneConfig{}
+	for _, z := range c.Zones {
+		z.Zone = dns.Fqdn(z.Zone)
+		ret = append(ret, z)
+	}
+	if c.Zone != "" {
+		ret = append(ret, zoneConfig{dns.Fqdn(c.Zone), c.CreateAddress, c.DeleteAddress})
+	}
+	return ret
+}
+
+// findZone returns the most specific zone fqdn belongs to.
+func findZone(zones []zoneConfig, fqdn string) (zoneConfig, bool) {
+	var ret zoneConfig
+	found := false
+	for _, z := range zones {
+		if dns.IsSubDomain(z.Zone, fqdn) && (!found || dns.CountLabel(z.Zone) > dns.CountLabel(ret.Zone)) {
+			ret = z
+			found = true
+		}
+	}
+	return ret, found
+}
+
+type cnameResolver func(fqdn string) (string, error)
+
+// lookupCNAME returns target of the CNAME record of fqdn if it has one.
+func lookupCNAME(fqdn string) (string, error) {
+	r, err := util.DNSQuery(fqdn, dns.TypeCNAME, util.RecursiveNameservers, true)
+	if err != nil {
+		return "", err
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		return "", nil
+	}
+	for _, rr := range r.Answer {
+		if cn, ok := rr.(*dns.CNAME); ok && strings.EqualFold(cn.Hdr.Name, fqdn) {
+			return cn.Target, nil
+		}
+	}
+	return "", nil
+}
+
+// resolveChallenge finds zone manager responsible for the challenge, and
+// returns it together with the zone and entry, relative to the zone, TXT
+// record must be created at. Challenges of the domains not managed by any of
+// the configured zones are followed through the _acme-challenge CNAME
+// delegation, which is how custom domains are validated. If that does not
+// lead to any of them either, top level addresses without zone are used with
+// the zone cert-manager resolved.
+func resolveChallenge(ch *v1alpha1.ChallengeRequest, cfg pcloudDNSProviderConfig, lookup cnameResolver) (ZoneManager, string, string, error) {
+	fqdn := dns.Fqdn(strings.ToLower(ch.ResolvedFQDN))
+	z, fqdn, err := followToZone(cfg.zones(), fqdn, lookup)
+	if err != nil {
+		if cfg.Zone != "" || cfg.CreateAddress == "" {
+			return nil, "", "", err
+		}
+		z = zoneConfig{dns.Fqdn(strings.ToLower(ch.ResolvedZone)), cfg.CreateAddress, cfg.DeleteAddress}
+		fqdn = dns.Fqdn(strings.ToLower(ch.ResolvedFQDN))
+		if !dns.IsSubDomain(z.Zone, fqdn) {
+			return nil, "", "", fmt.Errorf("%s is out of %s zone", fqdn, z.Zone)
+		}
+	}
+	entry := strings.TrimSuffix(strings.TrimSuffix(fqdn, z.Zone), ".")
+	return &zoneControllerManager{z.CreateAddress, z.DeleteAddress}, strings.TrimSuffix(z.Zone, "."), entry, nil
+}
+
+// followToZone follows CNAME chain starting at fqdn until it reaches one of
+// the zones.
+func followToZone(zones []zoneConfig, fqdn string, lookup cnameResolver) (zoneConfig, string, error) {
+	chain := []string{}
+	for {
+		if z, ok := findZone(zones, fqdn); ok {
+			return z, fqdn, nil
+		}
+		chain = append(chain, fqdn)
+		if len(zones) == 0 {
+			return zoneConfig{}, "", fmt.Errorf("no zone is configured for %s", fqdn)
+		}
+		if len(chain) > maxCNAMEHops {
+			return zoneConfig{}, "", fmt.Errorf("CNAME chain is too long: %s", strings.Join(chain, " -> "))
+		}
+		target, err := lookup(fqdn)
+		if err != nil {
+			return zoneConfig{}, "", err
+		}
+		if target == "" {
+			return zoneConfig{}, "", fmt.Errorf("no zone is configured for %s", strings.Join(chain, " -> "))
+		}
+		fqdn = dns.Fqdn(strings.ToLower(target))
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+)
+
+func TestResolveChallenge(t *testing.T) {
+	cnames := map[string]string{
+		"_acme-challenge.custom.com.":    "_acme-challenge.app.t40.lekva.me.",
+		"_acme-challenge.loop.com.":      "_acme-challenge.loop2.com.",
+		"_acme-challenge.loop2.com.":     "_acme-challenge.loop.com.",
+		"_acme-challenge.other.t41.me.":  "_acme-challenge.other.p.t40.lekva.me.",
+		"_acme-challenge.unmanaged.com.": "_acme-challenge.unmanaged.net.",
+		"_acme-challenge.unmanaged.net.": "",
+	}
+	lookup := func(fqdn string) (string, error) {
+		return cnames[fqdn], nil
+	}
+	cfg := pcloudDNSProviderConfig{
+		CreateAddress: "http://t40/create",
+		DeleteAddress: "http://t40/delete",
+		Zone:          "t40.lekva.me",
+		Zones: []zoneConfig{
+			{"t42.lekva.me", "http://t42/create", "http://t42/delete"},
+		},
+	}
+	for _, test := range []struct {
+		fqdn   string
+		zone   string
+		entry  string
+		create string
+	}{
+		{"_acme-challenge.app.t40.lekva.me.", "t40.lekva.me", "_acme-challenge.app", "http://t40/create"},
+		{"_acme-challenge.p.t40.lekva.me.", "t40.lekva.me", "_acme-challenge.p", "http://t40/create"},
+		{"_acme-challenge.app.t42.lekva.me.", "t42.lekva.me", "_acme-challenge.app", "http://t42/create"},
+		{"_acme-challenge.custom.com.", "t40.lekva.me", "_acme-challenge.app", "http://t40/create"},
+		{"_acme-challenge.other.t41.me.", "t40.lekva.me", "_acme-challenge.other.p", "http://t40/create"},
+	} {
+		zm, zone, entry, err := resolveChallenge(&v1alpha1.ChallengeRequest{ResolvedFQDN: test.fqdn}, cfg, lookup)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if zone != test.zone || entry != test.entry || zm.(*zoneControllerManager).CreateAddr != test.create {
+			t.Fatalf("%s: expected %s %s at %s, got %s %s at %+v", test.fqdn, test.entry, test.zone, test.create, entry, zone, zm)
+		}
+	}
+	for _, fqdn := range []string{"_acme-challenge.unmanaged.com.", "_acme-challenge.loop.com."} {
+		if _, _, _, err := resolveChallenge(&v1alpha1.ChallengeRequest{ResolvedFQDN: fqdn}, cfg, lookup); err == nil {
+			t.Fatalf("%s: expected error", fqdn)
+		}
+	}
+	// Without zone, addresses manage the zone resolved by cert-manager.
+	legacy := pcloudDNSProviderConfig{CreateAddress: "http://t40/create", DeleteAddress: "http://t40/delete"}
+	ch := &v1alpha1.ChallengeRequest{ResolvedFQDN: "_acme-challenge.foo.bar.t40.lekva.me.", ResolvedZone: "t40.lekva.me."}
+	if _, zone, entry, err := resolveChallenge(ch, legacy, lookup); err != nil {
+		t.Fatal(err)
+	} else if zone != "t40.lekva.me" || entry != "_acme-challenge.foo.bar" {
+		t.Fatalf("unexpected %s %s", entry, zone)
+	}
+}
@@ -34,9 +34,17 @@ func (c *client) createUser(name string) error {
 	return err
 }
 
-func (c *client) createPreAuthKey(user string) (string, error) {
+func (c *client) createPreAuthKey(user string, tags []string) (string, error) {
 	// TODO(giolekva): make expiration configurable, and auto-refresh
-	cmd := exec.Command("headscale", c.config, "--user", user, "preauthkeys", "create", "--reusable", "--expiration", "365d")
+	args := []string{c.config, "--user", user, "preauthkeys", "create", "--reusable", "--expiration", "365d"}
+	if len(tags) > 0 {
+		var aclTags []string
+		for _, t := range tags {
+			aclTags = append(aclTags, fmt.Sprintf("tag:%s", t))
+		}
+		args = append(args, "--tags", strings.Join(aclTags, ","))
+	}
+	cmd := exec.Command("headscale", args...)
 	out, err := cmd.Output()
 	fmt.Println(string(out))
 	if err != nil {
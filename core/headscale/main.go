@@ -13,6 +13,7 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 
@@ -26,11 +27,25 @@ var config = flag.String("config", "", "Path to headscale config")
 var acls = flag.String("acls", "", "Path to the headscale acls file")
 var ipSubnet = flag.String("ip-subnet", "10.1.0.0/24", "IP subnet of the private network")
 var fetchUsersAddr = flag.String("fetch-users-addr", "", "API endpoint to fetch user data")
+var fetchGroupsAddr = flag.String("fetch-groups-addr", "", "API endpoint to fetch groups and their members")
+var policy = flag.String("policy", "", "Path to the ACL policy file")
 var self = flag.String("self", "", "Self address")
+var policyPort = flag.Int("policy-port", 3001, "Port to serve ACL policy editor on")
+var policyAdminGroups = flag.String("policy-admin-groups", "admin", "Comma separated list of groups allowed to edit ACL policy")
 
 // TODO(gio): make internal network cidr and proxy user configurable
 const defaultACLs = `
 {
+  "groups": {
+    {{- range .policy.Groups }}
+    "group:{{ .Name }}": [{{ range $i, $m := .Members }}{{ if $i }}, {{ end }}{{ printf "%q" $m }}{{ end }}],
+    {{- end }}
+  },
+  "tagOwners": {
+    {{- range .policy.Tags }}
+    "tag:{{ .Name }}": [{{ range $i, $o := .Owners }}{{ if $i }}, {{ end }}{{ printf "%q" $o }}{{ end }}],
+    {{- end }}
+  },
   "autoApprovers": {
     "routes": {
       {{- range .cidrs }}
@@ -44,28 +59,39 @@ const defaultACLs = `
     //   "src": ["10.42.0.0/16", "10.43.0.0/16", "135.181.48.180/32", "65.108.39.172/32"],
     //   "dst": ["10.42.0.0/16:*", "10.43.0.0/16:*", "135.181.48.180/32:*", "65.108.39.172/32:*"],
     // },
+    // Tagged nodes are not part of their owner anymore, so rules granting
+    // access to the owner list its tags explicitly.
+    {{- $proxyTags := index .policy.Owned "private-network-proxy" }}
     {{- range .cidrs }}
     { // Everyone has passthough access to private-network-proxy node
       "action": "accept",
       "src": ["*"],
-      "dst": ["{{ . }}:*", "private-network-proxy:0"],
+      "dst": ["{{ . }}:*", "private-network-proxy:0"{{ range $proxyTags }}, "tag:{{ . }}:0"{{ end }}],
     },
     {{- end }}
     { // Everyone has access to every port of nodes owned by private-network-proxy
       "action": "accept",
       "src": ["*"],
-      "dst": ["private-network-proxy:*"],
+      "dst": ["private-network-proxy:*"{{ range $proxyTags }}, "tag:{{ . }}:*"{{ end }}],
     },
     {
       "action": "accept",
-      "src": ["private-network-proxy"],
-      "dst": ["private-network-proxy:*"],
+      "src": ["private-network-proxy"{{ range $proxyTags }}, "tag:{{ . }}"{{ end }}],
+      "dst": ["private-network-proxy:*"{{ range $proxyTags }}, "tag:{{ . }}:*"{{ end }}],
     },
-    {{- range .users }}
+    {{- range $u := .users }}
+    {{- $tags := index $.policy.Owned $u }}
+    {
+      "action": "accept",
+      "src": ["{{ $u }}"{{ range $tags }}, "tag:{{ . }}"{{ end }}],
+      "dst": ["{{ $u }}:*"{{ range $tags }}, "tag:{{ . }}:*"{{ end }}],
+    },
+    {{- end }}
+    {{- range .policy.Rules }}
     {
       "action": "accept",
-      "src": ["{{ . }}"],
-      "dst": ["{{ . }}:*"],
+      "src": ["{{ .Src }}"],
+      "dst": ["{{ .Dst }}:{{ .Ports }}"],
     },
     {{- end }}
   ],
@@ -73,24 +99,37 @@ const defaultACLs = `
 `
 
 type server struct {
-	port           int
-	client         *client
-	fetchUsersAddr string
-	self           string
-	aclsPath       string
-	aclsReloadPath string
-	cidrs          []string
+	port            int
+	policyPort      int
+	client          *client
+	fetchUsersAddr  string
+	fetchGroupsAddr string
+	self            string
+	aclsPath        string
+	aclsReloadPath  string
+	cidrs           []string
+	policy          *policyStore
+	adminGroups     []string
+	// Last synced users and groups, ACLs are regenerated from them on
+	// policy changes.
+	mu     sync.Mutex
+	users  []string
+	groups []group
 }
 
-func newServer(port int, client *client, fetchUsersAddr, self, aclsPath string, cidrs []string) *server {
+func newServer(port, policyPort int, client *client, fetchUsersAddr, fetchGroupsAddr, self, aclsPath string, cidrs []string, policy *policyStore, adminGroups []string) *server {
 	return &server{
-		port,
-		client,
-		fetchUsersAddr,
-		self,
-		aclsPath,
-		fmt.Sprintf("%s-reload", aclsPath), // TODO(gio): take from the flag
-		cidrs,
+		port:            port,
+		policyPort:      policyPort,
+		client:          client,
+		fetchUsersAddr:  fetchUsersAddr,
+		fetchGroupsAddr: fetchGroupsAddr,
+		self:            self,
+		aclsPath:        aclsPath,
+		aclsReloadPath:  fmt.Sprintf("%s-reload", aclsPath), // TODO(gio): take from the flag
+		cidrs:           cidrs,
+		policy:          policy,
+		adminGroups:     adminGroups,
 	}
 }
 
@@ -109,6 +148,7 @@ func (s *server) start() error {
 	r.HandleFunc("/user/{user}/node/{node}", s.removeUserNode).Methods(http.MethodDelete)
 	r.HandleFunc("/user", s.createUser).Methods(http.MethodPost)
 	r.HandleFunc("/routes/{id}/enable", s.enableRoute).Methods(http.MethodPost)
+	r.HandleFunc("/tag/{tag}", s.removeAppTag).Methods(http.MethodDelete)
 	go func() {
 		rand.Seed(uint64(time.Now().UnixNano()))
 		s.syncUsers()
@@ -118,7 +158,14 @@ func (s *server) start() error {
 			s.syncUsers()
 		}
 	}()
-	return http.ListenAndServe(fmt.Sprintf(":%d", s.port), r)
+	e := make(chan error)
+	go func() {
+		e <- http.ListenAndServe(fmt.Sprintf(":%d", s.policyPort), s.policyRouter())
+	}()
+	go func() {
+		e <- http.ListenAndServe(fmt.Sprintf(":%d", s.port), r)
+	}()
+	return <-e
 }
 
 type createUserReq struct {
@@ -137,13 +184,52 @@ func (s *server) createUser(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// appTagPrefix is the prefix of the tags app instance nodes are tagged with.
+const appTagPrefix = "app-"
+
+type createPreAuthKeyReq struct {
+	// Tags assigned to the nodes registered with the key, user becomes
+	// owner of the app instance ones.
+	Tags []string `json:"tags,omitempty"`
+}
+
 func (s *server) createReusablePreAuthKey(w http.ResponseWriter, r *http.Request) {
 	user, ok := mux.Vars(r)["user"]
 	if !ok {
 		http.Error(w, "no user", http.StatusBadRequest)
 		return
 	}
-	if key, err := s.client.createPreAuthKey(user); err != nil {
+	var req createPreAuthKeyReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, t := range req.Tags {
+		// NOTE(gio): Only app instance tags are created on demand, others
+		// are managed through the policy editor and must already be owned
+		// by the user.
+		var err error
+		if strings.HasPrefix(t, appTagPrefix) {
+			_, err = s.policy.addTagOwner(t, user)
+		} else {
+			err = s.policy.checkTagOwner(t, user)
+		}
+		if err != nil {
+			if errors.Is(err, ErrorInvalid) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			} else {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+	if len(req.Tags) > 0 {
+		if err := s.applyACLs(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if key, err := s.client.createPreAuthKey(user, req.Tags); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	} else {
@@ -151,6 +237,27 @@ func (s *server) createReusablePreAuthKey(w http.ResponseWriter, r *http.Request
 	}
 }
 
+// removeAppTag deletes the tag of the removed app instance together with the
+// rules referring to it.
+func (s *server) removeAppTag(w http.ResponseWriter, r *http.Request) {
+	tag := mux.Vars(r)["tag"]
+	if !strings.HasPrefix(tag, appTagPrefix) {
+		http.Error(w, fmt.Sprintf("not an app tag: %s", tag), http.StatusBadRequest)
+		return
+	}
+	if _, err := s.policy.removeTag(tag); err != nil {
+		if errors.Is(err, ErrorNotFound) {
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := s.applyACLs(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
 type expirePreAuthKeyReq struct {
 	AuthKey string `json:"authKey"`
 }
@@ -235,20 +342,66 @@ func (s *server) syncUsers() {
 			continue
 		}
 	}
-	currentACLs, err := ioutil.ReadFile(s.aclsPath)
+	groups, err := s.fetchGroups()
+	s.mu.Lock()
+	s.users = usernames
 	if err != nil {
+		// Keep previously synced groups.
 		fmt.Println(err)
+	} else {
+		s.groups = groups
 	}
-	newACLs, err := updateACLs(s.aclsPath, s.cidrs, usernames)
-	if err != nil {
+	s.mu.Unlock()
+	if err := s.applyACLs(); err != nil {
 		fmt.Println(err)
 		panic(err)
 	}
+}
+
+func (s *server) fetchGroups() ([]group, error) {
+	if s.fetchGroupsAddr == "" {
+		return []group{}, nil
+	}
+	resp, err := http.Get(fmt.Sprintf("%s?selfAddress=%s/sync-users", s.fetchGroupsAddr, s.self))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var b strings.Builder
+		io.Copy(&b, resp.Body)
+		return nil, fmt.Errorf("fetching groups failed: %d %s", resp.StatusCode, b.String())
+	}
+	groups := []group{}
+	if err := json.NewDecoder(resp.Body).Decode(&groups); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// applyACLs regenerates ACLs from the last synced users and groups, and the
+// current policy. Headscale is reloaded if they changed.
+func (s *server) applyACLs() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, err := s.policy.get()
+	if err != nil {
+		return err
+	}
+	currentACLs, err := ioutil.ReadFile(s.aclsPath)
+	if err != nil {
+		fmt.Println(err)
+	}
+	newACLs, err := updateACLs(s.aclsPath, s.cidrs, s.users, renderPolicy(p, s.groups))
+	if err != nil {
+		return err
+	}
 	if !bytes.Equal(currentACLs, newACLs) {
 		if err := os.Remove(s.aclsReloadPath); err != nil {
 			fmt.Println(err)
 		}
 	}
+	return nil
 }
 
 func (s *server) enableRoute(w http.ResponseWriter, r *http.Request) {
@@ -290,7 +443,7 @@ func (s *server) getNodeIP(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "%s", addr[0].String())
 }
 
-func updateACLs(aclsPath string, cidrs []string, users []string) ([]byte, error) {
+func updateACLs(aclsPath string, cidrs []string, users []string, policy renderedPolicy) ([]byte, error) {
 	tmpl, err := template.New("acls").Parse(defaultACLs)
 	if err != nil {
 		return nil, err
@@ -302,8 +455,9 @@ func updateACLs(aclsPath string, cidrs []string, users []string) ([]byte, error)
 	defer out.Close()
 	var ret bytes.Buffer
 	if err := tmpl.Execute(io.MultiWriter(out, &ret), map[string]any{
-		"cidrs": cidrs,
-		"users": users,
+		"cidrs":  cidrs,
+		"users":  users,
+		"policy": policy,
 	}); err != nil {
 		return nil, err
 	}
@@ -321,6 +475,12 @@ func main() {
 		cidrs = append(cidrs, cidr.String())
 	}
 	c := newClient(*config)
-	s := newServer(*port, c, *fetchUsersAddr, *self, *acls, cidrs)
+	var adminGroups []string
+	for _, g := range strings.Split(*policyAdminGroups, ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			adminGroups = append(adminGroups, g)
+		}
+	}
+	s := newServer(*port, *policyPort, c, *fetchUsersAddr, *fetchGroupsAddr, *self, *acls, cidrs, newPolicyStore(*policy), adminGroups)
 	log.Fatal(s.start())
 }
@@ -0,0 +1,404 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+var ErrorInvalid = errors.New("invalid")
+
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9\-_.]*$`)
+var validPorts = regexp.MustCompile(`^(\*|[0-9]+(-[0-9]+)?(,[0-9]+(-[0-9]+)?)*)$`)
+
+// aclRule lets source reach destination on given ports. Both source and
+// destination are either group:<name>, tag:<name> or a username.
+type aclRule struct {
+	Src   string `json:"src"`
+	Dst   string `json:"dst"`
+	Ports string `json:"ports"`
+}
+
+// aclPolicy is the user editable part of the ACLs, everything else is
+// generated from the memberships service.
+type aclPolicy struct {
+	Rules []aclRule `json:"rules"`
+	// Tags maps tag name to the users who can assign it to their nodes.
+	Tags map[string][]string `json:"tags"`
+}
+
+type group struct {
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+}
+
+type policyStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+func newPolicyStore(path string) *policyStore {
+	return &policyStore{path: path}
+}
+
+func (s *policyStore) get() (aclPolicy, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read()
+}
+
+func (s *policyStore) read() (aclPolicy, error) {
+	ret := aclPolicy{[]aclRule{}, map[string][]string{}}
+	if s.path == "" {
+		return ret, nil
+	}
+	d, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ret, nil
+	} else if err != nil {
+		return aclPolicy{}, err
+	}
+	if err := json.Unmarshal(d, &ret); err != nil {
+		return aclPolicy{}, err
+	}
+	if ret.Tags == nil {
+		ret.Tags = map[string][]string{}
+	}
+	return ret, nil
+}
+
+// update applies f to the policy and persists the result.
+func (s *policyStore) update(f func(p *aclPolicy) error) (aclPolicy, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, err := s.read()
+	if err != nil {
+		return aclPolicy{}, err
+	}
+	if err := f(&p); err != nil {
+		return aclPolicy{}, err
+	}
+	if s.path == "" {
+		return p, nil
+	}
+	d, err := json.MarshalIndent(p, "", "\t")
+	if err != nil {
+		return aclPolicy{}, err
+	}
+	if err := os.WriteFile(s.path, d, 0644); err != nil {
+		return aclPolicy{}, err
+	}
+	return p, nil
+}
+
+func (s *policyStore) addRule(r aclRule) (aclPolicy, error) {
+	if err := validateAlias(r.Src); err != nil {
+		return aclPolicy{}, err
+	}
+	if err := validateAlias(r.Dst); err != nil {
+		return aclPolicy{}, err
+	}
+	if r.Ports == "" {
+		r.Ports = "*"
+	}
+	if !validPorts.MatchString(r.Ports) {
+		return aclPolicy{}, fmt.Errorf("%w ports: %s", ErrorInvalid, r.Ports)
+	}
+	return s.update(func(p *aclPolicy) error {
+		for _, e := range p.Rules {
+			if e == r {
+				return nil
+			}
+		}
+		if strings.HasPrefix(r.Src, "tag:") {
+			if _, ok := p.Tags[strings.TrimPrefix(r.Src, "tag:")]; !ok {
+				return fmt.Errorf("%w tag: %s", ErrorInvalid, r.Src)
+			}
+		}
+		if strings.HasPrefix(r.Dst, "tag:") {
+			if _, ok := p.Tags[strings.TrimPrefix(r.Dst, "tag:")]; !ok {
+				return fmt.Errorf("%w tag: %s", ErrorInvalid, r.Dst)
+			}
+		}
+		p.Rules = append(p.Rules, r)
+		return nil
+	})
+}
+
+func (s *policyStore) removeRule(i int) (aclPolicy, error) {
+	return s.update(func(p *aclPolicy) error {
+		if i < 0 || i >= len(p.Rules) {
+			return fmt.Errorf("%w rule: %d", ErrorNotFound, i)
+		}
+		p.Rules = append(p.Rules[:i], p.Rules[i+1:]...)
+		return nil
+	})
+}
+
+// addTagOwner creates the tag if it does not exist yet and lets user assign
+// it to their nodes.
+func (s *policyStore) addTagOwner(tag, user string) (aclPolicy, error) {
+	if !validName.MatchString(tag) {
+		return aclPolicy{}, fmt.Errorf("%w tag: %s", ErrorInvalid, tag)
+	}
+	if user != "" && !validName.MatchString(user) {
+		return aclPolicy{}, fmt.Errorf("%w user: %s", ErrorInvalid, user)
+	}
+	return s.update(func(p *aclPolicy) error {
+		owners := p.Tags[tag]
+		if owners == nil {
+			owners = []string{}
+		}
+		for _, o := range owners {
+			if o == user {
+				return nil
+			}
+		}
+		if user != "" {
+			owners = append(owners, user)
+			sort.Strings(owners)
+		}
+		p.Tags[tag] = owners
+		return nil
+	})
+}
+
+// checkTagOwner returns error unless user can assign the tag to their nodes.
+func (s *policyStore) checkTagOwner(tag, user string) error {
+	p, err := s.get()
+	if err != nil {
+		return err
+	}
+	if !contains(p.Tags[tag], user) {
+		return fmt.Errorf("%w tag: %s is not owned by %s", ErrorInvalid, tag, user)
+	}
+	return nil
+}
+
+// removeTag deletes the tag together with the rules referencing it.
+func (s *policyStore) removeTag(tag string) (aclPolicy, error) {
+	return s.update(func(p *aclPolicy) error {
+		if _, ok := p.Tags[tag]; !ok {
+			return fmt.Errorf("%w tag: %s", ErrorNotFound, tag)
+		}
+		delete(p.Tags, tag)
+		alias := fmt.Sprintf("tag:%s", tag)
+		rules := []aclRule{}
+		for _, r := range p.Rules {
+			if r.Src != alias && r.Dst != alias {
+				rules = append(rules, r)
+			}
+		}
+		p.Rules = rules
+		return nil
+	})
+}
+
+func validateAlias(alias string) error {
+	name := strings.TrimPrefix(strings.TrimPrefix(alias, "group:"), "tag:")
+	if !validName.MatchString(name) {
+		return fmt.Errorf("%w source or destination: %s", ErrorInvalid, alias)
+	}
+	return nil
+}
+
+type aclTag struct {
+	Name   string
+	Owners []string
+}
+
+// renderedPolicy is the input of the ACLs template. Only groups with valid
+// names are exported, and rules referring to unknown groups or tags are left
+// out, as headscale refuses to load such ACLs.
+type renderedPolicy struct {
+	Groups []group
+	Tags   []aclTag
+	Rules  []aclRule
+	// Owned maps user to the tags they own, sorted by name.
+	Owned map[string][]string
+}
+
+func renderPolicy(p aclPolicy, groups []group) renderedPolicy {
+	ret := renderedPolicy{[]group{}, []aclTag{}, []aclRule{}, map[string][]string{}}
+	known := map[string]struct{}{}
+	for _, g := range groups {
+		if !validName.MatchString(g.Name) {
+			continue
+		}
+		ret.Groups = append(ret.Groups, g)
+		known[fmt.Sprintf("group:%s", g.Name)] = struct{}{}
+	}
+	tags := make([]string, 0, len(p.Tags))
+	for t := range p.Tags {
+		tags = append(tags, t)
+	}
+	sort.Strings(tags)
+	for _, t := range tags {
+		ret.Tags = append(ret.Tags, aclTag{t, p.Tags[t]})
+		for _, o := range p.Tags[t] {
+			ret.Owned[o] = append(ret.Owned[o], t)
+		}
+		known[fmt.Sprintf("tag:%s", t)] = struct{}{}
+	}
+	isKnown := func(alias string) bool {
+		if !strings.HasPrefix(alias, "group:") && !strings.HasPrefix(alias, "tag:") {
+			return true
+		}
+		_, ok := known[alias]
+		return ok
+	}
+	for _, r := range p.Rules {
+		if !isKnown(r.Src) || !isKnown(r.Dst) {
+			fmt.Printf("Skipping ACL rule with unknown source or destination: %+v\n", r)
+			continue
+		}
+		ret.Rules = append(ret.Rules, r)
+	}
+	return ret
+}
+
+//go:embed policy-tmpl/policy.html
+var policyTmplRaw string
+
+var policyTmpl = template.Must(template.New("policy").Parse(policyTmplRaw))
+
+type policyPageData struct {
+	Rules        []aclRule
+	Tags         []aclTag
+	Groups       []group
+	Users        []string
+	ErrorMessage string
+}
+
+// policyRouter serves the policy editor. It is exposed through the auth
+// proxy, and only members of the admin groups can use it.
+func (s *server) policyRouter() http.Handler {
+	r := mux.NewRouter()
+	r.Use(s.requireAdmin)
+	r.Handle("/", http.RedirectHandler("/policy", http.StatusSeeOther))
+	r.HandleFunc("/policy", s.policyPage).Methods(http.MethodGet)
+	r.HandleFunc("/policy/rules", s.addRule).Methods(http.MethodPost)
+	r.HandleFunc("/policy/rules/{index}/remove", s.removeRule).Methods(http.MethodPost)
+	r.HandleFunc("/policy/tags", s.addTag).Methods(http.MethodPost)
+	r.HandleFunc("/policy/tags/{tag}/remove", s.removeTag).Methods(http.MethodPost)
+	return r
+}
+
+func (s *server) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := r.Header.Get("X-Forwarded-User")
+		if user == "" {
+			http.Error(w, "unauthenticated", http.StatusUnauthorized)
+			return
+		}
+		if !s.isAdmin(user) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isAdmin checks user membership against the last synced groups.
+func (s *server) isAdmin(user string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, g := range s.groups {
+		if !contains(s.adminGroups, g.Name) {
+			continue
+		}
+		if contains(g.Members, user) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(l []string, v string) bool {
+	for _, i := range l {
+		if i == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *server) policyPage(w http.ResponseWriter, r *http.Request) {
+	p, err := s.policy.get()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.mu.Lock()
+	rendered := renderPolicy(p, s.groups)
+	users := s.users
+	s.mu.Unlock()
+	data := policyPageData{
+		// All rules are listed, including the ones referring to groups
+		// which do not exist anymore, so that they can be removed.
+		Rules:        p.Rules,
+		Tags:         rendered.Tags,
+		Groups:       rendered.Groups,
+		Users:        users,
+		ErrorMessage: r.FormValue("errorMessage"),
+	}
+	if err := policyTmpl.Execute(w, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// policyUpdated applies updated policy and takes user back to the policy
+// page, with an error message if the update failed.
+func (s *server) policyUpdated(w http.ResponseWriter, r *http.Request, err error) {
+	if err == nil {
+		err = s.applyACLs()
+	}
+	if err != nil {
+		http.Redirect(w, r, fmt.Sprintf("/policy?errorMessage=%s", url.QueryEscape(err.Error())), http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/policy", http.StatusSeeOther)
+}
+
+func (s *server) addRule(w http.ResponseWriter, r *http.Request) {
+	_, err := s.policy.addRule(aclRule{
+		Src:   strings.TrimSpace(r.FormValue("src")),
+		Dst:   strings.TrimSpace(r.FormValue("dst")),
+		Ports: strings.TrimSpace(r.FormValue("ports")),
+	})
+	s.policyUpdated(w, r, err)
+}
+
+func (s *server) removeRule(w http.ResponseWriter, r *http.Request) {
+	i, err := strconv.Atoi(mux.Vars(r)["index"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_, err = s.policy.removeRule(i)
+	s.policyUpdated(w, r, err)
+}
+
+func (s *server) addTag(w http.ResponseWriter, r *http.Request) {
+	tag := strings.TrimPrefix(strings.TrimSpace(r.FormValue("tag")), "tag:")
+	_, err := s.policy.addTagOwner(tag, strings.TrimSpace(r.FormValue("owner")))
+	s.policyUpdated(w, r, err)
+}
+
+func (s *server) removeTag(w http.ResponseWriter, r *http.Request) {
+	_, err := s.policy.removeTag(mux.Vars(r)["tag"])
+	s.policyUpdated(w, r, err)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestPolicyACLs(t *testing.T) {
+	dir := t.TempDir()
+	store := newPolicyStore(filepath.Join(dir, "policy.json"))
+	if _, err := store.addRule(aclRule{"group:family", "tag:media-servers", "443"}); !errors.Is(err, ErrorInvalid) {
+		t.Fatalf("expected rule with unknown tag to be rejected: %v", err)
+	}
+	if _, err := store.addTagOwner("media-servers", "private-network-proxy"); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []aclRule{
+		{"group:family", "tag:media-servers", "443"},
+		{"group:family", "group:family", ""},
+		{"group:removed", "alice", "22"},
+	} {
+		if _, err := store.addRule(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.addRule(aclRule{"group:family", "bob", "https"}); !errors.Is(err, ErrorInvalid) {
+		t.Fatalf("expected invalid ports to be rejected: %v", err)
+	}
+	// Policy must survive restarts.
+	p, err := newPolicyStore(filepath.Join(dir, "policy.json")).get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Rules) != 3 || len(p.Tags["media-servers"]) != 1 {
+		t.Fatalf("unexpected policy: %+v", p)
+	}
+	groups := []group{
+		{"family", []string{"alice", "bob"}},
+		{"has space", []string{"alice"}},
+	}
+	acls, err := updateACLs(filepath.Join(dir, "acls.hujson"), []string{"10.1.0.0/24"}, []string{"alice", "bob"}, renderPolicy(p, groups))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`"group:family": ["alice", "bob"],`,
+		`"tag:media-servers": ["private-network-proxy"],`,
+		`"src": ["group:family"],
+      "dst": ["tag:media-servers:443"],`,
+		`"src": ["group:family"],
+      "dst": ["group:family:*"],`,
+	} {
+		if !strings.Contains(string(acls), expected) {
+			t.Fatalf("expected %q in %s", expected, acls)
+		}
+	}
+	for _, unexpected := range []string{"has space", "group:removed"} {
+		if strings.Contains(string(acls), unexpected) {
+			t.Fatalf("expected %q to be left out of %s", unexpected, acls)
+		}
+	}
+	if p, err := store.removeTag("media-servers"); err != nil {
+		t.Fatal(err)
+	} else if len(p.Rules) != 2 {
+		t.Fatalf("expected rules referring to the tag to be removed: %+v", p.Rules)
+	}
+}
+
+func TestTaggedNodesACLs(t *testing.T) {
+	dir := t.TempDir()
+	store := newPolicyStore(filepath.Join(dir, "policy.json"))
+	if _, err := store.addTagOwner("app-jellyfin", "private-network-proxy"); err != nil {
+		t.Fatal(err)
+	}
+	p, err := store.addTagOwner("laptop", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	acls, err := updateACLs(filepath.Join(dir, "acls.hujson"), []string{"10.1.0.0/24"}, []string{"alice", "bob"}, renderPolicy(p, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`"dst": ["10.1.0.0/24:*", "private-network-proxy:0", "tag:app-jellyfin:0"],`,
+		`"src": ["*"],
+      "dst": ["private-network-proxy:*", "tag:app-jellyfin:*"],`,
+		`"src": ["private-network-proxy", "tag:app-jellyfin"],
+      "dst": ["private-network-proxy:*", "tag:app-jellyfin:*"],`,
+		`"src": ["alice", "tag:laptop"],
+      "dst": ["alice:*", "tag:laptop:*"],`,
+		`"src": ["bob"],
+      "dst": ["bob:*"],`,
+	} {
+		if !strings.Contains(string(acls), expected) {
+			t.Fatalf("expected %q in %s", expected, acls)
+		}
+	}
+}
+
+func TestPolicyRequiresAdmin(t *testing.T) {
+	s := newServer(0, 0, nil, "", "", "", "", nil, newPolicyStore(""), []string{"admin"})
+	s.groups = []group{
+		{"admin", []string{"alice"}},
+		{"family", []string{"bob"}},
+	}
+	for _, tc := range []struct {
+		user   string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"bob", http.StatusForbidden},
+		{"alice", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/policy", nil)
+		if tc.user != "" {
+			req.Header.Set("X-Forwarded-User", tc.user)
+		}
+		rec := httptest.NewRecorder()
+		s.policyRouter().ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Errorf("%q: expected %d, got %d", tc.user, tc.status, rec.Code)
+		}
+	}
+}
+
+func TestAppTags(t *testing.T) {
+	dir := t.TempDir()
+	policy := newPolicyStore(filepath.Join(dir, "policy.json"))
+	s := newServer(0, 0, nil, "", "", "", filepath.Join(dir, "acls.hujson"), nil, policy, []string{"admin"})
+	if _, err := policy.addTagOwner("media", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := policy.addTagOwner("app-jellyfin", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := policy.addRule(aclRule{"group:family", "tag:app-jellyfin", "*"}); err != nil {
+		t.Fatal(err)
+	}
+	// Tags managed through the policy editor can not be taken over.
+	req := httptest.NewRequest(http.MethodPost, "/user/bob/preauthkey", strings.NewReader(`{"tags": ["media"]}`))
+	rec := httptest.NewRecorder()
+	s.createReusablePreAuthKey(rec, mux.SetURLVars(req, map[string]string{"user": "bob"}))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected unowned tag to be rejected, got %d", rec.Code)
+	}
+	for _, tc := range []struct {
+		tag    string
+		status int
+	}{
+		{"media", http.StatusBadRequest},
+		{"app-jellyfin", http.StatusOK},
+		{"app-jellyfin", http.StatusOK},
+	} {
+		rec := httptest.NewRecorder()
+		s.removeAppTag(rec, mux.SetURLVars(httptest.NewRequest(http.MethodDelete, "/tag/"+tc.tag, nil), map[string]string{"tag": tc.tag}))
+		if rec.Code != tc.status {
+			t.Fatalf("%s: expected %d, got %d %s", tc.tag, tc.status, rec.Code, rec.Body.String())
+		}
+	}
+	p, err := policy.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.Tags["app-jellyfin"]; ok || len(p.Rules) != 0 || len(p.Tags["media"]) != 1 {
+		t.Fatalf("expected app tag and its rules to be removed: %+v", p)
+	}
+}
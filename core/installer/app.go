@@ -40,6 +40,7 @@ type rendered struct {
 	ContainerImages map[string]ContainerImage
 	Ports           []PortForward
 	ClusterProxies  map[string]ClusterProxy
+	DNSRecords      map[string]DNSRecord
 	Data            CueAppData
 	URL             string
 	Help            []HelpDocument
@@ -195,16 +196,18 @@ func NewEnvNetwork(subnet net.IP) (EnvNetwork, error) {
 }
 
 type EnvConfig struct {
-	Id              string     `json:"id,omitempty"`
-	InfraName       string     `json:"pcloudEnvName,omitempty"`
-	Domain          string     `json:"domain,omitempty"`
-	PrivateDomain   string     `json:"privateDomain,omitempty"`
-	ContactEmail    string     `json:"contactEmail,omitempty"`
-	AdminPublicKey  string     `json:"adminPublicKey,omitempty"`
-	PublicIP        []net.IP   `json:"publicIP,omitempty"`
-	NameserverIP    []net.IP   `json:"nameserverIP,omitempty"`
-	NamespacePrefix string     `json:"namespacePrefix,omitempty"`
-	Network         EnvNetwork `json:"network,omitempty"`
+	Id                    string     `json:"id,omitempty"`
+	InfraName             string     `json:"pcloudEnvName,omitempty"`
+	Domain                string     `json:"domain,omitempty"`
+	PrivateDomain         string     `json:"privateDomain,omitempty"`
+	ContactEmail          string     `json:"contactEmail,omitempty"`
+	AdminPublicKey        string     `json:"adminPublicKey,omitempty"`
+	PublicIP              []net.IP   `json:"publicIP,omitempty"`
+	NameserverIP          []net.IP   `json:"nameserverIP,omitempty"`
+	SecondaryNameserverIP []net.IP   `json:"secondaryNameserverIP,omitempty"`
+	NamespacePrefix       string     `json:"namespacePrefix,omitempty"`
+	Network               EnvNetwork `json:"network,omitempty"`
+	ClusterProxy          bool       `json:"clusterProxy,omitempty"`
 }
 
 type EnvApp interface {
@@ -360,6 +363,9 @@ func (a cueApp) render(values map[string]any) (rendered, error) {
 	if err := res.LookupPath(cue.ParsePath("output.clusterProxy")).Decode(&ret.ClusterProxies); err != nil {
 		return rendered{}, err
 	}
+	if err := res.LookupPath(cue.ParsePath("output.dnsRecord")).Decode(&ret.DNSRecords); err != nil {
+		return rendered{}, err
+	}
 	if err := res.LookupPath(cue.ParsePath("namespaces")).Decode(&ret.Namespaces); err != nil {
 		return rendered{}, err
 	}
@@ -483,6 +489,9 @@ func (a cueEnvApp) Render(
 	charts map[string]helmv2.HelmChartTemplateSpec,
 	vpnKeyGen VPNAPIClient,
 ) (EnvAppRendered, error) {
+	if vpnKeyGen != nil && release.AppInstanceId != "" {
+		vpnKeyGen = taggedVPNAPIClient{vpnKeyGen, []string{AppInstanceVPNTag(release.AppInstanceId)}}
+	}
 	derived, err := deriveValues(values, values, a.Schema(), networks, clusters, vpnKeyGen)
 	if err != nil {
 		return EnvAppRendered{}, err
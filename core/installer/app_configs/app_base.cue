@@ -63,6 +63,21 @@ appType: #AppType | *"env"
 	name: string
 }
 
+#Toleration: {
+	key: string
+	operator: "Equal" | "Exists" | *"Equal"
+	value?: string
+	effect?: "NoSchedule" | "PreferNoSchedule" | "NoExecute"
+}
+
+// Constrains servers pods can be scheduled on, using labels and taints set
+// on cluster servers.
+#Placement: {
+	nodeSelector?: {[string]: string}
+	tolerations?: [...#Toleration]
+	affinity?: {...}
+}
+
 #Chart: #GitRepositoryRef | #HelmRepositoryRef
 
 #GitRepositoryRef: {
@@ -308,6 +323,8 @@ release: #Release
 #PostgreSQL: #WithOut & {
 	cluster?: #Cluster
 	_cluster: cluster
+	placement?: #Placement
+	_placement: placement
 	name: string
 	version: "15.3"
 	initSQL: string | *""
@@ -346,6 +363,8 @@ release: #Release
 			if _cluster != _|_ {
 				cluster: _cluster
 			}
+			// Chart expects placement under primary.
+			placement: null
 			annotations: {
 				"dodo.cloud/resource-type": "postgresql"
 				"dodo.cloud/resource.postgresql.name": name
@@ -393,6 +412,17 @@ release: #Release
 						enabled: true
 						runAsUser: 0
 					}
+					if _placement != _|_ {
+						if _placement.nodeSelector != _|_ {
+							nodeSelector: _placement.nodeSelector
+						}
+						if _placement.tolerations != _|_ {
+							tolerations: _placement.tolerations
+						}
+						if _placement.affinity != _|_ {
+							affinity: _placement.affinity
+						}
+					}
 				}
 				volumePermissions: securityContext: runAsUser: 0
 			}
@@ -414,6 +444,9 @@ _localCharts: localCharts
 	info: string | *""
 	annotations: {...} | *{}
 	cluster?: #Cluster | null
+	// Rendered as top level nodeSelector, tolerations and affinity values,
+	// null if chart expects them elsewhere.
+	placement?: #Placement | null
 	targetNamespace?: string
 	...
 }
@@ -426,6 +459,7 @@ _localCharts: localCharts
 	_info: string | *""
 	_annotations: {...} | *{}
 	_cluster?: #Cluster | null
+	_placement?: #Placement | null
 	_namespace: string
 	_targetNamespace?: string
 
@@ -456,6 +490,19 @@ _localCharts: localCharts
 		install: remediation: retries: -1
 		upgrade: remediation: retries: -1
 		values: _values
+		if _placement != _|_ {
+			if _placement != null {
+				if _placement.nodeSelector != _|_ {
+					values: nodeSelector: _placement.nodeSelector
+				}
+				if _placement.tolerations != _|_ {
+					values: tolerations: _placement.tolerations
+				}
+				if _placement.affinity != _|_ {
+					values: affinity: _placement.affinity
+				}
+			}
+		}
 	}
 }
 
@@ -464,6 +511,7 @@ output: {
 		images: out.images
 		charts: out.charts
 		clusterProxy: out.clusterProxy
+		dnsRecord: out.dnsRecord
 		_lc: _localCharts & {
 			for k, v in out.charts {
 				"\(k)": {
@@ -484,6 +532,9 @@ output: {
 					if r.cluster != _|_ {
 						_cluster: r.cluster
 					}
+					if r.placement != _|_ {
+						_placement: r.placement
+					}
 					if r.targetNamespace != _|_ {
 						_targetNamespace: r.targetNamespace
 					}
@@ -514,10 +565,13 @@ url: string | *""
 		cluster: #Cluster | *input.cluster
 	}
 	_cluster: cluster
+	placement?: #Placement
+	_placement: placement
 	images: {...}
 	charts: {...}
 	helm: {...}
 	clusterProxy: {...}
+	dnsRecord: {...}
 	images: {
 		for k, v in images {
 			"\(k)": #Image & v
@@ -542,12 +596,20 @@ url: string | *""
 			"\(k)": #ClusterProxy & v
 		}
 	}
+	dnsRecord: {
+		for k, v in dnsRecord {
+			"\(k)": #DNSRecord & v
+		}
+	}
 	helmR: {
 		for k, v in helm {
 			"\(k)": v & {
 				if v.cluster == _|_ && _cluster != _|_ {
 					cluster: _cluster
 				}
+				if v.placement == _|_ && _placement != _|_ {
+					placement: _placement
+				}
 			}
 		}
 		...
@@ -628,6 +690,7 @@ if out.cluster != _|_ {
 					"dodo.cloud/resource.volume.size": v.size
 				}
 				values: v
+				placement: null
 				if v.cluster != _|_ {
 					cluster: v.cluster
 				}
@@ -722,6 +785,24 @@ resources: { ... }
 	to: string
 }
 
+// Record in the environment zone, created on install and deleted together
+// with the app instance.
+#DNSRecord: {
+	name: string
+	type: "A" | "AAAA" | "CNAME" | "TXT" | "MX" | "SRV" | "CAA"
+	ttl?: int
+	address?: string
+	target?: string
+	text?: string
+	preference?: int
+	priority?: int
+	weight?: int
+	port?: int
+	flag?: int
+	tag?: string
+	value?: string
+}
+
 // TODO(gio): Move this inside #WithOut definition
 if out.cluster != _|_ {
 	namespaces: [{
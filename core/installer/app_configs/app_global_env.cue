@@ -13,6 +13,9 @@ import (
     nameserverIP: [...string] | *[]
 	namespacePrefix: string | *""
 	network: #EnvNetwork
+	// Whether traffic to remote clusters goes through the cluster-proxy
+	// service instead of the nginx based proxy.
+	clusterProxy: bool | *false
 }
 
 #Network: {
@@ -174,8 +177,13 @@ clusterMap: {
 							port: name: _authProxyHTTPPortName
 						}
 						if !auth.enabled {
-							// TODO(gio): make this variables part of the env configuration
-							name: "proxy-backend-service"
+							if global.clusterProxy {
+								name: "cluster-proxy"
+							}
+							if !global.clusterProxy {
+								// TODO(gio): make this variables part of the env configuration
+								name: "proxy-backend-service"
+							}
 							port: name: "http"
 						}
 					}
@@ -7,11 +7,15 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"log"
 	"net/http"
 	"path"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/giolekva/pcloud/core/installer/cluster"
 	gio "github.com/giolekva/pcloud/core/installer/io"
@@ -39,6 +43,7 @@ type AppManager struct {
 	hf           HelmFetcher
 	vpnAPIClient VPNAPIClient
 	cnc          ClusterNetworkConfigurator
+	dns          DNSRecordsClient
 	appDirRoot   string
 }
 
@@ -49,6 +54,7 @@ func NewAppManager(
 	hf HelmFetcher,
 	vpnKeyGen VPNAPIClient,
 	cnc ClusterNetworkConfigurator,
+	dns DNSRecordsClient,
 	appDirRoot string,
 ) (*AppManager, error) {
 	return &AppManager{
@@ -59,6 +65,7 @@ func NewAppManager(
 		hf,
 		vpnKeyGen,
 		cnc,
+		dns,
 		appDirRoot,
 	}, nil
 }
@@ -168,6 +175,7 @@ type allocatePortReq struct {
 	TargetService string `json:"targetService"`
 	TargetPort    int    `json:"targetPort"`
 	Secret        string `json:"secret,omitempty"`
+	Owner         string `json:"owner,omitempty"`
 }
 
 type removePortReq struct {
@@ -177,21 +185,45 @@ type removePortReq struct {
 	TargetPort    int    `json:"targetPort"`
 }
 
+type reservePortReq struct {
+	Owner string `json:"owner,omitempty"`
+	TTL   string `json:"ttl,omitempty"`
+}
+
 type reservePortResp struct {
 	Port   int    `json:"port"`
 	Secret string `json:"secret"`
 }
 
-func reservePorts(ports map[string]string) (map[string]reservePortResp, error) {
+type releasePortReq struct {
+	Port   int    `json:"port"`
+	Secret string `json:"secret"`
+}
+
+// reservePorts reserves ports on behalf of the owner app instance, for the
+// default duration of port allocator if ttl is zero. Ports reserved so far are
+// released if any of the reservations fails.
+func reservePorts(ports map[string]string, owner string, ttl time.Duration) (map[string]reservePortResp, error) {
+	req := reservePortReq{Owner: owner}
+	if ttl > 0 {
+		req.TTL = ttl.String()
+	}
 	ret := map[string]reservePortResp{}
 	for p, reserveAddr := range ports {
-		resp, err := http.Post(reserveAddr, "application/json", nil) // TODO(gio): address
+		var buf bytes.Buffer
+		if err := json.NewEncoder(&buf).Encode(req); err != nil {
+			releasePorts(ret, ports)
+			return nil, err
+		}
+		resp, err := http.Post(reserveAddr, "application/json", &buf) // TODO(gio): address
 		if err != nil {
+			releasePorts(ret, ports)
 			return nil, err
 		}
 		if resp.StatusCode != http.StatusOK {
 			var e bytes.Buffer
 			io.Copy(&e, resp.Body)
+			releasePorts(ret, ports)
 			return nil, fmt.Errorf("Could not reserve port: %s", e.String())
 		}
 		var r reservePortResp
@@ -203,7 +235,31 @@ func reservePorts(ports map[string]string) (map[string]reservePortResp, error) {
 	return ret, nil
 }
 
-func openPorts(ports []PortForward, reservations map[string]reservePortResp, allocators map[string]string) error {
+// releasePorts gives back reserved ports which did not get allocated, so
+// that they do not stay taken until their reservation expires. Release is
+// best effort, errors are only logged.
+func releasePorts(reservations map[string]reservePortResp, reservators map[string]string) {
+	for n, r := range reservations {
+		var buf bytes.Buffer
+		if err := json.NewEncoder(&buf).Encode(releasePortReq{r.Port, r.Secret}); err != nil {
+			log.Printf("Could not release port %d: %s\n", r.Port, err)
+			continue
+		}
+		releaseAddr := strings.TrimSuffix(reservators[n], "/reserve") + "/release"
+		resp, err := http.Post(releaseAddr, "application/json", &buf)
+		if err != nil {
+			log.Printf("Could not release port %d: %s\n", r.Port, err)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			var e bytes.Buffer
+			io.Copy(&e, resp.Body)
+			log.Printf("Could not release port %d: %s\n", r.Port, e.String())
+		}
+	}
+}
+
+func openPorts(ports []PortForward, reservations map[string]reservePortResp, allocators map[string]string, owner string) error {
 	for _, p := range ports {
 		var buf bytes.Buffer
 		req := allocatePortReq{
@@ -211,6 +267,7 @@ func openPorts(ports []PortForward, reservations map[string]reservePortResp, all
 			SourcePort:    p.SourcePort,
 			TargetService: p.TargetService,
 			TargetPort:    p.TargetPort,
+			Owner:         owner,
 		}
 		allocator := ""
 		for n, r := range reservations {
@@ -239,6 +296,45 @@ func openPorts(ports []PortForward, reservations map[string]reservePortResp, all
 	return nil
 }
 
+// NOTE(gio): Must match the services ingresses of apps running on remote
+// clusters point to, see app_global_env.cue.
+const (
+	nginxClusterProxyServiceName = "proxy-backend-service"
+	clusterProxyServiceName      = "cluster-proxy"
+)
+
+// clusterStreamProxies carries traffic of ports forwarded to the app running
+// on remote cluster from the cluster proxy to the remote cluster ingress.
+func clusterStreamProxies(cluster string, ports []PortForward) []StreamProxy {
+	ret := make([]StreamProxy, 0, len(ports))
+	for _, p := range ports {
+		ret = append(ret, StreamProxy{
+			Cluster:  cluster,
+			Protocol: p.Protocol,
+			Port:     p.SourcePort,
+			Target:   fmt.Sprintf("%s:%d", p.TargetService, p.TargetPort),
+		})
+	}
+	return ret
+}
+
+// forwardToClusterProxy points ports of the app running on remote cluster to
+// the cluster proxy, as their target services are not reachable from the main
+// cluster ingress.
+func forwardToClusterProxy(env EnvConfig, ports []PortForward) []PortForward {
+	service := nginxClusterProxyServiceName
+	if env.ClusterProxy {
+		service = clusterProxyServiceName
+	}
+	ret := make([]PortForward, 0, len(ports))
+	for _, p := range ports {
+		p.TargetService = fmt.Sprintf("%singress-private/%s", env.NamespacePrefix, service)
+		p.TargetPort = p.SourcePort
+		ret = append(ret, p)
+	}
+	return ret
+}
+
 func closePorts(ports []PortForward) error {
 	var retErr error
 	for _, p := range ports {
@@ -325,12 +421,12 @@ func installApp(
 	if o.NoPublish {
 		dopts = append(dopts, soft.WithNoCommit())
 	}
-	if o.Force {
-		dopts = append(dopts, soft.WithForce())
-	}
 	if o.NoLock {
 		dopts = append(dopts, soft.WithNoLock())
 	}
+	if o.Author != "" {
+		dopts = append(dopts, soft.WithAuthor(o.Author))
+	}
 	_, err := repo.Do(func(r soft.RepoFS) (string, error) {
 		if err := r.RemoveAll(appDir); err != nil {
 			return "", err
@@ -426,8 +522,14 @@ func (m *AppManager) Install(
 		}
 	}
 	opts = append(opts, WithNoPull())
-	if err := m.nsc.Create(namespace); err != nil {
-		return ReleaseResources{}, err
+	// NOTE(gio): Proposed changes must not reach outside of the repository
+	// before they get approved, so namespaces and ports of the change request
+	// are only created once it gets merged.
+	namespaces := []Namespace{{Name: namespace}}
+	if o.Branch == "" {
+		if err := m.createNamespaces(namespaces); err != nil {
+			return ReleaseResources{}, err
+		}
 	}
 	var env EnvConfig
 	if o.Env != nil {
@@ -481,10 +583,19 @@ func (m *AppManager) Install(
 		reservators[portFields[pf.SourcePort]] = pf.ReserveAddr
 		allocators[portFields[pf.SourcePort]] = pf.Allocator
 	}
-	portReservations, err := reservePorts(reservators)
-	if err != nil {
-		return ReleaseResources{}, err
+	portReservations := map[string]reservePortResp{}
+	if o.Branch == "" {
+		portReservations, err = reservePorts(reservators, instanceId, 0)
+		if err != nil {
+			return ReleaseResources{}, err
+		}
 	}
+	portsOpened := false
+	defer func() {
+		if !portsOpened {
+			releasePorts(portReservations, reservators)
+		}
+	}()
 	if err := setPortFields(values, portReservations); err != nil {
 		return ReleaseResources{}, err
 	}
@@ -507,32 +618,55 @@ func (m *AppManager) Install(
 	if err != nil {
 		return ReleaseResources{}, err
 	}
-	for _, ns := range rendered.Namespaces {
-		if ns.Name == "" {
-			return ReleaseResources{}, fmt.Errorf("namespace name missing")
+	remoteNamespaces, err := clusterNamespaces(rendered.Namespaces)
+	if err != nil {
+		return ReleaseResources{}, err
+	}
+	if o.Branch == "" {
+		if err := m.createNamespaces(remoteNamespaces); err != nil {
+			return ReleaseResources{}, err
 		}
-		if ns.Kubeconfig == "" {
-			continue
+	}
+	effects := deferredEffects{
+		Owner:        instanceId,
+		Ports:        rendered.Ports,
+		Reservations: portReservations,
+		Allocators:   allocators,
+		AddProxies:   clusterProxies(rendered.ClusterProxies),
+	}
+	if len(rendered.DNSRecords) > 0 {
+		effects.ReplaceDNSRecords = true
+		effects.DNSRecords = dnsRecords(rendered.DNSRecords)
+	}
+	if rendered.Cluster != "" {
+		effects.StreamProxies = clusterStreamProxies(rendered.Cluster, rendered.Ports)
+		effects.Ports = forwardToClusterProxy(env, rendered.Ports)
+	}
+	if o.Branch != "" {
+		// NOTE(gio): Ports get reserved once the change is merged, instance
+		// is rendered again with them then.
+		effects.Namespaces = append(namespaces, remoteNamespaces...)
+		effects.Ports = nil
+		effects.StreamProxies = nil
+		if len(reservators) > 0 {
+			effects.ReservePorts = reservators
 		}
-		nsc, err := NewNamespaceCreator(kube.KubeConfigOpts{KubeConfig: ns.Kubeconfig})
+		data, err := withDeferredEffects(rendered.Data, effects)
 		if err != nil {
 			return ReleaseResources{}, err
 		}
-		if err := nsc.Create(ns.Name); err != nil {
+		if err := installApp(m.repo, appDir, rendered.Name, rendered.Config, rendered.Resources, data, opts...); err != nil {
 			return ReleaseResources{}, err
 		}
-	}
-	if err := installApp(m.repo, appDir, rendered.Name, rendered.Config, rendered.Resources, rendered.Data, opts...); err != nil {
-		return ReleaseResources{}, err
-	}
-	// TODO(gio): add ingress-nginx to release resources
-	if err := openPorts(rendered.Ports, portReservations, allocators); err != nil {
-		return ReleaseResources{}, err
-	}
-	for _, p := range rendered.ClusterProxies {
-		if err := m.cnc.AddProxy(p.From, p.To); err != nil {
+	} else {
+		if err := installApp(m.repo, appDir, rendered.Name, rendered.Config, rendered.Resources, rendered.Data, opts...); err != nil {
+			return ReleaseResources{}, err
+		}
+		// TODO(gio): add ingress-nginx to release resources
+		if err := m.applyEffects(effects); err != nil {
 			return ReleaseResources{}, err
 		}
+		portsOpened = true
 	}
 	return ReleaseResources{
 		Release:     rendered.Config.Release,
@@ -589,59 +723,78 @@ func (m *AppManager) Update(
 	values map[string]any,
 	opts ...InstallOption,
 ) (ReleaseResources, error) {
+	o := &installOptions{}
+	for _, i := range opts {
+		i(o)
+	}
 	m.l.Lock()
 	defer m.l.Unlock()
-	if err := m.repo.Pull(); err != nil {
+	rendered, effects, err := m.updateInstance(instanceId, values, opts...)
+	if err != nil {
 		return ReleaseResources{}, err
 	}
+	if o.Branch == "" {
+		if err := m.applyEffects(effects); err != nil {
+			return ReleaseResources{}, err
+		}
+	}
+	return ReleaseResources{
+		Release:     rendered.Config.Release,
+		RenderedRaw: rendered.Raw,
+		Helm:        extractHelm(rendered.Resources),
+	}, nil
+}
+
+// updateInstance renders the app instance with given values and commits it.
+// Returns effects of the change outside of the repository, which are left
+// for the caller to apply unless the change is proposed on a branch.
+func (m *AppManager) updateInstance(
+	instanceId string,
+	values map[string]any,
+	opts ...InstallOption,
+) (EnvAppRendered, deferredEffects, error) {
+	o := &installOptions{}
+	for _, i := range opts {
+		i(o)
+	}
+	if err := m.repo.Pull(); err != nil {
+		return EnvAppRendered{}, deferredEffects{}, err
+	}
 	env, err := m.Config()
 	if err != nil {
-		return ReleaseResources{}, err
+		return EnvAppRendered{}, deferredEffects{}, err
 	}
 	instanceDir := filepath.Join(m.appDirRoot, instanceId)
 	app, err := m.GetInstanceApp(instanceId)
 	if err != nil {
-		return ReleaseResources{}, err
+		return EnvAppRendered{}, deferredEffects{}, err
 	}
 	instanceConfigPath := filepath.Join(instanceDir, "config.json")
 	config, err := m.appConfig(instanceConfigPath)
 	if err != nil {
-		return ReleaseResources{}, err
+		return EnvAppRendered{}, deferredEffects{}, err
 	}
 	renderedCfg, err := readRendered(m.repo, filepath.Join(instanceDir, "rendered.json"))
 	if err != nil {
-		return ReleaseResources{}, err
+		return EnvAppRendered{}, deferredEffects{}, err
 	}
 	networks, err := m.CreateNetworks(env)
 	if err != nil {
-		return ReleaseResources{}, err
+		return EnvAppRendered{}, deferredEffects{}, err
 	}
 	clusters, err := m.GetClusters()
 	if err != nil {
-		return ReleaseResources{}, err
+		return EnvAppRendered{}, deferredEffects{}, err
 	}
 	rendered, err := app.Render(config.Release, env, networks, ToAccessConfigs(clusters), values, renderedCfg.LocalCharts, m.vpnAPIClient)
 	if err != nil {
-		return ReleaseResources{}, err
+		return EnvAppRendered{}, deferredEffects{}, err
 	}
-	for _, ns := range rendered.Namespaces {
-		if ns.Name == "" {
-			return ReleaseResources{}, fmt.Errorf("namespace name missing")
-		}
-		if ns.Kubeconfig == "" {
-			continue
-		}
-		nsc, err := NewNamespaceCreator(kube.KubeConfigOpts{KubeConfig: ns.Kubeconfig})
-		if err != nil {
-			return ReleaseResources{}, err
-		}
-		if err := nsc.Create(ns.Name); err != nil {
-			return ReleaseResources{}, err
-		}
-	}
-	if err := installApp(m.repo, instanceDir, rendered.Name, rendered.Config, rendered.Resources, rendered.Data, opts...); err != nil {
-		return ReleaseResources{}, err
+	namespaces, err := clusterNamespaces(rendered.Namespaces)
+	if err != nil {
+		return EnvAppRendered{}, deferredEffects{}, err
 	}
+	effects := deferredEffects{Owner: instanceId, Namespaces: namespaces}
 	for _, ocp := range renderedCfg.Out.ClusterProxy {
 		found := false
 		for _, ncp := range rendered.ClusterProxies {
@@ -651,12 +804,10 @@ func (m *AppManager) Update(
 			}
 		}
 		if !found {
-			if err := m.cnc.RemoveProxy(ocp.From, ocp.To); err != nil {
-				return ReleaseResources{}, err
-			}
+			effects.RemoveProxies = append(effects.RemoveProxies, ocp)
 		}
 	}
-	for _, ncp := range rendered.ClusterProxies {
+	for _, ncp := range clusterProxies(rendered.ClusterProxies) {
 		found := false
 		for _, ocp := range renderedCfg.Out.ClusterProxy {
 			if ocp == ncp {
@@ -665,16 +816,24 @@ func (m *AppManager) Update(
 			}
 		}
 		if !found {
-			if err := m.cnc.AddProxy(ncp.From, ncp.To); err != nil {
-				return ReleaseResources{}, err
-			}
+			effects.AddProxies = append(effects.AddProxies, ncp)
 		}
 	}
-	return ReleaseResources{
-		Release:     rendered.Config.Release,
-		RenderedRaw: rendered.Raw,
-		Helm:        extractHelm(rendered.Resources),
-	}, nil
+	if !reflect.DeepEqual(dnsRecords(renderedCfg.Out.DNSRecord), dnsRecords(rendered.DNSRecords)) {
+		effects.ReplaceDNSRecords = true
+		effects.DNSRecords = dnsRecords(rendered.DNSRecords)
+	}
+	data := rendered.Data
+	if o.Branch != "" {
+		data, err = withDeferredEffects(rendered.Data, effects)
+		if err != nil {
+			return EnvAppRendered{}, deferredEffects{}, err
+		}
+	}
+	if err := installApp(m.repo, instanceDir, rendered.Name, rendered.Config, rendered.Resources, data, opts...); err != nil {
+		return EnvAppRendered{}, deferredEffects{}, err
+	}
+	return rendered, effects, nil
 }
 
 func (m *AppManager) Remove(instanceId string) error {
@@ -714,6 +873,13 @@ func (m *AppManager) Remove(instanceId string) error {
 	if err := closePorts(cfg.PortForward); err != nil {
 		return err
 	}
+	if cfg.Input.Cluster != nil {
+		for _, p := range clusterStreamProxies(cfg.Input.Cluster.Name, cfg.PortForward) {
+			if err := m.cnc.RemoveStreamProxy(p); err != nil {
+				return err
+			}
+		}
+	}
 	for _, cp := range cfg.Out.ClusterProxy {
 		if err := m.cnc.RemoveProxy(cp.From, cp.To); err != nil {
 			return err
@@ -732,9 +898,337 @@ func (m *AppManager) Remove(instanceId string) error {
 			}
 		}
 	}
+	if m.vpnAPIClient != nil {
+		if err := m.vpnAPIClient.RemoveTag(AppInstanceVPNTag(instanceId)); err != nil {
+			return err
+		}
+	}
+	if m.dns != nil {
+		if err := m.dns.DeleteOwned(instanceId); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// Effects of proposed changes are recorded in this file of the app instance
+// directory on the change request branch.
+const deferredEffectsFileName = "deferred.json"
+
+// deferredEffects are changes made outside of the config repository, such as
+// created namespaces, opened ports and cluster proxies. Changes proposed as a
+// change request must not reach outside of the repository before they get
+// approved, so these are only applied once the change request is merged.
+type deferredEffects struct {
+	Owner      string      `json:"owner"`
+	Namespaces []Namespace `json:"namespaces,omitempty"`
+	// Maps port fields of the app instance input to port reservation
+	// addresses. Ports are reserved when effects get applied, and instance
+	// is rendered again with them.
+	ReservePorts  map[string]string          `json:"reservePorts,omitempty"`
+	Ports         []PortForward              `json:"ports,omitempty"`
+	Reservations  map[string]reservePortResp `json:"reservations,omitempty"`
+	Allocators    map[string]string          `json:"allocators,omitempty"`
+	StreamProxies []StreamProxy              `json:"streamProxies,omitempty"`
+	AddProxies    []ClusterProxy             `json:"addProxies,omitempty"`
+	RemoveProxies []ClusterProxy             `json:"removeProxies,omitempty"`
+	// When set, records owned by the app instance are replaced with the
+	// given ones.
+	ReplaceDNSRecords bool        `json:"replaceDNSRecords,omitempty"`
+	DNSRecords        []DNSRecord `json:"dnsRecords,omitempty"`
+}
+
+func clusterProxies(proxies map[string]ClusterProxy) []ClusterProxy {
+	ret := make([]ClusterProxy, 0, len(proxies))
+	for _, p := range proxies {
+		ret = append(ret, p)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].From < ret[j].From
+	})
+	return ret
+}
+
+func dnsRecords(records map[string]DNSRecord) []DNSRecord {
+	keys := make([]string, 0, len(records))
+	for k := range records {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	ret := make([]DNSRecord, 0, len(records))
+	for _, k := range keys {
+		ret = append(ret, records[k])
+	}
+	return ret
+}
+
+func withDeferredEffects(data CueAppData, effects deferredEffects) (CueAppData, error) {
+	ret := CueAppData{}
+	for name, contents := range data {
+		ret[name] = contents
+	}
+	if _, ok := ret[deferredEffectsFileName]; ok {
+		return nil, fmt.Errorf("%s is forbidden", deferredEffectsFileName)
+	}
+	contents, err := json.MarshalIndent(effects, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	ret[deferredEffectsFileName] = contents
+	return ret, nil
+}
+
+func (m *AppManager) applyEffects(effects deferredEffects) error {
+	if err := m.createNamespaces(effects.Namespaces); err != nil {
+		return err
+	}
+	if len(effects.ReservePorts) > 0 {
+		var err error
+		if effects, err = m.reserveDeferredPorts(effects); err != nil {
+			return err
+		}
+	}
+	added := []StreamProxy{}
+	for _, p := range effects.StreamProxies {
+		if err := m.cnc.AddStreamProxy(p); err != nil {
+			return errors.Join(err, m.removeStreamProxies(added))
+		}
+		added = append(added, p)
+	}
+	if err := openPorts(effects.Ports, effects.Reservations, effects.Allocators, effects.Owner); err != nil {
+		return errors.Join(err, m.removeStreamProxies(added))
+	}
+	for _, p := range effects.RemoveProxies {
+		if err := m.cnc.RemoveProxy(p.From, p.To); err != nil {
+			return err
+		}
+	}
+	for _, p := range effects.AddProxies {
+		if err := m.cnc.AddProxy(p.From, p.To); err != nil {
+			return err
+		}
+	}
+	if effects.ReplaceDNSRecords {
+		if err := m.replaceDNSRecords(effects.Owner, effects.DNSRecords); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reserveDeferredPorts reserves ports of the app instance proposed as a change
+// request, and renders it again with them. Returned effects open the reserved
+// ports.
+func (m *AppManager) reserveDeferredPorts(effects deferredEffects) (deferredEffects, error) {
+	cfg, err := m.appConfig(filepath.Join(m.appDirRoot, effects.Owner, "config.json"))
+	if err != nil {
+		return deferredEffects{}, err
+	}
+	env, err := m.Config()
+	if err != nil {
+		return deferredEffects{}, err
+	}
+	reservations, err := reservePorts(effects.ReservePorts, effects.Owner, 0)
+	if err != nil {
+		return deferredEffects{}, err
+	}
+	values := cfg.Values
+	if values == nil {
+		values = map[string]any{}
+	}
+	if err := setPortFields(values, reservations); err != nil {
+		releasePorts(reservations, effects.ReservePorts)
+		return deferredEffects{}, err
+	}
+	// NOTE(gio): Only port values change, so the update has no other effects.
+	rendered, _, err := m.updateInstance(effects.Owner, values)
+	if err != nil {
+		releasePorts(reservations, effects.ReservePorts)
+		return deferredEffects{}, err
+	}
+	effects.ReservePorts = nil
+	effects.Reservations = reservations
+	effects.Ports = rendered.Ports
+	if rendered.Cluster != "" {
+		effects.StreamProxies = clusterStreamProxies(rendered.Cluster, rendered.Ports)
+		effects.Ports = forwardToClusterProxy(env, rendered.Ports)
+	}
+	return effects, nil
+}
+
+// createNamespaces creates namespaces in the default cluster, or in the one
+// given kubeconfig points to.
+func (m *AppManager) createNamespaces(namespaces []Namespace) error {
+	for _, ns := range namespaces {
+		nsc := m.nsc
+		if ns.Kubeconfig != "" {
+			var err error
+			nsc, err = NewNamespaceCreator(kube.KubeConfigOpts{KubeConfig: ns.Kubeconfig})
+			if err != nil {
+				return err
+			}
+		}
+		if err := nsc.Create(ns.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clusterNamespaces returns rendered namespaces which live in remote
+// clusters.
+func clusterNamespaces(namespaces []Namespace) ([]Namespace, error) {
+	var ret []Namespace
+	for _, ns := range namespaces {
+		if ns.Name == "" {
+			return nil, fmt.Errorf("namespace name missing")
+		}
+		if ns.Kubeconfig != "" {
+			ret = append(ret, ns)
+		}
+	}
+	return ret, nil
+}
+
+// replaceDNSRecords replaces records owned by the app instance, tagging new
+// ones with it so that they get deleted together with the instance.
+func (m *AppManager) replaceDNSRecords(owner string, records []DNSRecord) error {
+	if m.dns == nil {
+		return fmt.Errorf("can not manage DNS records of %s: dns-api is not configured", owner)
+	}
+	if err := m.dns.DeleteOwned(owner); err != nil {
+		return err
+	}
+	for _, r := range records {
+		r.Owner = owner
+		if err := m.dns.Create(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeStreamProxies rolls back stream proxies added before one of the
+// following effects failed.
+func (m *AppManager) removeStreamProxies(proxies []StreamProxy) error {
+	var errs []error
+	for _, p := range proxies {
+		if err := m.cnc.RemoveStreamProxy(p); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ApplyMerged applies effects of the merged change request which touched
+// given paths, and removes their records from the repository.
+func (m *AppManager) ApplyMerged(paths []string) error {
+	m.l.Lock()
+	defer m.l.Unlock()
+	if err := m.repo.Pull(); err != nil {
+		return err
+	}
+	var applied []string
+	for _, p := range paths {
+		p = path.Join("/", p)
+		if path.Base(p) != deferredEffectsFileName {
+			continue
+		}
+		var effects deferredEffects
+		if err := soft.ReadJson(m.repo, p, &effects); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return err
+		}
+		if err := m.applyEffects(effects); err != nil {
+			return err
+		}
+		applied = append(applied, p)
+	}
+	if len(applied) == 0 {
+		return nil
+	}
+	_, err := m.repo.Do(func(r soft.RepoFS) (string, error) {
+		for _, p := range applied {
+			if err := r.RemoveAll(p); err != nil {
+				return "", err
+			}
+		}
+		return "apply merged change", nil
+	})
+	return err
+}
+
+// DiscardRejected releases resources held by the rejected change request
+// which touched given paths, reading its proposed files with read. Change
+// requests proposed by older versions reserved ports upfront, instead of
+// once getting merged.
+func (m *AppManager) DiscardRejected(paths []string, read func(path string) ([]byte, error)) error {
+	for _, p := range paths {
+		if path.Base(p) != deferredEffectsFileName {
+			continue
+		}
+		contents, err := read(p)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return err
+		}
+		var effects deferredEffects
+		if err := json.Unmarshal(contents, &effects); err != nil {
+			return err
+		}
+		reservators := map[string]string{}
+		for n, a := range effects.Allocators {
+			reservators[n] = strings.TrimSuffix(a, "/allocate") + "/reserve"
+		}
+		releasePorts(effects.Reservations, reservators)
+	}
+	return nil
+}
+
+// OrphanedDNSRecords returns records owned by app instances and clusters
+// which do not exist anymore.
+func (m *AppManager) OrphanedDNSRecords() ([]DNSRecord, error) {
+	if m.dns == nil {
+		return []DNSRecord{}, nil
+	}
+	owners, err := m.dns.Owners()
+	if err != nil {
+		return nil, err
+	}
+	live := map[string]struct{}{}
+	instances, err := m.GetAllInstances()
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range instances {
+		live[i.Id] = struct{}{}
+	}
+	clusters, err := m.GetClusters()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range clusters {
+		live[ClusterDNSOwner(c.Name)] = struct{}{}
+	}
+	ret := []DNSRecord{}
+	for owner, records := range owners {
+		if _, ok := live[owner]; !ok {
+			ret = append(ret, records...)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Owner != ret[j].Owner {
+			return ret[i].Owner < ret[j].Owner
+		}
+		return ret[i].Name < ret[j].Name
+	})
+	return ret, nil
+}
+
 func (m *AppManager) CreateNetworks(env EnvConfig) ([]Network, error) {
 	ret := []Network{
 		{
@@ -814,8 +1308,8 @@ type installOptions struct {
 	Branch               string
 	LG                   LocalChartGenerator
 	FetchContainerImages bool
-	Force                bool
 	NoLock               bool
+	Author               string
 }
 
 type InstallOption func(*installOptions)
@@ -848,9 +1342,9 @@ func WithBranch(branch string) InstallOption {
 	}
 }
 
-func WithForce() InstallOption {
+func WithAuthor(name string) InstallOption {
 	return func(o *installOptions) {
-		o.Force = true
+		o.Author = name
 	}
 }
 
@@ -1084,11 +1578,17 @@ func pullContainerImages(appName string, imgs map[string]ContainerImage, registr
 type renderedInstance struct {
 	LocalCharts map[string]helmv2.HelmChartTemplateSpec `json:"localCharts"`
 	PortForward []PortForward                           `json:"portForward"`
+	Input       inputRendered                           `json:"input"`
 	Out         outRendered                             `json:"out"`
 }
 
+type inputRendered struct {
+	Cluster *Cluster `json:"cluster"`
+}
+
 type outRendered struct {
 	ClusterProxy map[string]ClusterProxy
+	DNSRecord    map[string]DNSRecord  `json:"dnsRecord"`
 	VM           map[string]vmRendered `json:"vm"`
 }
 
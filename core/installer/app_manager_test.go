@@ -0,0 +1,227 @@
+package installer
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+
+	"github.com/giolekva/pcloud/core/installer/soft"
+)
+
+type fakeClusterNetworkConfigurator struct {
+	proxies map[string]string
+	streams []StreamProxy
+}
+
+func newFakeClusterNetworkConfigurator() *fakeClusterNetworkConfigurator {
+	return &fakeClusterNetworkConfigurator{proxies: map[string]string{}}
+}
+
+func (c *fakeClusterNetworkConfigurator) AddCluster(name string, ingressIP net.IP) error {
+	return nil
+}
+
+func (c *fakeClusterNetworkConfigurator) RemoveCluster(name string, ingressIP net.IP) error {
+	return nil
+}
+
+func (c *fakeClusterNetworkConfigurator) AddProxy(src, dst string) error {
+	c.proxies[src] = dst
+	return nil
+}
+
+func (c *fakeClusterNetworkConfigurator) RemoveProxy(src, dst string) error {
+	delete(c.proxies, src)
+	return nil
+}
+
+func (c *fakeClusterNetworkConfigurator) AddStreamProxy(p StreamProxy) error {
+	c.streams = append(c.streams, p)
+	return nil
+}
+
+func (c *fakeClusterNetworkConfigurator) RemoveStreamProxy(p StreamProxy) error {
+	for i, s := range c.streams {
+		if s == p {
+			c.streams = append(c.streams[:i], c.streams[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func TestApplyMerged(t *testing.T) {
+	repo := soft.NewMockRepoIO(soft.NewBillyRepoFS(memfs.New()), "foo.bar", t)
+	effects := deferredEffects{
+		Owner:         "app-1",
+		AddProxies:    []ClusterProxy{{"app.foo.cluster.p.bar.ge", "app.bar.ge"}},
+		RemoveProxies: []ClusterProxy{{"old.foo.cluster.p.bar.ge", "old.bar.ge"}},
+	}
+	if err := soft.WriteJson(repo, "/apps/app-1/deferred.json", effects); err != nil {
+		t.Fatal(err)
+	}
+	cnc := newFakeClusterNetworkConfigurator()
+	cnc.proxies["old.foo.cluster.p.bar.ge"] = "old.bar.ge"
+	m, err := NewAppManager(repo, nil, nil, nil, nil, cnc, nil, "/apps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ApplyMerged([]string{"apps/app-1/config.json", "apps/app-1/deferred.json"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(cnc.proxies) != 1 || cnc.proxies["app.foo.cluster.p.bar.ge"] != "app.bar.ge" {
+		t.Fatalf("unexpected proxies: %+v", cnc.proxies)
+	}
+	if _, err := repo.Reader("/apps/app-1/deferred.json"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected deferred effects to be removed, got %v", err)
+	}
+}
+
+func TestApplyEffectsRollsBackStreamProxies(t *testing.T) {
+	cnc := newFakeClusterNetworkConfigurator()
+	m := &AppManager{cnc: cnc}
+	effects := deferredEffects{
+		Owner:         "app-1",
+		StreamProxies: []StreamProxy{{"io", "TCP", 2222, "foo/ssh:22"}},
+		// No reservation was made for the port, opening it fails.
+		Ports: []PortForward{{Protocol: "TCP", SourcePort: 2222, TargetService: "ssh", TargetPort: 22}},
+	}
+	if err := m.applyEffects(effects); err == nil {
+		t.Fatal("expected opening ports to fail")
+	}
+	if len(cnc.streams) != 0 {
+		t.Fatalf("expected stream proxies to be rolled back, got %+v", cnc.streams)
+	}
+}
+
+type recordingNamespaceCreator struct {
+	created []string
+}
+
+func (c *recordingNamespaceCreator) Create(name string) error {
+	c.created = append(c.created, name)
+	return nil
+}
+
+func TestProposedInstallDefersNamespacesAndPorts(t *testing.T) {
+	var calls []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.URL.Path)
+		switch r.URL.Path {
+		case "/api/reserve":
+			json.NewEncoder(w).Encode(reservePortResp{Port: 2222, Secret: "secret"})
+		case "/api/allocate":
+			var req allocatePortReq
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.SourcePort != 2222 || req.Secret != "secret" {
+				http.Error(w, "unexpected allocation", http.StatusBadRequest)
+			}
+		}
+	}))
+	defer s.Close()
+	app, err := NewCueEnvApp(CueAppData{
+		"base.cue":   []byte(cueBaseConfig),
+		"global.cue": []byte(cueEnvAppGlobal),
+		"app.cue": []byte(`
+name: "ssh"
+namespace: "ssh"
+input: {
+	network: #Network
+	sshPort: int @role(port)
+}
+portForward: [#PortForward & {
+	allocator: input.network.allocatePortAddr
+	reservator: input.network.reservePortAddr
+	deallocator: input.network.deallocatePortAddr
+	sourcePort: input.sshPort
+	serviceName: "ssh"
+	targetPort: 22
+}]
+`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := soft.NewMockRepoIO(soft.NewBillyRepoFS(memfs.New()), "foo.bar", t)
+	if err := soft.WriteYaml(repo, configFileName, env); err != nil {
+		t.Fatal(err)
+	}
+	nsc := &recordingNamespaceCreator{}
+	m, err := NewAppManager(repo, nsc, nil, noOpHelmFetcher{}, nil, nil, nil, "/apps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nets := []Network{networks[0]}
+	nets[0].ReservePortAddr = s.URL + "/api/reserve"
+	nets[0].AllocatePortAddr = s.URL + "/api/allocate"
+	values := map[string]any{"network": "Public"}
+	if _, err := m.Install(app, "ssh-1", "/apps/ssh-1", "ssh", values, WithConfig(&env), WithNetworks(nets), WithClusters([]Cluster{}), WithBranch("change/ssh-1")); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 0 || len(nsc.created) != 0 {
+		t.Fatalf("expected nothing outside of the repository to change, got calls %+v and namespaces %+v", calls, nsc.created)
+	}
+	if err := m.ApplyMerged([]string{"apps/ssh-1/config.json", "apps/ssh-1/deferred.json"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 2 || calls[0] != "/api/reserve" || calls[1] != "/api/allocate" {
+		t.Fatalf("expected port to be reserved and allocated, got %+v", calls)
+	}
+	if len(nsc.created) != 1 || nsc.created[0] != "ssh" {
+		t.Fatalf("expected namespace to be created, got %+v", nsc.created)
+	}
+	cfg, err := m.GetInstance("ssh-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port, ok := cfg.Input["sshPort"].(float64); !ok || port != 2222 {
+		t.Fatalf("expected instance to be rendered with reserved port, got %+v", cfg.Input)
+	}
+}
+
+func TestDiscardRejectedReleasesReservations(t *testing.T) {
+	var released []releasePortReq
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/release" {
+			http.Error(w, "unexpected call", http.StatusBadRequest)
+			return
+		}
+		var req releasePortReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		released = append(released, req)
+	}))
+	defer s.Close()
+	m, err := NewAppManager(soft.NewMockRepoIO(soft.NewBillyRepoFS(memfs.New()), "foo.bar", t), nil, nil, noOpHelmFetcher{}, nil, nil, nil, "/apps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	effects, err := json.Marshal(deferredEffects{
+		Owner:        "ssh-1",
+		Reservations: map[string]reservePortResp{"sshPort": {Port: 2222, Secret: "secret"}},
+		Allocators:   map[string]string{"sshPort": s.URL + "/api/allocate"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{"apps/ssh-1/deferred.json": effects}
+	read := func(path string) ([]byte, error) {
+		if contents, ok := files[path]; ok {
+			return contents, nil
+		}
+		return nil, fs.ErrNotExist
+	}
+	if err := m.DiscardRejected([]string{"apps/ssh-1/config.json", "apps/ssh-1/deferred.json", "apps/ssh-2/deferred.json"}, read); err != nil {
+		t.Fatal(err)
+	}
+	if len(released) != 1 || released[0] != (releasePortReq{2222, "secret"}) {
+		t.Fatalf("expected reserved port to be released, got %+v", released)
+	}
+}
@@ -48,6 +48,7 @@ var envAppConfigs = []string{
 	"values-tmpl/core-auth.cue",
 	"values-tmpl/metallb-ipaddresspool.cue",
 	"values-tmpl/private-network.cue",
+	"values-tmpl/cluster-proxy.cue",
 	"values-tmpl/welcome.cue",
 	"values-tmpl/memberships.cue",
 	"values-tmpl/headscale.cue",
@@ -4,6 +4,7 @@ import (
 	_ "embed"
 	"fmt"
 	"net"
+	"strings"
 	"testing"
 
 	"cuelang.org/go/cue/errors"
@@ -344,6 +345,73 @@ func TestAppPackagesRemoteCluster(t *testing.T) {
 	}
 }
 
+func TestAppPackagesRemoteClusterProxy(t *testing.T) {
+	contents, err := valuesTmpls.ReadFile("values-tmpl/rpuppy.cue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, err := NewCueEnvApp(CueAppData{
+		"base.cue":   []byte(cueBaseConfig),
+		"app.cue":    []byte(contents),
+		"global.cue": []byte(cueEnvAppGlobal),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := Release{
+		AppInstanceId: "rpuppy",
+		Namespace:     "foo",
+	}
+	values := map[string]any{
+		"network":   "Public",
+		"subdomain": "woof",
+		"auth": map[string]any{
+			"enabled": false,
+		},
+		"cluster": "io",
+	}
+	proxyEnv := env
+	proxyEnv.ClusterProxy = true
+	rendered, err := app.Render(release, proxyEnv, networks, clusters, values, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ingress, ok := rendered.Resources["rpuppy-rpuppy-ingress.yaml"]
+	if !ok {
+		t.Fatal("ingress is not rendered")
+	}
+	if !strings.Contains(string(ingress), "name: cluster-proxy") || strings.Contains(string(ingress), "proxy-backend-service") {
+		t.Fatalf("ingress does not point to cluster proxy: %s", ingress)
+	}
+}
+
+func TestClusterProxyStreamPorts(t *testing.T) {
+	r := NewInMemoryAppRepository(CreateAllApps())
+	a, err := FindEnvApp(r, "cluster-proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := Release{
+		Namespace: "foo",
+	}
+	values := map[string]any{
+		"streamPorts": "tcp:2222,udp:3333",
+	}
+	rendered, err := a.Render(release, env, networks, nil, values, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy, ok := rendered.Resources["cluster-proxy.yaml"]
+	if !ok {
+		t.Fatal("cluster-proxy release is not rendered")
+	}
+	for _, p := range []string{"name: tcp-2222", "port: 2222", "name: udp-3333", "protocol: udp"} {
+		if !strings.Contains(string(proxy), p) {
+			t.Fatalf("stream port %s is not rendered: %s", p, proxy)
+		}
+	}
+}
+
 func TestLonghorn(t *testing.T) {
 	contents, err := valuesTmpls.ReadFile("values-tmpl/longhorn.cue")
 	if err != nil {
@@ -638,3 +706,175 @@ func TestAppVirtualMachine(t *testing.T) {
 		t.Log(string(r))
 	}
 }
+
+var placementCue = `
+name: "placement"
+namespace: "placement"
+
+out: {
+	placement: nodeSelector: storage: "big-disk"
+	charts: {
+		foo: {
+			kind: "GitRepository"
+			address: "https://code.v1.dodo.cloud/helm-charts"
+			branch: "main"
+			path: "charts/foo"
+		}
+	}
+	helm: {
+		foo: {
+			chart: charts.foo
+			values: {}
+		}
+		bar: {
+			chart: charts.foo
+			placement: tolerations: [{
+				key: "dedicated"
+				value: "media"
+				effect: "NoSchedule"
+			}]
+			values: {}
+		}
+	}
+	postgresql: db: {
+		name: "db"
+		placement: nodeSelector: storage: "ssd"
+	}
+}`
+
+func TestAppPlacement(t *testing.T) {
+	app, err := NewCueEnvApp(CueAppData{
+		"base.cue":   []byte(cueBaseConfig),
+		"app.cue":    []byte(placementCue),
+		"global.cue": []byte(cueEnvAppGlobal),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := Release{
+		Namespace: "foo",
+	}
+	rendered, err := app.Render(release, env, networks, nil, map[string]any{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"foo.yaml":      "storage: big-disk",
+		"bar.yaml":      "key: dedicated",
+		"postgres.yaml": "storage: ssd",
+	}
+	for name, e := range expected {
+		r, ok := rendered.Resources[name]
+		if !ok {
+			t.Fatalf("%s not rendered", name)
+		}
+		if !strings.Contains(string(r), e) {
+			t.Fatalf("Expected %s to contain %s, got %s", name, e, r)
+		}
+	}
+	if strings.Contains(string(rendered.Resources["bar.yaml"]), "big-disk") {
+		t.Fatal("Expected bar placement to override the one of the app")
+	}
+}
+
+func TestClusterNetworkPortMappings(t *testing.T) {
+	r := NewInMemoryAppRepository(CreateAllApps())
+	a, err := FindEnvApp(r, "cluster-network")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := Release{
+		Namespace: "foo",
+	}
+	values := map[string]any{
+		"cluster":          "io",
+		"vpnUser":          "private-network-proxy",
+		"vpnProxyHostname": "cluster-io",
+		"vpnAuthKey":       "key",
+		"tcp":              "2222:foo/ssh:22,3333:bar/db:5432",
+	}
+	rendered, err := a.Render(release, env, networks, clusters, values, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nginx, ok := rendered.Resources["ingress-nginx.yaml"]
+	if !ok {
+		t.Fatal("ingress-nginx release is not rendered")
+	}
+	if !strings.Contains(string(nginx), `"2222": foo/ssh:22`) || !strings.Contains(string(nginx), `"3333": bar/db:5432`) {
+		t.Fatalf("tcp port mapping is not rendered: %s", nginx)
+	}
+}
+
+func TestEnvDNSSecondaries(t *testing.T) {
+	r := NewInMemoryAppRepository(CreateAllApps())
+	a, err := FindEnvApp(r, "env-dns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := Release{
+		Namespace: "foo",
+	}
+	values := map[string]any{
+		"secondaryIP": "3.3.3.3,4.4.4.4",
+	}
+	rendered, err := a.Render(release, env, networks, nil, values, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api, ok := rendered.Resources["api.yaml"]
+	if !ok {
+		t.Fatal("dns-api release is not rendered")
+	}
+	if !strings.Contains(string(api), "secondaryIP: 3.3.3.3,4.4.4.4") {
+		t.Fatalf("secondary nameservers are not rendered: %s", api)
+	}
+}
+
+func TestEnvDNSPrivateView(t *testing.T) {
+	r := NewInMemoryAppRepository(CreateAllApps())
+	a, err := FindEnvApp(r, "env-dns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := Release{
+		Namespace: "foo",
+	}
+	rendered, err := a.Render(release, env, networks, nil, map[string]any{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if api := string(rendered.Resources["api.yaml"]); !strings.Contains(api, "privateCIDR: 100.64.0.0/10,3.3.3.0/24,10.42.0.0/16,10.43.0.0/16,10.45.0.0/16,10.46.0.0/16") {
+		t.Fatalf("unexpected private view networks: %s", api)
+	}
+	if coredns := string(rendered.Resources["coredns.yaml"]); !strings.Contains(coredns, "externalTrafficPolicy: Local") {
+		t.Fatalf("expected client addresses to be preserved: %s", coredns)
+	}
+}
+
+func TestHeadscalePolicyBehindAuth(t *testing.T) {
+	r := NewInMemoryAppRepository(CreateAllApps())
+	a, err := FindEnvApp(r, "headscale")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := Release{
+		Namespace: "foo",
+	}
+	values := map[string]any{
+		"network":   "Public",
+		"subdomain": "headscale",
+		"ipSubnet":  "10.1.0.0/24",
+	}
+	rendered, err := a.Render(release, env, networks, nil, values, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := string(rendered.Resources["policy-auth-proxy.yaml"])
+	if !strings.Contains(proxy, "upstream: headscale-policy.foo.svc.cluster.local") || !strings.Contains(proxy, "groups: admin") {
+		t.Fatalf("expected policy editor to be behind auth proxy: %s", proxy)
+	}
+	if headscale := string(rendered.Resources["headscale.yaml"]); !strings.Contains(headscale, "policyAdminGroups: admin") {
+		t.Fatalf("expected policy admin groups to be passed to headscale api: %s", headscale)
+	}
+}
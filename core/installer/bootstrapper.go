@@ -373,6 +373,12 @@ func (b Bootstrapper) installFluxcd(ss soft.Client, envName string) error {
 	return nil
 }
 
+// NOTE(gio): Flux source-controller can only verify OpenPGP signatures, while
+// dodo components sign their commits with SSH keys. So verification on the
+// Flux side is out of scope for now: Flux still applies whatever is pushed to
+// the config repository. Commits are verified by AppManager instead (see
+// --allowed-signers), which refuses to build on top of unsigned changes.
+// TODO(gio): configure GitRepository verification once Flux supports it.
 func (b Bootstrapper) installFluxBootstrap(repoAddr, repoHost string, repoHostPubKeys []string, privateKey, envName string) error {
 	config, err := b.ha.New(envName)
 	if err != nil {
@@ -5,11 +5,14 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net"
 	"net/http"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"text/template"
@@ -24,6 +27,8 @@ type ClusterNetworkConfigurator interface {
 	RemoveCluster(name string, ingressIP net.IP) error
 	AddProxy(src, dst string) error
 	RemoveProxy(src, dst string) error
+	AddStreamProxy(p StreamProxy) error
+	RemoveStreamProxy(p StreamProxy) error
 }
 
 type NginxProxyConfigurator struct {
@@ -36,39 +41,30 @@ type NginxProxyConfigurator struct {
 type createARecordReq struct {
 	Entry string `json:"entry"`
 	IP    net.IP `json:"text"`
+	Owner string `json:"owner,omitempty"`
 }
 
 func (c *NginxProxyConfigurator) AddCluster(name string, ingressIP net.IP) error {
-	req := createARecordReq{
-		Entry: fmt.Sprintf("*.%s.cluster.%s", name, c.PrivateSubdomain),
-		IP:    ingressIP,
-	}
-	var buf bytes.Buffer
-	if err := json.NewEncoder(&buf).Encode(req); err != nil {
-		return err
-	}
-	resp, err := http.Post(fmt.Sprintf("%s/create-a-record", c.DNSAPIAddr), "application/json", &buf)
-	if err != nil {
-		return err
-	}
-	if resp.StatusCode != http.StatusOK {
-		var buf bytes.Buffer
-		io.Copy(&buf, resp.Body)
-		return fmt.Errorf(buf.String())
-	}
-	return nil
+	return updateClusterDNSRecord(c.DNSAPIAddr, "create-a-record", name, c.PrivateSubdomain, ingressIP)
 }
 
 func (c *NginxProxyConfigurator) RemoveCluster(name string, ingressIP net.IP) error {
+	return updateClusterDNSRecord(c.DNSAPIAddr, "delete-a-record", name, c.PrivateSubdomain, ingressIP)
+}
+
+// updateClusterDNSRecord creates or deletes wildcard A record resolving all
+// subdomains of the cluster to its ingress IP.
+func updateClusterDNSRecord(dnsAPIAddr, action, name, privateSubdomain string, ingressIP net.IP) error {
 	req := createARecordReq{
-		Entry: fmt.Sprintf("*.%s.cluster.%s", name, c.PrivateSubdomain),
+		Entry: fmt.Sprintf("*.%s.cluster.%s", name, privateSubdomain),
 		IP:    ingressIP,
+		Owner: ClusterDNSOwner(name),
 	}
 	var buf bytes.Buffer
 	if err := json.NewEncoder(&buf).Encode(req); err != nil {
 		return err
 	}
-	resp, err := http.Post(fmt.Sprintf("%s/delete-a-record", c.DNSAPIAddr), "application/json", &buf)
+	resp, err := http.Post(fmt.Sprintf("%s/%s", dnsAPIAddr, action), "application/json", &buf)
 	if err != nil {
 		return err
 	}
@@ -82,14 +78,7 @@ func (c *NginxProxyConfigurator) RemoveCluster(name string, ingressIP net.IP) er
 
 func (c *NginxProxyConfigurator) AddProxy(src, dst string) error {
 	_, err := c.Repo.Do(func(fs soft.RepoFS) (string, error) {
-		cfg, err := func() (NginxProxyConfig, error) {
-			r, err := fs.Reader(c.NginxConfigPath)
-			if err != nil {
-				return NginxProxyConfig{}, err
-			}
-			defer r.Close()
-			return ParseNginxProxyConfig(r)
-		}()
+		cfg, err := c.readProxyConfig(fs)
 		if err != nil {
 			return "", err
 		}
@@ -97,134 +86,276 @@ func (c *NginxProxyConfigurator) AddProxy(src, dst string) error {
 			return "", fmt.Errorf("wrong mapping %s already exists (%s)", src, v)
 		}
 		cfg.Proxies[src] = dst
-		w, err := fs.Writer(c.NginxConfigPath)
-		if err != nil {
-			return "", err
-		}
-		defer w.Close()
-		h := sha256.New()
-		o := io.MultiWriter(w, h)
-		if err := cfg.Render(o); err != nil {
+		if err := c.writeProxyConfig(fs, cfg); err != nil {
 			return "", err
 		}
-		hash := base64.StdEncoding.EncodeToString(h.Sum(nil))
-		nginxPath := filepath.Join(filepath.Dir(c.NginxConfigPath), "ingress-nginx.yaml")
-		nginx, err := func() (map[string]any, error) {
-			r, err := fs.Reader(nginxPath)
-			if err != nil {
-				return nil, err
-			}
-			defer r.Close()
-			var buf bytes.Buffer
-			if _, err := io.Copy(&buf, r); err != nil {
-				return nil, err
-			}
-			ret := map[string]any{}
-			if err := yaml.Unmarshal(buf.Bytes(), &ret); err != nil {
-				return nil, err
-			}
-			return ret, nil
-		}()
+		return fmt.Sprintf("add proxy mapping: %s %s", src, dst), nil
+	})
+	return err
+}
+
+func (c *NginxProxyConfigurator) RemoveProxy(src, dst string) error {
+	_, err := c.Repo.Do(func(fs soft.RepoFS) (string, error) {
+		cfg, err := c.readProxyConfig(fs)
 		if err != nil {
 			return "", err
 		}
-		cv := nginx["spec"].(map[string]any)["values"].(map[string]any)["controller"].(map[string]any)
-		var annotations map[string]any
-		if a, ok := cv["podAnnotations"]; ok {
-			annotations = a.(map[string]any)
-		} else {
-			annotations = map[string]any{}
-			cv["podAnnotations"] = annotations
+		if v, ok := cfg.Proxies[src]; ok || v != dst {
+			return "", fmt.Errorf("wrong mapping %s already exists (%s)", src, v)
 		}
-		annotations["dodo.cloud/hash"] = string(hash)
-		buf, err := yaml.Marshal(nginx)
-		if err != nil {
+		delete(cfg.Proxies, src)
+		if err := c.writeProxyConfig(fs, cfg); err != nil {
 			return "", err
 		}
-		w, err = fs.Writer(nginxPath)
+		return fmt.Sprintf("remove proxy mapping: %s %s", src, dst), nil
+	})
+	return err
+}
+
+// StreamProxy forwards raw TCP or UDP traffic received on Port of the cluster
+// proxy to Target service, given as namespace/name:port, running on the remote
+// Cluster. Traffic enters remote cluster through its ingress, which listens on
+// the same Port.
+type StreamProxy struct {
+	Cluster  string `json:"cluster"`
+	Protocol string `json:"protocol"`
+	Port     int    `json:"port"`
+	Target   string `json:"target"`
+}
+
+func (c *NginxProxyConfigurator) AddStreamProxy(p StreamProxy) error {
+	protocol := strings.ToLower(p.Protocol)
+	if protocol != "tcp" && protocol != "udp" {
+		return fmt.Errorf("invalid protocol: %s", p.Protocol)
+	}
+	upstream := fmt.Sprintf("ingress.%s.cluster.%s:%d", p.Cluster, c.PrivateSubdomain, p.Port)
+	_, err := c.Repo.Do(func(fs soft.RepoFS) (string, error) {
+		cfg, err := c.readProxyConfig(fs)
 		if err != nil {
 			return "", err
 		}
-		defer w.Close()
-		if _, err := io.Copy(w, bytes.NewReader(buf)); err != nil {
+		if s, ok := cfg.findStream(protocol, p.Port); ok && s.Upstream != upstream {
+			return "", fmt.Errorf("%s port %d is already proxied to %s", protocol, p.Port, s.Upstream)
+		} else if !ok {
+			cfg.Streams = append(cfg.Streams, NginxStreamProxy{protocol, p.Port, upstream})
+		}
+		if err := c.writeProxyConfig(fs, cfg); err != nil {
 			return "", err
 		}
-		return fmt.Sprintf("add proxy mapping: %s %s", src, dst), nil
+		if err := addClusterIngressPort(fs, protocol, p); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("add %s stream proxy: %d %s/%s", protocol, p.Port, p.Cluster, p.Target), nil
 	})
 	return err
 }
 
-func (c *NginxProxyConfigurator) RemoveProxy(src, dst string) error {
+func (c *NginxProxyConfigurator) RemoveStreamProxy(p StreamProxy) error {
+	protocol := strings.ToLower(p.Protocol)
 	_, err := c.Repo.Do(func(fs soft.RepoFS) (string, error) {
-		cfg, err := func() (NginxProxyConfig, error) {
-			r, err := fs.Reader(c.NginxConfigPath)
-			if err != nil {
-				return NginxProxyConfig{}, err
-			}
-			defer r.Close()
-			return ParseNginxProxyConfig(r)
-		}()
+		cfg, err := c.readProxyConfig(fs)
 		if err != nil {
 			return "", err
 		}
-		if v, ok := cfg.Proxies[src]; ok || v != dst {
-			return "", fmt.Errorf("wrong mapping %s already exists (%s)", src, v)
-		}
-		delete(cfg.Proxies, src)
-		w, err := fs.Writer(c.NginxConfigPath)
-		if err != nil {
-			return "", err
+		if _, ok := cfg.findStream(protocol, p.Port); !ok {
+			return "", fmt.Errorf("%s port %d is not proxied", protocol, p.Port)
 		}
-		defer w.Close()
-		h := sha256.New()
-		o := io.MultiWriter(w, h)
-		if err := cfg.Render(o); err != nil {
+		cfg.removeStream(protocol, p.Port)
+		if err := c.writeProxyConfig(fs, cfg); err != nil {
 			return "", err
 		}
-		hash := base64.StdEncoding.EncodeToString(h.Sum(nil))
-		nginxPath := filepath.Join(filepath.Dir(c.NginxConfigPath), "ingress-nginx.yaml")
-		nginx, err := func() (map[string]any, error) {
-			r, err := fs.Reader(nginxPath)
-			if err != nil {
-				return nil, err
-			}
-			defer r.Close()
-			var buf bytes.Buffer
-			if _, err := io.Copy(&buf, r); err != nil {
-				return nil, err
-			}
-			ret := map[string]any{}
-			if err := yaml.Unmarshal(buf.Bytes(), &ret); err != nil {
-				return nil, err
-			}
-			return ret, nil
-		}()
-		if err != nil {
+		if err := removeClusterIngressPort(fs, protocol, p); err != nil {
 			return "", err
 		}
-		cv := nginx["spec"].(map[string]any)["values"].(map[string]any)["controller"].(map[string]any)
-		var annotations map[string]any
-		if a, ok := cv["podAnnotations"]; ok {
-			annotations = a.(map[string]any)
+		return fmt.Sprintf("remove %s stream proxy: %d %s/%s", protocol, p.Port, p.Cluster, p.Target), nil
+	})
+	return err
+}
+
+func (c *NginxProxyConfigurator) readProxyConfig(fs soft.RepoFS) (NginxProxyConfig, error) {
+	r, err := fs.Reader(c.NginxConfigPath)
+	if err != nil {
+		return NginxProxyConfig{}, err
+	}
+	defer r.Close()
+	return ParseNginxProxyConfig(r)
+}
+
+// writeProxyConfig also updates hash of the config on ingress-nginx pods,
+// forcing them to restart and pick up the changes.
+func (c *NginxProxyConfigurator) writeProxyConfig(fs soft.RepoFS, cfg NginxProxyConfig) error {
+	w, err := fs.Writer(c.NginxConfigPath)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	h := sha256.New()
+	o := io.MultiWriter(w, h)
+	if err := cfg.Render(o); err != nil {
+		return err
+	}
+	hash := base64.StdEncoding.EncodeToString(h.Sum(nil))
+	nginxPath := filepath.Join(filepath.Dir(c.NginxConfigPath), "ingress-nginx.yaml")
+	nginx, err := readHelmRelease(fs, nginxPath)
+	if err != nil {
+		return err
+	}
+	cv := nginx["spec"].(map[string]any)["values"].(map[string]any)["controller"].(map[string]any)
+	var annotations map[string]any
+	if a, ok := cv["podAnnotations"]; ok {
+		annotations = a.(map[string]any)
+	} else {
+		annotations = map[string]any{}
+		cv["podAnnotations"] = annotations
+	}
+	annotations["dodo.cloud/hash"] = string(hash)
+	return writeHelmRelease(fs, nginxPath, nginx)
+}
+
+// clusterIngressNginxPath points to the ingress-nginx release of the remote
+// cluster network, installed under /clusters/{name}/ingress.
+func clusterIngressNginxPath(cluster string) string {
+	return fmt.Sprintf("/clusters/%s/ingress/resources/ingress-nginx.yaml", cluster)
+}
+
+func clusterIngressConfigDir(cluster string) string {
+	return fmt.Sprintf("/clusters/%s/ingress", cluster)
+}
+
+// updateIngressPorts lets fn modify TCP and UDP port mappings of the
+// ingress-nginx release at given path, in the same format port-allocator
+// manages them: source port to namespace/name:port of the target service.
+func updateIngressPorts(fs soft.RepoFS, path string, fn func(tcp, udp map[string]any) error) error {
+	rel, err := readHelmRelease(fs, path)
+	if err != nil {
+		return err
+	}
+	values := rel["spec"].(map[string]any)["values"].(map[string]any)
+	ports := map[string]map[string]any{}
+	for _, protocol := range []string{"tcp", "udp"} {
+		if p, ok := values[protocol].(map[string]any); ok {
+			ports[protocol] = p
 		} else {
-			annotations = map[string]any{}
-			cv["podAnnotations"] = annotations
+			ports[protocol] = map[string]any{}
+			values[protocol] = ports[protocol]
 		}
-		annotations["dodo.cloud/hash"] = string(hash)
-		buf, err := yaml.Marshal(nginx)
-		if err != nil {
-			return "", err
+	}
+	if err := fn(ports["tcp"], ports["udp"]); err != nil {
+		return err
+	}
+	return writeHelmRelease(fs, path, rel)
+}
+
+// updateClusterIngressPorts modifies port mappings of the remote cluster
+// ingress. Besides the rendered release, mappings are recorded in the input
+// of the cluster network app as well, so that they survive it being rendered
+// again.
+func updateClusterIngressPorts(fs soft.RepoFS, cluster string, fn func(tcp, udp map[string]any) error) error {
+	var tcp, udp map[string]any
+	if err := updateIngressPorts(fs, clusterIngressNginxPath(cluster), func(t, u map[string]any) error {
+		tcp, udp = t, u
+		return fn(t, u)
+	}); err != nil {
+		return err
+	}
+	return writeClusterIngressPortsInput(fs, clusterIngressConfigDir(cluster), tcp, udp)
+}
+
+// writeClusterIngressPortsInput stores port mappings as tcp and udp inputs of
+// the app installed at appDir. See cluster-network.cue for the format.
+func writeClusterIngressPortsInput(r soft.RepoFS, appDir string, tcp, udp map[string]any) error {
+	cfgPath := filepath.Join(appDir, "config.json")
+	var cfg map[string]any
+	if err := soft.ReadJson(r, cfgPath, &cfg); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
 		}
-		w, err = fs.Writer(nginxPath)
-		if err != nil {
-			return "", err
+		return err
+	}
+	input, ok := cfg["input"].(map[string]any)
+	if !ok {
+		input = map[string]any{}
+		cfg["input"] = input
+	}
+	input["tcp"] = portMappingsToInput(tcp)
+	input["udp"] = portMappingsToInput(udp)
+	if err := soft.WriteJson(r, cfgPath, cfg); err != nil {
+		return err
+	}
+	return soft.WriteYaml(r, filepath.Join(appDir, configFileName), cfg)
+}
+
+// portMappingsToInput converts port mappings to the comma separated list of
+// port:target entries, sorted by port.
+func portMappingsToInput(ports map[string]any) string {
+	ret := make([]string, 0, len(ports))
+	for port, target := range ports {
+		ret = append(ret, fmt.Sprintf("%s:%s", port, target))
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		pi, _ := strconv.Atoi(strings.SplitN(ret[i], ":", 2)[0])
+		pj, _ := strconv.Atoi(strings.SplitN(ret[j], ":", 2)[0])
+		return pi < pj
+	})
+	return strings.Join(ret, ",")
+}
+
+// addClusterIngressPort exposes target service of the stream proxy on its
+// port through the ingress of the remote cluster.
+func addClusterIngressPort(fs soft.RepoFS, protocol string, p StreamProxy) error {
+	return updateClusterIngressPorts(fs, p.Cluster, func(tcp, udp map[string]any) error {
+		ports := tcp
+		if protocol == "udp" {
+			ports = udp
 		}
-		defer w.Close()
-		if _, err := io.Copy(w, bytes.NewReader(buf)); err != nil {
-			return "", err
+		key := strconv.Itoa(p.Port)
+		if v, ok := ports[key]; ok && v != p.Target {
+			return fmt.Errorf("%s port %d is already mapped to %s on %s cluster", protocol, p.Port, v, p.Cluster)
 		}
-		return fmt.Sprintf("remove proxy mapping: %s %s", src, dst), nil
+		ports[key] = p.Target
+		return nil
 	})
+}
+
+func removeClusterIngressPort(fs soft.RepoFS, protocol string, p StreamProxy) error {
+	return updateClusterIngressPorts(fs, p.Cluster, func(tcp, udp map[string]any) error {
+		if protocol == "udp" {
+			delete(udp, strconv.Itoa(p.Port))
+		} else {
+			delete(tcp, strconv.Itoa(p.Port))
+		}
+		return nil
+	})
+}
+
+func readHelmRelease(fs soft.RepoFS, path string) (map[string]any, error) {
+	r, err := fs.Reader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, r); err != nil {
+		return nil, err
+	}
+	ret := map[string]any{}
+	if err := yaml.Unmarshal(buf.Bytes(), &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func writeHelmRelease(fs soft.RepoFS, path string, rel map[string]any) error {
+	buf, err := yaml.Marshal(rel)
+	if err != nil {
+		return err
+	}
+	w, err := fs.Writer(path)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	_, err = io.Copy(w, bytes.NewReader(buf))
 	return err
 }
 
@@ -232,9 +363,37 @@ type NginxProxyConfig struct {
 	Port      int
 	Resolvers []net.IP
 	Proxies   map[string]string
+	Streams   []NginxStreamProxy
 	PreConf   []string
 }
 
+// NginxStreamProxy passes connections received on Port to the Upstream
+// address given as host:port.
+type NginxStreamProxy struct {
+	Protocol string
+	Port     int
+	Upstream string
+}
+
+func (c NginxProxyConfig) findStream(protocol string, port int) (NginxStreamProxy, bool) {
+	for _, s := range c.Streams {
+		if s.Protocol == protocol && s.Port == port {
+			return s, true
+		}
+	}
+	return NginxStreamProxy{}, false
+}
+
+func (c *NginxProxyConfig) removeStream(protocol string, port int) {
+	streams := make([]NginxStreamProxy, 0, len(c.Streams))
+	for _, s := range c.Streams {
+		if s.Protocol != protocol || s.Port != port {
+			streams = append(streams, s)
+		}
+	}
+	c.Streams = streams
+}
+
 func ParseNginxProxyConfig(r io.Reader) (NginxProxyConfig, error) {
 	var buf strings.Builder
 	if _, err := io.Copy(&buf, r); err != nil {
@@ -248,6 +407,8 @@ func ParseNginxProxyConfig(r io.Reader) (NginxProxyConfig, error) {
 	lines := strings.Split(buf.String(), "\n")
 	insideConf := true
 	insideMap := false
+	insideStream := false
+	var stream *NginxStreamProxy
 	for _, l := range lines {
 		items := strings.Fields(strings.TrimSuffix(l, ";"))
 		if len(items) == 0 {
@@ -258,6 +419,37 @@ func ParseNginxProxyConfig(r io.Reader) (NginxProxyConfig, error) {
 			insideConf = false
 		} else if insideConf {
 			ret.PreConf = append(ret.PreConf, l)
+		} else if insideStream {
+			switch items[0] {
+			case "server":
+				stream = &NginxStreamProxy{Protocol: "tcp"}
+			case "listen":
+				if stream == nil || len(items) < 2 {
+					return NginxProxyConfig{}, fmt.Errorf("invalid stream listen: %s", l)
+				}
+				port, err := strconv.Atoi(items[1])
+				if err != nil {
+					return NginxProxyConfig{}, err
+				}
+				stream.Port = port
+				if len(items) > 2 && items[2] == "udp" {
+					stream.Protocol = "udp"
+				}
+			case "set":
+				if stream == nil || len(items) < 3 || items[1] != "$upstream" {
+					return NginxProxyConfig{}, fmt.Errorf("invalid stream upstream: %s", l)
+				}
+				stream.Upstream = items[2]
+			case "}":
+				if stream != nil {
+					ret.Streams = append(ret.Streams, *stream)
+					stream = nil
+				} else {
+					insideStream = false
+				}
+			}
+		} else if items[0] == "stream" {
+			insideStream = true
 		} else if strings.Contains(l, "listen") {
 			if len(items) < 2 {
 				return NginxProxyConfig{}, fmt.Errorf("invalid listen: %s\n", l)
@@ -296,6 +488,12 @@ func (c NginxProxyConfig) Render(w io.Writer) error {
 	for _, l := range c.PreConf {
 		fmt.Fprintln(w, l)
 	}
+	sort.Slice(c.Streams, func(i, j int) bool {
+		if c.Streams[i].Port != c.Streams[j].Port {
+			return c.Streams[i].Port < c.Streams[j].Port
+		}
+		return c.Streams[i].Protocol < c.Streams[j].Protocol
+	})
 	tmpl, err := template.New("nginx.conf").Parse(nginxConfigTmpl)
 	if err != nil {
 		return err
@@ -303,6 +501,8 @@ func (c NginxProxyConfig) Render(w io.Writer) error {
 	return tmpl.Execute(w, c)
 }
 
+// NOTE(gio): Stream upstreams are set through variable so that they are
+// resolved with given resolvers at connection time, instead of on startup.
 const nginxConfigTmpl = `    worker_processes  1;
     worker_rlimit_nofile 8192;
     events {
@@ -323,4 +523,18 @@ const nginxConfigTmpl = `    worker_processes  1;
                 proxy_pass http://$backend;
             }
         }
-    }`
+    }
+    {{- if .Streams }}
+    stream {
+        {{- range .Streams }}
+        server {
+            listen {{ .Port }}{{ if eq .Protocol "udp" }} udp{{ end }};
+            {{- range $.Resolvers }}
+            resolver {{ . }};
+            {{- end }}
+            set $upstream {{ .Upstream }};
+            proxy_pass $upstream;
+        }
+        {{- end }}
+    }
+    {{- end }}`
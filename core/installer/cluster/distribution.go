@@ -0,0 +1,73 @@
+package cluster
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Distribution installs and maintains particular Kubernetes distribution on
+// servers accessed over SSH. Versions are given in the format distribution
+// itself reports them, empty one meaning latest.
+type Distribution interface {
+	Name() string
+	// Init bootstraps first controller of the cluster, with API server
+	// advertised on given address.
+	Init(c *SSHClient, version, addr string) error
+	JoinController(c *SSHClient, version, serverAddr, token string) error
+	JoinWorker(c *SSHClient, version, serverAddr, token string) error
+	// JoinTokens are generated on the first controller right after Init and
+	// used for joining servers afterwards.
+	JoinTokens(c *SSHClient) (controller string, worker string, err error)
+	// Kubeconfig returns admin credentials with API server address
+	// rewritten to given one.
+	Kubeconfig(c *SSHClient, addr string) (string, error)
+	Version(c *SSHClient) (string, error)
+	// KubeletVersion maps distribution version to the one its kubelets
+	// report to the API server.
+	KubeletVersion(version string) string
+	// ValidateVersion checks that version names an exact release of the
+	// distribution.
+	ValidateVersion(version string) error
+	Upgrade(c *SSHClient, version string, worker bool) error
+	Uninstall(c *SSHClient, worker bool) error
+	// Snapshot saves control plane datastore of the controller as a single
+	// file in given directory and returns its path.
+	Snapshot(c *SSHClient, dir string) (string, error)
+	// Restore rebuilds controller from the snapshot uploaded to given path,
+	// installing distribution first if needed. Whatever datastore the
+	// controller had is discarded.
+	Restore(c *SSHClient, version, snapshot, addr, token string) error
+}
+
+const DefaultDistribution = "k3s"
+
+var distributions = map[string]Distribution{}
+
+func registerDistribution(d Distribution) {
+	distributions[d.Name()] = d
+}
+
+func GetDistribution(name string) (Distribution, error) {
+	// NOTE(gio): Clusters created before distributions were configurable
+	// have none recorded in their state.
+	if name == "" {
+		name = DefaultDistribution
+	}
+	if d, ok := distributions[name]; ok {
+		return d, nil
+	}
+	return nil, fmt.Errorf("unknown distribution: %s", name)
+}
+
+// ListDistributions returns names of all known distributions, default one
+// first.
+func ListDistributions() []string {
+	ret := make([]string, 0, len(distributions))
+	for name := range distributions {
+		if name != DefaultDistribution {
+			ret = append(ret, name)
+		}
+	}
+	sort.Strings(ret)
+	return append([]string{DefaultDistribution}, ret...)
+}
@@ -0,0 +1,31 @@
+package cluster
+
+import (
+	"testing"
+)
+
+func TestValidateVersion(t *testing.T) {
+	for _, tc := range []struct {
+		dist    string
+		version string
+		valid   bool
+	}{
+		{"k3s", "v1.30.4+k3s1", true},
+		{"k3s", "v1.31.0-rc1+k3s1", true},
+		{"k3s", "v1.30.4+k0s.0", false},
+		{"k3s", "v1.30.4", false},
+		{"k3s", "v1.30.4+k3s1; rm -rf /", false},
+		{"k3s", "v1.30.4+k3s1\n", false},
+		{"k0s", "v1.30.4+k0s.0", true},
+		{"k0s", "v1.30.4+k3s1", false},
+		{"k0s", "v1.30.4+k0s.0 $(reboot)", false},
+	} {
+		d, err := GetDistribution(tc.dist)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.ValidateVersion(tc.version); (err == nil) != tc.valid {
+			t.Errorf("%s %q: expected valid %t, got %v", tc.dist, tc.version, tc.valid, err)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/giolekva/pcloud/core/installer/kube"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type NodeHealth struct {
+	Name           string   `json:"name"`
+	Ready          bool     `json:"ready"`
+	DiskPressure   bool     `json:"diskPressure"`
+	MemoryPressure bool     `json:"memoryPressure"`
+	KubeletVersion string   `json:"kubeletVersion"`
+	Tailscale      bool     `json:"tailscale"`
+	Problems       []string `json:"problems,omitempty"`
+}
+
+type Health struct {
+	Checked time.Time `json:"checked"`
+	// Error is set when cluster API server itself could not be reached.
+	Error string       `json:"error,omitempty"`
+	Nodes []NodeHealth `json:"nodes"`
+}
+
+func (h Health) Degraded() bool {
+	if h.Error != "" {
+		return true
+	}
+	for _, n := range h.Nodes {
+		if len(n.Problems) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Problems lists human readable descriptions of everything wrong with the
+// cluster, one entry per problem.
+func (h Health) Problems() []string {
+	var ret []string
+	if h.Error != "" {
+		ret = append(ret, h.Error)
+	}
+	for _, n := range h.Nodes {
+		for _, p := range n.Problems {
+			ret = append(ret, fmt.Sprintf("%s: %s", n.Name, p))
+		}
+	}
+	return ret
+}
+
+func (m *KubeManager) Health() Health {
+	m.l.Lock()
+	defer m.l.Unlock()
+	ret := Health{Checked: time.Now()}
+	servers := append(append([]Server{}, m.controllers...), m.workers...)
+	nodes := map[string]corev1.Node{}
+	if client, err := kube.NewKubeClient(kube.KubeConfigOpts{KubeConfig: m.kubeCfg}); err != nil {
+		ret.Error = err.Error()
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if l, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{}); err != nil {
+			ret.Error = fmt.Sprintf("api server unreachable: %s", err)
+		} else {
+			for _, n := range l.Items {
+				nodes[n.Name] = n
+			}
+		}
+	}
+	if m.imported {
+		// NOTE(gio): Servers of imported clusters are not accessible over
+		// SSH, relying on what API server reports about them.
+		for _, n := range nodes {
+			ret.Nodes = append(ret.Nodes, m.nodeHealth(n))
+		}
+		sort.Slice(ret.Nodes, func(i, j int) bool {
+			return ret.Nodes[i].Name < ret.Nodes[j].Name
+		})
+		return ret
+	}
+	for _, s := range servers {
+		h := NodeHealth{Name: s.Name}
+		if node, ok := nodes[s.Name]; ok {
+			h = m.nodeHealth(node)
+		} else if ret.Error == "" {
+			h.Problems = append(h.Problems, "not registered with the cluster")
+		}
+		if err := m.checkTailscale(s); err != nil {
+			h.Problems = append(h.Problems, fmt.Sprintf("tailscale: %s", err))
+		} else {
+			h.Tailscale = true
+		}
+		ret.Nodes = append(ret.Nodes, h)
+	}
+	return ret
+}
+
+// Expects manager state to be locked by caller.
+func (m *KubeManager) nodeHealth(node corev1.Node) NodeHealth {
+	h := NodeHealth{Name: node.Name}
+	for _, c := range node.Status.Conditions {
+		switch c.Type {
+		case corev1.NodeReady:
+			h.Ready = c.Status == corev1.ConditionTrue
+		case corev1.NodeDiskPressure:
+			h.DiskPressure = c.Status == corev1.ConditionTrue
+		case corev1.NodeMemoryPressure:
+			h.MemoryPressure = c.Status == corev1.ConditionTrue
+		}
+	}
+	h.KubeletVersion = node.Status.NodeInfo.KubeletVersion
+	if !h.Ready {
+		h.Problems = append(h.Problems, "not ready")
+	}
+	if h.DiskPressure {
+		h.Problems = append(h.Problems, "disk pressure")
+	}
+	if h.MemoryPressure {
+		h.Problems = append(h.Problems, "memory pressure")
+	}
+	// Versions of imported clusters are managed by their owners.
+	if !m.imported && m.version != "" && h.KubeletVersion != m.dist.KubeletVersion(m.version) {
+		h.Problems = append(h.Problems, fmt.Sprintf("kubelet version %s, expected %s", h.KubeletVersion, m.dist.KubeletVersion(m.version)))
+	}
+	return h
+}
+
+// Expects manager state to be locked by caller.
+func (m *KubeManager) checkTailscale(s Server) error {
+	c, err := m.connect(&s)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	_, err = ReadTailscaleIP(c)
+	return err
+}
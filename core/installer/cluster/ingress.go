@@ -0,0 +1,36 @@
+package cluster
+
+import (
+	"fmt"
+	"net"
+)
+
+func (m *KubeManager) AddFallbackIngressIP(ip net.IP) error {
+	m.l.Lock()
+	defer m.l.Unlock()
+	if m.ingressIP == nil {
+		return fmt.Errorf("cluster ingress is not set up yet")
+	}
+	if m.ingressIP.Equal(ip) {
+		return fmt.Errorf("%s is the primary ingress IP", ip)
+	}
+	for _, i := range m.fallbackIPs {
+		if i.Equal(ip) {
+			return nil
+		}
+	}
+	m.fallbackIPs = append(m.fallbackIPs, ip)
+	return nil
+}
+
+func (m *KubeManager) RemoveFallbackIngressIP(ip net.IP) error {
+	m.l.Lock()
+	defer m.l.Unlock()
+	for i, f := range m.fallbackIPs {
+		if f.Equal(ip) {
+			m.fallbackIPs = append(m.fallbackIPs[:i], m.fallbackIPs[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("not found")
+}
@@ -0,0 +1,34 @@
+package cluster
+
+import (
+	"net"
+	"testing"
+)
+
+func TestFallbackIngressIPs(t *testing.T) {
+	m, err := RestoreKubeManager(State{Name: "io", IngressIP: net.ParseIP("100.64.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddFallbackIngressIP(net.ParseIP("100.64.0.1")); err == nil {
+		t.Fatal("expected primary ingress IP to be rejected")
+	}
+	for i := 0; i < 2; i++ {
+		if err := m.AddFallbackIngressIP(net.ParseIP("100.64.0.2")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ips := m.State().IngressIPs()
+	if len(ips) != 2 || !ips[0].Equal(net.ParseIP("100.64.0.1")) || !ips[1].Equal(net.ParseIP("100.64.0.2")) {
+		t.Fatalf("unexpected ingress IPs: %s", ips)
+	}
+	if err := m.RemoveFallbackIngressIP(net.ParseIP("100.64.0.2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RemoveFallbackIngressIP(net.ParseIP("100.64.0.2")); err == nil {
+		t.Fatal("expected missing fallback ingress IP to be rejected")
+	}
+	if ips := m.State().IngressIPs(); len(ips) != 1 {
+		t.Fatalf("unexpected ingress IPs: %s", ips)
+	}
+}
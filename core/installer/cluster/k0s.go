@@ -0,0 +1,260 @@
+package cluster
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	k0sInstallScript = "curl -sSLf https://get.k0s.sh | sudo %ssh"
+	k0sConfigPath    = "/etc/k0s/k0s.yaml"
+	k0sTokenPath     = "/etc/k0s/join-token"
+	// Mirrors k3s setup, so that apps do not have to care which one they
+	// are running on.
+	k0sConfigTmpl = `apiVersion: k0s.k0sproject.io/v1beta1
+kind: ClusterConfig
+metadata:
+  name: k0s
+spec:%s
+  network:
+    podCIDR: 10.45.0.0/16
+    serviceCIDR: 10.46.0.0/16
+    kubeProxy:
+      mode: ipvs
+      ipvs:
+        strictARP: true
+`
+	k0sAPITmpl = `
+  api:
+    address: %s
+    sans:
+    - %s`
+)
+
+var k0sKubeconfigServer = regexp.MustCompile(`server: https://[^\s]+:6443`)
+var k0sVersion = regexp.MustCompile(`^v\d+\.\d+\.\d+(-rc\d+)?\+k0s\.\d+$`)
+
+type k0s struct{}
+
+func init() {
+	registerDistribution(k0s{})
+}
+
+func (d k0s) Name() string {
+	return "k0s"
+}
+
+// installCmd pins k0s to given version, latest one is installed if empty.
+func (d k0s) installCmd(version string) string {
+	env := ""
+	if version != "" {
+		env = fmt.Sprintf("K0S_VERSION=%s ", shellQuote(version))
+	}
+	return fmt.Sprintf(k0sInstallScript, env)
+}
+
+func (d k0s) writeConfig(c *SSHClient, addr string) error {
+	api := ""
+	if addr != "" {
+		api = fmt.Sprintf(k0sAPITmpl, addr, addr)
+	}
+	_, err := c.Exec(fmt.Sprintf(
+		"sudo mkdir -p /etc/k0s && echo %s | sudo tee %s > /dev/null",
+		shellQuote(fmt.Sprintf(k0sConfigTmpl, api)),
+		k0sConfigPath,
+	))
+	return err
+}
+
+func (d k0s) writeToken(c *SSHClient, token string) error {
+	_, err := c.Exec(fmt.Sprintf(
+		"sudo mkdir -p /etc/k0s && echo %s | sudo tee %s > /dev/null && sudo chmod 600 %s",
+		shellQuote(token),
+		k0sTokenPath,
+		k0sTokenPath,
+	))
+	return err
+}
+
+// NOTE(gio): Controllers are started with --enable-worker so they can run
+// workloads, same as k3s servers do.
+func (d k0s) Init(c *SSHClient, version, addr string) error {
+	fmt.Println("Starting k0s")
+	if _, err := c.Exec("which k0s"); err == nil {
+		return nil
+	}
+	if _, err := c.Exec(d.installCmd(version)); err != nil {
+		return err
+	}
+	if err := d.writeConfig(c, addr); err != nil {
+		return err
+	}
+	if _, err := c.Exec(fmt.Sprintf("sudo k0s install controller --enable-worker --no-taints -c %s && sudo k0s start", k0sConfigPath)); err != nil {
+		return err
+	}
+	return d.waitForAPIServer(c, 5*time.Minute)
+}
+
+// JoinController relies on join token to find the cluster, serverAddr is not
+// used.
+// TODO(gio): advertise API server of joined controllers on their Tailscale
+// address as well.
+func (d k0s) JoinController(c *SSHClient, version, serverAddr, token string) error {
+	fmt.Println("Starting k0s")
+	if _, err := c.Exec("which k0s"); err == nil {
+		return nil
+	}
+	if _, err := c.Exec(d.installCmd(version)); err != nil {
+		return err
+	}
+	if err := d.writeConfig(c, ""); err != nil {
+		return err
+	}
+	if err := d.writeToken(c, token); err != nil {
+		return err
+	}
+	_, err := c.Exec(fmt.Sprintf("sudo k0s install controller --enable-worker --no-taints -c %s --token-file %s && sudo k0s start", k0sConfigPath, k0sTokenPath))
+	return err
+}
+
+func (d k0s) JoinWorker(c *SSHClient, version, serverAddr, token string) error {
+	fmt.Println("Starting k0s")
+	if _, err := c.Exec("which k0s"); err == nil {
+		return nil
+	}
+	if _, err := c.Exec(d.installCmd(version)); err != nil {
+		return err
+	}
+	if err := d.writeToken(c, token); err != nil {
+		return err
+	}
+	_, err := c.Exec(fmt.Sprintf("sudo k0s install worker --token-file %s && sudo k0s start", k0sTokenPath))
+	return err
+}
+
+func (d k0s) waitForAPIServer(c *SSHClient, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		_, err := c.Exec("sudo k0s kubectl get --raw=/readyz")
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("k0s API server not ready after %s: %w", timeout, err)
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func (d k0s) JoinTokens(c *SSHClient) (string, string, error) {
+	fmt.Println("Creating join tokens")
+	controller, err := c.Exec("sudo k0s token create --role=controller")
+	if err != nil {
+		return "", "", err
+	}
+	worker, err := c.Exec("sudo k0s token create --role=worker")
+	if err != nil {
+		return "", "", err
+	}
+	return strings.TrimSpace(controller), strings.TrimSpace(worker), nil
+}
+
+func (d k0s) Kubeconfig(c *SSHClient, addr string) (string, error) {
+	fmt.Println("Getting Kubeconfig")
+	out, err := c.Exec("sudo k0s kubeconfig admin")
+	if err != nil {
+		return "", err
+	}
+	return k0sKubeconfigServer.ReplaceAllString(out, fmt.Sprintf("server: https://%s:6443", addr)), nil
+}
+
+func (d k0s) Version(c *SSHClient) (string, error) {
+	out, err := c.Exec("k0s version")
+	if err != nil {
+		return "", err
+	}
+	// v1.30.4+k0s.0
+	return strings.TrimSpace(out), nil
+}
+
+// KubeletVersion drops k0s release number, v1.30.4+k0s.0 kubelets report
+// themselves as v1.30.4+k0s.
+func (d k0s) KubeletVersion(version string) string {
+	if i := strings.Index(version, "+k0s"); i != -1 {
+		return version[:i+len("+k0s")]
+	}
+	return version
+}
+
+func (d k0s) ValidateVersion(version string) error {
+	if !k0sVersion.MatchString(version) {
+		return fmt.Errorf("invalid k0s version: %s", version)
+	}
+	return nil
+}
+
+// Upgrade stops k0s before replacing its binary, as the running one can not
+// be overwritten, and starts it back with configuration intact.
+func (d k0s) Upgrade(c *SSHClient, version string, worker bool) error {
+	fmt.Printf("Upgrading k0s to %s\n", version)
+	_, err := c.Exec(strings.Join([]string{
+		"sudo k0s stop",
+		d.installCmd(version),
+		"sudo k0s start",
+	}, " && "))
+	return err
+}
+
+func (d k0s) Uninstall(c *SSHClient, worker bool) error {
+	fmt.Println("Uninstalling k0s")
+	if _, err := c.Exec("which k0s"); err != nil {
+		return nil
+	}
+	// NOTE(gio): Binary is removed as well, so that joining the server again
+	// installs k0s from scratch.
+	_, err := c.Exec("sudo k0s stop; sudo k0s reset && sudo rm -f $(which k0s)")
+	return err
+}
+
+// Snapshot creates k0s backup archive, which besides etcd data includes
+// certificates and configuration needed to rebuild the controller.
+func (d k0s) Snapshot(c *SSHClient, dir string) (string, error) {
+	fmt.Println("Taking k0s backup")
+	if _, err := c.Exec(fmt.Sprintf("sudo k0s backup --save-path %s", dir)); err != nil {
+		return "", err
+	}
+	out, err := c.Exec(fmt.Sprintf("sudo find %s -type f -name 'k0s_backup_*'", dir))
+	if err != nil {
+		return "", err
+	}
+	path, _, _ := strings.Cut(strings.TrimSpace(out), "\n")
+	if path == "" {
+		return "", fmt.Errorf("k0s backup not found in %s", dir)
+	}
+	return path, nil
+}
+
+func (d k0s) Restore(c *SSHClient, version, snapshot, addr, token string) error {
+	fmt.Println("Restoring k0s from backup")
+	if _, err := c.Exec("which k0s"); err != nil {
+		if _, err := c.Exec(d.installCmd(version)); err != nil {
+			return err
+		}
+	} else if _, err := c.Exec("sudo k0s stop; sudo k0s reset"); err != nil {
+		return err
+	}
+	if _, err := c.Exec(fmt.Sprintf("sudo k0s restore %s", snapshot)); err != nil {
+		return err
+	}
+	// NOTE(gio): Restored configuration advertises API server on the address
+	// of the old controller.
+	if err := d.writeConfig(c, addr); err != nil {
+		return err
+	}
+	if _, err := c.Exec(fmt.Sprintf("sudo k0s install controller --enable-worker --no-taints -c %s && sudo k0s start", k0sConfigPath)); err != nil {
+		return err
+	}
+	return d.waitForAPIServer(c, 5*time.Minute)
+}
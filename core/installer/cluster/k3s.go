@@ -0,0 +1,231 @@
+package cluster
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	k3sInstallScript = "curl -sfL https://get.k3s.io | %ssh -s - %s"
+	k3sDBDir         = "/var/lib/rancher/k3s/server/db"
+	k3sServerArgs    = "--disable traefik --disable local-storage --disable servicelb --kube-proxy-arg proxy-mode=ipvs --kube-proxy-arg ipvs-strict-arp --flannel-backend vxlan --cluster-cidr=10.45.0.0/16 --service-cidr=10.46.0.0/16 # --flannel-iface=tailscale0"
+	k3sReleaseURL    = "https://github.com/k3s-io/k3s/releases/download/%s/%s"
+)
+
+var k3sVersion = regexp.MustCompile(`^v\d+\.\d+\.\d+(-rc\d+)?\+k3s\d+$`)
+
+type k3s struct{}
+
+func init() {
+	registerDistribution(k3s{})
+}
+
+func (d k3s) Name() string {
+	return "k3s"
+}
+
+// installCmd pins k3s to given version, latest one is installed if empty.
+func (d k3s) installCmd(version, args string) string {
+	return d.installCmdWithEnv("", version, args)
+}
+
+func (d k3s) installCmdWithEnv(env, version, args string) string {
+	if version != "" {
+		env = fmt.Sprintf("%sINSTALL_K3S_VERSION=%s ", env, shellQuote(version))
+	}
+	return fmt.Sprintf(k3sInstallScript, env, args)
+}
+
+func (d k3s) Init(c *SSHClient, version, addr string) error {
+	fmt.Println("Starting k3s")
+	if _, err := c.Exec("which k3s"); err == nil {
+		return nil
+	}
+	_, err := c.Exec(d.installCmd(version, fmt.Sprintf("--cluster-init %s", k3sServerArgs)))
+	return err
+}
+
+func (d k3s) JoinController(c *SSHClient, version, serverAddr, token string) error {
+	fmt.Println("Starting k3s")
+	if _, err := c.Exec("which k3s"); err == nil {
+		return nil
+	}
+	_, err := c.Exec(d.installCmd(version, fmt.Sprintf("server --server=https://%s --token=%s %s", serverAddr, token, k3sServerArgs)))
+	return err
+}
+
+func (d k3s) JoinWorker(c *SSHClient, version, serverAddr, token string) error {
+	fmt.Println("Starting k3s")
+	if _, err := c.Exec("which k3s"); err == nil {
+		return nil
+	}
+	_, err := c.Exec(d.installCmd(version, fmt.Sprintf("agent --server=https://%s --token=%s", serverAddr, token)))
+	return err
+}
+
+// JoinTokens returns the same node token for both roles, as k3s does not
+// differentiate between them.
+func (d k3s) JoinTokens(c *SSHClient) (string, string, error) {
+	fmt.Println("Getting server token")
+	out, err := c.Exec("sudo cat /var/lib/rancher/k3s/server/node-token")
+	if err != nil {
+		return "", "", err
+	}
+	token := strings.TrimSpace(out)
+	return token, token, nil
+}
+
+func (d k3s) Kubeconfig(c *SSHClient, addr string) (string, error) {
+	fmt.Println("Getting Kubeconfig")
+	out, err := c.Exec("sudo cat /etc/rancher/k3s/k3s.yaml")
+	if err != nil {
+		return "", err
+	}
+	return strings.Replace(out, "server: https://127.0.0.1:6443", fmt.Sprintf("server: https://%s:6443", addr), 1), nil
+}
+
+func (d k3s) Version(c *SSHClient) (string, error) {
+	out, err := c.Exec("k3s --version")
+	if err != nil {
+		return "", err
+	}
+	// k3s version v1.30.4+k3s1 (98262b5d)
+	fields := strings.Fields(out)
+	if len(fields) < 3 {
+		return "", fmt.Errorf("unexpected k3s version output: %s", out)
+	}
+	return fields[2], nil
+}
+
+func (d k3s) KubeletVersion(version string) string {
+	return version
+}
+
+func (d k3s) ValidateVersion(version string) error {
+	if !k3sVersion.MatchString(version) {
+		return fmt.Errorf("invalid k3s version: %s", version)
+	}
+	return nil
+}
+
+func (d k3s) binaryName(c *SSHClient) (string, error) {
+	arch, err := c.Exec("uname -m")
+	if err != nil {
+		return "", err
+	}
+	switch strings.TrimSpace(arch) {
+	case "x86_64", "amd64":
+		return "k3s", nil
+	case "aarch64", "arm64":
+		return "k3s-arm64", nil
+	case "armv7l", "armhf":
+		return "k3s-armhf", nil
+	default:
+		return "", fmt.Errorf("unsupported architecture: %s", arch)
+	}
+}
+
+// Upgrade replaces k3s binary with the one of given version and restarts the
+// service, leaving its configuration intact.
+func (d k3s) Upgrade(c *SSHClient, version string, worker bool) error {
+	fmt.Printf("Upgrading k3s to %s\n", version)
+	binary, err := d.binaryName(c)
+	if err != nil {
+		return err
+	}
+	service := "k3s"
+	if worker {
+		service = "k3s-agent"
+	}
+	url := fmt.Sprintf(k3sReleaseURL, strings.ReplaceAll(version, "+", "%2B"), binary)
+	_, err = c.Exec(strings.Join([]string{
+		fmt.Sprintf("sudo curl -sfL -o /usr/local/bin/k3s.new %s", shellQuote(url)),
+		"sudo chmod +x /usr/local/bin/k3s.new",
+		"sudo mv /usr/local/bin/k3s.new /usr/local/bin/k3s",
+		fmt.Sprintf("sudo systemctl restart %s", service),
+	}, " && "))
+	return err
+}
+
+func (d k3s) Uninstall(c *SSHClient, worker bool) error {
+	fmt.Println("Uninstalling k3s")
+	script := "k3s-uninstall.sh"
+	if worker {
+		script = "k3s-agent-uninstall.sh"
+	}
+	if _, err := c.Exec(fmt.Sprintf("which %s", script)); err != nil {
+		return nil
+	}
+	_, err := c.Exec(script)
+	return err
+}
+
+func (d k3s) usesEtcd(c *SSHClient) bool {
+	_, err := c.Exec(fmt.Sprintf("sudo test -d %s/etcd", k3sDBDir))
+	return err == nil
+}
+
+// Snapshot uses etcd-snapshot for clusters with embedded etcd and falls back
+// to the online backup of SQLite database otherwise.
+func (d k3s) Snapshot(c *SSHClient, dir string) (string, error) {
+	fmt.Println("Taking k3s snapshot")
+	if d.usesEtcd(c) {
+		if _, err := c.Exec(fmt.Sprintf("sudo k3s etcd-snapshot save --name dodo --dir %s", dir)); err != nil {
+			return "", err
+		}
+		out, err := c.Exec(fmt.Sprintf("sudo find %s -type f -name 'dodo*'", dir))
+		if err != nil {
+			return "", err
+		}
+		path, _, _ := strings.Cut(strings.TrimSpace(out), "\n")
+		if path == "" {
+			return "", fmt.Errorf("etcd snapshot not found in %s", dir)
+		}
+		return path, nil
+	}
+	if _, err := c.Exec("which sqlite3 || sudo apt-get install sqlite3 -y"); err != nil {
+		return "", err
+	}
+	path := fmt.Sprintf("%s/state.db", dir)
+	_, err := c.Exec(fmt.Sprintf("sudo sqlite3 %s/state.db \".backup '%s'\"", k3sDBDir, path))
+	return path, err
+}
+
+// Restore resets embedded etcd from the snapshot, or replaces SQLite database
+// with it, telling them apart by file header. Token must be the one of the
+// cluster snapshot was taken from, as it encrypts bootstrap data.
+func (d k3s) Restore(c *SSHClient, version, snapshot, addr, token string) error {
+	fmt.Println("Restoring k3s from snapshot")
+	header, err := c.Exec(fmt.Sprintf("sudo head -c 15 %s", snapshot))
+	if err != nil {
+		return err
+	}
+	sqlite := header == "SQLite format 3"
+	if _, err := c.Exec("which k3s"); err != nil {
+		args := fmt.Sprintf("server --token=%s %s", token, k3sServerArgs)
+		if !sqlite {
+			args = fmt.Sprintf("server --cluster-init --token=%s %s", token, k3sServerArgs)
+		}
+		if _, err := c.Exec(d.installCmdWithEnv("INSTALL_K3S_SKIP_START=true ", version, args)); err != nil {
+			return err
+		}
+	} else if _, err := c.Exec("sudo systemctl stop k3s"); err != nil {
+		return err
+	}
+	if sqlite {
+		if _, err := c.Exec(fmt.Sprintf(
+			"sudo mkdir -p %s && sudo rm -f %s/state.db-wal %s/state.db-shm && sudo cp %s %s/state.db",
+			k3sDBDir, k3sDBDir, k3sDBDir, snapshot, k3sDBDir,
+		)); err != nil {
+			return err
+		}
+	} else if _, err := c.Exec(fmt.Sprintf(
+		"sudo k3s server --cluster-reset --cluster-reset-restore-path=%s --token=%s %s",
+		snapshot, token, k3sServerArgs,
+	)); err != nil {
+		return err
+	}
+	_, err = c.Exec("sudo systemctl start k3s")
+	return err
+}
@@ -2,16 +2,22 @@ package cluster
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
 	"fmt"
 	"golang.org/x/crypto/ssh"
 	"net"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/giolekva/pcloud/core/installer/kube"
 
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/kubectl/pkg/drain"
 )
 
@@ -20,30 +26,50 @@ type KubeManager struct {
 	name             string
 	ingressClassName string
 	ingressIP        net.IP
+	fallbackIPs      []net.IP
 	kubeCfg          string
 	serverAddr       string
 	serverToken      string
+	workerToken      string
 	controllers      []Server
 	workers          []Server
 	storageEnabled   bool
+	version          string
+	targetVersion    string
+	imported         bool
+	dist             Distribution
 }
 
-func NewKubeManager() *KubeManager {
-	return &KubeManager{l: &sync.Mutex{}}
+func NewKubeManager(distribution string) (*KubeManager, error) {
+	dist, err := GetDistribution(distribution)
+	if err != nil {
+		return nil, err
+	}
+	return &KubeManager{l: &sync.Mutex{}, dist: dist}, nil
 }
 
 func RestoreKubeManager(st State) (*KubeManager, error) {
+	dist, err := GetDistribution(st.Distribution)
+	if err != nil {
+		return nil, err
+	}
 	return &KubeManager{
 		l:                &sync.Mutex{},
 		name:             st.Name,
 		ingressClassName: st.IngressClassName,
 		ingressIP:        st.IngressIP,
+		fallbackIPs:      st.FallbackIngressIPs,
 		kubeCfg:          st.Kubeconfig,
 		serverAddr:       st.ServerAddr,
 		serverToken:      st.ServerToken,
+		workerToken:      st.WorkerToken,
 		controllers:      st.Controllers,
 		workers:          st.Workers,
 		storageEnabled:   st.StorageEnabled,
+		version:          st.Version,
+		targetVersion:    st.TargetVersion,
+		imported:         st.Imported,
+		dist:             dist,
 	}, nil
 }
 
@@ -56,10 +82,16 @@ func (m *KubeManager) State() State {
 		m.ingressIP,
 		m.serverAddr,
 		m.serverToken,
+		m.workerToken,
 		m.kubeCfg,
 		m.controllers,
 		m.workers,
 		m.storageEnabled,
+		m.version,
+		m.targetVersion,
+		m.dist.Name(),
+		m.imported,
+		m.fallbackIPs,
 	}
 }
 
@@ -85,24 +117,34 @@ func (m *KubeManager) Init(s Server, setupFn ClusterIngressSetupFunc) (net.IP, e
 	if err := TailscaleUp(c, loginServer, s.Name, s.AuthKey); err != nil {
 		return nil, err
 	}
-	if err := InstallK3s(c); err != nil {
+	serverIP, err := GetTailscaleIP(c)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.dist.Init(c, m.version, serverIP); err != nil {
 		return nil, err
 	}
-	kubeCfg, err := GetKubeconfig(c)
+	s.Version, err = m.dist.Version(c)
 	if err != nil {
 		return nil, err
 	}
-	m.kubeCfg = kubeCfg
-	serverIP, err := GetTailscaleIP(c)
+	m.version = s.Version
+	kubeCfg, err := m.dist.Kubeconfig(c, serverIP)
 	if err != nil {
 		return nil, err
 	}
+	m.kubeCfg = kubeCfg
 	m.serverAddr = fmt.Sprintf("%s:6443", serverIP)
-	serverToken, err := GetServerToken(c)
+	m.serverToken, m.workerToken, err = m.dist.JoinTokens(c)
 	if err != nil {
 		return nil, err
 	}
-	m.serverToken = serverToken
+	if err := m.installClientKey(c, &s); err != nil {
+		return nil, err
+	}
+	if err := m.applyJoinedPlacement(s); err != nil {
+		return nil, err
+	}
 	m.controllers = []Server{s}
 	m.ingressClassName = "default"
 	ingressIP, err := setupFn(m.name, m.kubeCfg, m.ingressClassName)
@@ -113,12 +155,46 @@ func (m *KubeManager) Init(s Server, setupFn ClusterIngressSetupFunc) (net.IP, e
 	return ingressIP, nil
 }
 
+// Import expects kubeconfig to point to the API server reachable from dodo,
+// so kubeconfigs generated by kind or k3d, which listen on localhost, have to
+// be adjusted first.
+func (m *KubeManager) Import(kubeconfig, ingressClassName string, setupFn ClusterIngressSetupFunc) (net.IP, error) {
+	m.l.Lock()
+	defer m.l.Unlock()
+	if m.kubeCfg != "" {
+		return nil, fmt.Errorf("already initialized")
+	}
+	client, err := kube.NewKubeClient(kube.KubeConfigOpts{
+		KubeConfig: kubeconfig,
+	})
+	if err != nil {
+		return nil, err
+	}
+	version, err := client.Discovery().ServerVersion()
+	if err != nil {
+		return nil, fmt.Errorf("could not reach cluster: %w", err)
+	}
+	m.kubeCfg = kubeconfig
+	m.imported = true
+	m.version = version.GitVersion
+	m.ingressClassName = ingressClassName
+	ingressIP, err := setupFn(m.name, m.kubeCfg, m.ingressClassName)
+	if err != nil {
+		return nil, err
+	}
+	m.ingressIP = ingressIP
+	return ingressIP, nil
+}
+
 func (m *KubeManager) JoinController(s Server) error {
 	m.l.Lock()
 	defer m.l.Unlock()
 	if m.kubeCfg == "" {
 		return fmt.Errorf("not initialized")
 	}
+	if m.imported {
+		return ErrorNotManaged
+	}
 	if i := m.findServerByIP(s.IP); i != nil {
 		return fmt.Errorf("already exists")
 	}
@@ -134,7 +210,17 @@ func (m *KubeManager) JoinController(s Server) error {
 	if err := TailscaleUp(c, loginServer, s.Name, s.AuthKey); err != nil {
 		return err
 	}
-	if err := InstallK3sJoinServer(c, m.serverAddr, m.serverToken); err != nil {
+	if err := m.dist.JoinController(c, m.version, m.serverAddr, m.serverToken); err != nil {
+		return err
+	}
+	s.Version, err = m.dist.Version(c)
+	if err != nil {
+		return err
+	}
+	if err := m.installClientKey(c, &s); err != nil {
+		return err
+	}
+	if err := m.applyJoinedPlacement(s); err != nil {
 		return err
 	}
 	m.controllers = append(m.controllers, s)
@@ -147,6 +233,9 @@ func (m *KubeManager) JoinWorker(s Server) error {
 	if m.kubeCfg == "" {
 		return fmt.Errorf("not initialized")
 	}
+	if m.imported {
+		return ErrorNotManaged
+	}
 	if i := m.findServerByIP(s.IP); i != nil {
 		return fmt.Errorf("already exists")
 	}
@@ -162,7 +251,23 @@ func (m *KubeManager) JoinWorker(s Server) error {
 	if err := TailscaleUp(c, loginServer, s.Name, s.AuthKey); err != nil {
 		return err
 	}
-	if err := InstallK3sJoinAgent(c, m.serverAddr, m.serverToken); err != nil {
+	workerToken := m.workerToken
+	// Clusters created before tokens were split by role only have k3s node
+	// token, which works for agents as well.
+	if workerToken == "" {
+		workerToken = m.serverToken
+	}
+	if err := m.dist.JoinWorker(c, m.version, m.serverAddr, workerToken); err != nil {
+		return err
+	}
+	s.Version, err = m.dist.Version(c)
+	if err != nil {
+		return err
+	}
+	if err := m.installClientKey(c, &s); err != nil {
+		return err
+	}
+	if err := m.applyJoinedPlacement(s); err != nil {
 		return err
 	}
 	m.workers = append(m.workers, s)
@@ -172,24 +277,16 @@ func (m *KubeManager) JoinWorker(s Server) error {
 func (m *KubeManager) RemoveServer(name string) error {
 	m.l.Lock()
 	defer m.l.Unlock()
+	if m.imported {
+		return ErrorNotManaged
+	}
 	client, err := kube.NewKubeClient(kube.KubeConfigOpts{
 		KubeConfig: m.kubeCfg,
 	})
 	if err != nil {
 		return err
 	}
-	helper := &drain.Helper{
-		Ctx:                 context.Background(),
-		Client:              client,
-		Force:               true,
-		GracePeriodSeconds:  -1,
-		IgnoreAllDaemonSets: true,
-		Out:                 os.Stdout,
-		ErrOut:              os.Stdout,
-		// We want to proceed even when pods are using emptyDir volumes
-		DeleteEmptyDirData: true,
-		Timeout:            10 * time.Minute,
-	}
+	helper := newDrainHelper(client)
 	node, err := client.CoreV1().Nodes().Get(context.Background(), name, metav1.GetOptions{})
 	if err != nil {
 		return err
@@ -210,7 +307,7 @@ func (m *KubeManager) RemoveServer(name string) error {
 				return err
 			}
 			defer c.Close()
-			if err := UninstallK3sServer(c); err != nil {
+			if err := m.dist.Uninstall(c, false); err != nil {
 				return err
 			}
 			m.controllers = append(m.controllers[:i], m.controllers[i+1:]...)
@@ -224,7 +321,7 @@ func (m *KubeManager) RemoveServer(name string) error {
 				return err
 			}
 			defer c.Close()
-			if err := UninstallK3sAgent(c); err != nil {
+			if err := m.dist.Uninstall(c, true); err != nil {
 				return err
 			}
 			m.workers = append(m.workers[:i], m.workers[i+1:]...)
@@ -234,6 +331,123 @@ func (m *KubeManager) RemoveServer(name string) error {
 	return fmt.Errorf("not found")
 }
 
+func newDrainHelper(client kubernetes.Interface) *drain.Helper {
+	return &drain.Helper{
+		Ctx:                 context.Background(),
+		Client:              client,
+		Force:               true,
+		GracePeriodSeconds:  -1,
+		IgnoreAllDaemonSets: true,
+		Out:                 os.Stdout,
+		ErrOut:              os.Stdout,
+		// We want to proceed even when pods are using emptyDir volumes
+		DeleteEmptyDirData: true,
+		Timeout:            10 * time.Minute,
+	}
+}
+
+func (m *KubeManager) Upgrade(version string) error {
+	m.l.Lock()
+	defer m.l.Unlock()
+	if m.kubeCfg == "" {
+		return fmt.Errorf("not initialized")
+	}
+	if m.imported {
+		return ErrorNotManaged
+	}
+	if err := m.dist.ValidateVersion(version); err != nil {
+		return err
+	}
+	client, err := kube.NewKubeClient(kube.KubeConfigOpts{
+		KubeConfig: m.kubeCfg,
+	})
+	if err != nil {
+		return err
+	}
+	m.targetVersion = version
+	// NOTE(gio): Control plane must never be older than kubelets, hence
+	// controllers go first.
+	for i := range m.controllers {
+		if err := m.upgradeServer(client, &m.controllers[i], version, false); err != nil {
+			return err
+		}
+	}
+	for i := range m.workers {
+		if err := m.upgradeServer(client, &m.workers[i], version, true); err != nil {
+			return err
+		}
+	}
+	m.version = version
+	return nil
+}
+
+// upgradeServer takes server out of rotation, upgrades it and waits for it to
+// report back as ready with the new version before making it schedulable again.
+// Expects manager state to be locked by caller.
+func (m *KubeManager) upgradeServer(client kubernetes.Interface, s *Server, version string, worker bool) error {
+	node, err := client.CoreV1().Nodes().Get(context.Background(), s.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	kubeletVersion := m.dist.KubeletVersion(version)
+	if node.Status.NodeInfo.KubeletVersion == kubeletVersion {
+		s.Version = version
+		return nil
+	}
+	fmt.Printf("Upgrading %s from %s to %s\n", s.Name, node.Status.NodeInfo.KubeletVersion, version)
+	helper := newDrainHelper(client)
+	if err := drain.RunCordonOrUncordon(helper, node, true); err != nil {
+		return err
+	}
+	if err := drain.RunNodeDrain(helper, s.Name); err != nil {
+		return err
+	}
+	c, err := m.connect(s)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if err := m.dist.Upgrade(c, version, worker); err != nil {
+		return err
+	}
+	node, err = waitForNodeReady(client, s.Name, kubeletVersion, 10*time.Minute)
+	if err != nil {
+		return err
+	}
+	if err := drain.RunCordonOrUncordon(helper, node, false); err != nil {
+		return err
+	}
+	s.Version = version
+	return nil
+}
+
+func waitForNodeReady(client kubernetes.Interface, name, version string, timeout time.Duration) (*corev1.Node, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		node, err := client.CoreV1().Nodes().Get(context.Background(), name, metav1.GetOptions{})
+		// NOTE(gio): API server itself might be restarting.
+		if err == nil && isNodeReady(node) && node.Status.NodeInfo.KubeletVersion == version {
+			return node, nil
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%s: not ready with version %s after %s", name, version, timeout)
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func isNodeReady(node *corev1.Node) bool {
+	for _, c := range node.Status.Conditions {
+		if c.Type == corev1.NodeReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
 // Expects manager state to be locked by caller.
 func (m *KubeManager) findServerByIP(ip net.IP) *Server {
 	for _, s := range m.controllers {
@@ -249,6 +463,8 @@ func (m *KubeManager) findServerByIP(ip net.IP) *Server {
 	return nil
 }
 
+// connect pins host key of the server on first use and rejects connections
+// to hosts presenting a different one afterwards.
 func (m *KubeManager) connect(s *Server) (*SSHClient, error) {
 	cfg := &ssh.ClientConfig{
 		User:    s.User,
@@ -265,14 +481,27 @@ func (m *KubeManager) connect(s *Server) (*SSHClient, error) {
 	if s.Password != "" {
 		cfg.Auth = append(cfg.Auth, ssh.Password(s.Password))
 	}
-	if s.HostKey != "" {
-		hostKey, err := ssh.ParsePublicKey([]byte(s.HostKey))
+	if strings.HasPrefix(s.HostKey, "SHA256:") {
+		// NOTE(gio): Fingerprint given by the user is replaced with the
+		// full key once it is confirmed.
+		cfg.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if ssh.FingerprintSHA256(key) != s.HostKey {
+				return fmt.Errorf("%s: host key fingerprint mismatch, got %s", hostname, ssh.FingerprintSHA256(key))
+			}
+			s.HostKey = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+			return nil
+		}
+	} else if s.HostKey != "" {
+		hostKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(s.HostKey))
 		if err != nil {
 			return nil, err
 		}
 		cfg.HostKeyCallback = ssh.FixedHostKey(hostKey)
 	} else {
-		cfg.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+		cfg.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			s.HostKey = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+			return nil
+		}
 	}
 	client, err := ssh.Dial("tcp", fmt.Sprintf("%s:%d", s.IP.String(), s.Port), cfg)
 	if err != nil {
@@ -285,3 +514,85 @@ func (m *KubeManager) connect(s *Server) (*SSHClient, error) {
 	}
 	return ret, nil
 }
+
+// installClientKey authorizes a freshly generated key on the server and, once
+// logging in with it is confirmed to work, replaces previous credentials of s
+// with it. Password logins are disabled on the server and previously
+// installed key is removed from it.
+func (m *KubeManager) installClientKey(c *SSHClient, s *Server) error {
+	key, pub, err := generateClientKey(fmt.Sprintf("pcloud-%s-%s", m.name, s.Name))
+	if err != nil {
+		return err
+	}
+	if err := AddAuthorizedKey(c, pub); err != nil {
+		return err
+	}
+	updated := *s
+	updated.ClientKey = key
+	updated.Password = ""
+	nc, err := m.connect(&updated)
+	if err != nil {
+		return fmt.Errorf("%s: could not log in with generated key: %w", s.Name, err)
+	}
+	defer nc.Close()
+	if err := DisablePasswordAuthentication(nc); err != nil {
+		return fmt.Errorf("%s: could not disable password authentication: %w", s.Name, err)
+	}
+	if s.ClientKey != "" {
+		prev, err := ssh.ParsePrivateKey([]byte(s.ClientKey))
+		if err != nil {
+			return err
+		}
+		if err := RemoveAuthorizedKey(nc, prev.PublicKey()); err != nil {
+			return err
+		}
+	}
+	*s = updated
+	return nil
+}
+
+func (m *KubeManager) RotateServerKey(name string) error {
+	m.l.Lock()
+	defer m.l.Unlock()
+	s := m.findServerByName(name)
+	if s == nil {
+		return fmt.Errorf("not found")
+	}
+	c, err := m.connect(s)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return m.installClientKey(c, s)
+}
+
+// Expects manager state to be locked by caller.
+func (m *KubeManager) findServerByName(name string) *Server {
+	for i := range m.controllers {
+		if m.controllers[i].Name == name {
+			return &m.controllers[i]
+		}
+	}
+	for i := range m.workers {
+		if m.workers[i].Name == name {
+			return &m.workers[i]
+		}
+	}
+	return nil
+}
+
+func generateClientKey(comment string) (string, ssh.PublicKey, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", nil, err
+	}
+	block, err := ssh.MarshalPrivateKey(priv, comment)
+	if err != nil {
+		return "", nil, err
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return "", nil, err
+	}
+	return string(pem.EncodeToMemory(block)), sshPub, nil
+}
@@ -1,6 +1,7 @@
 package cluster
 
 import (
+	"errors"
 	"net"
 )
 
@@ -10,15 +11,23 @@ const (
 	tailscaleUpCmd      = "sudo tailscale up --login-server=%s --auth-key=%s --hostname=%s --reset"
 )
 
+// Server is accessed over SSH. HostKey is pinned on first connection unless
+// given upfront, either in authorized_keys format or as SHA256 fingerprint.
+// Password is only used until a generated ClientKey gets installed on join.
+// Labels and Taints are applied to the Kubernetes node of the server and let
+// apps target or avoid it.
 type Server struct {
-	Name      string `json:"name"`
-	IP        net.IP `json:"ip"`
-	Port      int    `json:"port"`
-	HostKey   string `json:"hostKey"`
-	User      string `json:"user"`
-	Password  string `json:"password"`
-	ClientKey string `json:"clientKey"`
-	AuthKey   string `json:"authKey"`
+	Name      string            `json:"name"`
+	IP        net.IP            `json:"ip"`
+	Port      int               `json:"port"`
+	HostKey   string            `json:"hostKey"`
+	User      string            `json:"user"`
+	Password  string            `json:"password"`
+	ClientKey string            `json:"clientKey"`
+	AuthKey   string            `json:"authKey"`
+	Version   string            `json:"version"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Taints    []Taint           `json:"taints,omitempty"`
 }
 
 type State struct {
@@ -27,20 +36,59 @@ type State struct {
 	IngressIP        net.IP   `json:"ingressIP"`
 	ServerAddr       string   `json:"serverAddr"`
 	ServerToken      string   `json:"serverToken"`
+	WorkerToken      string   `json:"workerToken"`
 	Kubeconfig       string   `json:"kubeconfig"`
 	Controllers      []Server `json:"controllers"`
 	Workers          []Server `json:"workers"`
 	StorageEnabled   bool     `json:"storageEnabled"`
+	Version          string   `json:"version"`
+	TargetVersion    string   `json:"targetVersion"`
+	Distribution     string   `json:"distribution"`
+	// Imported clusters are provisioned by someone else, dodo only has
+	// kubeconfig to access them and does not manage any of their servers.
+	Imported bool `json:"imported"`
+	// FallbackIngressIPs are additional addresses the ingress of the cluster
+	// is reachable at, traffic is sent to them when IngressIP is unhealthy.
+	FallbackIngressIPs []net.IP `json:"fallbackIngressIPs,omitempty"`
 }
 
+// IngressIPs returns all addresses the ingress of the cluster is reachable
+// at, primary one first.
+func (s State) IngressIPs() []net.IP {
+	ret := []net.IP{}
+	if s.IngressIP != nil {
+		ret = append(ret, s.IngressIP)
+	}
+	return append(ret, s.FallbackIngressIPs...)
+}
+
+var ErrorNotManaged = errors.New("servers of imported cluster are not managed")
+
 type ClusterIngressSetupFunc func(name, kubeconfig, ingressClassName string) (net.IP, error)
 type ClusterSetupFunc func(m Manager) error
 
 type Manager interface {
 	Init(s Server, setupFn ClusterIngressSetupFunc) (net.IP, error)
+	// Import adopts already running cluster accessible with given kubeconfig.
+	Import(kubeconfig, ingressClassName string, setupFn ClusterIngressSetupFunc) (net.IP, error)
 	JoinController(s Server) error
 	JoinWorker(s Server) error
 	RemoveServer(name string) error
+	// Upgrade moves every server of the cluster to given version of its
+	// distribution one at a time, controllers first.
+	Upgrade(version string) error
+	// RotateServerKey replaces the key used to log into the given server.
+	RotateServerKey(name string) error
+	// SetServerPlacement replaces labels and taints of the given server.
+	SetServerPlacement(name string, labels map[string]string, taints []Taint) error
+	// Snapshot saves control plane datastore of the cluster into the store.
+	Snapshot(store SnapshotStore) (Snapshot, error)
+	Restore(s Server, snapshot string, store SnapshotStore) error
+	// AddFallbackIngressIP registers additional address the ingress of the
+	// cluster is reachable at.
+	AddFallbackIngressIP(ip net.IP) error
+	RemoveFallbackIngressIP(ip net.IP) error
+	Health() Health
 	State() State
 	EnableStorage()
 }
@@ -0,0 +1,187 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/giolekva/pcloud/core/installer/kube"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Taint keeps pods which do not tolerate it off the server.
+type Taint struct {
+	Key    string `json:"key"`
+	Value  string `json:"value,omitempty"`
+	Effect string `json:"effect"`
+}
+
+func (t Taint) String() string {
+	if t.Value == "" {
+		return fmt.Sprintf("%s:%s", t.Key, t.Effect)
+	}
+	return fmt.Sprintf("%s=%s:%s", t.Key, t.Value, t.Effect)
+}
+
+// ParseLabels parses comma separated list of key=value pairs, for example
+// gpu=false,storage=ssd.
+func ParseLabels(s string) (map[string]string, error) {
+	ret := map[string]string{}
+	for _, l := range strings.Split(s, ",") {
+		l = strings.TrimSpace(l)
+		if l == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(l, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid label: %s", l)
+		}
+		k = strings.TrimSpace(k)
+		v = strings.TrimSpace(v)
+		if errs := validation.IsQualifiedName(k); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid label key %s: %s", k, strings.Join(errs, ", "))
+		}
+		if errs := validation.IsValidLabelValue(v); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid label value %s: %s", v, strings.Join(errs, ", "))
+		}
+		ret[k] = v
+	}
+	return ret, nil
+}
+
+// ParseTaints parses comma separated list of taints in the format kubectl
+// accepts them, key[=value]:effect, for example dedicated=db:NoSchedule.
+func ParseTaints(s string) ([]Taint, error) {
+	ret := []Taint{}
+	for _, t := range strings.Split(s, ",") {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		kv, effect, ok := strings.Cut(t, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid taint, effect is missing: %s", t)
+		}
+		switch corev1.TaintEffect(effect) {
+		case corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+		default:
+			return nil, fmt.Errorf("invalid taint effect: %s", effect)
+		}
+		k, v, _ := strings.Cut(kv, "=")
+		if errs := validation.IsQualifiedName(k); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid taint key %s: %s", k, strings.Join(errs, ", "))
+		}
+		if errs := validation.IsValidLabelValue(v); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid taint value %s: %s", v, strings.Join(errs, ", "))
+		}
+		ret = append(ret, Taint{k, v, effect})
+	}
+	return ret, nil
+}
+
+func (m *KubeManager) SetServerPlacement(name string, labels map[string]string, taints []Taint) error {
+	m.l.Lock()
+	defer m.l.Unlock()
+	if m.imported {
+		return ErrorNotManaged
+	}
+	s := m.findServerByName(name)
+	if s == nil {
+		return fmt.Errorf("not found")
+	}
+	client, err := kube.NewKubeClient(kube.KubeConfigOpts{
+		KubeConfig: m.kubeCfg,
+	})
+	if err != nil {
+		return err
+	}
+	if err := applyPlacement(client, name, s.Labels, s.Taints, labels, taints); err != nil {
+		return err
+	}
+	s.Labels = labels
+	s.Taints = taints
+	return nil
+}
+
+// applyJoinedPlacement applies labels and taints requested for the server
+// once it registers itself with the API server after joining the cluster.
+// Expects manager state to be locked by caller.
+func (m *KubeManager) applyJoinedPlacement(s Server) error {
+	if len(s.Labels) == 0 && len(s.Taints) == 0 {
+		return nil
+	}
+	client, err := kube.NewKubeClient(kube.KubeConfigOpts{
+		KubeConfig: m.kubeCfg,
+	})
+	if err != nil {
+		return err
+	}
+	if err := waitForNode(client, s.Name, 5*time.Minute); err != nil {
+		return err
+	}
+	return applyPlacement(client, s.Name, nil, nil, s.Labels, s.Taints)
+}
+
+// applyPlacement replaces labels and taints previously set by dodo with the
+// given ones, leaving the ones set by Kubernetes itself or by other tools
+// intact.
+func applyPlacement(client kubernetes.Interface, name string, prevLabels map[string]string, prevTaints []Taint, labels map[string]string, taints []Taint) error {
+	node, err := client.CoreV1().Nodes().Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if node.Labels == nil {
+		node.Labels = map[string]string{}
+	}
+	for k := range prevLabels {
+		delete(node.Labels, k)
+	}
+	for k, v := range labels {
+		node.Labels[k] = v
+	}
+	nodeTaints := []corev1.Taint{}
+	for _, t := range node.Spec.Taints {
+		if !containsTaint(prevTaints, t) && !containsTaint(taints, t) {
+			nodeTaints = append(nodeTaints, t)
+		}
+	}
+	for _, t := range taints {
+		nodeTaints = append(nodeTaints, corev1.Taint{
+			Key:    t.Key,
+			Value:  t.Value,
+			Effect: corev1.TaintEffect(t.Effect),
+		})
+	}
+	node.Spec.Taints = nodeTaints
+	_, err = client.CoreV1().Nodes().Update(context.Background(), node, metav1.UpdateOptions{})
+	return err
+}
+
+// Taints are identified by key and effect, value is not part of it.
+func containsTaint(taints []Taint, t corev1.Taint) bool {
+	for _, i := range taints {
+		if i.Key == t.Key && corev1.TaintEffect(i.Effect) == t.Effect {
+			return true
+		}
+	}
+	return false
+}
+
+func waitForNode(client kubernetes.Interface, name string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		_, err := client.CoreV1().Nodes().Get(context.Background(), name, metav1.GetOptions{})
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
@@ -0,0 +1,341 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/giolekva/pcloud/core/installer/kube"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var ErrorSnapshotNotFound = errors.New("snapshot not found")
+
+type Snapshot struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	Created time.Time `json:"created"`
+}
+
+// SnapshotStore keeps control plane datastore snapshots of clusters.
+type SnapshotStore interface {
+	// List returns snapshots of given cluster, most recent one first.
+	List(cluster string) ([]Snapshot, error)
+	Reader(cluster, name string) (io.ReadCloser, error)
+	Writer(cluster, name string) (io.WriteCloser, error)
+	Remove(cluster, name string) error
+}
+
+type dirSnapshotStore struct {
+	root string
+}
+
+// NewDirSnapshotStore keeps snapshots in the root/clusters/<cluster>
+// directories.
+func NewDirSnapshotStore(root string) SnapshotStore {
+	return &dirSnapshotStore{root}
+}
+
+func (s *dirSnapshotStore) path(cluster, name string) (string, error) {
+	for _, p := range []string{cluster, name} {
+		if p == "" || p == "." || p == ".." || strings.ContainsAny(p, "/\\") {
+			return "", fmt.Errorf("invalid name: %s", p)
+		}
+	}
+	return filepath.Join(s.root, "clusters", cluster, name), nil
+}
+
+func (s *dirSnapshotStore) List(cluster string) ([]Snapshot, error) {
+	dir := filepath.Join(s.root, "clusters", cluster)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ret []Snapshot
+	for _, e := range entries {
+		// Snapshots still being written are skipped.
+		if e.IsDir() || strings.HasSuffix(e.Name(), ".tmp") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, Snapshot{e.Name(), info.Size(), info.ModTime()})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Created.After(ret[j].Created)
+	})
+	return ret, nil
+}
+
+func (s *dirSnapshotStore) Reader(cluster, name string) (io.ReadCloser, error) {
+	p, err := s.path(cluster, name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrorSnapshotNotFound
+	}
+	return f, err
+}
+
+func (s *dirSnapshotStore) Writer(cluster, name string) (io.WriteCloser, error) {
+	p, err := s.path(cluster, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(p+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &atomicFile{f, p}, nil
+}
+
+func (s *dirSnapshotStore) Remove(cluster, name string) error {
+	p, err := s.path(cluster, name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// atomicFile moves temporary file in place once closed, so that partially
+// transferred snapshots are never picked up for restore.
+type atomicFile struct {
+	*os.File
+	path string
+}
+
+func (f *atomicFile) Close() error {
+	if err := f.File.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.File.Name(), f.path)
+}
+
+func (m *KubeManager) Snapshot(store SnapshotStore) (Snapshot, error) {
+	m.l.Lock()
+	defer m.l.Unlock()
+	if m.imported {
+		return Snapshot{}, ErrorNotManaged
+	}
+	if len(m.controllers) == 0 {
+		return Snapshot{}, fmt.Errorf("not initialized")
+	}
+	c, err := m.connect(&m.controllers[0])
+	if err != nil {
+		return Snapshot{}, err
+	}
+	defer c.Close()
+	created := time.Now().UTC()
+	name := fmt.Sprintf("%s-%s", m.dist.Name(), created.Format("20060102-150405"))
+	dir := fmt.Sprintf("/tmp/dodo-snapshot-%s", name)
+	if _, err := c.Exec(fmt.Sprintf("sudo mkdir -p %s", dir)); err != nil {
+		return Snapshot{}, err
+	}
+	defer c.Exec(fmt.Sprintf("sudo rm -rf %s", dir))
+	path, err := m.dist.Snapshot(c, dir)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	w, err := store.Writer(m.name, name)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	cw := &countingWriter{w: w}
+	if err := c.ExecStream(fmt.Sprintf("sudo cat %s", path), cw); err != nil {
+		w.Close()
+		store.Remove(m.name, name)
+		return Snapshot{}, err
+	}
+	if err := w.Close(); err != nil {
+		return Snapshot{}, err
+	}
+	return Snapshot{name, cw.n, created}, nil
+}
+
+// Restore rebuilds the cluster control plane on given server from the
+// snapshot. Server can either be one of the existing controllers or a new one,
+// in which case it gets set up from scratch. Remaining controllers are reset
+// and joined back to it, unreachable ones are dropped as restore most likely
+// replaces them. Workers are pointed to the new controller address if it has
+// changed.
+func (m *KubeManager) Restore(s Server, snapshot string, store SnapshotStore) error {
+	m.l.Lock()
+	defer m.l.Unlock()
+	if m.imported {
+		return ErrorNotManaged
+	}
+	if m.kubeCfg == "" {
+		return fmt.Errorf("not initialized")
+	}
+	existing := false
+	if curr := m.findServerByIP(s.IP); curr != nil {
+		for _, w := range m.workers {
+			if w.Name == curr.Name {
+				return fmt.Errorf("%s is a worker", curr.Name)
+			}
+		}
+		s = *curr
+		existing = true
+	}
+	r, err := store.Reader(m.name, snapshot)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	c, err := m.connect(&s)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if !existing {
+		if err := InstallTailscale(c); err != nil {
+			return err
+		}
+		const loginServer = "https://headscale.v1.dodo.cloud"
+		if err := TailscaleUp(c, loginServer, s.Name, s.AuthKey); err != nil {
+			return err
+		}
+	}
+	const restoreDir = "/var/lib/dodo-restore"
+	path := fmt.Sprintf("%s/%s", restoreDir, snapshot)
+	if _, err := c.ExecWithInput(fmt.Sprintf("sudo mkdir -p %s && sudo tee %s > /dev/null", restoreDir, path), r); err != nil {
+		return err
+	}
+	defer c.Exec(fmt.Sprintf("sudo rm -rf %s", restoreDir))
+	serverIP, err := GetTailscaleIP(c)
+	if err != nil {
+		return err
+	}
+	if err := m.dist.Restore(c, m.version, path, serverIP, m.serverToken); err != nil {
+		return err
+	}
+	s.Version, err = m.dist.Version(c)
+	if err != nil {
+		return err
+	}
+	kubeCfg, err := m.dist.Kubeconfig(c, serverIP)
+	if err != nil {
+		return err
+	}
+	controllerToken, workerToken, err := m.dist.JoinTokens(c)
+	if err != nil {
+		return err
+	}
+	if !existing {
+		if err := m.installClientKey(c, &s); err != nil {
+			return err
+		}
+	}
+	oldServerAddr := m.serverAddr
+	oldControllers := m.controllers
+	m.kubeCfg = kubeCfg
+	m.serverAddr = fmt.Sprintf("%s:6443", serverIP)
+	m.serverToken = controllerToken
+	m.workerToken = workerToken
+	m.controllers = []Server{s}
+	var errs []error
+	for _, o := range oldControllers {
+		if o.Name == s.Name {
+			continue
+		}
+		oc, err := m.connect(&o)
+		if err != nil {
+			fmt.Printf("Dropping unreachable controller %s: %s\n", o.Name, err)
+			if err := m.deleteNode(o.Name); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", o.Name, err))
+			}
+			continue
+		}
+		err = m.rejoin(oc, &o, false)
+		oc.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", o.Name, err))
+			continue
+		}
+		m.controllers = append(m.controllers, o)
+	}
+	if m.serverAddr != oldServerAddr {
+		for i := range m.workers {
+			w := &m.workers[i]
+			wc, err := m.connect(w)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", w.Name, err))
+				continue
+			}
+			err = m.rejoin(wc, w, true)
+			wc.Close()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", w.Name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// rejoin resets the server still pointing to the control plane as it was
+// before restore, and joins it to the restored one.
+func (m *KubeManager) rejoin(c *SSHClient, s *Server, worker bool) error {
+	if err := m.dist.Uninstall(c, worker); err != nil {
+		return err
+	}
+	var err error
+	if worker {
+		err = m.dist.JoinWorker(c, m.version, m.serverAddr, m.workerToken)
+	} else {
+		err = m.dist.JoinController(c, m.version, m.serverAddr, m.serverToken)
+	}
+	if err != nil {
+		return err
+	}
+	s.Version, err = m.dist.Version(c)
+	return err
+}
+
+// deleteNode removes node of the server which is gone from the restored
+// control plane.
+func (m *KubeManager) deleteNode(name string) error {
+	client, err := kube.NewKubeClient(kube.KubeConfigOpts{
+		KubeConfig: m.kubeCfg,
+	})
+	if err != nil {
+		return err
+	}
+	err = client.CoreV1().Nodes().Delete(context.Background(), name, metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
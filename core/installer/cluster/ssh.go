@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"golang.org/x/crypto/ssh"
+	"io"
 	"os"
 	"strings"
 )
@@ -32,6 +33,36 @@ func (c *SSHClient) Exec(cmd string) (string, error) {
 	return out.String(), nil
 }
 
+// ExecStream writes output of the command to w instead of buffering it, meant
+// for transferring files off the server.
+func (c *SSHClient) ExecStream(cmd string, w io.Writer) error {
+	ses, err := c.client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer ses.Close()
+	ses.Stdout = w
+	ses.Stderr = os.Stdout
+	return ses.Run(cmd)
+}
+
+// ExecWithInput feeds r as standard input of the command.
+func (c *SSHClient) ExecWithInput(cmd string, r io.Reader) (string, error) {
+	ses, err := c.client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer ses.Close()
+	var out bytes.Buffer
+	ses.Stdin = r
+	ses.Stdout = &out
+	ses.Stderr = os.Stdout
+	if err := ses.Run(cmd); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
 func GetHostname(c *SSHClient) (string, error) {
 	name, err := c.Exec("hostname")
 	if err != nil {
@@ -62,85 +93,62 @@ func TailscaleUp(c *SSHClient, loginServer, hostname, authKey string) error {
 	return err
 }
 
-func InstallK3s(c *SSHClient) error {
-	fmt.Println("Starting k3s")
-	if _, err := c.Exec("which k3s"); err == nil {
-		return nil
-	}
-	_, err := c.Exec("curl -sfL https://get.k3s.io | sh -s - --cluster-init --disable traefik --disable local-storage --disable servicelb --kube-proxy-arg proxy-mode=ipvs --kube-proxy-arg ipvs-strict-arp --flannel-backend vxlan --cluster-cidr=10.45.0.0/16 --service-cidr=10.46.0.0/16 # --flannel-iface=tailscale0")
-	return err
-}
-
-func InstallK3sJoinServer(c *SSHClient, serverAddr, token string) error {
-	fmt.Println("Starting k3s")
-	if _, err := c.Exec("which k3s"); err == nil {
-		return nil
-	}
-	_, err := c.Exec(fmt.Sprintf("curl -sfL https://get.k3s.io | sh -s - server --server=https://%s --token=%s --disable traefik --disable local-storage --disable servicelb --kube-proxy-arg proxy-mode=ipvs --kube-proxy-arg ipvs-strict-arp --flannel-backend vxlan --cluster-cidr=10.45.0.0/16 --service-cidr=10.46.0.0/16 # --flannel-iface=tailscale0", serverAddr, token))
-	return err
-}
-
-func InstallK3sJoinAgent(c *SSHClient, serverAddr, token string) error {
-	fmt.Println("Starting k3s")
-	if _, err := c.Exec("which k3s"); err == nil {
-		return nil
-	}
-	_, err := c.Exec(fmt.Sprintf("curl -sfL https://get.k3s.io | sh -s - agent --server=https://%s --token=%s", serverAddr, token))
-	return err
-}
-
-func UninstallK3sServer(c *SSHClient) error {
-	fmt.Println("Uninstalling k3s")
-	if _, err := c.Exec("which k3s-uninstall.sh"); err != nil {
-		return nil
-	}
-	_, err := c.Exec("k3s-uninstall.sh")
-	return err
-}
-
-func UninstallK3sAgent(c *SSHClient) error {
-	fmt.Println("Uninstalling k3s")
-	if _, err := c.Exec("which k3s-agent-uninstall.sh"); err != nil {
-		return nil
-	}
-	_, err := c.Exec("k3s-agent-uninstall.sh")
-	return err
-}
-
 func GetTailscaleIP(c *SSHClient) (string, error) {
 	fmt.Println("Getting Tailscale IP")
 	// TODO(gio): install all necessary packages beforehand
 	if _, err := c.Exec("sudo apt-get install net-tools open-iscsi -y"); err != nil {
 		return "", err
 	}
+	return ReadTailscaleIP(c)
+}
+
+// ReadTailscaleIP is GetTailscaleIP without installing prerequisites, meant
+// to be called once server has already been set up.
+func ReadTailscaleIP(c *SSHClient) (string, error) {
 	ip, err := c.Exec("sudo ifconfig | grep 10.42")
 	if err != nil {
 		return "", err
 	}
-	return strings.Fields(ip)[1], nil
+	fields := strings.Fields(ip)
+	if len(fields) < 2 {
+		return "", fmt.Errorf("unexpected ifconfig output: %s", ip)
+	}
+	return fields[1], nil
 	// ip, err := c.Exec("sudo tailscale ip")
 	// return strings.TrimSpace(ip), err
 }
 
-func GetKubeconfig(c *SSHClient) (string, error) {
-	// return "", nil
-	fmt.Println("Getting Kubeconfig")
-	out, err := c.Exec("sudo cat /etc/rancher/k3s/k3s.yaml")
-	if err != nil {
-		return "", err
-	}
-	ip, err := GetTailscaleIP(c)
-	if err != nil {
-		return "", err
-	}
-	return strings.Replace(out, "server: https://127.0.0.1:6443", fmt.Sprintf("server: https://%s:6443", ip), 1), nil
+func AddAuthorizedKey(c *SSHClient, key ssh.PublicKey) error {
+	_, err := c.Exec(fmt.Sprintf(
+		"mkdir -p ~/.ssh && chmod 700 ~/.ssh && echo %s >> ~/.ssh/authorized_keys && chmod 600 ~/.ssh/authorized_keys",
+		shellQuote(strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))),
+	))
+	return err
 }
 
-func GetServerToken(c *SSHClient) (string, error) {
-	fmt.Println("Getting server token")
-	out, err := c.Exec("sudo cat /var/lib/rancher/k3s/server/node-token")
-	if err != nil {
-		return "", err
-	}
-	return strings.TrimSpace(out), err
+func RemoveAuthorizedKey(c *SSHClient, key ssh.PublicKey) error {
+	// NOTE(gio): Matching on key body only, as comment might have been edited.
+	body := strings.Fields(string(ssh.MarshalAuthorizedKey(key)))[1]
+	_, err := c.Exec(fmt.Sprintf(
+		"{ grep -v -F %s ~/.ssh/authorized_keys || true; } > ~/.ssh/authorized_keys.new && chmod 600 ~/.ssh/authorized_keys.new && mv ~/.ssh/authorized_keys.new ~/.ssh/authorized_keys",
+		shellQuote(body),
+	))
+	return err
+}
+
+// DisablePasswordAuthentication makes SSH server only accept key based
+// logins, so that the password server was added with stops working. Drop-in
+// takes precedence over the main config and the ones of cloud-init.
+func DisablePasswordAuthentication(c *SSHClient) error {
+	_, err := c.Exec(
+		"sudo mkdir -p /etc/ssh/sshd_config.d && " +
+			"printf 'PasswordAuthentication no\\nKbdInteractiveAuthentication no\\n' | sudo tee /etc/ssh/sshd_config.d/00-pcloud.conf > /dev/null && " +
+			"sudo sshd -t && " +
+			"{ sudo systemctl reload ssh || sudo systemctl reload sshd; }",
+	)
+	return err
+}
+
+func shellQuote(s string) string {
+	return fmt.Sprintf("'%s'", strings.ReplaceAll(s, "'", `'\''`))
 }
@@ -0,0 +1,231 @@
+package installer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/giolekva/pcloud/core/installer/soft"
+)
+
+// ClusterProxyConfigurator routes traffic of remote clusters through the
+// cluster-proxy service, which applies route changes live instead of
+// restarting like nginx based proxy does. Proxy falls back across all
+// registered ingress IPs of the cluster, skipping unhealthy ones.
+type ClusterProxyConfigurator struct {
+	PrivateSubdomain string
+	DNSAPIAddr       string
+	APIAddr          string
+	Repo             soft.RepoIO
+}
+
+type clusterProxyIPReq struct {
+	IP string `json:"ip"`
+}
+
+type clusterProxyHTTPRoute struct {
+	Host     string `json:"host"`
+	Cluster  string `json:"cluster"`
+	Upstream string `json:"upstream"`
+}
+
+type clusterProxyStreamRoute struct {
+	Protocol string `json:"protocol"`
+	Port     int    `json:"port"`
+	Cluster  string `json:"cluster"`
+}
+
+func (c *ClusterProxyConfigurator) AddCluster(name string, ingressIP net.IP) error {
+	if err := updateClusterDNSRecord(c.DNSAPIAddr, "create-a-record", name, c.PrivateSubdomain, ingressIP); err != nil {
+		return err
+	}
+	return c.call(http.MethodPost, fmt.Sprintf("/api/clusters/%s/ips", url.PathEscape(name)), clusterProxyIPReq{ingressIP.String()})
+}
+
+func (c *ClusterProxyConfigurator) RemoveCluster(name string, ingressIP net.IP) error {
+	if err := c.call(http.MethodDelete, fmt.Sprintf("/api/clusters/%s/ips/%s", url.PathEscape(name), ingressIP), nil); err != nil {
+		return err
+	}
+	return updateClusterDNSRecord(c.DNSAPIAddr, "delete-a-record", name, c.PrivateSubdomain, ingressIP)
+}
+
+func (c *ClusterProxyConfigurator) AddProxy(src, dst string) error {
+	cluster, err := c.clusterOf(dst)
+	if err != nil {
+		return err
+	}
+	return c.call(http.MethodPost, "/api/http-routes", clusterProxyHTTPRoute{src, cluster, dst})
+}
+
+func (c *ClusterProxyConfigurator) RemoveProxy(src, dst string) error {
+	return c.call(http.MethodDelete, fmt.Sprintf("/api/http-routes/%s", url.PathEscape(src)), nil)
+}
+
+func (c *ClusterProxyConfigurator) AddStreamProxy(p StreamProxy) error {
+	protocol := strings.ToLower(p.Protocol)
+	if protocol != "tcp" && protocol != "udp" {
+		return fmt.Errorf("invalid protocol: %s", p.Protocol)
+	}
+	if err := c.call(http.MethodPost, "/api/stream-routes", clusterProxyStreamRoute{protocol, p.Port, p.Cluster}); err != nil {
+		return err
+	}
+	if _, err := c.Repo.Do(func(fs soft.RepoFS) (string, error) {
+		if err := addClusterIngressPort(fs, protocol, p); err != nil {
+			return "", err
+		}
+		if err := updateClusterProxyStreamPorts(fs, func(ports map[string]bool) {
+			ports[streamPortKey(protocol, p.Port)] = true
+		}); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("add %s stream proxy: %d %s/%s", protocol, p.Port, p.Cluster, p.Target), nil
+	}); err != nil {
+		c.call(http.MethodDelete, fmt.Sprintf("/api/stream-routes/%s/%d", protocol, p.Port), nil)
+		return err
+	}
+	return nil
+}
+
+func (c *ClusterProxyConfigurator) RemoveStreamProxy(p StreamProxy) error {
+	protocol := strings.ToLower(p.Protocol)
+	if err := c.call(http.MethodDelete, fmt.Sprintf("/api/stream-routes/%s/%d", protocol, p.Port), nil); err != nil {
+		return err
+	}
+	_, err := c.Repo.Do(func(fs soft.RepoFS) (string, error) {
+		if err := removeClusterIngressPort(fs, protocol, p); err != nil {
+			return "", err
+		}
+		if err := updateClusterProxyStreamPorts(fs, func(ports map[string]bool) {
+			delete(ports, streamPortKey(protocol, p.Port))
+		}); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("remove %s stream proxy: %d %s/%s", protocol, p.Port, p.Cluster, p.Target), nil
+	})
+	return err
+}
+
+// clusterProxyAppDir is where SetupNetwork task installs the cluster-proxy
+// env app.
+const clusterProxyAppDir = "/apps/cluster-proxy"
+
+func streamPortKey(protocol string, port int) string {
+	return fmt.Sprintf("%s:%d", protocol, port)
+}
+
+// updateClusterProxyStreamPorts lets fn modify the set of protocol:port stream
+// ports exposed by the cluster-proxy service. Besides the rendered release,
+// ports are recorded in the input of the app as well, so that they survive it
+// being rendered again. Does nothing if the proxy is not installed as an env
+// app.
+func updateClusterProxyStreamPorts(r soft.RepoFS, fn func(ports map[string]bool)) error {
+	cfgPath := filepath.Join(clusterProxyAppDir, "config.json")
+	var cfg map[string]any
+	if err := soft.ReadJson(r, cfgPath, &cfg); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	input, ok := cfg["input"].(map[string]any)
+	if !ok {
+		input = map[string]any{}
+		cfg["input"] = input
+	}
+	ports := map[string]bool{}
+	if v, ok := input["streamPorts"].(string); ok && v != "" {
+		for _, p := range strings.Split(v, ",") {
+			ports[p] = true
+		}
+	}
+	fn(ports)
+	keys := make([]string, 0, len(ports))
+	for p := range ports {
+		keys = append(keys, p)
+	}
+	sort.Strings(keys)
+	input["streamPorts"] = strings.Join(keys, ",")
+	if err := soft.WriteJson(r, cfgPath, cfg); err != nil {
+		return err
+	}
+	if err := soft.WriteYaml(r, filepath.Join(clusterProxyAppDir, configFileName), cfg); err != nil {
+		return err
+	}
+	relPath := filepath.Join(clusterProxyAppDir, "resources", "cluster-proxy.yaml")
+	rel, err := readHelmRelease(r, relPath)
+	if err != nil {
+		return err
+	}
+	streamPorts := make([]any, 0, len(keys))
+	for _, k := range keys {
+		p := strings.SplitN(k, ":", 2)
+		port, err := strconv.Atoi(p[1])
+		if err != nil {
+			return err
+		}
+		streamPorts = append(streamPorts, map[string]any{
+			"name":     fmt.Sprintf("%s-%d", p[0], port),
+			"protocol": p[0],
+			"port":     port,
+		})
+	}
+	rel["spec"].(map[string]any)["values"].(map[string]any)["streamPorts"] = streamPorts
+	return writeHelmRelease(r, relPath, rel)
+}
+
+// clusterOf extracts name of the cluster from proxy destination, which has
+// {subdomain}.{cluster}.cluster.{private-subdomain}.{domain} format.
+// PrivateSubdomain may or may not include the domain itself.
+func (c *ClusterProxyConfigurator) clusterOf(dst string) (string, error) {
+	marker := fmt.Sprintf(".cluster.%s", c.PrivateSubdomain)
+	i := strings.LastIndex(dst, marker)
+	if i == -1 {
+		return "", fmt.Errorf("not a cluster address: %s", dst)
+	}
+	if rest := dst[i+len(marker):]; rest != "" && !strings.HasPrefix(rest, ".") {
+		return "", fmt.Errorf("not a cluster address: %s", dst)
+	}
+	prefix := dst[:i]
+	if j := strings.LastIndex(prefix, "."); j != -1 {
+		prefix = prefix[j+1:]
+	}
+	if prefix == "" {
+		return "", fmt.Errorf("not a cluster address: %s", dst)
+	}
+	return prefix, nil
+}
+
+func (c *ClusterProxyConfigurator) call(method, path string, body any) error {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, c.APIAddr+path, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var buf bytes.Buffer
+		io.Copy(&buf, resp.Body)
+		return fmt.Errorf("%s %s: %s", method, path, strings.TrimSpace(buf.String()))
+	}
+	return nil
+}
@@ -1,9 +1,18 @@
 package installer
 
 import (
+	"fmt"
+	"io"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+
+	"github.com/giolekva/pcloud/core/installer/soft"
 )
 
 func TestParseNginxProxyConfig(t *testing.T) {
@@ -68,3 +77,218 @@ func TestRenderNginxProxyConfig(t *testing.T) {
 	}
 	t.Log(buf.String())
 }
+
+func TestRenderParseNginxProxyConfigStreams(t *testing.T) {
+	cfg := NginxProxyConfig{
+		Port:      8080,
+		Resolvers: []net.IP{net.ParseIP("1.1.1.1")},
+		Proxies: map[string]string{
+			"a": "A",
+		},
+		Streams: []NginxStreamProxy{
+			{"udp", 27015, "ingress.io.cluster.p.bar.ge:27015"},
+			{"tcp", 2222, "ingress.io.cluster.p.bar.ge:2222"},
+		},
+		PreConf: []string{"nginx.conf: |"},
+	}
+	var buf strings.Builder
+	if err := cfg.Render(&buf); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseNginxProxyConfig(strings.NewReader(buf.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Port != 8080 {
+		t.Errorf("invalid port: expected 8080, got %d", parsed.Port)
+	}
+	if len(parsed.Resolvers) != 1 || !parsed.Resolvers[0].Equal(net.ParseIP("1.1.1.1")) {
+		t.Errorf("invalid resolvers: expected [1.1.1.1], got %s", parsed.Resolvers)
+	}
+	if len(parsed.Proxies) != 1 || parsed.Proxies["a"] != "A" {
+		t.Errorf("invalid proxies: expected map[a:A], got %s", parsed.Proxies)
+	}
+	if len(parsed.Streams) != 2 ||
+		parsed.Streams[0] != (NginxStreamProxy{"tcp", 2222, "ingress.io.cluster.p.bar.ge:2222"}) ||
+		parsed.Streams[1] != (NginxStreamProxy{"udp", 27015, "ingress.io.cluster.p.bar.ge:27015"}) {
+		t.Errorf("invalid streams: got %+v", parsed.Streams)
+	}
+}
+
+func TestNginxProxyConfiguratorStreamProxy(t *testing.T) {
+	repo := soft.NewMockRepoIO(soft.NewBillyRepoFS(memfs.New()), "foo.bar", t)
+	cfg := NginxProxyConfig{
+		Port:    8080,
+		Proxies: map[string]string{},
+		PreConf: []string{"nginx.conf: |"},
+	}
+	w, err := repo.Writer("/apps/private-network/resources/proxy-backend-config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Render(w); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	release := map[string]any{"spec": map[string]any{"values": map[string]any{"controller": map[string]any{}}}}
+	if err := writeHelmRelease(repo, "/apps/private-network/resources/ingress-nginx.yaml", release); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeHelmRelease(repo, clusterIngressNginxPath("io"), release); err != nil {
+		t.Fatal(err)
+	}
+	if err := soft.WriteJson(repo, "/clusters/io/ingress/config.json", map[string]any{"input": map[string]any{"vpnUser": "foo"}}); err != nil {
+		t.Fatal(err)
+	}
+	cnc := &NginxProxyConfigurator{
+		PrivateSubdomain: "p.bar.ge",
+		Repo:             repo,
+		NginxConfigPath:  "/apps/private-network/resources/proxy-backend-config.yaml",
+	}
+	p := StreamProxy{"io", "TCP", 2222, "foo/ssh:22"}
+	if err := cnc.AddStreamProxy(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := cnc.AddStreamProxy(StreamProxy{"io", "TCP", 2222, "bar/ssh:22"}); err == nil {
+		t.Fatal("Expected port already taken by other target to be rejected")
+	}
+	parsed, err := cnc.readProxyConfig(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.Streams) != 1 || parsed.Streams[0] != (NginxStreamProxy{"tcp", 2222, "ingress.io.cluster.p.bar.ge:2222"}) {
+		t.Fatalf("invalid streams: got %+v", parsed.Streams)
+	}
+	remote, err := readHelmRelease(repo, clusterIngressNginxPath("io"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcp := remote["spec"].(map[string]any)["values"].(map[string]any)["tcp"].(map[string]any)
+	if len(tcp) != 1 || tcp["2222"] != "foo/ssh:22" {
+		t.Fatalf("invalid remote tcp ports: got %+v", tcp)
+	}
+	var input AppInstanceConfig
+	if err := soft.ReadJson(repo, "/clusters/io/ingress/config.json", &input); err != nil {
+		t.Fatal(err)
+	}
+	if input.Input["tcp"] != "2222:foo/ssh:22" || input.Input["vpnUser"] != "foo" {
+		t.Fatalf("invalid cluster network input: got %+v", input.Input)
+	}
+	if err := cnc.RemoveStreamProxy(p); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err = cnc.readProxyConfig(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.Streams) != 0 {
+		t.Fatalf("expected no streams, got %+v", parsed.Streams)
+	}
+	if err := soft.ReadJson(repo, "/clusters/io/ingress/config.json", &input); err != nil {
+		t.Fatal(err)
+	}
+	if input.Input["tcp"] != "" {
+		t.Fatalf("expected no tcp ports in cluster network input, got %+v", input.Input)
+	}
+}
+
+func TestClusterProxyConfigurator(t *testing.T) {
+	var calls []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		calls = append(calls, strings.TrimSpace(r.Method+" "+r.URL.Path+" "+string(body)))
+		if r.URL.Path == "/api/stream-routes/udp/27015" {
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}))
+	defer s.Close()
+	repo := soft.NewMockRepoIO(soft.NewBillyRepoFS(memfs.New()), "foo.bar", t)
+	release := map[string]any{"spec": map[string]any{"values": map[string]any{"controller": map[string]any{}}}}
+	if err := writeHelmRelease(repo, clusterIngressNginxPath("io"), release); err != nil {
+		t.Fatal(err)
+	}
+	proxyRelease := map[string]any{"spec": map[string]any{"values": map[string]any{"streamPorts": []any{}}}}
+	if err := writeHelmRelease(repo, "/apps/cluster-proxy/resources/cluster-proxy.yaml", proxyRelease); err != nil {
+		t.Fatal(err)
+	}
+	if err := soft.WriteJson(repo, "/apps/cluster-proxy/config.json", map[string]any{"input": map[string]any{}}); err != nil {
+		t.Fatal(err)
+	}
+	cnc := &ClusterProxyConfigurator{
+		PrivateSubdomain: "p",
+		APIAddr:          s.URL,
+		Repo:             repo,
+	}
+	if err := cnc.AddProxy("app.bar.ge", "app-bar-ge.io.cluster.p.bar.ge"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cnc.AddProxy("app.bar.ge", "app.bar.ge"); err == nil {
+		t.Fatal("Expected non cluster destination to be rejected")
+	}
+	if err := cnc.AddStreamProxy(StreamProxy{"io", "TCP", 2222, "foo/ssh:22"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cnc.RemoveProxy("app.bar.ge", "app-bar-ge.io.cluster.p.bar.ge"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cnc.RemoveStreamProxy(StreamProxy{"io", "udp", 27015, "foo/game:27015"}); err == nil {
+		t.Fatal("Expected proxy error to be returned")
+	}
+	expected := []string{
+		`POST /api/http-routes {"host":"app.bar.ge","cluster":"io","upstream":"app-bar-ge.io.cluster.p.bar.ge"}`,
+		`POST /api/stream-routes {"protocol":"tcp","port":2222,"cluster":"io"}`,
+		`DELETE /api/http-routes/app.bar.ge`,
+		`DELETE /api/stream-routes/udp/27015`,
+	}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, calls)
+	}
+	remote, err := readHelmRelease(repo, clusterIngressNginxPath("io"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcp := remote["spec"].(map[string]any)["values"].(map[string]any)["tcp"].(map[string]any)
+	if len(tcp) != 1 || tcp["2222"] != "foo/ssh:22" {
+		t.Fatalf("invalid remote tcp ports: got %+v", tcp)
+	}
+	proxy, err := readHelmRelease(repo, "/apps/cluster-proxy/resources/cluster-proxy.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamPorts := proxy["spec"].(map[string]any)["values"].(map[string]any)["streamPorts"].([]any)
+	if len(streamPorts) != 1 || fmt.Sprint(streamPorts[0]) != "map[name:tcp-2222 port:2222 protocol:tcp]" {
+		t.Fatalf("invalid cluster proxy stream ports: got %+v", streamPorts)
+	}
+	var input AppInstanceConfig
+	if err := soft.ReadJson(repo, "/apps/cluster-proxy/config.json", &input); err != nil {
+		t.Fatal(err)
+	}
+	if input.Input["streamPorts"] != "tcp:2222" {
+		t.Fatalf("invalid cluster proxy input: got %+v", input.Input)
+	}
+}
+
+func TestClusterProxyConfiguratorClusterOf(t *testing.T) {
+	for _, tc := range []struct {
+		privateSubdomain string
+		dst              string
+		cluster          string
+	}{
+		{"p", "app-bar-ge.io.cluster.p.bar.ge", "io"},
+		{"p", "io.cluster.p.bar.ge", "io"},
+		{"p.bar.ge", "app-bar-ge.io.cluster.p.bar.ge", "io"},
+		{"p", "app.bar.ge", ""},
+		{"p", "app.io.cluster.pp.bar.ge", ""},
+		{"p", ".cluster.p.bar.ge", ""},
+	} {
+		c := &ClusterProxyConfigurator{PrivateSubdomain: tc.privateSubdomain}
+		cluster, err := c.clusterOf(tc.dst)
+		if tc.cluster == "" {
+			if err == nil {
+				t.Errorf("%s: expected error, got %s", tc.dst, cluster)
+			}
+		} else if err != nil || cluster != tc.cluster {
+			t.Errorf("%s: expected %s, got %s %v", tc.dst, tc.cluster, cluster, err)
+		}
+	}
+}
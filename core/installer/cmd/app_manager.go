@@ -1,12 +1,16 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 
 	"github.com/giolekva/pcloud/core/installer"
+	"github.com/giolekva/pcloud/core/installer/cluster"
 	"github.com/giolekva/pcloud/core/installer/soft"
 	"github.com/giolekva/pcloud/core/installer/tasks"
 	"github.com/giolekva/pcloud/core/installer/welcome"
@@ -23,6 +27,15 @@ var appManagerFlags struct {
 	headscaleAPIAddr       string
 	dnsAPIAddr             string
 	clusterProxyConfigPath string
+	clusterProxyAPIAddr    string
+	allowedSigners         string
+	approverGroups         string
+	membershipsAddr        string
+	clusterHealthInterval  time.Duration
+	clusterHealthWebhook   string
+	backupDir              string
+	snapshotInterval       time.Duration
+	snapshotKeep           int
 }
 
 func appManagerCmd() *cobra.Command {
@@ -72,6 +85,60 @@ func appManagerCmd() *cobra.Command {
 		"",
 		"",
 	)
+	cmd.Flags().StringVar(
+		&appManagerFlags.clusterProxyAPIAddr,
+		"cluster-proxy-api-addr",
+		"",
+		"Address of the cluster-proxy API. If set it is used instead of nginx to proxy traffic to remote clusters.",
+	)
+	cmd.Flags().StringVar(
+		&appManagerFlags.allowedSigners,
+		"allowed-signers",
+		"",
+		"Path to public keys trusted to sign commits of the config repository",
+	)
+	cmd.Flags().StringVar(
+		&appManagerFlags.approverGroups,
+		"approver-groups",
+		"",
+		"Comma separated list of groups allowed to approve change requests. If empty changes are applied without approval.",
+	)
+	cmd.Flags().StringVar(
+		&appManagerFlags.membershipsAddr,
+		"memberships-addr",
+		"",
+		"",
+	)
+	cmd.Flags().DurationVar(
+		&appManagerFlags.clusterHealthInterval,
+		"cluster-health-interval",
+		5*time.Minute,
+		"How often to check health of remote clusters. Zero disables the checks.",
+	)
+	cmd.Flags().StringVar(
+		&appManagerFlags.clusterHealthWebhook,
+		"cluster-health-webhook",
+		"",
+		"",
+	)
+	cmd.Flags().StringVar(
+		&appManagerFlags.backupDir,
+		"backup-dir",
+		"",
+		"Directory to store cluster snapshots in. Snapshots are disabled if empty.",
+	)
+	cmd.Flags().DurationVar(
+		&appManagerFlags.snapshotInterval,
+		"snapshot-interval",
+		24*time.Hour,
+		"",
+	)
+	cmd.Flags().IntVar(
+		&appManagerFlags.snapshotKeep,
+		"snapshot-keep",
+		7,
+		"",
+	)
 	return cmd
 }
 
@@ -84,19 +151,24 @@ func appManagerCmdRun(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
-	addr, err := soft.ParseRepositoryAddress(appManagerFlags.repoAddr)
-	if err != nil {
-		return err
+	var ropts []soft.RepoIOOption
+	if appManagerFlags.allowedSigners != "" {
+		f, err := os.Open(appManagerFlags.allowedSigners)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		v, err := soft.ParseAllowedSigners(f)
+		if err != nil {
+			return err
+		}
+		ropts = append(ropts, soft.WithCommitVerifier(v))
 	}
-	repo, err := soft.CloneRepository(addr, signer)
+	repoIO, err := soft.OpenRepository(appManagerFlags.repoAddr, signer, ropts...)
 	if err != nil {
 		return err
 	}
 	log.Println("Cloned repository")
-	repoIO, err := soft.NewRepoIO(repo, signer)
-	if err != nil {
-		return err
-	}
 	nsc, err := newNSCreator()
 	if err != nil {
 		return err
@@ -107,14 +179,29 @@ func appManagerCmdRun(cmd *cobra.Command, args []string) error {
 	}
 	hf := installer.NewGitHelmFetcher()
 	vpnAPIClient := installer.NewHeadscaleAPIClient(appManagerFlags.headscaleAPIAddr)
-	cnc := &installer.NginxProxyConfigurator{
-		// TODO(gio): read from env config
-		PrivateSubdomain: "p",
-		DNSAPIAddr:       appManagerFlags.dnsAPIAddr,
-		Repo:             repoIO,
-		NginxConfigPath:  appManagerFlags.clusterProxyConfigPath,
-	}
-	m, err := installer.NewAppManager(repoIO, nsc, jc, hf, vpnAPIClient, cnc, "/apps")
+	var cnc installer.ClusterNetworkConfigurator
+	if appManagerFlags.clusterProxyAPIAddr != "" {
+		cnc = &installer.ClusterProxyConfigurator{
+			// TODO(gio): read from env config
+			PrivateSubdomain: "p",
+			DNSAPIAddr:       appManagerFlags.dnsAPIAddr,
+			APIAddr:          appManagerFlags.clusterProxyAPIAddr,
+			Repo:             repoIO,
+		}
+	} else {
+		cnc = &installer.NginxProxyConfigurator{
+			// TODO(gio): read from env config
+			PrivateSubdomain: "p",
+			DNSAPIAddr:       appManagerFlags.dnsAPIAddr,
+			Repo:             repoIO,
+			NginxConfigPath:  appManagerFlags.clusterProxyConfigPath,
+		}
+	}
+	var dnsClient installer.DNSRecordsClient
+	if appManagerFlags.dnsAPIAddr != "" {
+		dnsClient = installer.NewDNSAPIClient(appManagerFlags.dnsAPIAddr)
+	}
+	m, err := installer.NewAppManager(repoIO, nsc, jc, hf, vpnAPIClient, cnc, dnsClient, "/apps")
 	if err != nil {
 		return err
 	}
@@ -143,6 +230,31 @@ func appManagerCmdRun(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	var cr *welcome.ChangeRequestConfig
+	if appManagerFlags.approverGroups != "" {
+		if appManagerFlags.membershipsAddr == "" {
+			return fmt.Errorf("memberships-addr is required when approver-groups is set")
+		}
+		cr = &welcome.ChangeRequestConfig{
+			ApproverGroups:  strings.Split(appManagerFlags.approverGroups, ","),
+			MembershipsAddr: appManagerFlags.membershipsAddr,
+		}
+	}
+	var health *welcome.ClusterHealthConfig
+	if appManagerFlags.clusterHealthInterval > 0 {
+		health = &welcome.ClusterHealthConfig{
+			Interval:    appManagerFlags.clusterHealthInterval,
+			WebhookAddr: appManagerFlags.clusterHealthWebhook,
+		}
+	}
+	var snapshots *welcome.ClusterSnapshotConfig
+	if appManagerFlags.backupDir != "" {
+		snapshots = &welcome.ClusterSnapshotConfig{
+			Store:    cluster.NewDirSnapshotStore(appManagerFlags.backupDir),
+			Interval: appManagerFlags.snapshotInterval,
+			Keep:     appManagerFlags.snapshotKeep,
+		}
+	}
 	s, err := welcome.NewAppManagerServer(
 		appManagerFlags.port,
 		repoIO,
@@ -153,6 +265,9 @@ func appManagerCmdRun(cmd *cobra.Command, args []string) error {
 		helmMon,
 		cnc,
 		vpnAPIClient,
+		cr,
+		health,
+		snapshots,
 	)
 	if err != nil {
 		return err
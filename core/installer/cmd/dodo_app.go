@@ -39,6 +39,10 @@ var dodoAppFlags struct {
 	networks          []string
 	fetchUsersAddr    string
 	headscaleAPIAddr  string
+	gitBackend        string
+	giteaAPIAddr      string
+	giteaToken        string
+	giteaOwner        string
 }
 
 func dodoAppCmd() *cobra.Command {
@@ -142,9 +146,50 @@ func dodoAppCmd() *cobra.Command {
 		"",
 		"",
 	)
+	cmd.Flags().StringVar(
+		&dodoAppFlags.gitBackend,
+		"git-backend",
+		"soft-serve",
+		"One of: soft-serve, gitea, fs",
+	)
+	cmd.Flags().StringVar(
+		&dodoAppFlags.giteaAPIAddr,
+		"gitea-api-addr",
+		"",
+		"",
+	)
+	cmd.Flags().StringVar(
+		&dodoAppFlags.giteaToken,
+		"gitea-token",
+		"",
+		"",
+	)
+	cmd.Flags().StringVar(
+		&dodoAppFlags.giteaOwner,
+		"gitea-owner",
+		"",
+		"",
+	)
 	return cmd
 }
 
+func newDodoAppClientGetter() (soft.ClientGetter, error) {
+	switch dodoAppFlags.gitBackend {
+	case "soft-serve":
+		return soft.RealClientGetter{}, nil
+	case "gitea":
+		return soft.GiteaClientGetter{
+			APIAddr: dodoAppFlags.giteaAPIAddr,
+			Token:   dodoAppFlags.giteaToken,
+			Owner:   dodoAppFlags.giteaOwner,
+		}, nil
+	case "fs":
+		return soft.FSClientGetter{}, nil
+	default:
+		return nil, fmt.Errorf("unknown git backend: %s", dodoAppFlags.gitBackend)
+	}
+}
+
 func dodoAppCmdRun(cmd *cobra.Command, args []string) error {
 	sshKey, err := os.ReadFile(dodoAppFlags.sshKey)
 	if err != nil {
@@ -159,7 +204,10 @@ func dodoAppCmdRun(cmd *cobra.Command, args []string) error {
 	if err := json.NewDecoder(envConfig).Decode(&env); err != nil {
 		return err
 	}
-	cg := soft.RealClientGetter{}
+	cg, err := newDodoAppClientGetter()
+	if err != nil {
+		return err
+	}
 	softClient, err := cg.Get(dodoAppFlags.repoAddr, sshKey, log.Default())
 	if err != nil {
 		return err
@@ -293,3 +341,37 @@ func (pc *proxyConfigurator) RemoveProxy(src, dst string) error {
 	}
 	return nil
 }
+
+func (pc *proxyConfigurator) AddStreamProxy(p installer.StreamProxy) error {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(p); err != nil {
+		return err
+	}
+	resp, err := http.Post(fmt.Sprintf("%s/api/proxy/stream/add", pc.apiAddr), "application/json", &buf)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var buf bytes.Buffer
+		io.Copy(&buf, resp.Body)
+		return fmt.Errorf(buf.String())
+	}
+	return nil
+}
+
+func (pc *proxyConfigurator) RemoveStreamProxy(p installer.StreamProxy) error {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(p); err != nil {
+		return err
+	}
+	resp, err := http.Post(fmt.Sprintf("%s/api/proxy/stream/remove", pc.apiAddr), "application/json", &buf)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var buf bytes.Buffer
+		io.Copy(&buf, resp.Body)
+		return fmt.Errorf(buf.String())
+	}
+	return nil
+}
@@ -63,20 +63,12 @@ func launcherCmdRun(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
-	addr, err := soft.ParseRepositoryAddress(launcherFlags.repoAddr)
-	if err != nil {
-		return err
-	}
-	repo, err := soft.CloneRepository(addr, signer)
+	repoIO, err := soft.OpenRepository(launcherFlags.repoAddr, signer)
 	if err != nil {
 		return err
 	}
 	log.Println("Cloned repository")
-	repoIO, err := soft.NewRepoIO(repo, signer)
-	if err != nil {
-		return err
-	}
-	appManager, err := installer.NewAppManager(repoIO, nil, nil, nil, nil, nil, "/apps")
+	appManager, err := installer.NewAppManager(repoIO, nil, nil, nil, nil, nil, nil, "/apps")
 	if err != nil {
 		return err
 	}
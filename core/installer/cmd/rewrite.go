@@ -45,23 +45,15 @@ func rewriteCmdRun(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
-	addr, err := soft.ParseRepositoryAddress(appManagerFlags.repoAddr)
-	if err != nil {
-		return err
-	}
-	repo, err := soft.CloneRepository(addr, signer)
+	repoIO, err := soft.OpenRepository(appManagerFlags.repoAddr, signer)
 	if err != nil {
 		return err
 	}
 	log.Println("Cloned repository")
-	repoIO, err := soft.NewRepoIO(repo, signer)
-	if err != nil {
-		return err
-	}
 	log.Println("Creating repository")
 	r := installer.NewInMemoryAppRepository(installer.CreateAllApps())
 	hf := installer.NewGitHelmFetcher()
-	mgr, err := installer.NewAppManager(repoIO, nil, nil, hf, nil, nil, "/apps")
+	mgr, err := installer.NewAppManager(repoIO, nil, nil, hf, nil, nil, nil, "/apps")
 	if err != nil {
 		return err
 	}
@@ -72,15 +72,7 @@ func welcomeCmdRun(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
-	addr, err := soft.ParseRepositoryAddress(welcomeFlags.repo)
-	if err != nil {
-		return err
-	}
-	repo, err := soft.CloneRepository(addr, signer)
-	if err != nil {
-		return err
-	}
-	repoIO, err := soft.NewRepoIO(repo, signer)
+	repoIO, err := soft.OpenRepository(welcomeFlags.repo, signer)
 	if err != nil {
 		return err
 	}
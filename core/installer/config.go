@@ -10,6 +10,18 @@ type EnvDNS struct {
 	Address string `json:"address,omitempty"`
 }
 
+// EnvDNSConfig records DNS provider records of the environment zone were
+// published to, so that they can be updated later, for example when DS
+// records change after key rollover.
+type EnvDNSConfig struct {
+	Zone     string            `json:"zone"`
+	Address  string            `json:"address"`
+	Provider string            `json:"provider"`
+	Params   map[string]string `json:"params,omitempty"`
+}
+
+type EnvDNSConfigs []EnvDNSConfig
+
 type EnvServiceIPs struct {
 	ConfigRepo    netip.Addr `json:"configRepo"`
 	IngressPublic netip.Addr `json:"ingressPublic"`
@@ -116,7 +116,7 @@ func deriveValues(
 						return nil, fmt.Errorf("could not resolve username: %+v %s %+v", def.Meta(), v, root)
 					}
 				}
-				authKey, err := vpnKeyGen.GenerateAuthKey(username)
+				authKey, err := vpnKeyGen.GenerateAuthKey(username, nil)
 				if err != nil {
 					return nil, err
 				}
@@ -1,14 +1,19 @@
 package installer
 
 import (
+	"fmt"
 	"net"
+	"strings"
 	"testing"
 )
 
 type testKeyGen struct{}
 
-func (g testKeyGen) GenerateAuthKey(username string) (string, error) {
-	return username, nil
+func (g testKeyGen) GenerateAuthKey(username string, tags []string) (string, error) {
+	if len(tags) == 0 {
+		return username, nil
+	}
+	return fmt.Sprintf("%s:%s", username, strings.Join(tags, ",")), nil
 }
 
 func (g testKeyGen) ExpireKey(username, key string) error {
@@ -27,6 +32,10 @@ func (g testKeyGen) GetNodeIP(username, node string) (net.IP, error) {
 	return nil, nil
 }
 
+func (g testKeyGen) RemoveTag(tag string) error {
+	return nil
+}
+
 func TestDeriveVPNAuthKey(t *testing.T) {
 	schema := structSchema{
 		"input",
@@ -50,6 +59,27 @@ func TestDeriveVPNAuthKey(t *testing.T) {
 	}
 }
 
+func TestDeriveTaggedVPNAuthKey(t *testing.T) {
+	schema := structSchema{
+		"input",
+		[]Field{
+			Field{"authKey", basicSchema{"authKey", KindVPNAuthKey, false, map[string]string{
+				"username": "private-network-proxy",
+			}}},
+		},
+		false,
+	}
+	input := map[string]any{}
+	keyGen := taggedVPNAPIClient{testKeyGen{}, []string{AppInstanceVPNTag("Jellyfin")}}
+	v, err := deriveValues(input, input, schema, nil, nil, keyGen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, ok := v["authKey"].(string); !ok || key != "private-network-proxy:app-jellyfin" {
+		t.Fatal(v)
+	}
+}
+
 func TestDeriveVPNAuthKeyDisabled(t *testing.T) {
 	schema := structSchema{
 		"input",
@@ -0,0 +1,112 @@
+package installer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// DNSRecordsClient manages records which app instances and environment
+// components own in the environment zone.
+type DNSRecordsClient interface {
+	// Owners returns owned records grouped by their owner.
+	Owners() (map[string][]DNSRecord, error)
+	// Create creates the record tagged with its owner.
+	Create(r DNSRecord) error
+	// DeleteOwned deletes all records owned by the given owner, it is not an
+	// error if there are none.
+	DeleteOwned(owner string) error
+}
+
+type DNSRecord struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	TTL        uint32 `json:"ttl,omitempty"`
+	Address    string `json:"address,omitempty"`
+	Target     string `json:"target,omitempty"`
+	Text       string `json:"text,omitempty"`
+	Preference uint16 `json:"preference,omitempty"`
+	Priority   uint16 `json:"priority,omitempty"`
+	Weight     uint16 `json:"weight,omitempty"`
+	Port       uint16 `json:"port,omitempty"`
+	Flag       uint8  `json:"flag,omitempty"`
+	Tag        string `json:"tag,omitempty"`
+	Value      string `json:"value,omitempty"`
+	Owner      string `json:"owner,omitempty"`
+}
+
+// ClusterDNSOwner is the owner of the records created for the cluster
+// ingress.
+func ClusterDNSOwner(name string) string {
+	return fmt.Sprintf("cluster:%s", name)
+}
+
+type dnsAPIClient struct {
+	c       *http.Client
+	apiAddr string
+}
+
+func NewDNSAPIClient(apiAddr string) DNSRecordsClient {
+	return &dnsAPIClient{
+		&http.Client{},
+		apiAddr,
+	}
+}
+
+func (c *dnsAPIClient) Owners() (map[string][]DNSRecord, error) {
+	resp, err := c.c.Get(fmt.Sprintf("%s/api/v1/owners", c.apiAddr))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var buf bytes.Buffer
+		io.Copy(&buf, resp.Body)
+		return nil, errors.New(buf.String())
+	}
+	var ret map[string][]DNSRecord
+	if err := json.NewDecoder(resp.Body).Decode(&ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (c *dnsAPIClient) Create(r DNSRecord) error {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(r); err != nil {
+		return err
+	}
+	resp, err := c.c.Post(fmt.Sprintf("%s/api/v1/records", c.apiAddr), "application/json", &buf)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		var buf bytes.Buffer
+		io.Copy(&buf, resp.Body)
+		return errors.New(buf.String())
+	}
+	return nil
+}
+
+func (c *dnsAPIClient) DeleteOwned(owner string) error {
+	req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("%s/api/v1/owners/%s/records", c.apiAddr, url.PathEscape(owner)), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		var buf bytes.Buffer
+		io.Copy(&buf, resp.Body)
+		return errors.New(buf.String())
+	}
+	return nil
+}
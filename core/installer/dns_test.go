@@ -0,0 +1,130 @@
+package installer
+
+import (
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+
+	"github.com/giolekva/pcloud/core/installer/cluster"
+	gio "github.com/giolekva/pcloud/core/installer/io"
+	"github.com/giolekva/pcloud/core/installer/soft"
+)
+
+type fakeDNSRecordsClient struct {
+	owners map[string][]DNSRecord
+}
+
+func (c *fakeDNSRecordsClient) Owners() (map[string][]DNSRecord, error) {
+	return c.owners, nil
+}
+
+func (c *fakeDNSRecordsClient) Create(r DNSRecord) error {
+	c.owners[r.Owner] = append(c.owners[r.Owner], r)
+	return nil
+}
+
+func (c *fakeDNSRecordsClient) DeleteOwned(owner string) error {
+	delete(c.owners, owner)
+	return nil
+}
+
+func TestOrphanedDNSRecords(t *testing.T) {
+	repo := soft.NewMockRepoIO(soft.NewBillyRepoFS(memfs.New()), "foo.bar", t)
+	kust := gio.NewKustomization()
+	kust.AddResources("app-1")
+	if err := soft.WriteYaml(repo, "/apps/kustomization.yaml", kust); err != nil {
+		t.Fatal(err)
+	}
+	if err := soft.WriteJson(repo, "/apps/app-1/config.json", AppInstanceConfig{AppId: "app"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := soft.WriteJson(repo, "/clusters/foo/config.json", cluster.State{Name: "foo"}); err != nil {
+		t.Fatal(err)
+	}
+	dns := &fakeDNSRecordsClient{map[string][]DNSRecord{
+		"app-1":                 {{Name: "app", Type: "A", Address: "1.2.3.4", Owner: "app-1"}},
+		"app-2":                 {{Name: "old", Type: "TXT", Text: "foo", Owner: "app-2"}},
+		ClusterDNSOwner("foo"):  {{Name: "*.foo.cluster.p", Type: "A", Address: "10.0.0.1", Owner: ClusterDNSOwner("foo")}},
+		ClusterDNSOwner("gone"): {{Name: "*.gone.cluster.p", Type: "A", Address: "10.0.0.2", Owner: ClusterDNSOwner("gone")}},
+	}}
+	m, err := NewAppManager(repo, nil, nil, nil, nil, nil, dns, "/apps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	orphans, err := m.OrphanedDNSRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 2 || orphans[0].Owner != "app-2" || orphans[1].Owner != ClusterDNSOwner("gone") {
+		t.Fatalf("unexpected orphans: %+v", orphans)
+	}
+}
+
+type noOpHelmFetcher struct{}
+
+func (f noOpHelmFetcher) Pull(chart HelmChartGitRepo, rfs soft.RepoFS, root string) error {
+	return nil
+}
+
+type removedTagsVPNAPIClient struct {
+	testKeyGen
+	removed []string
+}
+
+func (c *removedTagsVPNAPIClient) RemoveTag(tag string) error {
+	c.removed = append(c.removed, tag)
+	return nil
+}
+
+func TestAppDNSRecords(t *testing.T) {
+	app, err := NewCueEnvApp(CueAppData{
+		"base.cue":   []byte(cueBaseConfig),
+		"global.cue": []byte(cueEnvAppGlobal),
+		"app.cue": []byte(`
+name: "mail"
+namespace: "mail"
+input: {}
+out: dnsRecord: {
+	mx: {
+		name: "@"
+		type: "MX"
+		preference: 10
+		target: "mail"
+	}
+	spf: {
+		name: "@"
+		type: "TXT"
+		text: "v=spf1 mx -all"
+	}
+}
+`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := soft.NewMockRepoIO(soft.NewBillyRepoFS(memfs.New()), "foo.bar", t)
+	dns := &fakeDNSRecordsClient{map[string][]DNSRecord{}}
+	vpn := &removedTagsVPNAPIClient{}
+	m, err := NewAppManager(repo, NewNoOpNamespaceCreator(), nil, noOpHelmFetcher{}, vpn, nil, dns, "/apps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Install(app, "mail-1", "/apps/mail-1", "mail", map[string]any{}, WithConfig(&env), WithNetworks(networks), WithClusters([]Cluster{})); err != nil {
+		t.Fatal(err)
+	}
+	records := dns.owners["mail-1"]
+	if len(records) != 2 ||
+		records[0] != (DNSRecord{Name: "@", Type: "MX", Preference: 10, Target: "mail", Owner: "mail-1"}) ||
+		records[1] != (DNSRecord{Name: "@", Type: "TXT", Text: "v=spf1 mx -all", Owner: "mail-1"}) {
+		t.Fatalf("unexpected records: %+v", dns.owners)
+	}
+	if err := m.Remove("mail-1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(dns.owners) != 0 {
+		t.Fatalf("expected records to be deleted, got %+v", dns.owners)
+	}
+	if len(vpn.removed) != 1 || vpn.removed[0] != "app-mail-1" {
+		t.Fatalf("expected app instance VPN tag to be removed, got %+v", vpn.removed)
+	}
+}
@@ -0,0 +1,346 @@
+package soft
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// Changes proposed to the tracked branch are pushed to change/<id> branches
+// created on top of it. They stay there until someone merges or rejects them.
+
+const changeRequestPrefix = "change/"
+
+var ErrorChangeRequestNotFound = errors.New("change request not found")
+
+func ChangeRequestBranch(id string) string {
+	return changeRequestPrefix + id
+}
+
+type ChangeRequest struct {
+	Id      string
+	Title   string
+	Message string
+	Author  string
+	Created time.Time
+	Base    string
+	Head    string
+	Paths   []string
+	// Patch is the unified diff between base and head, only populated by Get.
+	Patch string
+}
+
+type ChangeRequestManager interface {
+	List() ([]ChangeRequest, error)
+	Get(id string) (ChangeRequest, error)
+	// Merge applies the change on top of the latest state of the tracked
+	// branch and deletes the change branch. Fails with ConflictError if paths
+	// touched by the change have been modified on the tracked branch since.
+	Merge(id, approver string) (string, error)
+	Reject(id string) error
+	// ReadFile returns contents of the file at given path as proposed by the
+	// change.
+	ReadFile(id, path string) ([]byte, error)
+}
+
+type changeRequests struct {
+	r *repoIO
+}
+
+func NewChangeRequestManager(repo RepoIO) (ChangeRequestManager, error) {
+	r, ok := repo.(*repoIO)
+	if !ok {
+		return nil, ErrorNotSupported
+	}
+	return &changeRequests{r}, nil
+}
+
+func (c *changeRequests) remoteRef(id string) plumbing.ReferenceName {
+	return plumbing.NewRemoteReferenceName("origin", ChangeRequestBranch(id))
+}
+
+// ids lists change requests currently present on the remote.
+func (c *changeRequests) ids() ([]string, error) {
+	remote, err := c.r.repo.Remote("origin")
+	if err != nil {
+		return nil, err
+	}
+	refs, err := remote.List(&git.ListOptions{Auth: c.r.auth()})
+	if err != nil {
+		if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ret []string
+	for _, ref := range refs {
+		if ref.Name().IsBranch() && strings.HasPrefix(ref.Name().Short(), changeRequestPrefix) {
+			ret = append(ret, strings.TrimPrefix(ref.Name().Short(), changeRequestPrefix))
+		}
+	}
+	return ret, nil
+}
+
+func (c *changeRequests) fetch(ids ...string) error {
+	var specs []config.RefSpec
+	for _, id := range ids {
+		specs = append(specs, config.RefSpec(fmt.Sprintf("+refs/heads/%s:%s", ChangeRequestBranch(id), c.remoteRef(id))))
+	}
+	if len(specs) == 0 {
+		return nil
+	}
+	err := c.r.repo.Fetch(&git.FetchOptions{
+		RemoteName: "origin",
+		RefSpecs:   specs,
+		Auth:       c.r.auth(),
+		Force:      true,
+	})
+	if errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return nil
+	}
+	if err != nil && strings.Contains(err.Error(), "couldn't find remote ref") {
+		return ErrorChangeRequestNotFound
+	}
+	return err
+}
+
+// load describes already fetched change request relative to the current
+// state of the tracked branch.
+func (c *changeRequests) load(id string, withPatch bool) (ChangeRequest, *object.Commit, *object.Commit, error) {
+	ref, err := c.r.repo.Reference(c.remoteRef(id), true)
+	if err != nil {
+		if errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return ChangeRequest{}, nil, nil, ErrorChangeRequestNotFound
+		}
+		return ChangeRequest{}, nil, nil, err
+	}
+	head, err := c.r.repo.CommitObject(ref.Hash())
+	if err != nil {
+		return ChangeRequest{}, nil, nil, err
+	}
+	currHash, err := c.r.head()
+	if err != nil {
+		return ChangeRequest{}, nil, nil, err
+	}
+	curr, err := c.r.repo.CommitObject(currHash)
+	if err != nil {
+		return ChangeRequest{}, nil, nil, err
+	}
+	bases, err := head.MergeBase(curr)
+	if err != nil {
+		return ChangeRequest{}, nil, nil, err
+	}
+	if len(bases) == 0 {
+		return ChangeRequest{}, nil, nil, fmt.Errorf("%s: not based on %s", id, c.r.repo.Ref)
+	}
+	base := bases[0]
+	paths, err := c.r.changedPaths(base.Hash, head.Hash)
+	if err != nil {
+		return ChangeRequest{}, nil, nil, err
+	}
+	title, _, _ := strings.Cut(head.Message, "\n")
+	ret := ChangeRequest{
+		Id:      id,
+		Title:   title,
+		Message: head.Message,
+		Author:  head.Author.Name,
+		Created: head.Committer.When,
+		Base:    base.Hash.String(),
+		Head:    head.Hash.String(),
+		Paths:   paths,
+	}
+	if withPatch {
+		baseTree, err := base.Tree()
+		if err != nil {
+			return ChangeRequest{}, nil, nil, err
+		}
+		headTree, err := head.Tree()
+		if err != nil {
+			return ChangeRequest{}, nil, nil, err
+		}
+		patch, err := baseTree.Patch(headTree)
+		if err != nil {
+			return ChangeRequest{}, nil, nil, err
+		}
+		ret.Patch = patch.String()
+	}
+	return ret, base, head, nil
+}
+
+func (c *changeRequests) List() ([]ChangeRequest, error) {
+	c.r.l.Lock()
+	defer c.r.l.Unlock()
+	ids, err := c.ids()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.fetch(ids...); err != nil {
+		return nil, err
+	}
+	ret := make([]ChangeRequest, 0, len(ids))
+	for _, id := range ids {
+		cr, _, _, err := c.load(id, false)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, cr)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Created.Before(ret[j].Created)
+	})
+	return ret, nil
+}
+
+func (c *changeRequests) Get(id string) (ChangeRequest, error) {
+	c.r.l.Lock()
+	defer c.r.l.Unlock()
+	if err := c.fetch(id); err != nil {
+		return ChangeRequest{}, err
+	}
+	cr, _, _, err := c.load(id, true)
+	return cr, err
+}
+
+func (c *changeRequests) Merge(id, approver string) (string, error) {
+	c.r.l.Lock()
+	defer c.r.l.Unlock()
+	if err := c.r.pullWithoutLock(); err != nil {
+		return "", err
+	}
+	if err := c.fetch(id); err != nil {
+		return "", err
+	}
+	cr, base, head, err := c.load(id, false)
+	if err != nil {
+		return "", err
+	}
+	if err := c.r.verifyRange(base.Hash, head.Hash); err != nil {
+		return "", err
+	}
+	// NOTE(gio): Change is replayed as a single new commit instead of merging
+	// the branch, so that the tracked branch only contains commits signed by
+	// us and records who approved the change.
+	hash, err := c.r.Do(func(fs RepoFS) (string, error) {
+		curr, err := c.r.head()
+		if err != nil {
+			return "", err
+		}
+		changed, err := c.r.changedPaths(base.Hash, curr)
+		if err != nil {
+			return "", err
+		}
+		if paths := intersect(cr.Paths, changed); len(paths) > 0 {
+			return "", &ConflictError{paths}
+		}
+		if err := applyChanges(fs, base, head); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s\n\nChange-Request: %s\nApproved-By: %s\n", strings.TrimSpace(cr.Message), id, approver), nil
+	}, WithNoLock(), WithNoPull(), WithAuthor(cr.Author))
+	if err != nil {
+		return "", err
+	}
+	return hash, c.delete(id)
+}
+
+func (c *changeRequests) Reject(id string) error {
+	c.r.l.Lock()
+	defer c.r.l.Unlock()
+	return c.delete(id)
+}
+
+func (c *changeRequests) ReadFile(id, p string) ([]byte, error) {
+	c.r.l.Lock()
+	defer c.r.l.Unlock()
+	if err := c.fetch(id); err != nil {
+		return nil, err
+	}
+	_, _, head, err := c.load(id, false)
+	if err != nil {
+		return nil, err
+	}
+	f, err := head.File(strings.TrimPrefix(p, "/"))
+	if err != nil {
+		if errors.Is(err, object.ErrFileNotFound) {
+			return nil, fs.ErrNotExist
+		}
+		return nil, err
+	}
+	contents, err := f.Contents()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(contents), nil
+}
+
+func (c *changeRequests) delete(id string) error {
+	if err := c.r.repo.Push(&git.PushOptions{
+		RemoteName: "origin",
+		RefSpecs:   []config.RefSpec{config.RefSpec(fmt.Sprintf(":refs/heads/%s", ChangeRequestBranch(id)))},
+		Auth:       c.r.auth(),
+	}); err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return err
+	}
+	if err := c.r.repo.Storer.RemoveReference(c.remoteRef(id)); err != nil && !errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return err
+	}
+	return nil
+}
+
+// applyChanges writes difference between trees of from and to commits into fs.
+func applyChanges(fs RepoFS, from, to *object.Commit) error {
+	fromTree, err := from.Tree()
+	if err != nil {
+		return err
+	}
+	toTree, err := to.Tree()
+	if err != nil {
+		return err
+	}
+	changes, err := object.DiffTree(fromTree, toTree)
+	if err != nil {
+		return err
+	}
+	for _, ch := range changes {
+		if ch.From.Name != "" && ch.From.Name != ch.To.Name {
+			if err := fs.RemoveAll(path.Join("/", ch.From.Name)); err != nil {
+				return err
+			}
+		}
+		if ch.To.Name == "" {
+			continue
+		}
+		f, err := toTree.File(ch.To.Name)
+		if err != nil {
+			return err
+		}
+		if err := func() error {
+			r, err := f.Reader()
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+			w, err := fs.Writer(path.Join("/", ch.To.Name))
+			if err != nil {
+				return err
+			}
+			defer w.Close()
+			_, err = io.Copy(w, r)
+			return err
+		}(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package soft
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+func TestChangeRequestMerge(t *testing.T) {
+	c := newTestRepo(t)
+	repo, err := c.GetRepo("config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Do(func(r RepoFS) (string, error) {
+		return "propose b", WriteFile(r, "b", "b")
+	}, WithCommitToBranch(ChangeRequestBranch("foo")), WithAuthor("alice")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadFile(repo, "b"); err == nil {
+		t.Fatal("Expected proposed change to stay off master")
+	}
+	if _, err := repo.Do(func(r RepoFS) (string, error) {
+		return "unrelated", WriteFile(r, "c", "c")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	crm, err := NewChangeRequestManager(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := crm.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 || all[0].Id != "foo" || all[0].Title != "propose b" || all[0].Author != "alice" {
+		t.Fatalf("Unexpected change requests: %+v", all)
+	}
+	cr, err := crm.Get("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cr.Paths) != 1 || cr.Paths[0] != "b" {
+		t.Fatalf("Expected b to be changed, got %v", cr.Paths)
+	}
+	if !strings.Contains(cr.Patch, "+b") {
+		t.Fatalf("Expected patch to add b, got %s", cr.Patch)
+	}
+	if contents, err := crm.ReadFile("foo", "b"); err != nil {
+		t.Fatal(err)
+	} else if string(contents) != "b" {
+		t.Fatalf("Expected proposed b, got %s", contents)
+	}
+	if _, err := crm.ReadFile("foo", "d"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected not exist, got %v", err)
+	}
+	if _, err := crm.Merge("foo", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	fresh, err := c.GetRepo("config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"a", "b", "c"} {
+		if contents, err := ReadFile(fresh, p); err != nil {
+			t.Fatal(err)
+		} else if string(contents) != p {
+			t.Fatalf("Expected %s, got %s", p, contents)
+		}
+	}
+	if all, err := crm.List(); err != nil {
+		t.Fatal(err)
+	} else if len(all) != 0 {
+		t.Fatalf("Expected merged change request to be gone, got %+v", all)
+	}
+}
+
+func TestChangeRequestConflict(t *testing.T) {
+	c := newTestRepo(t)
+	repo, err := c.GetRepo("config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Do(func(r RepoFS) (string, error) {
+		return "propose a", WriteFile(r, "a", "proposed")
+	}, WithCommitToBranch(ChangeRequestBranch("foo"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Do(func(r RepoFS) (string, error) {
+		return "change a", WriteFile(r, "a", "changed")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	crm, err := NewChangeRequestManager(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = crm.Merge("foo", "admin")
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("Expected conflict, got %v", err)
+	}
+	if err := crm.Reject("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := crm.Get("foo"); !errors.Is(err, ErrorChangeRequestNotFound) {
+		t.Fatalf("Expected not found, got %v", err)
+	}
+}
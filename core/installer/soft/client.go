@@ -23,7 +23,11 @@ import (
 )
 
 var ErrorAlreadyExists = errors.New("already exists")
+var ErrorNotSupported = errors.New("not supported")
 
+// Client manages repositories, users and their access rights on a git server.
+// Soft Serve is the default backend, see git.go and gitea.go for others.
+// Backends which do not manage users or webhooks return ErrorNotSupported.
 type Client interface {
 	Address() string
 	Signer() ssh.Signer
@@ -214,7 +218,11 @@ func CleanKey(key string) string {
 func (ss *realClient) RunCommand(args ...string) (string, error) {
 	cmd := strings.Join(args, " ")
 	log.Printf("Running command %s", cmd)
-	client, err := ssh.Dial("tcp", ss.addr, ss.sshClientConfig())
+	return runSSHCommand(ss.addr, ss.sshClientConfig(), cmd)
+}
+
+func runSSHCommand(addr string, config *ssh.ClientConfig, cmd string) (string, error) {
+	client, err := ssh.Dial("tcp", addr, config)
 	if err != nil {
 		return "", err
 	}
@@ -299,6 +307,8 @@ type Repository struct {
 	*git.Repository
 	Addr RepositoryAddress
 	Ref  string
+	url  string
+	auth transport.AuthMethod
 }
 
 func (ss *realClient) GetRepo(name string) (RepoIO, error) {
@@ -345,20 +355,31 @@ func CloneRepository(addr RepositoryAddress, signer ssh.Signer) (*Repository, er
 
 func CloneRepositoryBranch(addr RepositoryAddress, branch string, signer ssh.Signer) (*Repository, error) {
 	fmt.Printf("Cloning repository: %s %s %s\n", addr.Addr, addr.Name, branch)
-	ref := fmt.Sprintf("refs/heads/%s", branch)
-	c, err := git.Clone(memory.NewStorage(), memfs.New(), &git.CloneOptions{
-		URL: addr.FullAddress(),
-		Auth: &gitssh.PublicKeys{
-			User:   "git",
-			Signer: signer,
-			HostKeyCallbackHelper: gitssh.HostKeyCallbackHelper{
-				HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-					// TODO(giolekva): verify server public key
-					fmt.Printf("--- %+v\n", ssh.MarshalAuthorizedKey(key))
-					return nil
-				},
+	r, err := CloneRepositoryURL(addr.FullAddress(), branch, &gitssh.PublicKeys{
+		User:   "git",
+		Signer: signer,
+		HostKeyCallbackHelper: gitssh.HostKeyCallbackHelper{
+			HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+				// TODO(giolekva): verify server public key
+				fmt.Printf("--- %+v\n", ssh.MarshalAuthorizedKey(key))
+				return nil
 			},
 		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.Addr = addr
+	return r, nil
+}
+
+// CloneRepositoryURL clones given branch of the repository at url, which can
+// be any address supported by go-git, including local paths.
+func CloneRepositoryURL(url, branch string, auth transport.AuthMethod) (*Repository, error) {
+	ref := fmt.Sprintf("refs/heads/%s", branch)
+	c, err := git.Clone(memory.NewStorage(), memfs.New(), &git.CloneOptions{
+		URL:             url,
+		Auth:            auth,
 		RemoteName:      "origin",
 		ReferenceName:   plumbing.ReferenceName(ref),
 		SingleBranch:    true,
@@ -387,8 +408,9 @@ func CloneRepositoryBranch(addr RepositoryAddress, branch string, signer ssh.Sig
 	}
 	return &Repository{
 		Repository: c,
-		Addr:       addr,
 		Ref:        ref,
+		url:        url,
+		auth:       auth,
 	}, nil
 }
 
@@ -432,17 +454,22 @@ func (ss *realClient) authGit() *gitssh.PublicKeys {
 }
 
 func (ss *realClient) GetPublicKeys() ([]string, error) {
+	return hostPublicKeys(ss.addr, "", ss.signer)
+}
+
+func hostPublicKeys(addr, user string, signer ssh.Signer) ([]string, error) {
 	var ret []string
 	config := &ssh.ClientConfig{
+		User: user,
 		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(ss.signer),
+			ssh.PublicKeys(signer),
 		},
 		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
 			ret = append(ret, string(ssh.MarshalAuthorizedKey(key)))
 			return nil
 		},
 	}
-	client, err := ssh.Dial("tcp", ss.addr, config)
+	client, err := ssh.Dial("tcp", addr, config)
 	if err != nil {
 		return nil, err
 	}
@@ -471,3 +498,25 @@ func (ss *realClient) GetRepoAddress(name string) string {
 func (ss *realClient) addressGit() string {
 	return fmt.Sprintf("ssh://%s", ss.addr)
 }
+
+// OpenRepository clones master branch of the repository at addr. Soft Serve
+// style ssh:// addresses authenticate with signer, everything else, such as
+// on-disk bare repositories used for local development, is cloned as is.
+func OpenRepository(addr string, signer ssh.Signer, opts ...RepoIOOption) (RepoIO, error) {
+	if strings.HasPrefix(addr, "ssh://") {
+		a, err := ParseRepositoryAddress(addr)
+		if err != nil {
+			return nil, err
+		}
+		r, err := CloneRepository(a, signer)
+		if err != nil {
+			return nil, err
+		}
+		return NewRepoIO(r, signer, opts...)
+	}
+	r, err := CloneRepositoryURL(addr, "master", nil)
+	if err != nil {
+		return nil, err
+	}
+	return NewRepoIO(r, nil, opts...)
+}
@@ -0,0 +1,179 @@
+package soft
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"golang.org/x/crypto/ssh"
+)
+
+// noAccessControl is embedded by backends serving plain bare repositories.
+// Access to such repositories is governed by the host itself, for example
+// with file system permissions, so there are no users to manage.
+type noAccessControl struct{}
+
+func (noAccessControl) UserExists(name string) (bool, error) {
+	return false, ErrorNotSupported
+}
+
+func (noAccessControl) FindUser(pubKey string) (string, error) {
+	return "", ErrorNotSupported
+}
+
+func (noAccessControl) GetAllUsers() ([]string, error) {
+	return nil, ErrorNotSupported
+}
+
+func (noAccessControl) AddUser(name, pubKey string) error {
+	return ErrorNotSupported
+}
+
+func (noAccessControl) RemoveUser(user string) error {
+	return ErrorNotSupported
+}
+
+func (noAccessControl) AddPublicKey(user string, pubKey string) error {
+	return ErrorNotSupported
+}
+
+func (noAccessControl) RemovePublicKey(user string, pubKey string) error {
+	return ErrorNotSupported
+}
+
+func (noAccessControl) GetUserPublicKeys(user string) ([]string, error) {
+	return nil, ErrorNotSupported
+}
+
+func (noAccessControl) MakeUserAdmin(name string) error {
+	return ErrorNotSupported
+}
+
+func (noAccessControl) AddReadWriteCollaborator(repo, user string) error {
+	return ErrorNotSupported
+}
+
+func (noAccessControl) AddReadOnlyCollaborator(repo, user string) error {
+	return ErrorNotSupported
+}
+
+func (noAccessControl) AddWebhook(repo, url string, opts ...string) error {
+	return ErrorNotSupported
+}
+
+func (noAccessControl) DisableAnonAccess() error {
+	return ErrorNotSupported
+}
+
+func (noAccessControl) DisableKeyless() error {
+	return ErrorNotSupported
+}
+
+func bareRepoName(name string) string {
+	return fmt.Sprintf("%s.git", name)
+}
+
+// fsClient serves bare repositories stored in a local directory, each
+// repository living in <root>/<name>.git. Meant for local development.
+type fsClient struct {
+	noAccessControl
+	root string
+}
+
+func NewFSClient(root string) (Client, error) {
+	if err := os.MkdirAll(root, fs.ModePerm); err != nil {
+		return nil, err
+	}
+	return &fsClient{root: root}, nil
+}
+
+func (c *fsClient) repoPath(name string) string {
+	return filepath.Join(c.root, bareRepoName(name))
+}
+
+func (c *fsClient) Address() string {
+	return c.root
+}
+
+func (c *fsClient) Signer() ssh.Signer {
+	return nil
+}
+
+func (c *fsClient) GetPublicKeys() ([]string, error) {
+	return nil, nil
+}
+
+func (c *fsClient) RepoExists(name string) (bool, error) {
+	if _, err := os.Stat(c.repoPath(name)); err == nil {
+		return true, nil
+	} else if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else {
+		return false, err
+	}
+}
+
+func (c *fsClient) GetRepo(name string) (RepoIO, error) {
+	return c.GetRepoBranch(name, "master")
+}
+
+func (c *fsClient) GetRepoBranch(name, branch string) (RepoIO, error) {
+	r, err := CloneRepositoryURL(c.repoPath(name), branch, nil)
+	if err != nil {
+		return nil, err
+	}
+	return NewRepoIO(r, nil)
+}
+
+func (c *fsClient) DeleteRepoBranch(name, branch string) error {
+	r, err := git.PlainOpen(c.repoPath(name))
+	if err != nil {
+		return err
+	}
+	return r.Storer.RemoveReference(plumbing.NewBranchReferenceName(branch))
+}
+
+func (c *fsClient) DeleteRepo(name string) error {
+	return os.RemoveAll(c.repoPath(name))
+}
+
+func (c *fsClient) GetAllRepos() ([]string, error) {
+	entries, err := os.ReadDir(c.root)
+	if err != nil {
+		return nil, err
+	}
+	var ret []string
+	for _, e := range entries {
+		if e.IsDir() && strings.HasSuffix(e.Name(), ".git") {
+			ret = append(ret, strings.TrimSuffix(e.Name(), ".git"))
+		}
+	}
+	return ret, nil
+}
+
+func (c *fsClient) GetRepoAddress(name string) string {
+	return c.repoPath(name)
+}
+
+func (c *fsClient) AddRepository(name string) error {
+	if ok, err := c.RepoExists(name); ok {
+		return ErrorAlreadyExists
+	} else if err != nil {
+		return err
+	}
+	_, err := git.PlainInit(c.repoPath(name), true)
+	return err
+}
+
+// FSClientGetter treats given address as the root directory of repositories.
+type FSClientGetter struct{}
+
+func (c FSClientGetter) Get(addr string, _ []byte, _ *log.Logger) (Client, error) {
+	return NewFSClient(addr)
+}
@@ -0,0 +1,51 @@
+package soft
+
+import (
+	"testing"
+)
+
+func TestFSClientRoundTrip(t *testing.T) {
+	c, err := NewFSClient(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddRepository("config"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddRepository("config"); err != ErrorAlreadyExists {
+		t.Fatalf("Expected already exists, got %s", err)
+	}
+	repo, err := c.GetRepo("config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Do(func(r RepoFS) (string, error) {
+		if err := WriteFile(r, "README.md", "hello"); err != nil {
+			return "", err
+		}
+		return "init", nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	repo, err = c.GetRepo("config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := ReadFile(repo, "README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != "hello" {
+		t.Fatalf("Expected hello, got %s", contents)
+	}
+	repos, err := c.GetAllRepos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repos) != 1 || repos[0] != "config" {
+		t.Fatalf("Unexpected repos: %v", repos)
+	}
+	if err := c.AddUser("foo", "bar"); err != ErrorNotSupported {
+		t.Fatalf("Expected not supported, got %s", err)
+	}
+}
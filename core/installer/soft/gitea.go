@@ -0,0 +1,395 @@
+package soft
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"golang.org/x/crypto/ssh"
+)
+
+// giteaClient manages repositories of the owner organization on a Gitea
+// server through its REST API, while repositories themselves are cloned
+// over SSH with the client key.
+type giteaClient struct {
+	apiAddr string
+	token   string
+	owner   string
+	sshAddr string
+	signer  ssh.Signer
+	log     *log.Logger
+	client  *http.Client
+}
+
+func NewGiteaClient(apiAddr, token, owner, sshAddr string, clientPrivateKey []byte, log *log.Logger) (Client, error) {
+	signer, err := ssh.ParsePrivateKey(clientPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return &giteaClient{
+		apiAddr: strings.TrimSuffix(apiAddr, "/"),
+		token:   token,
+		owner:   owner,
+		sshAddr: sshAddr,
+		signer:  signer,
+		log:     log,
+		client:  &http.Client{},
+	}, nil
+}
+
+type giteaError struct {
+	Status  int
+	Message string
+}
+
+func (e giteaError) Error() string {
+	return fmt.Sprintf("gitea: %d %s", e.Status, e.Message)
+}
+
+func (c *giteaClient) call(method, path string, req, resp any) error {
+	var body io.Reader
+	if req != nil {
+		var buf bytes.Buffer
+		if err := json.NewEncoder(&buf).Encode(req); err != nil {
+			return err
+		}
+		body = &buf
+	}
+	r, err := http.NewRequest(method, fmt.Sprintf("%s/api/v1%s", c.apiAddr, path), body)
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Authorization", fmt.Sprintf("token %s", c.token))
+	r.Header.Set("Accept", "application/json")
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	res, err := c.client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		var msg struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(res.Body).Decode(&msg)
+		return giteaError{res.StatusCode, msg.Message}
+	}
+	if resp == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(resp)
+}
+
+func isNotFound(err error) bool {
+	if e, ok := err.(giteaError); ok {
+		return e.Status == http.StatusNotFound
+	}
+	return false
+}
+
+func (c *giteaClient) Address() string {
+	return c.sshAddr
+}
+
+func (c *giteaClient) Signer() ssh.Signer {
+	return c.signer
+}
+
+func (c *giteaClient) GetPublicKeys() ([]string, error) {
+	return hostPublicKeys(c.sshAddr, "git", c.signer)
+}
+
+func (c *giteaClient) repoPath(name string) string {
+	return fmt.Sprintf("/repos/%s/%s", url.PathEscape(c.owner), url.PathEscape(name))
+}
+
+func (c *giteaClient) RepoExists(name string) (bool, error) {
+	if err := c.call(http.MethodGet, c.repoPath(name), nil, nil); err == nil {
+		return true, nil
+	} else if isNotFound(err) {
+		return false, nil
+	} else {
+		return false, err
+	}
+}
+
+func (c *giteaClient) GetRepo(name string) (RepoIO, error) {
+	return c.GetRepoBranch(name, "master")
+}
+
+func (c *giteaClient) GetRepoBranch(name, branch string) (RepoIO, error) {
+	r, err := CloneRepositoryURL(c.GetRepoAddress(name), branch, &gitssh.PublicKeys{
+		User:   "git",
+		Signer: c.signer,
+		HostKeyCallbackHelper: gitssh.HostKeyCallbackHelper{
+			HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+				// TODO(gio): verify server public key
+				return nil
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return NewRepoIO(r, c.signer)
+}
+
+func (c *giteaClient) DeleteRepoBranch(name, branch string) error {
+	c.log.Printf("Deleting branch %s %s", name, branch)
+	return c.call(http.MethodDelete, fmt.Sprintf("%s/branches/%s", c.repoPath(name), url.PathEscape(branch)), nil, nil)
+}
+
+func (c *giteaClient) DeleteRepo(name string) error {
+	c.log.Printf("Deleting repo %s", name)
+	return c.call(http.MethodDelete, c.repoPath(name), nil, nil)
+}
+
+func (c *giteaClient) GetAllRepos() ([]string, error) {
+	var ret []string
+	for page := 1; ; page++ {
+		var repos []struct {
+			Name string `json:"name"`
+		}
+		if err := c.call(http.MethodGet, fmt.Sprintf("/orgs/%s/repos?limit=50&page=%d", url.PathEscape(c.owner), page), nil, &repos); err != nil {
+			return nil, err
+		}
+		if len(repos) == 0 {
+			return ret, nil
+		}
+		for _, r := range repos {
+			ret = append(ret, r.Name)
+		}
+	}
+}
+
+func (c *giteaClient) GetRepoAddress(name string) string {
+	return fmt.Sprintf("ssh://git@%s/%s/%s.git", c.sshAddr, c.owner, name)
+}
+
+func (c *giteaClient) AddRepository(name string) error {
+	c.log.Printf("Adding repository %s", name)
+	if ok, err := c.RepoExists(name); ok {
+		return ErrorAlreadyExists
+	} else if err != nil {
+		return err
+	}
+	return c.call(http.MethodPost, fmt.Sprintf("/orgs/%s/repos", url.PathEscape(c.owner)), map[string]any{
+		"name":           name,
+		"private":        true,
+		"default_branch": "master",
+	}, nil)
+}
+
+func (c *giteaClient) UserExists(name string) (bool, error) {
+	if err := c.call(http.MethodGet, fmt.Sprintf("/users/%s", url.PathEscape(name)), nil, nil); err == nil {
+		return true, nil
+	} else if isNotFound(err) {
+		return false, nil
+	} else {
+		return false, err
+	}
+}
+
+func (c *giteaClient) FindUser(pubKey string) (string, error) {
+	pk := CleanKey(pubKey)
+	users, err := c.GetAllUsers()
+	if err != nil {
+		return "", err
+	}
+	for _, user := range users {
+		keys, err := c.GetUserPublicKeys(user)
+		if err != nil {
+			return "", err
+		}
+		for _, k := range keys {
+			if k == pk {
+				return user, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+func (c *giteaClient) GetAllUsers() ([]string, error) {
+	var ret []string
+	for page := 1; ; page++ {
+		var users []struct {
+			Login string `json:"login"`
+		}
+		if err := c.call(http.MethodGet, fmt.Sprintf("/admin/users?limit=50&page=%d", page), nil, &users); err != nil {
+			return nil, err
+		}
+		if len(users) == 0 {
+			return ret, nil
+		}
+		for _, u := range users {
+			ret = append(ret, u.Login)
+		}
+	}
+}
+
+// AddUser creates a user which can only log in with given public key, as
+// password is randomly generated and never shared.
+func (c *giteaClient) AddUser(name, pubKey string) error {
+	c.log.Printf("Adding user %s", name)
+	password := make([]byte, 32)
+	if _, err := rand.Read(password); err != nil {
+		return err
+	}
+	host := c.sshAddr
+	if h, _, err := net.SplitHostPort(c.sshAddr); err == nil {
+		host = h
+	}
+	if err := c.call(http.MethodPost, "/admin/users", map[string]any{
+		"username":             name,
+		"email":                fmt.Sprintf("%s@noreply.%s", name, host),
+		"password":             base64.RawURLEncoding.EncodeToString(password),
+		"must_change_password": false,
+	}, nil); err != nil {
+		return err
+	}
+	return c.AddPublicKey(name, pubKey)
+}
+
+func (c *giteaClient) RemoveUser(user string) error {
+	c.log.Printf("Removing user: %s", user)
+	return c.call(http.MethodDelete, fmt.Sprintf("/admin/users/%s", url.PathEscape(user)), nil, nil)
+}
+
+func (c *giteaClient) AddPublicKey(user string, pubKey string) error {
+	c.log.Printf("Adding public key: %s %s", user, pubKey)
+	key := CleanKey(pubKey)
+	return c.call(http.MethodPost, fmt.Sprintf("/admin/users/%s/keys", url.PathEscape(user)), map[string]any{
+		"title": fmt.Sprintf("%s-%x", user, sha256.Sum256([]byte(key)))[:len(user)+9],
+		"key":   key,
+	}, nil)
+}
+
+type giteaPublicKey struct {
+	Id  int64  `json:"id"`
+	Key string `json:"key"`
+}
+
+func (c *giteaClient) listPublicKeys(user string) ([]giteaPublicKey, error) {
+	var keys []giteaPublicKey
+	if err := c.call(http.MethodGet, fmt.Sprintf("/users/%s/keys", url.PathEscape(user)), nil, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (c *giteaClient) RemovePublicKey(user string, pubKey string) error {
+	c.log.Printf("Removing public key: %s %s", user, pubKey)
+	keys, err := c.listPublicKeys(user)
+	if err != nil {
+		return err
+	}
+	pk := CleanKey(pubKey)
+	for _, k := range keys {
+		if CleanKey(k.Key) == pk {
+			return c.call(http.MethodDelete, fmt.Sprintf("/admin/users/%s/keys/%d", url.PathEscape(user), k.Id), nil, nil)
+		}
+	}
+	return nil
+}
+
+func (c *giteaClient) GetUserPublicKeys(user string) ([]string, error) {
+	keys, err := c.listPublicKeys(user)
+	if err != nil {
+		return nil, err
+	}
+	var ret []string
+	for _, k := range keys {
+		ret = append(ret, CleanKey(k.Key))
+	}
+	return ret, nil
+}
+
+func (c *giteaClient) MakeUserAdmin(name string) error {
+	c.log.Printf("Making user %s admin", name)
+	return c.call(http.MethodPatch, fmt.Sprintf("/admin/users/%s", url.PathEscape(name)), map[string]any{
+		"login_name": name,
+		"source_id":  0,
+		"admin":      true,
+	}, nil)
+}
+
+func (c *giteaClient) addCollaborator(repo, user, permission string) error {
+	return c.call(http.MethodPut, fmt.Sprintf("%s/collaborators/%s", c.repoPath(repo), url.PathEscape(user)), map[string]any{
+		"permission": permission,
+	}, nil)
+}
+
+func (c *giteaClient) AddReadWriteCollaborator(repo, user string) error {
+	c.log.Printf("Adding read-write collaborator %s %s", repo, user)
+	return c.addCollaborator(repo, user, "write")
+}
+
+func (c *giteaClient) AddReadOnlyCollaborator(repo, user string) error {
+	c.log.Printf("Adding read-only collaborator %s %s", repo, user)
+	return c.addCollaborator(repo, user, "read")
+}
+
+// AddWebhook accepts the same --key=value options as Soft Serve's webhook
+// create command does: --events, --content-type and --active.
+func (c *giteaClient) AddWebhook(repo, addr string, opts ...string) error {
+	c.log.Printf("Adding webhook %s %s", repo, addr)
+	events := []string{"push"}
+	contentType := "json"
+	active := true
+	for _, o := range opts {
+		key, value, _ := strings.Cut(strings.TrimPrefix(o, "--"), "=")
+		switch key {
+		case "events":
+			events = strings.Split(value, ",")
+		case "content-type":
+			contentType = value
+		case "active":
+			active = value == "true"
+		default:
+			return fmt.Errorf("unsupported webhook option: %s", o)
+		}
+	}
+	return c.call(http.MethodPost, fmt.Sprintf("%s/hooks", c.repoPath(repo)), map[string]any{
+		"type": "gitea",
+		"config": map[string]string{
+			"url":          addr,
+			"content_type": contentType,
+		},
+		"events": events,
+		"active": active,
+	}, nil)
+}
+
+// Anonymous access and keyless login are configured server wide in
+// app.ini of Gitea, and are off for private repositories regardless.
+func (c *giteaClient) DisableAnonAccess() error {
+	return ErrorNotSupported
+}
+
+func (c *giteaClient) DisableKeyless() error {
+	return ErrorNotSupported
+}
+
+// GiteaClientGetter treats given address as the SSH address of Gitea server.
+type GiteaClientGetter struct {
+	APIAddr string
+	Token   string
+	Owner   string
+}
+
+func (c GiteaClientGetter) Get(addr string, clientPrivateKey []byte, log *log.Logger) (Client, error) {
+	return NewGiteaClient(c.APIAddr, c.Token, c.Owner, addr, clientPrivateKey, log)
+}
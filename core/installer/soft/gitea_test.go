@@ -0,0 +1,82 @@
+package soft
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestPrivateKey(t *testing.T) []byte {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(block)
+}
+
+func TestGiteaClient(t *testing.T) {
+	var hook map[string]any
+	repos := map[string]bool{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v1/repos/dodo/config":
+			if !repos["config"] {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v1/orgs/dodo/repos":
+			var req struct {
+				Name string `json:"name"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Error(err)
+			}
+			repos[req.Name] = true
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v1/repos/dodo/config/hooks":
+			if err := json.NewDecoder(r.Body).Decode(&hook); err != nil {
+				t.Error(err)
+			}
+			w.WriteHeader(http.StatusCreated)
+		default:
+			t.Errorf("Unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+	c, err := NewGiteaClient(srv.URL, "secret", "dodo", "gitea.example.com:22", newTestPrivateKey(t), log.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := c.RepoExists("config"); err != nil || ok {
+		t.Fatalf("Expected repository to be missing: %t %s", ok, err)
+	}
+	if err := c.AddRepository("config"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddRepository("config"); err != ErrorAlreadyExists {
+		t.Fatalf("Expected already exists, got %s", err)
+	}
+	if err := c.AddWebhook("config", "http://dodo-app/update", "--active=true", "--events=push", "--content-type=json"); err != nil {
+		t.Fatal(err)
+	}
+	if hook["config"].(map[string]any)["url"] != "http://dodo-app/update" {
+		t.Fatalf("Unexpected webhook: %+v", hook)
+	}
+	if addr := c.GetRepoAddress("config"); addr != "ssh://git@gitea.example.com:22/dodo/config.git" {
+		t.Fatalf("Unexpected address: %s", addr)
+	}
+}
@@ -10,6 +10,8 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -19,7 +21,10 @@ import (
 	"github.com/go-git/go-billy/v5/util"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
 	"golang.org/x/crypto/ssh"
 	"sigs.k8s.io/yaml"
@@ -36,15 +41,48 @@ type RepoFS interface {
 type DoFn func(r RepoFS) (string, error)
 
 type doOptions struct {
-	NoPull   bool
-	NoCommit bool
-	Force    bool
-	ToBranch string
-	NoLock   bool
+	NoPull         bool
+	NoCommit       bool
+	Force          bool
+	ToBranch       string
+	NoLock         bool
+	MaxAttempts    int
+	FailOnConflict bool
+	Author         string
 }
 
 type DoOption func(*doOptions)
 
+const defaultMaxAttempts = 5
+
+// ConflictError is returned by Do when changes pushed concurrently by someone
+// else touched the same paths as the operation did.
+type ConflictError struct {
+	Paths []string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("concurrent modification of: %s", strings.Join(e.Paths, ", "))
+}
+
+// WithMaxAttempts limits how many times Do applies the operation when its
+// push gets rejected because remote branch has moved in the meantime.
+func WithMaxAttempts(n int) DoOption {
+	return func(o *doOptions) {
+		o.MaxAttempts = n
+	}
+}
+
+// WithFailOnConflict makes Do give up with ConflictError instead of applying
+// the operation again on top of remote changes touching the same paths. Meant
+// for operations writing contents computed before Do was called, as opposed
+// to ones reading current state of the repository.
+func WithFailOnConflict() DoOption {
+	return func(o *doOptions) {
+		o.FailOnConflict = true
+	}
+}
+
 func WithNoLock() DoOption {
 	return func(o *doOptions) {
 		o.NoLock = true
@@ -69,6 +107,14 @@ func WithForce() DoOption {
 	}
 }
 
+// WithAuthor records name as the author of the commit, while the repository
+// client itself stays the committer.
+func WithAuthor(name string) DoOption {
+	return func(o *doOptions) {
+		o.Author = name
+	}
+}
+
 func WithCommitToBranch(branch string) DoOption {
 	return func(o *doOptions) {
 		o.ToBranch = branch
@@ -78,6 +124,7 @@ func WithCommitToBranch(branch string) DoOption {
 type pushOptions struct {
 	ToBranch string
 	Force    bool
+	Author   string
 }
 
 type PushOption func(*pushOptions)
@@ -88,6 +135,12 @@ func WithToBranch(branch string) PushOption {
 	}
 }
 
+func PushWithAuthor(name string) PushOption {
+	return func(o *pushOptions) {
+		o.Author = name
+	}
+}
+
 func PushWithForce() PushOption {
 	return func(o *pushOptions) {
 		o.Force = true
@@ -141,28 +194,110 @@ func (r *repoFS) ListDir(path string) ([]os.FileInfo, error) {
 
 type repoIO struct {
 	*repoFS
-	repo   *Repository
-	signer ssh.Signer
-	l      sync.Locker
+	repo     *Repository
+	signer   ssh.Signer
+	l        sync.Locker
+	verifier *CommitVerifier
+}
+
+type RepoIOOption func(*repoIO)
+
+// WithCommitVerifier makes repository refuse to move to commits which are not
+// signed by one of the trusted keys, be it on clone or on pull.
+func WithCommitVerifier(v *CommitVerifier) RepoIOOption {
+	return func(r *repoIO) {
+		r.verifier = v
+	}
 }
 
-func NewRepoIO(repo *Repository, signer ssh.Signer) (RepoIO, error) {
+// NewRepoIO wraps repo, signing all commits made through it with signer when
+// one is given.
+func NewRepoIO(repo *Repository, signer ssh.Signer, opts ...RepoIOOption) (RepoIO, error) {
 	wt, err := repo.Worktree()
 	if err != nil {
 		return nil, err
 	}
-	return &repoIO{
-		&repoFS{wt.Filesystem},
-		repo,
-		signer,
-		&sync.Mutex{},
-	}, nil
+	ret := &repoIO{
+		repoFS: &repoFS{wt.Filesystem},
+		repo:   repo,
+		signer: signer,
+		l:      &sync.Mutex{},
+	}
+	for _, o := range opts {
+		o(ret)
+	}
+	head, err := ret.head()
+	if err != nil {
+		return nil, err
+	}
+	if err := ret.verify(head); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (r *repoIO) verify(hash plumbing.Hash) error {
+	if r.verifier == nil || hash.IsZero() {
+		return nil
+	}
+	c, err := r.repo.CommitObject(hash)
+	if err != nil {
+		return err
+	}
+	return r.verifier.Verify(c)
+}
+
+// rejectUnverified moves back to the last verified commit.
+func (r *repoIO) rejectUnverified(verified plumbing.Hash, err error) error {
+	if !verified.IsZero() {
+		if rerr := r.resetTo(verified); rerr != nil {
+			return rerr
+		}
+	}
+	return err
+}
+
+// verifyRange checks every commit reachable from to but not from, so that an
+// unsigned commit can not sneak in behind a signed one.
+func (r *repoIO) verifyRange(from, to plumbing.Hash) error {
+	if r.verifier == nil || to.IsZero() || from == to {
+		return nil
+	}
+	commits, err := r.repo.Log(&git.LogOptions{From: to})
+	if err != nil {
+		return err
+	}
+	defer commits.Close()
+	err = commits.ForEach(func(c *object.Commit) error {
+		if c.Hash == from {
+			return storer.ErrStop
+		}
+		return r.verifier.Verify(c)
+	})
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		// NOTE(gio): Reached the boundary of shallow clone.
+		return nil
+	}
+	return err
 }
 
 func (r *repoIO) FullAddress() string {
+	if r.repo.url != "" {
+		return r.repo.url
+	}
 	return r.repo.Addr.FullAddress()
 }
 
+func (r *repoIO) auth() transport.AuthMethod {
+	if r.repo.auth != nil {
+		return r.repo.auth
+	}
+	if r.signer == nil {
+		return nil
+	}
+	return auth(r.signer)
+}
+
 func (r *repoIO) Pull() error {
 	r.l.Lock()
 	defer r.l.Unlock()
@@ -174,8 +309,26 @@ func (r *repoIO) pullWithoutLock() error {
 	if err != nil {
 		return nil
 	}
-	err = wt.Pull(&git.PullOptions{
-		Auth:     auth(r.signer),
+	prev, err := r.head()
+	if err != nil {
+		return err
+	}
+	if err := r.pullUnverified(wt); err != nil {
+		return err
+	}
+	curr, err := r.head()
+	if err != nil {
+		return err
+	}
+	if err := r.verifyRange(prev, curr); err != nil {
+		return r.rejectUnverified(prev, err)
+	}
+	return nil
+}
+
+func (r *repoIO) pullUnverified(wt *git.Worktree) error {
+	err := wt.Pull(&git.PullOptions{
+		Auth:     r.auth(),
 		Force:    true,
 		Progress: os.Stdout,
 	})
@@ -209,19 +362,30 @@ func (r *repoIO) CommitAndPush(message string, opts ...PushOption) (string, erro
 	if len(st) == 0 {
 		return "", nil // TODO(gio): maybe return ErrorNothingToCommit
 	}
-	fmt.Printf("@@@ %+v\n", st)
-	hash, err := wt.Commit(message, &git.CommitOptions{
-		Author: &object.Signature{
-			Name: "pcloud-installer",
-			When: time.Now(),
-		},
-	})
+	committer := &object.Signature{
+		Name: "pcloud-installer",
+		When: time.Now(),
+	}
+	copts := &git.CommitOptions{
+		Author:    committer,
+		Committer: committer,
+	}
+	if o.Author != "" {
+		copts.Author = &object.Signature{
+			Name: o.Author,
+			When: committer.When,
+		}
+	}
+	if r.signer != nil {
+		copts.Signer = NewSSHCommitSigner(r.signer)
+	}
+	hash, err := wt.Commit(message, copts)
 	if err != nil {
 		return "", err
 	}
 	gopts := &git.PushOptions{
 		RemoteName: "origin",
-		Auth:       auth(r.signer),
+		Auth:       r.auth(),
 	}
 	if o.ToBranch != "" {
 		gopts.RefSpecs = []config.RefSpec{config.RefSpec(fmt.Sprintf("%s:refs/heads/%s", r.repo.Ref, o.ToBranch))}
@@ -233,12 +397,17 @@ func (r *repoIO) CommitAndPush(message string, opts ...PushOption) (string, erro
 	return hash.String(), r.repo.Push(gopts)
 }
 
+// Do applies op on top of the latest state of the repository, commits and
+// pushes the result. If push is rejected as some other writer got there first,
+// fresh state is fetched and op is applied again, up to MaxAttempts times.
 func (r *repoIO) Do(op DoFn, opts ...DoOption) (string, error) {
-	o := &doOptions{}
+	o := &doOptions{
+		MaxAttempts: defaultMaxAttempts,
+	}
 	for _, i := range opts {
 		i(o)
 	}
-	if o.NoLock {
+	if !o.NoLock {
 		r.l.Lock()
 		defer r.l.Unlock()
 	}
@@ -247,13 +416,6 @@ func (r *repoIO) Do(op DoFn, opts ...DoOption) (string, error) {
 			return "", err
 		}
 	}
-	msg, err := op(r)
-	if err != nil {
-		return "", err
-	}
-	if o.NoCommit {
-		return "", nil
-	}
 	popts := []PushOption{}
 	if o.Force {
 		popts = append(popts, PushWithForce())
@@ -261,12 +423,163 @@ func (r *repoIO) Do(op DoFn, opts ...DoOption) (string, error) {
 	if o.ToBranch != "" {
 		popts = append(popts, WithToBranch(o.ToBranch))
 	}
-	fmt.Println(2222)
-	return r.CommitAndPush(msg, popts...)
+	if o.Author != "" {
+		popts = append(popts, PushWithAuthor(o.Author))
+	}
+	for attempt := 1; ; attempt++ {
+		base, err := r.head()
+		if err != nil {
+			return "", err
+		}
+		msg, err := op(r)
+		if err != nil {
+			return "", err
+		}
+		if o.NoCommit {
+			return "", nil
+		}
+		hash, err := r.CommitAndPush(msg, popts...)
+		if hash != "" && o.ToBranch != "" && o.ToBranch != r.branch() && !base.IsZero() {
+			// NOTE(gio): Commit only belongs to the target branch, tracked one
+			// must keep following its remote counterpart.
+			if rerr := r.resetTo(base); rerr != nil {
+				return "", rerr
+			}
+		}
+		// NOTE(gio): Retrying only makes sense when pushing to the branch
+		// being tracked, otherwise rebasing on top of it changes nothing.
+		if err == nil || o.Force || o.ToBranch != "" || !isNonFastForward(err) {
+			return hash, err
+		}
+		if attempt >= o.MaxAttempts {
+			return "", fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+		fmt.Printf("Push rejected, retrying on top of remote changes: %s\n", err)
+		touched, err := r.changedPaths(base, plumbing.NewHash(hash))
+		if err != nil {
+			return "", err
+		}
+		remote, err := r.fetch()
+		if err != nil {
+			return "", err
+		}
+		if err := r.verifyRange(base, remote); err != nil {
+			return "", r.rejectUnverified(base, err)
+		}
+		if err := r.resetTo(remote); err != nil {
+			return "", err
+		}
+		if o.FailOnConflict {
+			changed, err := r.changedPaths(base, remote)
+			if err != nil {
+				return "", err
+			}
+			if paths := intersect(touched, changed); len(paths) > 0 {
+				return "", &ConflictError{paths}
+			}
+		}
+	}
+}
+
+func isNonFastForward(err error) bool {
+	return errors.Is(err, git.ErrForceNeeded) ||
+		errors.Is(err, git.ErrNonFastForwardUpdate) ||
+		strings.Contains(err.Error(), "non-fast-forward") ||
+		strings.Contains(err.Error(), "fetch first")
+}
+
+func (r *repoIO) head() (plumbing.Hash, error) {
+	ref, err := r.repo.Head()
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return plumbing.ZeroHash, nil
+	} else if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return ref.Hash(), nil
+}
+
+func (r *repoIO) branch() string {
+	return plumbing.ReferenceName(r.repo.Ref).Short()
+}
+
+// fetch retrieves latest state of the tracked branch and returns its head.
+func (r *repoIO) fetch() (plumbing.Hash, error) {
+	if err := r.repo.Fetch(&git.FetchOptions{
+		RemoteName: "origin",
+		Auth:       r.auth(),
+		Force:      true,
+	}); err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return plumbing.ZeroHash, err
+	}
+	ref, err := r.repo.Reference(plumbing.NewRemoteReferenceName("origin", r.branch()), true)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return ref.Hash(), nil
+}
+
+// resetTo discards local commits and changes, moving tracked branch to hash.
+func (r *repoIO) resetTo(hash plumbing.Hash) error {
+	wt, err := r.repo.Worktree()
+	if err != nil {
+		return err
+	}
+	return wt.Reset(&git.ResetOptions{
+		Commit: hash,
+		Mode:   git.HardReset,
+	})
+}
+
+func (r *repoIO) tree(hash plumbing.Hash) (*object.Tree, error) {
+	if hash.IsZero() {
+		return &object.Tree{}, nil
+	}
+	c, err := r.repo.CommitObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	return c.Tree()
+}
+
+// changedPaths lists paths which differ between trees of given commits.
+func (r *repoIO) changedPaths(from, to plumbing.Hash) ([]string, error) {
+	fromTree, err := r.tree(from)
+	if err != nil {
+		return nil, err
+	}
+	toTree, err := r.tree(to)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := object.DiffTree(fromTree, toTree)
+	if err != nil {
+		return nil, err
+	}
+	var ret []string
+	for _, c := range changes {
+		if c.From.Name != "" {
+			ret = append(ret, c.From.Name)
+		}
+		if c.To.Name != "" && c.To.Name != c.From.Name {
+			ret = append(ret, c.To.Name)
+		}
+	}
+	return ret, nil
+}
+
+func intersect(a, b []string) []string {
+	var ret []string
+	for _, i := range a {
+		if slices.Contains(b, i) && !slices.Contains(ret, i) {
+			ret = append(ret, i)
+		}
+	}
+	return ret
 }
 
 func auth(signer ssh.Signer) *gitssh.PublicKeys {
 	return &gitssh.PublicKeys{
+		User:   "git",
 		Signer: signer,
 		HostKeyCallbackHelper: gitssh.HostKeyCallbackHelper{
 			HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
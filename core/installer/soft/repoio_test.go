@@ -0,0 +1,99 @@
+package soft
+
+import (
+	"errors"
+	"testing"
+)
+
+func newTestRepo(t *testing.T) Client {
+	c, err := NewFSClient(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddRepository("config"); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := c.GetRepo("config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Do(func(r RepoFS) (string, error) {
+		if err := WriteFile(r, "a", "a"); err != nil {
+			return "", err
+		}
+		return "init", nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestDoRetriesOnConcurrentPush(t *testing.T) {
+	c := newTestRepo(t)
+	first, err := c.GetRepo("config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.GetRepo("config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := first.Do(func(r RepoFS) (string, error) {
+		return "first", WriteFile(r, "b", "b")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	attempts := 0
+	if _, err := second.Do(func(r RepoFS) (string, error) {
+		attempts++
+		return "second", WriteFile(r, "c", "c")
+	}, WithNoPull()); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 {
+		t.Fatalf("Expected 2 attempts, got %d", attempts)
+	}
+	repo, err := c.GetRepo("config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"a", "b", "c"} {
+		if contents, err := ReadFile(repo, p); err != nil {
+			t.Fatal(err)
+		} else if string(contents) != p {
+			t.Fatalf("Expected %s, got %s", p, contents)
+		}
+	}
+}
+
+func TestDoFailsOnConflict(t *testing.T) {
+	c := newTestRepo(t)
+	first, err := c.GetRepo("config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.GetRepo("config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := first.Do(func(r RepoFS) (string, error) {
+		return "first", WriteFile(r, "a", "first")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = second.Do(func(r RepoFS) (string, error) {
+		return "second", WriteFile(r, "a", "second")
+	}, WithNoPull(), WithFailOnConflict())
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("Expected conflict, got %s", err)
+	}
+	if len(conflict.Paths) != 1 || conflict.Paths[0] != "a" {
+		t.Fatalf("Unexpected conflicting paths: %v", conflict.Paths)
+	}
+	if contents, err := ReadFile(second, "a"); err != nil {
+		t.Fatal(err)
+	} else if string(contents) != "first" {
+		t.Fatalf("Expected first, got %s", contents)
+	}
+}
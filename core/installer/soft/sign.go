@@ -0,0 +1,200 @@
+package soft
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"golang.org/x/crypto/ssh"
+)
+
+// Commits are signed following the SSHSIG protocol, same as `git commit -S`
+// does when gpg.format is set to ssh, so they can be verified with stock git
+// using an allowed signers file.
+// See https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.sshsig
+
+const (
+	sshSigMagic      = "SSHSIG"
+	sshSigVersion    = 1
+	sshSigNamespace  = "git"
+	sshSigHash       = "sha512"
+	sshSigArmorBegin = "-----BEGIN SSH SIGNATURE-----"
+	sshSigArmorEnd   = "-----END SSH SIGNATURE-----"
+)
+
+var ErrorUnsignedCommit = errors.New("commit is not signed")
+var ErrorUntrustedSignature = errors.New("commit is not signed by a trusted key")
+
+type sshSigSignedData struct {
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Hash          string
+}
+
+type sshSigBlob struct {
+	Version       uint32
+	PublicKey     string
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Signature     string
+}
+
+func sshSigMessage(message io.Reader) ([]byte, error) {
+	h := sha512.New()
+	if _, err := io.Copy(h, message); err != nil {
+		return nil, err
+	}
+	return append([]byte(sshSigMagic), ssh.Marshal(sshSigSignedData{
+		Namespace:     sshSigNamespace,
+		HashAlgorithm: sshSigHash,
+		Hash:          string(h.Sum(nil)),
+	})...), nil
+}
+
+// sshCommitSigner implements git.Signer with an SSH key.
+type sshCommitSigner struct {
+	signer ssh.Signer
+}
+
+func NewSSHCommitSigner(signer ssh.Signer) git.Signer {
+	return &sshCommitSigner{signer}
+}
+
+func (s *sshCommitSigner) Sign(message io.Reader) ([]byte, error) {
+	data, err := sshSigMessage(message)
+	if err != nil {
+		return nil, err
+	}
+	var sig *ssh.Signature
+	// NOTE(gio): SSHSIG forbids SHA-1 based ssh-rsa signatures.
+	if as, ok := s.signer.(ssh.AlgorithmSigner); ok && s.signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		sig, err = as.SignWithAlgorithm(nil, data, ssh.KeyAlgoRSASHA512)
+	} else {
+		sig, err = s.signer.Sign(nil, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	blob := append([]byte(sshSigMagic), ssh.Marshal(sshSigBlob{
+		Version:       sshSigVersion,
+		PublicKey:     string(s.signer.PublicKey().Marshal()),
+		Namespace:     sshSigNamespace,
+		HashAlgorithm: sshSigHash,
+		Signature:     string(ssh.Marshal(sig)),
+	})...)
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, sshSigArmorBegin)
+	enc := base64.StdEncoding.EncodeToString(blob)
+	for len(enc) > 70 {
+		fmt.Fprintln(&buf, enc[:70])
+		enc = enc[70:]
+	}
+	fmt.Fprintln(&buf, enc)
+	fmt.Fprintln(&buf, sshSigArmorEnd)
+	return buf.Bytes(), nil
+}
+
+// CommitVerifier accepts commits signed by one of the allowed SSH keys.
+type CommitVerifier struct {
+	allowed []ssh.PublicKey
+}
+
+func NewCommitVerifier(allowed []ssh.PublicKey) *CommitVerifier {
+	return &CommitVerifier{allowed}
+}
+
+// ParseAllowedSigners reads public keys in authorized_keys format, one per
+// line. Lines in git's allowed signers format, prefixed by principals, are
+// accepted as well.
+func ParseAllowedSigners(r io.Reader) (*CommitVerifier, error) {
+	var allowed []ssh.PublicKey
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			if _, rest, ok := strings.Cut(line, " "); ok {
+				key, _, _, _, err = ssh.ParseAuthorizedKey([]byte(rest))
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed signer %s: %w", line, err)
+		}
+		allowed = append(allowed, key)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return NewCommitVerifier(allowed), nil
+}
+
+func (v *CommitVerifier) isAllowed(key ssh.PublicKey) bool {
+	for _, a := range v.allowed {
+		if bytes.Equal(a.Marshal(), key.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *CommitVerifier) Verify(c *object.Commit) error {
+	if c.PGPSignature == "" {
+		return fmt.Errorf("%s: %w", c.Hash, ErrorUnsignedCommit)
+	}
+	armored := strings.TrimSpace(c.PGPSignature)
+	if !strings.HasPrefix(armored, sshSigArmorBegin) || !strings.HasSuffix(armored, sshSigArmorEnd) {
+		return fmt.Errorf("%s: not an SSH signature", c.Hash)
+	}
+	armored = strings.TrimSuffix(strings.TrimPrefix(armored, sshSigArmorBegin), sshSigArmorEnd)
+	raw, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(armored), ""))
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(raw, []byte(sshSigMagic)) {
+		return fmt.Errorf("%s: malformed SSH signature", c.Hash)
+	}
+	var blob sshSigBlob
+	if err := ssh.Unmarshal(raw[len(sshSigMagic):], &blob); err != nil {
+		return err
+	}
+	if blob.Version != sshSigVersion || blob.Namespace != sshSigNamespace || blob.HashAlgorithm != sshSigHash {
+		return fmt.Errorf("%s: unsupported SSH signature", c.Hash)
+	}
+	key, err := ssh.ParsePublicKey([]byte(blob.PublicKey))
+	if err != nil {
+		return err
+	}
+	if !v.isAllowed(key) {
+		return fmt.Errorf("%s: %w", c.Hash, ErrorUntrustedSignature)
+	}
+	var sig ssh.Signature
+	if err := ssh.Unmarshal([]byte(blob.Signature), &sig); err != nil {
+		return err
+	}
+	encoded := &plumbing.MemoryObject{}
+	if err := c.EncodeWithoutSignature(encoded); err != nil {
+		return err
+	}
+	r, err := encoded.Reader()
+	if err != nil {
+		return err
+	}
+	data, err := sshSigMessage(r)
+	if err != nil {
+		return err
+	}
+	return key.Verify(data, &sig)
+}
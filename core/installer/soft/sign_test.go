@@ -0,0 +1,68 @@
+package soft
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSignedCommitsAreVerified(t *testing.T) {
+	signer, err := ssh.ParsePrivateKey(newTestPrivateKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewFSClient(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddRepository("config"); err != nil {
+		t.Fatal(err)
+	}
+	r, err := CloneRepositoryURL(c.GetRepoAddress("config"), "master", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := NewRepoIO(r, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := signed.Do(func(r RepoFS) (string, error) {
+		return "signed", WriteFile(r, "a", "a")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	v := NewCommitVerifier([]ssh.PublicKey{signer.PublicKey()})
+	r, err = CloneRepositoryURL(c.GetRepoAddress("config"), "master", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verified, err := NewRepoIO(r, nil, WithCommitVerifier(v))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned, err := c.GetRepo("config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := unsigned.Do(func(r RepoFS) (string, error) {
+		return "unsigned", WriteFile(r, "a", "b")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := verified.Pull(); !errors.Is(err, ErrorUnsignedCommit) {
+		t.Fatalf("Expected unsigned commit error, got %v", err)
+	}
+	if contents, err := ReadFile(verified, "a"); err != nil {
+		t.Fatal(err)
+	} else if string(contents) != "a" {
+		t.Fatalf("Expected a, got %s", contents)
+	}
+	r, err = CloneRepositoryURL(c.GetRepoAddress("config"), "master", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewRepoIO(r, nil, WithCommitVerifier(v)); !errors.Is(err, ErrorUnsignedCommit) {
+		t.Fatalf("Expected unsigned commit error, got %v", err)
+	}
+}
@@ -0,0 +1,222 @@
+package tasks
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+type dagNode struct {
+	task     Task
+	deps     []Task
+	started  time.Time
+	finished time.Time
+}
+
+// TaskGraph describes a set of tasks together with the tasks each of them
+// depends on. Tasks whose dependencies have all succeeded run concurrently.
+type TaskGraph struct {
+	l     sync.Mutex
+	nodes []*dagNode
+}
+
+func newTaskGraph() *TaskGraph {
+	return &TaskGraph{}
+}
+
+// Add registers t to be started once every task in deps has succeeded.
+// Dependencies must be added to the graph themselves, in any order.
+func (g *TaskGraph) Add(t Task, deps ...Task) Task {
+	g.nodes = append(g.nodes, &dagNode{task: t, deps: deps})
+	return t
+}
+
+func (g *TaskGraph) Tasks() []Task {
+	ret := make([]Task, 0, len(g.nodes))
+	for _, n := range g.nodes {
+		ret = append(ret, n.task)
+	}
+	return ret
+}
+
+func (g *TaskGraph) indexOf(t Task) int {
+	for i, n := range g.nodes {
+		if n.task == t {
+			return i
+		}
+	}
+	return -1
+}
+
+// dependents returns for each node the indices of the nodes depending on it,
+// and the number of unsatisfied dependencies of every node.
+func (g *TaskGraph) dependents() ([][]int, []int, error) {
+	out := make([][]int, len(g.nodes))
+	pending := make([]int, len(g.nodes))
+	for i, n := range g.nodes {
+		for _, d := range n.deps {
+			j := g.indexOf(d)
+			if j == -1 {
+				return nil, nil, fmt.Errorf("%s: depends on unknown task %s", n.task.Title(), d.Title())
+			}
+			out[j] = append(out[j], i)
+			pending[i]++
+		}
+	}
+	return out, pending, nil
+}
+
+func (g *TaskGraph) validate() error {
+	out, pending, err := g.dependents()
+	if err != nil {
+		return err
+	}
+	var ready []int
+	for i, p := range pending {
+		if p == 0 {
+			ready = append(ready, i)
+		}
+	}
+	visited := 0
+	for len(ready) > 0 {
+		i := ready[0]
+		ready = ready[1:]
+		visited++
+		for _, j := range out[i] {
+			pending[j]--
+			if pending[j] == 0 {
+				ready = append(ready, j)
+			}
+		}
+	}
+	if visited != len(g.nodes) {
+		return fmt.Errorf("task graph has a cycle")
+	}
+	return nil
+}
+
+func (g *TaskGraph) run() error {
+	if len(g.nodes) == 0 {
+		return nil
+	}
+	if err := g.validate(); err != nil {
+		return err
+	}
+	out, pending, err := g.dependents()
+	if err != nil {
+		return err
+	}
+	type result struct {
+		i   int
+		err error
+	}
+	// NOTE(gio): Buffered so that tasks still running after the first
+	// failure do not block forever when reporting their result.
+	doneCh := make(chan result, len(g.nodes))
+	for i, n := range g.nodes {
+		n.task.OnDone(func(err error) {
+			doneCh <- result{i, err}
+		})
+	}
+	start := func(i int) {
+		g.l.Lock()
+		g.nodes[i].started = time.Now()
+		g.l.Unlock()
+		go g.nodes[i].task.Start()
+	}
+	for i, p := range pending {
+		if p == 0 {
+			start(i)
+		}
+	}
+	for range g.nodes {
+		r := <-doneCh
+		g.l.Lock()
+		g.nodes[r.i].finished = time.Now()
+		g.l.Unlock()
+		if r.err != nil {
+			return r.err
+		}
+		for _, j := range out[r.i] {
+			pending[j]--
+			if pending[j] == 0 {
+				start(j)
+			}
+		}
+	}
+	return nil
+}
+
+// CriticalPath returns the chain of finished tasks which determined the total
+// running time of the graph, starting from the one which was started first.
+func (g *TaskGraph) CriticalPath() []Task {
+	g.l.Lock()
+	defer g.l.Unlock()
+	last := -1
+	for i, n := range g.nodes {
+		if n.finished.IsZero() {
+			continue
+		}
+		if last == -1 || n.finished.After(g.nodes[last].finished) {
+			last = i
+		}
+	}
+	var ret []Task
+	for last != -1 {
+		ret = append([]Task{g.nodes[last].task}, ret...)
+		next := -1
+		for _, d := range g.nodes[last].deps {
+			j := g.indexOf(d)
+			if j == -1 || g.nodes[j].finished.IsZero() {
+				continue
+			}
+			if next == -1 || g.nodes[j].finished.After(g.nodes[next].finished) {
+				next = j
+			}
+		}
+		last = next
+	}
+	return ret
+}
+
+// Duration returns how long the given task ran, zero if it has not finished.
+func (g *TaskGraph) Duration(t Task) time.Duration {
+	g.l.Lock()
+	defer g.l.Unlock()
+	i := g.indexOf(t)
+	if i == -1 || g.nodes[i].finished.IsZero() {
+		return 0
+	}
+	return g.nodes[i].finished.Sub(g.nodes[i].started)
+}
+
+func (g *TaskGraph) formatCriticalPath() string {
+	var steps []string
+	for _, t := range g.CriticalPath() {
+		steps = append(steps, fmt.Sprintf("%s (%s)", t.Title(), g.Duration(t).Round(time.Millisecond)))
+	}
+	return strings.Join(steps, " -> ")
+}
+
+type graphParentTask struct {
+	parentTask
+	graph *TaskGraph
+}
+
+func (t *graphParentTask) CriticalPath() []Task {
+	return t.graph.CriticalPath()
+}
+
+func newGraphParentTask(title string, showChildren bool, g *TaskGraph) *graphParentTask {
+	start := func() error {
+		err := g.run()
+		log.Printf("%s critical path: %s", title, g.formatCriticalPath())
+		return err
+	}
+	return &graphParentTask{
+		parentTask: newParentTask(title, showChildren, start, g),
+		graph:      g,
+	}
+}
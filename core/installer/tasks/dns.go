@@ -55,6 +55,7 @@ func SetupDNSServer(env installer.EnvConfig, st *state) Task {
 				"publicIP":     join(env.PublicIP, ","),
 				"privateIP":    env.Network.Ingress.String(),
 				"nameserverIP": join(env.NameserverIP, ","),
+				"secondaryIP":  join(env.SecondaryNameserverIP, ","),
 			}); err != nil {
 				return err
 			}
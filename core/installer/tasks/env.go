@@ -29,6 +29,7 @@ type state struct {
 	appManager      *installer.AppManager
 	appsRepo        installer.AppRepository
 	infraAppManager *installer.InfraAppManager
+	configWriters   map[string]*keygen.KeyPair
 }
 
 type EnvInfoListener func(string)
@@ -58,13 +59,17 @@ func NewCreateEnvTask(
 		repoClient:      repoClient,
 		infraAppManager: mgr,
 	}
-	t := newSequentialParentTask(
-		"Create env",
-		true,
-		SetupConfigRepoTask(env, &st),
-		SetupZoneTask(env, mgr, &st),
-		SetupInfra(env, &st),
-	)
+	// NOTE(gio): Core services do not need public DNS records to be
+	// propagated to get installed, they only wait for their addresses to
+	// become reachable. So propagation runs alongside infrastructure setup.
+	// Certificate issuers are the exception, they solve DNS-01 challenges
+	// using dns-api, so they wait for the zone to propagate.
+	g := newTaskGraph()
+	configRepo := g.Add(SetupConfigRepoTask(env, &st))
+	zone := g.Add(SetupZoneTask(env, mgr, &st), configRepo)
+	g.Add(SetupInfra(env, &st), configRepo)
+	g.Add(SetupCertificateIssuers(env, &st), configRepo, zone)
+	t := newGraphParentTask("Create env", true, g)
 	t.afterDone = func() {
 		infoListener(fmt.Sprintf("dodo environment for %s has been provisioned successfully. Visit [https://welcome.%s](https://welcome.%s) to create administrative account and log into the system.", env.Domain, env.Domain, env.Domain))
 	}
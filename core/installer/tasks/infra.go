@@ -2,8 +2,10 @@ package tasks
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
+	"github.com/charmbracelet/keygen"
 	"github.com/miekg/dns"
 
 	"github.com/giolekva/pcloud/core/installer"
@@ -18,12 +20,28 @@ func CreateRepoClient(env installer.EnvConfig, st *state) Task {
 		if err != nil {
 			return err
 		}
-		appManager, err := installer.NewAppManager(r, st.nsCreator, st.jc, st.hf, nil, nil, "/apps")
+		appManager, err := installer.NewAppManager(r, st.nsCreator, st.jc, st.hf, nil, nil, nil, "/apps")
 		if err != nil {
 			return err
 		}
 		st.appManager = appManager
 		st.appsRepo = installer.NewInMemoryAppRepository(installer.CreateAllApps())
+		// NOTE(gio): Keys of the services committing to the config repository
+		// are generated upfront, so that AppManager can trust their signatures
+		// no matter in which order the services get installed.
+		st.configWriters = map[string]*keygen.KeyPair{}
+		for name, path := range map[string]string{
+			"port-allocator": "port-allocator",
+			"launcher":       fmt.Sprintf("%s-launcher", env.Id),
+			"welcome":        "welcome",
+			"appmanager":     fmt.Sprintf("%s-appmanager", env.Id),
+		} {
+			keys, err := installer.NewSSHKeyPair(path)
+			if err != nil {
+				return err
+			}
+			st.configWriters[name] = keys
+		}
 		return nil
 	})
 	t.beforeStart = func() {
@@ -33,23 +51,17 @@ func CreateRepoClient(env installer.EnvConfig, st *state) Task {
 }
 
 func SetupInfra(env installer.EnvConfig, st *state) Task {
-	tasks := []Task{
-		SetupNetwork(env, st),
-		SetupCertificateIssuers(env, st),
-		SetupAuth(env, st),
-		SetupGroupMemberships(env, st),
-		SetupWelcome(env, st),
-		SetupAppStore(env, st),
-		SetupLauncher(env, st),
-	}
+	g := newTaskGraph()
+	g.Add(SetupNetwork(env, st))
+	g.Add(SetupAuth(env, st))
+	g.Add(SetupGroupMemberships(env, st))
+	g.Add(SetupWelcome(env, st))
+	g.Add(SetupAppStore(env, st))
+	g.Add(SetupLauncher(env, st))
 	if env.PrivateDomain != "" {
-		tasks = append(tasks, SetupHeadscale(env, st))
+		g.Add(SetupHeadscale(env, st))
 	}
-	return newConcurrentParentTask(
-		"Setup core services",
-		true,
-		tasks...,
-	)
+	return newGraphParentTask("Setup core services", true, g)
 }
 
 func CommitEnvironmentConfiguration(env installer.EnvConfig, st *state) Task {
@@ -151,10 +163,7 @@ func SetupNetwork(env installer.EnvConfig, st *state) Task {
 			}
 		}
 		if env.PrivateDomain != "" {
-			keys, err := installer.NewSSHKeyPair("port-allocator")
-			if err != nil {
-				return err
-			}
+			keys := st.configWriters["port-allocator"]
 			user := fmt.Sprintf("%s-port-allocator", env.Id)
 			if err := st.ssClient.AddUser(user, keys.AuthorizedKey()); err != nil {
 				return err
@@ -179,6 +188,18 @@ func SetupNetwork(env installer.EnvConfig, st *state) Task {
 			}); err != nil {
 				return err
 			}
+			if env.ClusterProxy {
+				app, err := installer.FindEnvApp(st.appsRepo, "cluster-proxy")
+				if err != nil {
+					return err
+				}
+				instanceId := app.Slug()
+				appDir := fmt.Sprintf("/apps/%s", instanceId)
+				namespace := fmt.Sprintf("%s%s", env.NamespacePrefix, app.Namespace())
+				if _, err := st.appManager.Install(app, instanceId, appDir, namespace, map[string]any{}); err != nil {
+					return err
+				}
+			}
 		}
 		return nil
 	})
@@ -201,7 +222,8 @@ func SetupCertificateIssuers(env installer.EnvConfig, st *state) Task {
 		}
 		return nil
 	})
-	tasks := []Task{&pub}
+	g := newTaskGraph()
+	g.Add(&pub)
 	if env.PrivateDomain != "" {
 		priv := newLeafTask(fmt.Sprintf("Private p.%s", env.Domain), func() error {
 			app, err := installer.FindEnvApp(st.appsRepo, "certificate-issuer-private")
@@ -216,9 +238,9 @@ func SetupCertificateIssuers(env installer.EnvConfig, st *state) Task {
 			}
 			return nil
 		})
-		tasks = append(tasks, &priv)
+		g.Add(&priv)
 	}
-	return newSequentialParentTask("Configure TLS certificate issuers", false, tasks...)
+	return newGraphParentTask("Configure TLS certificate issuers", false, g)
 }
 
 func SetupAuth(env installer.EnvConfig, st *state) Task {
@@ -286,10 +308,7 @@ func SetupGroupMemberships(env installer.EnvConfig, st *state) Task {
 func SetupLauncher(env installer.EnvConfig, st *state) Task {
 	t := newLeafTask("Setup", func() error {
 		user := fmt.Sprintf("%s-launcher", env.Id)
-		keys, err := installer.NewSSHKeyPair(user)
-		if err != nil {
-			return err
-		}
+		keys := st.configWriters["launcher"]
 		if err := st.ssClient.AddUser(user, keys.AuthorizedKey()); err != nil {
 			return err
 		}
@@ -348,10 +367,7 @@ func SetupHeadscale(env installer.EnvConfig, st *state) Task {
 
 func SetupWelcome(env installer.EnvConfig, st *state) Task {
 	t := newLeafTask("Setup", func() error {
-		keys, err := installer.NewSSHKeyPair("welcome")
-		if err != nil {
-			return err
-		}
+		keys := st.configWriters["welcome"]
 		user := fmt.Sprintf("%s-welcome", env.Id)
 		if err := st.ssClient.AddUser(user, keys.AuthorizedKey()); err != nil {
 			return err
@@ -386,10 +402,7 @@ func SetupWelcome(env installer.EnvConfig, st *state) Task {
 func SetupAppStore(env installer.EnvConfig, st *state) Task {
 	t := newLeafTask("Setup", func() error {
 		user := fmt.Sprintf("%s-appmanager", env.Id)
-		keys, err := installer.NewSSHKeyPair(user)
-		if err != nil {
-			return err
-		}
+		keys := st.configWriters["appmanager"]
 		if err := st.ssClient.AddUser(user, keys.AuthorizedKey()); err != nil {
 			return err
 		}
@@ -408,10 +421,11 @@ func SetupAppStore(env installer.EnvConfig, st *state) Task {
 			network = "Private"
 		}
 		if _, err := st.appManager.Install(app, instanceId, appDir, namespace, map[string]any{
-			"network":       network,
-			"repoAddr":      st.ssClient.GetRepoAddress("config"),
-			"sshPrivateKey": string(keys.RawPrivateKey()),
-			"authGroups":    strings.Join(initGroups, ","),
+			"network":        network,
+			"repoAddr":       st.ssClient.GetRepoAddress("config"),
+			"sshPrivateKey":  string(keys.RawPrivateKey()),
+			"authGroups":     strings.Join(initGroups, ","),
+			"allowedSigners": configSigners(env, st),
 		}); err != nil {
 			return err
 		}
@@ -431,6 +445,21 @@ func SetupAppStore(env installer.EnvConfig, st *state) Task {
 	)
 }
 
+// configSigners lists public keys trusted to sign commits of the config
+// repository: the environment admin, the installer itself and the services
+// committing to it.
+func configSigners(env installer.EnvConfig, st *state) string {
+	keys := []string{st.ssAdminKeys.AuthorizedKey()}
+	if k := strings.TrimSpace(env.AdminPublicKey); k != "" {
+		keys = append(keys, k)
+	}
+	for _, k := range st.configWriters {
+		keys = append(keys, k.AuthorizedKey())
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, "\n")
+}
+
 // TODO(gio-dns): remove
 type DNSSecKey struct {
 	Basename string `json:"basename,omitempty"`
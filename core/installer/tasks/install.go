@@ -1,7 +1,9 @@
 package tasks
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"path/filepath"
 
 	"github.com/giolekva/pcloud/core/installer"
@@ -89,10 +91,45 @@ func NewClusterInitTask(m cluster.Manager, server cluster.Server, cnc installer.
 	return &t
 }
 
+func NewClusterImportTask(m cluster.Manager, kubeconfig, ingressClassName string, cnc installer.ClusterNetworkConfigurator, repo soft.RepoIO, setupFn cluster.ClusterIngressSetupFunc) Task {
+	d := &dynamicTaskSlice{t: []Task{}}
+	done := make(chan error)
+	setupTask := newLeafTask(fmt.Sprintf("Installing dodo on %s cluster", m.State().Name), func() error {
+		_, err := m.Import(kubeconfig, ingressClassName, setupFn)
+		return err
+	})
+	d.Append(&setupTask)
+	setupTask.OnDone(func(err error) {
+		if err != nil {
+			done <- err
+			return
+		}
+		if err := cnc.AddCluster(m.State().Name, m.State().IngressIP); err != nil {
+			done <- err
+			return
+		}
+		_, err = repo.Do(func(fs soft.RepoFS) (string, error) {
+			if err := soft.WriteJson(fs, fmt.Sprintf("/clusters/%s/config.json", m.State().Name), m.State()); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("import cluster: %s", m.State().Name), nil
+		})
+		done <- err
+	})
+	start := func() error {
+		setupTask.Start()
+		return <-done
+	}
+	t := newParentTask("Importing cluster", true, start, d)
+	return &t
+}
+
 func NewRemoveClusterTask(m cluster.Manager, cnc installer.ClusterNetworkConfigurator, repo soft.RepoIO) Task {
 	t := newLeafTask(fmt.Sprintf("Removing %s cluster", m.State().Name), func() error {
-		if err := cnc.RemoveCluster(m.State().Name, m.State().IngressIP); err != nil {
-			return err
+		for _, ip := range m.State().IngressIPs() {
+			if err := cnc.RemoveCluster(m.State().Name, ip); err != nil {
+				return err
+			}
 		}
 		_, err := repo.Do(func(fs soft.RepoFS) (string, error) {
 			if err := fs.RemoveAll(fmt.Sprintf("/clusters/%s", m.State().Name)); err != nil {
@@ -112,6 +149,44 @@ func NewRemoveClusterTask(m cluster.Manager, cnc installer.ClusterNetworkConfigu
 	return &t
 }
 
+func NewClusterAddFallbackIngressIPTask(m cluster.Manager, ip net.IP, cnc installer.ClusterNetworkConfigurator, repo soft.RepoIO) Task {
+	t := newLeafTask(fmt.Sprintf("Adding %s fallback ingress to %s cluster", ip, m.State().Name), func() error {
+		if err := m.AddFallbackIngressIP(ip); err != nil {
+			return err
+		}
+		if err := cnc.AddCluster(m.State().Name, ip); err != nil {
+			return errors.Join(err, m.RemoveFallbackIngressIP(ip))
+		}
+		_, err := repo.Do(func(fs soft.RepoFS) (string, error) {
+			if err := soft.WriteJson(fs, fmt.Sprintf("/clusters/%s/config.json", m.State().Name), m.State()); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("add fallback ingress %s to cluster: %s", ip, m.State().Name), nil
+		})
+		return err
+	})
+	return &t
+}
+
+func NewClusterRemoveFallbackIngressIPTask(m cluster.Manager, ip net.IP, cnc installer.ClusterNetworkConfigurator, repo soft.RepoIO) Task {
+	t := newLeafTask(fmt.Sprintf("Removing %s fallback ingress from %s cluster", ip, m.State().Name), func() error {
+		if err := m.RemoveFallbackIngressIP(ip); err != nil {
+			return err
+		}
+		if err := cnc.RemoveCluster(m.State().Name, ip); err != nil {
+			return err
+		}
+		_, err := repo.Do(func(fs soft.RepoFS) (string, error) {
+			if err := soft.WriteJson(fs, fmt.Sprintf("/clusters/%s/config.json", m.State().Name), m.State()); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("remove fallback ingress %s from cluster: %s", ip, m.State().Name), nil
+		})
+		return err
+	})
+	return &t
+}
+
 func NewClusterJoinControllerTask(m cluster.Manager, server cluster.Server, repo soft.RepoIO) Task {
 	d := &dynamicTaskSlice{t: []Task{}}
 	done := make(chan error)
@@ -223,3 +298,125 @@ func NewClusterSetupTask(m cluster.Manager, setupFn cluster.ClusterSetupFunc, re
 	t := newParentTask("Installing application", true, start, d)
 	return &t
 }
+
+// NewClusterUpgradeTask persists cluster state even if upgrade fails midway,
+// so that versions of already upgraded servers are not lost.
+func NewClusterUpgradeTask(m cluster.Manager, version string, repo soft.RepoIO) Task {
+	d := &dynamicTaskSlice{t: []Task{}}
+	done := make(chan error)
+	upgradeTask := newLeafTask(fmt.Sprintf("Upgrading %s cluster to %s", m.State().Name, version), func() error {
+		return m.Upgrade(version)
+	})
+	d.Append(&upgradeTask)
+	upgradeTask.OnDone(func(err error) {
+		_, serr := repo.Do(func(fs soft.RepoFS) (string, error) {
+			if err := soft.WriteJson(fs, fmt.Sprintf("/clusters/%s/config.json", m.State().Name), m.State()); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("upgrade cluster %s to %s", m.State().Name, version), nil
+		})
+		if err != nil {
+			done <- err
+		} else {
+			done <- serr
+		}
+	})
+	start := func() error {
+		upgradeTask.Start()
+		return <-done
+	}
+	t := newParentTask("Upgrading cluster", true, start, d)
+	return &t
+}
+
+func NewClusterRotateServerKeyTask(m cluster.Manager, server string, repo soft.RepoIO) Task {
+	d := &dynamicTaskSlice{t: []Task{}}
+	done := make(chan error)
+	rotateTask := newLeafTask(fmt.Sprintf("Rotating SSH key of %s in %s cluster", server, m.State().Name), func() error {
+		return m.RotateServerKey(server)
+	})
+	d.Append(&rotateTask)
+	rotateTask.OnDone(func(err error) {
+		if err != nil {
+			done <- err
+			return
+		}
+		_, err = repo.Do(func(fs soft.RepoFS) (string, error) {
+			if err := soft.WriteJson(fs, fmt.Sprintf("/clusters/%s/config.json", m.State().Name), m.State()); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("rotate %s key in cluster: %s", server, m.State().Name), nil
+		})
+		done <- err
+	})
+	start := func() error {
+		rotateTask.Start()
+		return <-done
+	}
+	t := newParentTask("Rotating server key", true, start, d)
+	return &t
+}
+
+func NewClusterSetServerPlacementTask(m cluster.Manager, server string, labels map[string]string, taints []cluster.Taint, repo soft.RepoIO) Task {
+	d := &dynamicTaskSlice{t: []Task{}}
+	done := make(chan error)
+	placementTask := newLeafTask(fmt.Sprintf("Updating labels and taints of %s in %s cluster", server, m.State().Name), func() error {
+		return m.SetServerPlacement(server, labels, taints)
+	})
+	d.Append(&placementTask)
+	placementTask.OnDone(func(err error) {
+		if err != nil {
+			done <- err
+			return
+		}
+		_, err = repo.Do(func(fs soft.RepoFS) (string, error) {
+			if err := soft.WriteJson(fs, fmt.Sprintf("/clusters/%s/config.json", m.State().Name), m.State()); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("update %s placement in cluster: %s", server, m.State().Name), nil
+		})
+		done <- err
+	})
+	start := func() error {
+		placementTask.Start()
+		return <-done
+	}
+	t := newParentTask("Updating server placement", true, start, d)
+	return &t
+}
+
+func NewClusterSnapshotTask(m cluster.Manager, store cluster.SnapshotStore) Task {
+	t := newLeafTask(fmt.Sprintf("Taking snapshot of %s cluster", m.State().Name), func() error {
+		_, err := m.Snapshot(store)
+		return err
+	})
+	return &t
+}
+
+func NewClusterRestoreTask(m cluster.Manager, server cluster.Server, snapshot string, store cluster.SnapshotStore, repo soft.RepoIO) Task {
+	d := &dynamicTaskSlice{t: []Task{}}
+	done := make(chan error)
+	restoreTask := newLeafTask(fmt.Sprintf("Restoring %s cluster from %s on %s", m.State().Name, snapshot, server.IP.String()), func() error {
+		return m.Restore(server, snapshot, store)
+	})
+	d.Append(&restoreTask)
+	restoreTask.OnDone(func(err error) {
+		if err != nil {
+			done <- err
+			return
+		}
+		_, err = repo.Do(func(fs soft.RepoFS) (string, error) {
+			if err := soft.WriteJson(fs, fmt.Sprintf("/clusters/%s/config.json", m.State().Name), m.State()); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("restore cluster %s from snapshot: %s", m.State().Name, snapshot), nil
+		})
+		done <- err
+	})
+	start := func() error {
+		restoreTask.Start()
+		return <-done
+	}
+	t := newParentTask("Restoring cluster", true, start, d)
+	return &t
+}
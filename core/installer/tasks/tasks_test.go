@@ -111,3 +111,116 @@ func TestConcurrentTaskSucceeds_WaitsForAllChildren(t *testing.T) {
 		t.Fatalf("Expected 2, got %d", cnt)
 	}
 }
+
+func TestGraphRunsAfterDependencies(t *testing.T) {
+	var m sync.Mutex
+	var order []string
+	record := func(name string) func() error {
+		return func() error {
+			m.Lock()
+			defer m.Unlock()
+			order = append(order, name)
+			return nil
+		}
+	}
+	one := newLeafTask("one", record("one"))
+	two := newLeafTask("two", record("two"))
+	three := newLeafTask("three", record("three"))
+	g := newTaskGraph()
+	g.Add(&three, &one, &two)
+	g.Add(&one)
+	g.Add(&two, &one)
+	l := newGraphParentTask("parent", true, g)
+	done := make(chan error)
+	l.OnDone(func(err error) {
+		done <- err
+	})
+	go l.Start()
+	if err := <-done; err != nil {
+		t.Fatalf("Expected nil, got %s", err.Error())
+	}
+	if len(order) != 3 || order[0] != "one" || order[1] != "two" || order[2] != "three" {
+		t.Fatalf("Unexpected order: %v", order)
+	}
+	path := l.CriticalPath()
+	if len(path) != 3 || path[0] != &one || path[1] != &two || path[2] != &three {
+		t.Fatalf("Unexpected critical path: %s", g.formatCriticalPath())
+	}
+}
+
+func TestGraphRunsIndependentTasksConcurrently(t *testing.T) {
+	slow := newLeafTask("slow", func() error {
+		time.Sleep(1 * time.Second)
+		return nil
+	})
+	fast := newLeafTask("fast", func() error {
+		return nil
+	})
+	after := newLeafTask("after", func() error {
+		return nil
+	})
+	g := newTaskGraph()
+	g.Add(&slow)
+	g.Add(&fast)
+	g.Add(&after, &fast)
+	l := newGraphParentTask("parent", true, g)
+	done := make(chan error)
+	l.OnDone(func(err error) {
+		done <- err
+	})
+	go l.Start()
+	if err := <-done; err != nil {
+		t.Fatalf("Expected nil, got %s", err.Error())
+	}
+	path := l.CriticalPath()
+	if len(path) != 1 || path[0] != &slow {
+		t.Fatalf("Unexpected critical path: %s", g.formatCriticalPath())
+	}
+}
+
+func TestGraphFailsDependent(t *testing.T) {
+	one := newLeafTask("one", func() error {
+		return fmt.Errorf("one")
+	})
+	ran := false
+	two := newLeafTask("two", func() error {
+		ran = true
+		return nil
+	})
+	g := newTaskGraph()
+	g.Add(&one)
+	g.Add(&two, &one)
+	l := newGraphParentTask("parent", true, g)
+	done := make(chan error)
+	l.OnDone(func(err error) {
+		done <- err
+	})
+	go l.Start()
+	if err := <-done; err == nil || err.Error() != "one" {
+		t.Fatalf("Expected one, got %s", err)
+	}
+	if ran {
+		t.Fatal("Expected two not to run")
+	}
+}
+
+func TestGraphDetectsCycle(t *testing.T) {
+	one := newLeafTask("one", func() error {
+		return nil
+	})
+	two := newLeafTask("two", func() error {
+		return nil
+	})
+	g := newTaskGraph()
+	g.Add(&one, &two)
+	g.Add(&two, &one)
+	l := newGraphParentTask("parent", true, g)
+	done := make(chan error)
+	l.OnDone(func(err error) {
+		done <- err
+	})
+	go l.Start()
+	if err := <-done; err == nil {
+		t.Fatal("Expected error")
+	}
+}
@@ -7,6 +7,9 @@ input: {
 	repoAddr: string @name(Repository Address)
 	sshPrivateKey: string @name(SSH Private Key)
 	authGroups: string @name(Allowed Groups)
+	approverGroups: string | *"" @name(Change Request Approver Groups)
+	clusterProxyAPIAddr: string | *"" @name(Cluster Proxy API Address)
+	allowedSigners: string | *"" @name(Allowed Commit Signers)
 }
 
 name: "App Manager"
@@ -54,6 +57,8 @@ out: {
 		}
 	}
 
+	volumes: backups: size: "10Gi"
+
 	charts: {
 		appmanager: {
 			kind: "GitRepository"
@@ -73,6 +78,16 @@ out: {
 				headscaleAPIAddr: "http://headscale-api.\(global.namespacePrefix)app-headscale.svc.cluster.local"
 				dnsAPIAddr: "http://dns-api.\(global.namespacePrefix)dns.svc.cluster.local"
 				clusterProxyConfigPath: "/apps/private-network/resources/proxy-backend-config.yaml"
+				if global.clusterProxy {
+					clusterProxyAPIAddr: "http://cluster-proxy-api.\(global.namespacePrefix)ingress-private.svc.cluster.local"
+				}
+				if !global.clusterProxy {
+					clusterProxyAPIAddr: input.clusterProxyAPIAddr
+				}
+				approverGroups: input.approverGroups
+				allowedSigners: input.allowedSigners
+				backupsPersistentVolumeClaimName: volumes.backups.name
+				membershipsAddr: "http://memberships-api.\(global.namespacePrefix)core-auth-memberships.svc.cluster.local"
 				ingress: {
 					className: input.network.ingressClass
 					domain: _domain
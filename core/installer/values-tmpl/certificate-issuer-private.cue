@@ -30,6 +30,7 @@ out: {
 				config: {
 					createTXTAddr: "http://dns-api.\(global.id)-dns.svc.cluster.local/create-txt-record"
 					deleteTXTAddr: "http://dns-api.\(global.id)-dns.svc.cluster.local/delete-txt-record"
+					zone: networks.public.domain
 				}
 			}
 		}
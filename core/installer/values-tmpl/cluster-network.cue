@@ -1,5 +1,6 @@
 import (
 	// "encoding/base64"
+	"strings"
 )
 
 input: {
@@ -7,9 +8,25 @@ input: {
 	vpnUser: string
 	vpnProxyHostname: string
 	vpnAuthKey: string @role(VPNAuthKey) @usernameField(vpnUser)
+	// Comma separated TCP and UDP port mappings managed by the cluster proxy
+	// configurator, each in port:namespace/name:port format.
+	tcp: string | *""
+	udp: string | *""
 	// TODO(gio): support port allocator
 }
 
+_portMappings: {
+	#in: string
+	out: {
+		if #in != "" {
+			for m in strings.Split(#in, ",") {
+				let p = strings.SplitN(m, ":", 2)
+				"\(p[0])": p[1]
+			}
+		}
+	}
+}
+
 name: "Cluster Network"
 namespace: "cluster-network"
 
@@ -79,6 +96,8 @@ out: {
 			}]
 			values: {
 				fullnameOverride: _fullnameOverride
+				tcp: (_portMappings & {#in: input.tcp}).out
+				udp: (_portMappings & {#in: input.udp}).out
 				controller: {
 					service: enabled: false
 					ingressClassByName: true
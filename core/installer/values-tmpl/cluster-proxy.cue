@@ -0,0 +1,55 @@
+import (
+	"strconv"
+	"strings"
+)
+
+input: {
+	// Comma separated stream ports exposed by the proxy service, each in
+	// protocol:port format. Managed by the cluster proxy configurator.
+	streamPorts: string | *""
+}
+
+name: "cluster-proxy"
+namespace: "ingress-private"
+
+out: {
+	images: {
+		clusterProxy: {
+			repository: "giolekva"
+			name: "cluster-proxy"
+			tag: "latest"
+			pullPolicy: "Always"
+		}
+	}
+
+	charts: {
+		clusterProxy: {
+			kind: "GitRepository"
+			address: "https://code.v1.dodo.cloud/helm-charts"
+			branch: "main"
+			path: "charts/cluster-proxy"
+		}
+	}
+
+	helm: {
+		"cluster-proxy": {
+			chart: charts.clusterProxy
+			values: {
+				image: {
+					repository: images.clusterProxy.fullName
+					tag: images.clusterProxy.tag
+					pullPolicy: images.clusterProxy.pullPolicy
+				}
+				streamPorts: [
+					if input.streamPorts != ""
+					for p in strings.Split(input.streamPorts, ",") {
+						let x = strings.SplitN(p, ":", 2)
+						name: "\(x[0])-\(x[1])"
+						protocol: x[0]
+						port: strconv.Atoi(x[1])
+					},
+				]
+			}
+		}
+	}
+}
@@ -2,7 +2,9 @@ import (
 	"strings"
 )
 
-input: {}
+input: {
+	secondaryIP: string | *"" @name(Secondary Nameserver IPs)
+}
 
 name: "env-dns"
 namespace: "dns"
@@ -86,6 +88,9 @@ out: {
 				serviceType: "LoadBalancer"
 				service: {
 					name: "coredns"
+					// Preserves client addresses, which private view is
+					// matched against.
+					externalTrafficPolicy: "Local"
 					annotations: {
 						"metallb.universe.tf/loadBalancerIPs": global.network.dns
 					}
@@ -159,6 +164,11 @@ out: {
 				publicIP: strings.Join(global.publicIP, ",")
 				privateIP: global.network.ingress
 				nameserverIP: strings.Join(global.nameserverIP, ",")
+				views: {
+					privateCIDR: strings.Join(_privateCIDRs, ",")
+					privateDomain: global.privateDomain
+				}
+				transfer: secondaryIP: input.secondaryIP
 				service: type: "ClusterIP"
 				volume: {
 					claimName: volumes.data.name
@@ -212,3 +222,20 @@ out: {
 }
 
 _mountPath: "/pcloud"
+
+_ingressOctets: strings.Split(global.network.ingress, ".")
+
+// VPN, environment ingress and cluster networks, which are served private
+// view of the zone. In-cluster clients resolve the zone through dns-gateway,
+// so queries come from its pod address.
+_privateCIDRs: [
+	// Headscale allocates VPN addresses from this range.
+	"100.64.0.0/10",
+	"\(_ingressOctets[0]).\(_ingressOctets[1]).\(_ingressOctets[2]).0/24",
+	// Default k3s pod and service networks.
+	"10.42.0.0/16",
+	"10.43.0.0/16",
+	// Pod and service networks of the clusters set up by the installer.
+	"10.45.0.0/16",
+	"10.46.0.0/16",
+]
@@ -2,6 +2,7 @@ input: {
 	network: #Network
 	subdomain: string
 	ipSubnet: string
+	policyAdminGroups: string | *"admin" @name(ACL Policy Admin Groups)
 }
 
 name: "headscale"
@@ -10,8 +11,24 @@ icon: "<svg xmlns='http://www.w3.org/2000/svg' width='50' height='50' viewBox='0
 
 _domain: "\(input.subdomain).\(input.network.domain)"
 _oauth2ClientSecretName: "oauth2-client"
+_policySubdomain: "\(input.subdomain)-policy"
 
 out: {
+	ingress: {
+		policy: {
+			auth: {
+				enabled: true
+				groups: "\(input.policyAdminGroups)"
+			}
+			network: input.network
+			subdomain: _policySubdomain
+			service: {
+				name: "headscale-policy"
+				port: name: "http"
+			}
+		}
+	}
+
 	images: {
 		headscale: {
 			repository: "headscale"
@@ -84,8 +101,12 @@ out: {
 				}
 				api: {
 					port: 8585
+					policyPort: 8586
+					policyAdminGroups: input.policyAdminGroups
 					ipSubnet: input.ipSubnet
-					self: "http://headscale-api.\(release.namespace).svc.cluster/sync-users"
+					self: "http://headscale-api.\(release.namespace).svc.cluster.local"
+					fetchUsersAddr: "http://memberships-api.\(global.namespacePrefix)core-auth-memberships.svc.cluster.local/api/users"
+					fetchGroupsAddr: "http://memberships-api.\(global.namespacePrefix)core-auth-memberships.svc.cluster.local/api/groups"
 					image: {
 						repository: images.api.fullName
 						tag: images.api.tag
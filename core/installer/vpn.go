@@ -9,14 +9,20 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"strings"
 )
 
 type VPNAPIClient interface {
-	GenerateAuthKey(username string) (string, error)
+	// GenerateAuthKey generates reusable key for the user, nodes registered
+	// with it are tagged with given tags.
+	GenerateAuthKey(username string, tags []string) (string, error)
 	ExpireKey(username, key string) error
 	ExpireNode(username, node string) error
 	RemoveNode(username, node string) error
 	GetNodeIP(username, node string) (net.IP, error)
+	// RemoveTag deletes the app instance tag together with the ACL rules
+	// referring to it.
+	RemoveTag(tag string) error
 }
 
 type headscaleAPIClient struct {
@@ -31,8 +37,16 @@ func NewHeadscaleAPIClient(apiAddr string) VPNAPIClient {
 	}
 }
 
-func (g *headscaleAPIClient) GenerateAuthKey(username string) (string, error) {
-	resp, err := http.Post(fmt.Sprintf("%s/user/%s/preauthkey", g.apiAddr, username), "application/json", nil)
+type generateAuthKeyReq struct {
+	Tags []string `json:"tags,omitempty"`
+}
+
+func (g *headscaleAPIClient) GenerateAuthKey(username string, tags []string) (string, error) {
+	var req bytes.Buffer
+	if err := json.NewEncoder(&req).Encode(generateAuthKeyReq{tags}); err != nil {
+		return "", err
+	}
+	resp, err := http.Post(fmt.Sprintf("%s/user/%s/preauthkey", g.apiAddr, username), "application/json", &req)
 	if err != nil {
 		return "", err
 	}
@@ -44,6 +58,43 @@ func (g *headscaleAPIClient) GenerateAuthKey(username string) (string, error) {
 	return buf.String(), nil
 }
 
+func (g *headscaleAPIClient) RemoveTag(tag string) error {
+	addr, err := url.Parse(fmt.Sprintf("%s/tag/%s", g.apiAddr, url.PathEscape(tag)))
+	if err != nil {
+		return err
+	}
+	resp, err := g.c.Do(&http.Request{
+		URL:    addr,
+		Method: http.MethodDelete,
+		Body:   nil,
+	})
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var buf bytes.Buffer
+		io.Copy(&buf, resp.Body)
+		return errors.New(buf.String())
+	}
+	return nil
+}
+
+// AppInstanceVPNTag is the tag VPN nodes of the app instance are tagged with,
+// ACL policies refer to it to grant access to them.
+func AppInstanceVPNTag(appInstanceId string) string {
+	return fmt.Sprintf("app-%s", strings.ToLower(appInstanceId))
+}
+
+// taggedVPNAPIClient adds tags to all generated auth keys.
+type taggedVPNAPIClient struct {
+	VPNAPIClient
+	tags []string
+}
+
+func (c taggedVPNAPIClient) GenerateAuthKey(username string, tags []string) (string, error) {
+	return c.VPNAPIClient.GenerateAuthKey(username, append(tags, c.tags...))
+}
+
 type expirePreAuthKeyReq struct {
 	AuthKey string `json:"authKey"`
 }
@@ -11,6 +11,8 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -47,6 +49,19 @@ type AppManagerServer struct {
 	tasks        map[string]taskForward
 	ta           map[string]installer.EnvApp
 	tmpl         tmplts
+	cr           *ChangeRequestConfig
+	crm          soft.ChangeRequestManager
+	hm           *clusterHealthMonitor
+	snapshots    *ClusterSnapshotConfig
+}
+
+// ChangeRequestConfig makes AppManager propose installs and updates as change
+// requests instead of applying them right away. Change request has to be
+// approved by a member of one of the approver groups, other than the user who
+// has proposed it.
+type ChangeRequestConfig struct {
+	ApproverGroups  []string
+	MembershipsAddr string
 }
 
 type tmplts struct {
@@ -55,6 +70,8 @@ type tmplts struct {
 	allClusters *template.Template
 	cluster     *template.Template
 	task        *template.Template
+	changes     *template.Template
+	change      *template.Template
 }
 
 func parseTemplatesAppManager(fs embed.FS) (tmplts, error) {
@@ -89,7 +106,15 @@ func parseTemplatesAppManager(fs embed.FS) (tmplts, error) {
 	if err != nil {
 		return tmplts{}, err
 	}
-	return tmplts{index, app, allClusters, cluster, task}, nil
+	changes, err := parse("appmanager-tmpl/changes.html")
+	if err != nil {
+		return tmplts{}, err
+	}
+	change, err := parse("appmanager-tmpl/change.html")
+	if err != nil {
+		return tmplts{}, err
+	}
+	return tmplts{index, app, allClusters, cluster, task, changes, change}, nil
 }
 
 func NewAppManagerServer(
@@ -102,12 +127,22 @@ func NewAppManagerServer(
 	h installer.HelmReleaseMonitor,
 	cnc installer.ClusterNetworkConfigurator,
 	vpnAPIClient installer.VPNAPIClient,
+	cr *ChangeRequestConfig,
+	health *ClusterHealthConfig,
+	snapshots *ClusterSnapshotConfig,
 ) (*AppManagerServer, error) {
 	tmpl, err := parseTemplatesAppManager(appTmpls)
 	if err != nil {
 		return nil, err
 	}
-	return &AppManagerServer{
+	var crm soft.ChangeRequestManager
+	if cr != nil {
+		crm, err = soft.NewChangeRequestManager(repo)
+		if err != nil {
+			return nil, err
+		}
+	}
+	ret := &AppManagerServer{
 		l:            &sync.Mutex{},
 		port:         port,
 		repo:         repo,
@@ -121,7 +156,14 @@ func NewAppManagerServer(
 		tasks:        make(map[string]taskForward),
 		ta:           make(map[string]installer.EnvApp),
 		tmpl:         tmpl,
-	}, nil
+		cr:           cr,
+		crm:          crm,
+		snapshots:    snapshots,
+	}
+	if health != nil {
+		ret.hm = newClusterHealthMonitor(*health, repo, m, ret.clusterTaskInProgress)
+	}
+	return ret, nil
 }
 
 type cachingHandler struct {
@@ -138,8 +180,11 @@ func (s *AppManagerServer) Start() error {
 	r.PathPrefix("/stat/").Handler(cachingHandler{http.FileServer(http.FS(statAssets))})
 	r.HandleFunc("/api/networks", s.handleNetworks).Methods(http.MethodGet)
 	r.HandleFunc("/api/clusters", s.handleClusters).Methods(http.MethodGet)
+	r.HandleFunc("/api/dns/orphans", s.handleDNSOrphans).Methods(http.MethodGet)
 	r.HandleFunc("/api/proxy/add", s.handleProxyAdd).Methods(http.MethodPost)
 	r.HandleFunc("/api/proxy/remove", s.handleProxyRemove).Methods(http.MethodPost)
+	r.HandleFunc("/api/proxy/stream/add", s.handleStreamProxyAdd).Methods(http.MethodPost)
+	r.HandleFunc("/api/proxy/stream/remove", s.handleStreamProxyRemove).Methods(http.MethodPost)
 	r.HandleFunc("/api/app-repo", s.handleAppRepo)
 	r.HandleFunc("/api/app/{slug}/install", s.handleAppInstall).Methods(http.MethodPost)
 	r.HandleFunc("/api/app/{slug}", s.handleApp).Methods(http.MethodGet)
@@ -147,17 +192,35 @@ func (s *AppManagerServer) Start() error {
 	r.HandleFunc("/api/instance/{slug}/update", s.handleAppUpdate).Methods(http.MethodPost)
 	r.HandleFunc("/api/instance/{slug}/remove", s.handleAppRemove).Methods(http.MethodPost)
 	r.HandleFunc("/clusters/{cluster}/servers/{server}/remove", s.handleClusterRemoveServer).Methods(http.MethodPost)
+	r.HandleFunc("/clusters/{cluster}/servers/{server}/rotate-key", s.handleClusterRotateServerKey).Methods(http.MethodPost)
+	r.HandleFunc("/clusters/{cluster}/servers/{server}/placement", s.handleClusterSetServerPlacement).Methods(http.MethodPost)
 	r.HandleFunc("/clusters/{cluster}/servers", s.handleClusterAddServer).Methods(http.MethodPost)
 	r.HandleFunc("/clusters/{name}", s.handleCluster).Methods(http.MethodGet)
 	r.HandleFunc("/clusters/{name}/setup-storage", s.handleClusterSetupStorage).Methods(http.MethodPost)
+	r.HandleFunc("/clusters/{name}/upgrade", s.handleClusterUpgrade).Methods(http.MethodPost)
+	r.HandleFunc("/clusters/{name}/import", s.handleClusterImport).Methods(http.MethodPost)
+	r.HandleFunc("/clusters/{name}/snapshots", s.handleClusterSnapshot).Methods(http.MethodPost)
+	r.HandleFunc("/clusters/{name}/snapshots/{snapshot}/restore", s.handleClusterRestore).Methods(http.MethodPost)
+	r.HandleFunc("/clusters/{name}/fallback-ingress-ips", s.handleClusterAddFallbackIngressIP).Methods(http.MethodPost)
+	r.HandleFunc("/clusters/{name}/fallback-ingress-ips/{ip}/remove", s.handleClusterRemoveFallbackIngressIP).Methods(http.MethodPost)
 	r.HandleFunc("/clusters/{name}/remove", s.handleRemoveCluster).Methods(http.MethodPost)
 	r.HandleFunc("/clusters", s.handleAllClusters).Methods(http.MethodGet)
 	r.HandleFunc("/clusters", s.handleCreateCluster).Methods(http.MethodPost)
 	r.HandleFunc("/app/{slug}", s.handleAppUI).Methods(http.MethodGet)
 	r.HandleFunc("/instance/{slug}", s.handleInstanceUI).Methods(http.MethodGet)
 	r.HandleFunc("/tasks/{slug}", s.handleTaskStatus).Methods(http.MethodGet)
+	r.HandleFunc("/changes/{id}/merge", s.handleChangeMerge).Methods(http.MethodPost)
+	r.HandleFunc("/changes/{id}/reject", s.handleChangeReject).Methods(http.MethodPost)
+	r.HandleFunc("/changes/{id}", s.handleChange).Methods(http.MethodGet)
+	r.HandleFunc("/changes", s.handleChanges).Methods(http.MethodGet)
 	r.HandleFunc("/{pageType}", s.handleAppsList).Methods(http.MethodGet)
 	r.HandleFunc("/", s.handleAppsList).Methods(http.MethodGet)
+	if s.hm != nil {
+		go s.hm.Start()
+	}
+	if s.snapshots != nil && s.snapshots.Interval > 0 {
+		go snapshotClusters(*s.snapshots, s.m, s.clusterTaskInProgress)
+	}
 	fmt.Printf("Starting HTTP server on port: %d\n", s.port)
 	return http.ListenAndServe(fmt.Sprintf(":%d", s.port), r)
 }
@@ -191,6 +254,20 @@ func (s *AppManagerServer) handleClusters(w http.ResponseWriter, r *http.Request
 	}
 }
 
+// handleDNSOrphans reports records left behind by removed app instances and
+// clusters.
+func (s *AppManagerServer) handleDNSOrphans(w http.ResponseWriter, r *http.Request) {
+	records, err := s.m.OrphanedDNSRecords()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(records); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
 type proxyPair struct {
 	From string `json:"from"`
 	To   string `json:"to"`
@@ -220,6 +297,30 @@ func (s *AppManagerServer) handleProxyRemove(w http.ResponseWriter, r *http.Requ
 	}
 }
 
+func (s *AppManagerServer) handleStreamProxyAdd(w http.ResponseWriter, r *http.Request) {
+	var req installer.StreamProxy
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.cnc.AddStreamProxy(req); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *AppManagerServer) handleStreamProxyRemove(w http.ResponseWriter, r *http.Request) {
+	var req installer.StreamProxy
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.cnc.RemoveStreamProxy(req); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
 type app struct {
 	Name             string                        `json:"name"`
 	Icon             template.HTML                 `json:"icon"`
@@ -333,6 +434,16 @@ func (s *AppManagerServer) handleAppInstall(w http.ResponseWriter, r *http.Reque
 	instanceId := a.Slug() + suffix
 	appDir := fmt.Sprintf("/apps/%s", instanceId)
 	namespace := fmt.Sprintf("%s%s%s", env.NamespacePrefix, a.Namespace(), suffix)
+	if s.crm != nil {
+		if _, err := s.m.Install(a, instanceId, appDir, namespace, values, s.proposeOptions(r, instanceId)...); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if _, err := fmt.Fprintf(w, "/changes/%s", instanceId); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
 	t := tasks.NewInstallTask(s.h, func() (installer.ReleaseResources, error) {
 		rr, err := s.m.Install(a, instanceId, appDir, namespace, values)
 		if err == nil {
@@ -384,6 +495,22 @@ func (s *AppManagerServer) handleAppUpdate(w http.ResponseWriter, r *http.Reques
 		http.Error(w, "Update already in progress", http.StatusBadRequest)
 		return
 	}
+	if s.crm != nil {
+		suffix, err := installer.NewFixedLengthRandomSuffixGenerator(3).Generate()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		id := fmt.Sprintf("%s-update%s", slug, suffix)
+		if _, err := s.m.Update(slug, values, s.proposeOptions(r, id)...); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if _, err := fmt.Fprintf(w, "/changes/%s", id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
 	rr, err := s.m.Update(slug, values)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -630,8 +757,11 @@ func (s *AppManagerServer) handleTaskStatus(w http.ResponseWriter, r *http.Reque
 }
 
 type clustersData struct {
-	CurrentPage string
-	Clusters    []cluster.State
+	CurrentPage   string
+	Clusters      []cluster.State
+	Distributions []string
+	Degraded      map[string]bool
+	Events        []clusterHealthEvent
 }
 
 func (s *AppManagerServer) handleAllClusters(w http.ResponseWriter, r *http.Request) {
@@ -640,9 +770,23 @@ func (s *AppManagerServer) handleAllClusters(w http.ResponseWriter, r *http.Requ
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	degraded := map[string]bool{}
+	var events []clusterHealthEvent
+	if s.hm != nil {
+		for _, c := range clusters {
+			if h, ok := s.hm.Get(c.Name); ok {
+				degraded[c.Name] = h.Degraded()
+			}
+		}
+		events = s.hm.Events()
+		slices.Reverse(events)
+	}
 	data := clustersData{
 		"clusters",
 		clusters,
+		cluster.ListDistributions(),
+		degraded,
+		events,
 	}
 	if err := s.tmpl.allClusters.Execute(w, data); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -653,6 +797,10 @@ func (s *AppManagerServer) handleAllClusters(w http.ResponseWriter, r *http.Requ
 type clusterData struct {
 	CurrentPage string
 	Cluster     cluster.State
+	Health      *cluster.Health
+	// SnapshotsEnabled is false when no snapshot store is configured.
+	SnapshotsEnabled bool
+	Snapshots        []cluster.Snapshot
 }
 
 func (s *AppManagerServer) handleCluster(w http.ResponseWriter, r *http.Request) {
@@ -670,9 +818,20 @@ func (s *AppManagerServer) handleCluster(w http.ResponseWriter, r *http.Request)
 		}
 		return
 	}
+	var snapshots []cluster.Snapshot
+	if s.snapshots != nil {
+		snapshots, err = s.snapshots.Store.List(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
 	data := clusterData{
 		"clusters",
 		m.State(),
+		s.getClusterHealth(name),
+		s.snapshots != nil,
+		snapshots,
 	}
 	if err := s.tmpl.cluster.Execute(w, data); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -713,6 +872,55 @@ func (s *AppManagerServer) handleClusterSetupStorage(w http.ResponseWriter, r *h
 	http.Redirect(w, r, fmt.Sprintf("/tasks/%s", cName), http.StatusSeeOther)
 }
 
+func (s *AppManagerServer) handleClusterUpgrade(w http.ResponseWriter, r *http.Request) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	cName, ok := mux.Vars(r)["name"]
+	if !ok {
+		http.Error(w, "empty name", http.StatusBadRequest)
+		return
+	}
+	if _, ok := s.tasks[cName]; ok {
+		http.Error(w, "cluster task in progress", http.StatusLocked)
+		return
+	}
+	version := strings.TrimSpace(r.PostFormValue("version"))
+	if version == "" {
+		http.Error(w, "no version", http.StatusBadRequest)
+		return
+	}
+	m, err := s.getClusterManager(cName)
+	if err != nil {
+		if errors.Is(err, installer.ErrorNotFound) {
+			http.Error(w, "not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	dist, err := cluster.GetDistribution(m.State().Distribution)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := dist.ValidateVersion(version); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	task := tasks.NewClusterUpgradeTask(m, version, s.repo)
+	task.OnDone(func(err error) {
+		go func() {
+			time.Sleep(30 * time.Second)
+			s.l.Lock()
+			defer s.l.Unlock()
+			delete(s.tasks, cName)
+		}()
+	})
+	go task.Start()
+	s.tasks[cName] = taskForward{task, fmt.Sprintf("/clusters/%s", cName)}
+	http.Redirect(w, r, fmt.Sprintf("/tasks/%s", cName), http.StatusSeeOther)
+}
+
 func (s *AppManagerServer) handleClusterRemoveServer(w http.ResponseWriter, r *http.Request) {
 	s.l.Lock()
 	defer s.l.Unlock()
@@ -753,6 +961,123 @@ func (s *AppManagerServer) handleClusterRemoveServer(w http.ResponseWriter, r *h
 	http.Redirect(w, r, fmt.Sprintf("/tasks/%s", cName), http.StatusSeeOther)
 }
 
+func (s *AppManagerServer) handleClusterRotateServerKey(w http.ResponseWriter, r *http.Request) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	cName, ok := mux.Vars(r)["cluster"]
+	if !ok {
+		http.Error(w, "empty name", http.StatusBadRequest)
+		return
+	}
+	if _, ok := s.tasks[cName]; ok {
+		http.Error(w, "cluster task in progress", http.StatusLocked)
+		return
+	}
+	sName, ok := mux.Vars(r)["server"]
+	if !ok {
+		http.Error(w, "empty name", http.StatusBadRequest)
+		return
+	}
+	m, err := s.getClusterManager(cName)
+	if err != nil {
+		if errors.Is(err, installer.ErrorNotFound) {
+			http.Error(w, "not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	task := tasks.NewClusterRotateServerKeyTask(m, sName, s.repo)
+	task.OnDone(func(err error) {
+		go func() {
+			time.Sleep(30 * time.Second)
+			s.l.Lock()
+			defer s.l.Unlock()
+			delete(s.tasks, cName)
+		}()
+	})
+	go task.Start()
+	s.tasks[cName] = taskForward{task, fmt.Sprintf("/clusters/%s", cName)}
+	http.Redirect(w, r, fmt.Sprintf("/tasks/%s", cName), http.StatusSeeOther)
+}
+
+func (s *AppManagerServer) handleClusterSetServerPlacement(w http.ResponseWriter, r *http.Request) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	cName, ok := mux.Vars(r)["cluster"]
+	if !ok {
+		http.Error(w, "empty name", http.StatusBadRequest)
+		return
+	}
+	if _, ok := s.tasks[cName]; ok {
+		http.Error(w, "cluster task in progress", http.StatusLocked)
+		return
+	}
+	sName, ok := mux.Vars(r)["server"]
+	if !ok {
+		http.Error(w, "empty name", http.StatusBadRequest)
+		return
+	}
+	labels, err := cluster.ParseLabels(r.PostFormValue("labels"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	taints, err := cluster.ParseTaints(r.PostFormValue("taints"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m, err := s.getClusterManager(cName)
+	if err != nil {
+		if errors.Is(err, installer.ErrorNotFound) {
+			http.Error(w, "not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if m.State().Imported {
+		http.Error(w, cluster.ErrorNotManaged.Error(), http.StatusBadRequest)
+		return
+	}
+	task := tasks.NewClusterSetServerPlacementTask(m, sName, labels, taints, s.repo)
+	task.OnDone(func(err error) {
+		go func() {
+			time.Sleep(30 * time.Second)
+			s.l.Lock()
+			defer s.l.Unlock()
+			delete(s.tasks, cName)
+		}()
+	})
+	go task.Start()
+	s.tasks[cName] = taskForward{task, fmt.Sprintf("/clusters/%s", cName)}
+	http.Redirect(w, r, fmt.Sprintf("/tasks/%s", cName), http.StatusSeeOther)
+}
+
+func (s *AppManagerServer) clusterTaskInProgress(name string) bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+	_, ok := s.tasks[name]
+	return ok
+}
+
+// getClusterHealth returns latest health check results of the given cluster,
+// falling back to the last persisted ones when monitor has not checked it yet.
+func (s *AppManagerServer) getClusterHealth(name string) *cluster.Health {
+	if s.hm == nil {
+		return nil
+	}
+	if h, ok := s.hm.Get(name); ok {
+		return &h
+	}
+	var h cluster.Health
+	if err := soft.ReadJson(s.repo, s.hm.healthPath(name), &h); err != nil {
+		return nil
+	}
+	return &h
+}
+
 func (s *AppManagerServer) getClusterManager(cName string) (cluster.Manager, error) {
 	clusters, err := s.m.GetClusters()
 	if err != nil {
@@ -792,7 +1117,21 @@ func (s *AppManagerServer) handleClusterAddServer(w http.ResponseWriter, r *http
 		}
 		return
 	}
+	if m.State().Imported {
+		http.Error(w, cluster.ErrorNotManaged.Error(), http.StatusBadRequest)
+		return
+	}
 	t := r.PostFormValue("type")
+	labels, err := cluster.ParseLabels(r.PostFormValue("labels"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	taints, err := cluster.ParseTaints(r.PostFormValue("taints"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	ip := net.ParseIP(strings.TrimSpace(r.PostFormValue("ip")))
 	if ip == nil {
 		http.Error(w, "invalid ip", http.StatusBadRequest)
@@ -811,6 +1150,9 @@ func (s *AppManagerServer) handleClusterAddServer(w http.ResponseWriter, r *http
 		Port:     port,
 		User:     r.PostFormValue("user"),
 		Password: r.PostFormValue("password"),
+		HostKey:  strings.TrimSpace(r.PostFormValue("host-key")),
+		Labels:   labels,
+		Taints:   taints,
 	}
 	var task tasks.Task
 	switch strings.ToLower(t) {
@@ -839,13 +1181,179 @@ func (s *AppManagerServer) handleClusterAddServer(w http.ResponseWriter, r *http
 	http.Redirect(w, r, fmt.Sprintf("/tasks/%s", cName), http.StatusSeeOther)
 }
 
+func (s *AppManagerServer) handleClusterImport(w http.ResponseWriter, r *http.Request) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	cName, ok := mux.Vars(r)["name"]
+	if !ok {
+		http.Error(w, "empty name", http.StatusBadRequest)
+		return
+	}
+	if _, ok := s.tasks[cName]; ok {
+		http.Error(w, "cluster task in progress", http.StatusLocked)
+		return
+	}
+	m, err := s.getClusterManager(cName)
+	if err != nil {
+		if errors.Is(err, installer.ErrorNotFound) {
+			http.Error(w, "not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if m.State().Kubeconfig != "" {
+		http.Error(w, "already initialized", http.StatusBadRequest)
+		return
+	}
+	kubeconfig := strings.TrimSpace(r.PostFormValue("kubeconfig"))
+	if kubeconfig == "" {
+		http.Error(w, "no kubeconfig", http.StatusBadRequest)
+		return
+	}
+	ingressClassName := strings.TrimSpace(r.PostFormValue("ingress-class"))
+	if ingressClassName == "" {
+		ingressClassName = "default"
+	}
+	task := tasks.NewClusterImportTask(m, kubeconfig, ingressClassName, s.cnc, s.repo, s.setupRemoteCluster())
+	task.OnDone(func(err error) {
+		go func() {
+			time.Sleep(30 * time.Second)
+			s.l.Lock()
+			defer s.l.Unlock()
+			delete(s.tasks, cName)
+		}()
+	})
+	go task.Start()
+	s.tasks[cName] = taskForward{task, fmt.Sprintf("/clusters/%s", cName)}
+	http.Redirect(w, r, fmt.Sprintf("/tasks/%s", cName), http.StatusSeeOther)
+}
+
+func (s *AppManagerServer) handleClusterSnapshot(w http.ResponseWriter, r *http.Request) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.snapshots == nil {
+		http.Error(w, "snapshots are not configured", http.StatusBadRequest)
+		return
+	}
+	cName, ok := mux.Vars(r)["name"]
+	if !ok {
+		http.Error(w, "empty name", http.StatusBadRequest)
+		return
+	}
+	if _, ok := s.tasks[cName]; ok {
+		http.Error(w, "cluster task in progress", http.StatusLocked)
+		return
+	}
+	m, err := s.getClusterManager(cName)
+	if err != nil {
+		if errors.Is(err, installer.ErrorNotFound) {
+			http.Error(w, "not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	task := tasks.NewClusterSnapshotTask(m, s.snapshots.Store)
+	task.OnDone(func(err error) {
+		if err == nil {
+			if err := pruneSnapshots(s.snapshots.Store, cName, s.snapshots.Keep); err != nil {
+				log.Printf("%s: could not prune snapshots: %s\n", cName, err)
+			}
+		}
+		go func() {
+			time.Sleep(30 * time.Second)
+			s.l.Lock()
+			defer s.l.Unlock()
+			delete(s.tasks, cName)
+		}()
+	})
+	go task.Start()
+	s.tasks[cName] = taskForward{task, fmt.Sprintf("/clusters/%s", cName)}
+	http.Redirect(w, r, fmt.Sprintf("/tasks/%s", cName), http.StatusSeeOther)
+}
+
+func (s *AppManagerServer) handleClusterRestore(w http.ResponseWriter, r *http.Request) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.snapshots == nil {
+		http.Error(w, "snapshots are not configured", http.StatusBadRequest)
+		return
+	}
+	cName, ok := mux.Vars(r)["name"]
+	if !ok {
+		http.Error(w, "empty name", http.StatusBadRequest)
+		return
+	}
+	snapshot, ok := mux.Vars(r)["snapshot"]
+	if !ok {
+		http.Error(w, "empty snapshot", http.StatusBadRequest)
+		return
+	}
+	if _, ok := s.tasks[cName]; ok {
+		http.Error(w, "cluster task in progress", http.StatusLocked)
+		return
+	}
+	m, err := s.getClusterManager(cName)
+	if err != nil {
+		if errors.Is(err, installer.ErrorNotFound) {
+			http.Error(w, "not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	ip := net.ParseIP(strings.TrimSpace(r.PostFormValue("ip")))
+	if ip == nil {
+		http.Error(w, "invalid ip", http.StatusBadRequest)
+		return
+	}
+	port := 22
+	if p := r.PostFormValue("port"); p != "" {
+		port, err = strconv.Atoi(p)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	// NOTE(gio): Credentials are ignored when restoring on one of the
+	// existing controllers.
+	server := cluster.Server{
+		IP:       ip,
+		Port:     port,
+		User:     r.PostFormValue("user"),
+		Password: r.PostFormValue("password"),
+		HostKey:  strings.TrimSpace(r.PostFormValue("host-key")),
+	}
+	task := tasks.NewClusterRestoreTask(m, server, snapshot, s.snapshots.Store, s.repo)
+	task.OnDone(func(err error) {
+		go func() {
+			time.Sleep(30 * time.Second)
+			s.l.Lock()
+			defer s.l.Unlock()
+			delete(s.tasks, cName)
+		}()
+	})
+	go task.Start()
+	s.tasks[cName] = taskForward{task, fmt.Sprintf("/clusters/%s", cName)}
+	http.Redirect(w, r, fmt.Sprintf("/tasks/%s", cName), http.StatusSeeOther)
+}
+
 func (s *AppManagerServer) handleCreateCluster(w http.ResponseWriter, r *http.Request) {
 	cName := r.PostFormValue("name")
 	if cName == "" {
 		http.Error(w, "no name", http.StatusBadRequest)
 		return
 	}
-	st := cluster.State{Name: cName}
+	distribution := r.PostFormValue("distribution")
+	if distribution == "" {
+		distribution = cluster.DefaultDistribution
+	}
+	if _, err := cluster.GetDistribution(distribution); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	st := cluster.State{Name: cName, Distribution: distribution}
 	if _, err := s.repo.Do(func(fs soft.RepoFS) (string, error) {
 		if err := soft.WriteJson(fs, fmt.Sprintf("/clusters/%s/config.json", cName), st); err != nil {
 			return "", err
@@ -891,6 +1399,63 @@ func (s *AppManagerServer) handleRemoveCluster(w http.ResponseWriter, r *http.Re
 	http.Redirect(w, r, fmt.Sprintf("/tasks/%s", cName), http.StatusSeeOther)
 }
 
+func (s *AppManagerServer) handleClusterAddFallbackIngressIP(w http.ResponseWriter, r *http.Request) {
+	ip := net.ParseIP(strings.TrimSpace(r.PostFormValue("ip")))
+	if ip == nil {
+		http.Error(w, "invalid ip", http.StatusBadRequest)
+		return
+	}
+	s.startFallbackIngressIPTask(w, r, func(m cluster.Manager) tasks.Task {
+		return tasks.NewClusterAddFallbackIngressIPTask(m, ip, s.cnc, s.repo)
+	})
+}
+
+func (s *AppManagerServer) handleClusterRemoveFallbackIngressIP(w http.ResponseWriter, r *http.Request) {
+	ip := net.ParseIP(mux.Vars(r)["ip"])
+	if ip == nil {
+		http.Error(w, "invalid ip", http.StatusBadRequest)
+		return
+	}
+	s.startFallbackIngressIPTask(w, r, func(m cluster.Manager) tasks.Task {
+		return tasks.NewClusterRemoveFallbackIngressIPTask(m, ip, s.cnc, s.repo)
+	})
+}
+
+func (s *AppManagerServer) startFallbackIngressIPTask(w http.ResponseWriter, r *http.Request, newTask func(m cluster.Manager) tasks.Task) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	cName, ok := mux.Vars(r)["name"]
+	if !ok {
+		http.Error(w, "empty name", http.StatusBadRequest)
+		return
+	}
+	if _, ok := s.tasks[cName]; ok {
+		http.Error(w, "cluster task in progress", http.StatusLocked)
+		return
+	}
+	m, err := s.getClusterManager(cName)
+	if err != nil {
+		if errors.Is(err, installer.ErrorNotFound) {
+			http.Error(w, "not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	task := newTask(m)
+	task.OnDone(func(err error) {
+		go func() {
+			time.Sleep(30 * time.Second)
+			s.l.Lock()
+			defer s.l.Unlock()
+			delete(s.tasks, cName)
+		}()
+	})
+	go task.Start()
+	s.tasks[cName] = taskForward{task, fmt.Sprintf("/clusters/%s", cName)}
+	http.Redirect(w, r, fmt.Sprintf("/tasks/%s", cName), http.StatusSeeOther)
+}
+
 func (s *AppManagerServer) setupRemoteCluster() cluster.ClusterIngressSetupFunc {
 	const vpnUser = "private-network-proxy"
 	return func(name, kubeconfig, ingressClassName string) (net.IP, error) {
@@ -983,3 +1548,199 @@ func (s *AppManagerServer) setupRemoteClusterStorage() cluster.ClusterSetupFunc
 		return nil
 	}
 }
+
+// proposeOptions commits changes to the change request branch on behalf of
+// the user making the request. Namespaces, ports and cluster proxies are only
+// created once the change gets merged.
+func (s *AppManagerServer) proposeOptions(r *http.Request, id string) []installer.InstallOption {
+	ret := []installer.InstallOption{installer.WithBranch(soft.ChangeRequestBranch(id))}
+	if user := r.Header.Get("X-Forwarded-User"); user != "" {
+		ret = append(ret, installer.WithAuthor(user))
+	}
+	return ret
+}
+
+type membershipInfo struct {
+	MemberOf []string `json:"memberOf"`
+}
+
+func (s *AppManagerServer) getUserGroups(user string) ([]string, error) {
+	resp, err := http.Get(fmt.Sprintf("%s/api/user/%s", s.cr.MembershipsAddr, url.PathEscape(user)))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("memberships error: %d", resp.StatusCode)
+	}
+	var info membershipInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	return info.MemberOf, nil
+}
+
+// checkApprover returns reason why given user can not approve the change
+// request, empty if they can.
+func (s *AppManagerServer) checkApprover(user string, cr soft.ChangeRequest) (string, error) {
+	if user == "" {
+		return "not logged in", nil
+	}
+	if user == cr.Author {
+		return "change must be approved by someone other than its author", nil
+	}
+	groups, err := s.getUserGroups(user)
+	if err != nil {
+		return "", err
+	}
+	for _, g := range groups {
+		for _, a := range s.cr.ApproverGroups {
+			if g == a {
+				return "", nil
+			}
+		}
+	}
+	return fmt.Sprintf("must be a member of one of: %s", strings.Join(s.cr.ApproverGroups, ", ")), nil
+}
+
+type changesData struct {
+	CurrentPage string
+	Enabled     bool
+	Changes     []soft.ChangeRequest
+}
+
+func (s *AppManagerServer) handleChanges(w http.ResponseWriter, r *http.Request) {
+	data := changesData{
+		CurrentPage: "changes",
+		Enabled:     s.crm != nil,
+	}
+	if s.crm != nil {
+		var err error
+		data.Changes, err = s.crm.List()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := s.tmpl.changes.Execute(w, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+type changeData struct {
+	CurrentPage   string
+	Change        soft.ChangeRequest
+	Diff          []string
+	CannotApprove string
+}
+
+func (s *AppManagerServer) getChangeRequest(w http.ResponseWriter, r *http.Request) (soft.ChangeRequest, bool) {
+	if s.crm == nil {
+		http.Error(w, "change requests are disabled", http.StatusNotFound)
+		return soft.ChangeRequest{}, false
+	}
+	id, ok := mux.Vars(r)["id"]
+	if !ok {
+		http.Error(w, "empty id", http.StatusBadRequest)
+		return soft.ChangeRequest{}, false
+	}
+	cr, err := s.crm.Get(id)
+	if err != nil {
+		if errors.Is(err, soft.ErrorChangeRequestNotFound) {
+			http.Error(w, "not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return soft.ChangeRequest{}, false
+	}
+	return cr, true
+}
+
+func (s *AppManagerServer) handleChange(w http.ResponseWriter, r *http.Request) {
+	cr, ok := s.getChangeRequest(w, r)
+	if !ok {
+		return
+	}
+	reason, err := s.checkApprover(r.Header.Get("X-Forwarded-User"), cr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data := changeData{
+		CurrentPage:   "changes",
+		Change:        cr,
+		Diff:          strings.Split(cr.Patch, "\n"),
+		CannotApprove: reason,
+	}
+	if err := s.tmpl.change.Execute(w, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *AppManagerServer) handleChangeMerge(w http.ResponseWriter, r *http.Request) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	cr, ok := s.getChangeRequest(w, r)
+	if !ok {
+		return
+	}
+	user := r.Header.Get("X-Forwarded-User")
+	if reason, err := s.checkApprover(user, cr); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if reason != "" {
+		http.Error(w, reason, http.StatusForbidden)
+		return
+	}
+	if _, err := s.crm.Merge(cr.Id, user); err != nil {
+		var conflict *soft.ConflictError
+		if errors.As(err, &conflict) {
+			http.Error(w, err.Error(), http.StatusConflict)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	err := s.m.ApplyMerged(cr.Paths)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	go func() {
+		defer cancel()
+		s.reconciler.Reconcile(ctx)
+	}()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("change has been merged, but could not be applied: %s", err), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/changes", http.StatusSeeOther)
+}
+
+func (s *AppManagerServer) handleChangeReject(w http.ResponseWriter, r *http.Request) {
+	cr, ok := s.getChangeRequest(w, r)
+	if !ok {
+		return
+	}
+	user := r.Header.Get("X-Forwarded-User")
+	// NOTE(gio): Authors can always withdraw their own change requests.
+	if user == "" || user != cr.Author {
+		if reason, err := s.checkApprover(user, cr); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		} else if reason != "" {
+			http.Error(w, reason, http.StatusForbidden)
+			return
+		}
+	}
+	if err := s.m.DiscardRejected(cr.Paths, func(path string) ([]byte, error) {
+		return s.crm.ReadFile(cr.Id, path)
+	}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := s.crm.Reject(cr.Id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/changes", http.StatusSeeOther)
+}
@@ -0,0 +1,214 @@
+package welcome
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/giolekva/pcloud/core/installer"
+	"github.com/giolekva/pcloud/core/installer/cluster"
+	"github.com/giolekva/pcloud/core/installer/soft"
+)
+
+const maxClusterHealthEvents = 50
+
+type ClusterHealthConfig struct {
+	Interval time.Duration
+	// WebhookAddr receives clusterHealthEvent as JSON encoded POST request
+	// body whenever cluster becomes degraded or recovers.
+	WebhookAddr string
+}
+
+type clusterHealthEvent struct {
+	Cluster  string    `json:"cluster"`
+	Time     time.Time `json:"time"`
+	Degraded bool      `json:"degraded"`
+	Problems []string  `json:"problems"`
+}
+
+type clusterHealthCheckFunc func(st cluster.State) (cluster.Health, error)
+
+// clusterHealthMonitor periodically checks health of remote clusters. Latest
+// results are kept in memory, while every change in the set of detected
+// problems is persisted next to the cluster configuration as health.json.
+type clusterHealthMonitor struct {
+	l      sync.Locker
+	cfg    ClusterHealthConfig
+	repo   soft.RepoIO
+	m      *installer.AppManager
+	check  clusterHealthCheckFunc
+	busy   func(name string) bool
+	health map[string]cluster.Health
+	events []clusterHealthEvent
+}
+
+func newClusterHealthMonitor(
+	cfg ClusterHealthConfig,
+	repo soft.RepoIO,
+	m *installer.AppManager,
+	busy func(name string) bool,
+) *clusterHealthMonitor {
+	return &clusterHealthMonitor{
+		l:    &sync.Mutex{},
+		cfg:  cfg,
+		repo: repo,
+		m:    m,
+		check: func(st cluster.State) (cluster.Health, error) {
+			m, err := cluster.RestoreKubeManager(st)
+			if err != nil {
+				return cluster.Health{}, err
+			}
+			return m.Health(), nil
+		},
+		busy:   busy,
+		health: map[string]cluster.Health{},
+	}
+}
+
+func (hm *clusterHealthMonitor) Start() {
+	for {
+		if err := hm.checkAll(); err != nil {
+			log.Printf("cluster health check failed: %s\n", err)
+		}
+		time.Sleep(hm.cfg.Interval)
+	}
+}
+
+func (hm *clusterHealthMonitor) Get(name string) (cluster.Health, bool) {
+	hm.l.Lock()
+	defer hm.l.Unlock()
+	h, ok := hm.health[name]
+	return h, ok
+}
+
+func (hm *clusterHealthMonitor) Events() []clusterHealthEvent {
+	hm.l.Lock()
+	defer hm.l.Unlock()
+	return slices.Clone(hm.events)
+}
+
+func (hm *clusterHealthMonitor) checkAll() error {
+	clusters, err := hm.m.GetClusters()
+	if err != nil {
+		return err
+	}
+	seen := map[string]struct{}{}
+	for _, c := range clusters {
+		// NOTE(gio): default cluster is the one appmanager runs in, hence no
+		// kubeconfig.
+		if c.Kubeconfig == "" {
+			continue
+		}
+		seen[c.Name] = struct{}{}
+		// NOTE(gio): Servers get drained and restarted while cluster tasks
+		// are running, reporting those would only be noise.
+		if hm.busy != nil && hm.busy(c.Name) {
+			continue
+		}
+		if err := hm.checkOne(c); err != nil {
+			log.Printf("%s: cluster health check failed: %s\n", c.Name, err)
+		}
+	}
+	hm.l.Lock()
+	defer hm.l.Unlock()
+	for name := range hm.health {
+		if _, ok := seen[name]; !ok {
+			delete(hm.health, name)
+		}
+	}
+	return nil
+}
+
+func (hm *clusterHealthMonitor) checkOne(c cluster.State) error {
+	h, err := hm.check(c)
+	if err != nil {
+		return err
+	}
+	prev, ok := hm.Get(c.Name)
+	if !ok {
+		if err := soft.ReadJson(hm.repo, hm.healthPath(c.Name), &prev); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	hm.l.Lock()
+	hm.health[c.Name] = h
+	hm.l.Unlock()
+	if slices.Equal(prev.Problems(), h.Problems()) {
+		return nil
+	}
+	if err := hm.persist(c.Name, h); err != nil {
+		return err
+	}
+	hm.notify(clusterHealthEvent{
+		Cluster:  c.Name,
+		Time:     h.Checked,
+		Degraded: h.Degraded(),
+		Problems: h.Problems(),
+	})
+	return nil
+}
+
+func (hm *clusterHealthMonitor) healthPath(name string) string {
+	return fmt.Sprintf("/clusters/%s/health.json", name)
+}
+
+func (hm *clusterHealthMonitor) persist(name string, h cluster.Health) error {
+	_, err := hm.repo.Do(func(r soft.RepoFS) (string, error) {
+		// Cluster might have been removed since the check has started.
+		if cfg, err := r.Reader(fmt.Sprintf("/clusters/%s/config.json", name)); err != nil {
+			return "", err
+		} else {
+			cfg.Close()
+		}
+		if err := soft.WriteJson(r, hm.healthPath(name), h); err != nil {
+			return "", err
+		}
+		status := "healthy"
+		if h.Degraded() {
+			status = "degraded"
+		}
+		return fmt.Sprintf("cluster %s is %s", name, status), nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (hm *clusterHealthMonitor) notify(e clusterHealthEvent) {
+	if e.Degraded {
+		log.Printf("%s: cluster is degraded: %v\n", e.Cluster, e.Problems)
+	} else {
+		log.Printf("%s: cluster has recovered\n", e.Cluster)
+	}
+	hm.l.Lock()
+	hm.events = append(hm.events, e)
+	if len(hm.events) > maxClusterHealthEvents {
+		hm.events = hm.events[len(hm.events)-maxClusterHealthEvents:]
+	}
+	hm.l.Unlock()
+	if hm.cfg.WebhookAddr == "" {
+		return
+	}
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(e); err != nil {
+		log.Printf("%s: could not encode cluster health event: %s\n", e.Cluster, err)
+		return
+	}
+	resp, err := http.Post(hm.cfg.WebhookAddr, "application/json", &buf)
+	if err != nil {
+		log.Printf("%s: could not deliver cluster health notification: %s\n", e.Cluster, err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("%s: cluster health webhook responded with %s\n", e.Cluster, resp.Status)
+	}
+}
@@ -0,0 +1,73 @@
+package welcome
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+
+	"github.com/giolekva/pcloud/core/installer"
+	"github.com/giolekva/pcloud/core/installer/cluster"
+	"github.com/giolekva/pcloud/core/installer/soft"
+)
+
+func TestClusterHealthMonitorNotifiesOnChange(t *testing.T) {
+	repo := soft.NewMockRepoIO(soft.NewBillyRepoFS(memfs.New()), "foo.bar", t)
+	if err := soft.WriteJson(repo, "/clusters/foo/config.json", cluster.State{Name: "foo", Kubeconfig: "kubeconfig"}); err != nil {
+		t.Fatal(err)
+	}
+	m, err := installer.NewAppManager(repo, nil, nil, nil, nil, nil, nil, "/apps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received []clusterHealthEvent
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e clusterHealthEvent
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		received = append(received, e)
+	}))
+	defer webhook.Close()
+	hm := newClusterHealthMonitor(ClusterHealthConfig{time.Minute, webhook.URL}, repo, m, nil)
+	healthy := cluster.Health{Nodes: []cluster.NodeHealth{{Name: "pi", Ready: true, Tailscale: true}}}
+	degraded := cluster.Health{Nodes: []cluster.NodeHealth{{Name: "pi", Tailscale: true, Problems: []string{"not ready"}}}}
+	for _, h := range []cluster.Health{healthy, degraded, degraded, healthy} {
+		hm.check = func(st cluster.State) (cluster.Health, error) {
+			if st.Name != "foo" {
+				t.Fatalf("Expected foo, got %s", st.Name)
+			}
+			return h, nil
+		}
+		if err := hm.checkAll(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	events := hm.Events()
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events, got %+v", events)
+	}
+	if !events[0].Degraded || len(events[0].Problems) != 1 || events[0].Problems[0] != "pi: not ready" {
+		t.Fatalf("Expected cluster to become degraded, got %+v", events[0])
+	}
+	if events[1].Degraded {
+		t.Fatalf("Expected cluster to recover, got %+v", events[1])
+	}
+	if len(received) != 2 {
+		t.Fatalf("Expected 2 notifications, got %+v", received)
+	}
+	var persisted cluster.Health
+	if err := soft.ReadJson(repo, "/clusters/foo/health.json", &persisted); err != nil {
+		t.Fatal(err)
+	}
+	if persisted.Degraded() {
+		t.Fatalf("Expected persisted health to be healthy, got %+v", persisted)
+	}
+	if h, ok := hm.Get("foo"); !ok || h.Degraded() {
+		t.Fatalf("Expected foo to be healthy, got %+v", h)
+	}
+}
@@ -0,0 +1,66 @@
+package welcome
+
+import (
+	"log"
+	"time"
+
+	"github.com/giolekva/pcloud/core/installer"
+	"github.com/giolekva/pcloud/core/installer/cluster"
+)
+
+type ClusterSnapshotConfig struct {
+	Store cluster.SnapshotStore
+	// Interval between scheduled snapshots, zero disables them while still
+	// allowing to take ones on demand.
+	Interval time.Duration
+	// Keep is the number of most recent snapshots retained per cluster.
+	Keep int
+}
+
+// snapshotClusters periodically takes snapshots of all managed clusters and
+// removes the ones exceeding retention.
+func snapshotClusters(cfg ClusterSnapshotConfig, m *installer.AppManager, busy func(name string) bool) {
+	for {
+		time.Sleep(cfg.Interval)
+		clusters, err := m.GetClusters()
+		if err != nil {
+			log.Printf("could not list clusters to snapshot: %s\n", err)
+			continue
+		}
+		for _, c := range clusters {
+			if c.Imported || len(c.Controllers) == 0 || busy(c.Name) {
+				continue
+			}
+			cm, err := cluster.RestoreKubeManager(c)
+			if err != nil {
+				log.Printf("%s: %s\n", c.Name, err)
+				continue
+			}
+			if s, err := cm.Snapshot(cfg.Store); err != nil {
+				log.Printf("%s: snapshot failed: %s\n", c.Name, err)
+				continue
+			} else {
+				log.Printf("%s: took snapshot %s\n", c.Name, s.Name)
+			}
+			if err := pruneSnapshots(cfg.Store, c.Name, cfg.Keep); err != nil {
+				log.Printf("%s: could not prune snapshots: %s\n", c.Name, err)
+			}
+		}
+	}
+}
+
+func pruneSnapshots(store cluster.SnapshotStore, name string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	snapshots, err := store.List(name)
+	if err != nil {
+		return err
+	}
+	for i := keep; i < len(snapshots); i++ {
+		if err := store.Remove(name, snapshots[i].Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
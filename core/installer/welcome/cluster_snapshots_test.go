@@ -0,0 +1,58 @@
+package welcome
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/giolekva/pcloud/core/installer/cluster"
+)
+
+func TestPruneSnapshots(t *testing.T) {
+	root := t.TempDir()
+	store := cluster.NewDirSnapshotStore(root)
+	now := time.Now()
+	for i := 0; i < 4; i++ {
+		name := fmt.Sprintf("k3s-%d", i)
+		w, err := store.Writer("foo", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, name); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		created := now.Add(time.Duration(i) * time.Hour)
+		if err := os.Chtimes(filepath.Join(root, "clusters", "foo", name), created, created); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pruneSnapshots(store, "foo", 2); err != nil {
+		t.Fatal(err)
+	}
+	snapshots, err := store.List("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 || snapshots[0].Name != "k3s-3" || snapshots[1].Name != "k3s-2" {
+		t.Fatalf("Expected two most recent snapshots, got %+v", snapshots)
+	}
+	r, err := store.Reader("foo", "k3s-3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if contents, err := io.ReadAll(r); err != nil {
+		t.Fatal(err)
+	} else if string(contents) != "k3s-3" {
+		t.Fatalf("Expected k3s-3, got %s", contents)
+	}
+	if _, err := store.Reader("foo", "../foo"); err == nil {
+		t.Fatal("Expected invalid snapshot name to be rejected")
+	}
+}
@@ -4,11 +4,15 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"log"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/libdns/gandi"
 	"github.com/libdns/libdns"
+
+	"github.com/giolekva/pcloud/core/installer"
 )
 
 type DNSUpdater interface {
@@ -46,17 +50,108 @@ func ParseRecords(zone string, records []string) ([]libdns.Record, error) {
 	return rrs, nil
 }
 
-type gandiUpdater struct {
-	provider libdns.RecordSetter
+// DNSProviderParam is a credential or setting user has to provide to let
+// updater change records at their DNS provider.
+type DNSProviderParam struct {
+	Name     string
+	Title    string
+	Optional bool
+}
+
+// DNSProvider describes DNS hosting provider records can be published to.
+type DNSProvider struct {
+	Name   string
+	Title  string
+	Params []DNSProviderParam
+	// newProvider creates libdns provider, all non-optional params are
+	// guaranteed to be set.
+	newProvider func(params map[string]string) (libdns.RecordSetter, error)
+}
+
+var dnsProviders = []DNSProvider{
+	{
+		Name:  "gandi",
+		Title: "Gandi",
+		Params: []DNSProviderParam{
+			{Name: "api-token", Title: "API Token"},
+		},
+		newProvider: func(params map[string]string) (libdns.RecordSetter, error) {
+			return &gandi.Provider{BearerToken: params["api-token"]}, nil
+		},
+	},
+	{
+		Name:  "cloudflare",
+		Title: "Cloudflare",
+		Params: []DNSProviderParam{
+			{Name: "api-token", Title: "API Token"},
+		},
+		newProvider: func(params map[string]string) (libdns.RecordSetter, error) {
+			return NewCloudflareProvider(params["api-token"]), nil
+		},
+	},
+	{
+		Name:  "desec",
+		Title: "deSEC",
+		Params: []DNSProviderParam{
+			{Name: "api-token", Title: "API Token"},
+		},
+		newProvider: func(params map[string]string) (libdns.RecordSetter, error) {
+			return NewDeSECProvider(params["api-token"]), nil
+		},
+	},
+	{
+		Name:  "rfc2136",
+		Title: "RFC 2136 (Dynamic DNS Update)",
+		Params: []DNSProviderParam{
+			{Name: "server", Title: "Primary Nameserver (host:port)"},
+			{Name: "tsig-key-name", Title: "TSIG Key Name"},
+			{Name: "tsig-algorithm", Title: "TSIG Algorithm (defaults to hmac-sha256)", Optional: true},
+			{Name: "tsig-secret", Title: "TSIG Secret"},
+		},
+		newProvider: func(params map[string]string) (libdns.RecordSetter, error) {
+			return NewRFC2136Provider(params["server"], params["tsig-key-name"], params["tsig-algorithm"], params["tsig-secret"])
+		},
+	},
+}
+
+// DNSProviders returns all providers records can be published to.
+func DNSProviders() []DNSProvider {
+	return dnsProviders
+}
+
+func findDNSProvider(name string) (DNSProvider, bool) {
+	for _, p := range dnsProviders {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return DNSProvider{}, false
 }
 
-func NewGandiUpdater(apiToken string) *gandiUpdater {
-	return &gandiUpdater{
-		provider: &gandi.Provider{BearerToken: apiToken},
+// NewDNSUpdater creates updater for the named provider configured with given
+// params.
+func NewDNSUpdater(provider string, params map[string]string) (DNSUpdater, error) {
+	p, ok := findDNSProvider(provider)
+	if !ok {
+		return nil, fmt.Errorf("unknown DNS provider: %s", provider)
+	}
+	for _, param := range p.Params {
+		if !param.Optional && params[param.Name] == "" {
+			return nil, fmt.Errorf("%s: %s is required", p.Title, param.Title)
+		}
 	}
+	rs, err := p.newProvider(params)
+	if err != nil {
+		return nil, err
+	}
+	return &libdnsUpdater{rs}, nil
 }
 
-func (u *gandiUpdater) Update(zone string, records []string) error {
+type libdnsUpdater struct {
+	provider libdns.RecordSetter
+}
+
+func (u *libdnsUpdater) Update(zone string, records []string) error {
 	if rrs, err := ParseRecords(zone, records); err != nil {
 		return err
 	} else {
@@ -65,3 +160,83 @@ func (u *gandiUpdater) Update(zone string, records []string) error {
 		return err
 	}
 }
+
+type recordSet struct {
+	Name    string
+	Type    string
+	Records []libdns.Record
+}
+
+// groupRecordSets groups records by name and type, providers replace records
+// set by set.
+func groupRecordSets(records []libdns.Record) []recordSet {
+	var ret []recordSet
+	idx := map[string]int{}
+	for _, r := range records {
+		name := r.Name
+		if name == "@" {
+			name = ""
+		}
+		k := fmt.Sprintf("%s/%s", strings.ToLower(name), strings.ToUpper(r.Type))
+		if i, ok := idx[k]; ok {
+			ret[i].Records = append(ret[i].Records, r)
+		} else {
+			idx[k] = len(ret)
+			ret = append(ret, recordSet{name, strings.ToUpper(r.Type), []libdns.Record{r}})
+		}
+	}
+	return ret
+}
+
+// dsSyncInterval is how often DS records of the environment zone are checked
+// for changes.
+const dsSyncInterval = time.Hour
+
+// dsRecords returns DS records out of the given records to publish, sorted so
+// that they can be compared.
+func dsRecords(records string) []string {
+	ret := []string{}
+	for _, r := range strings.Split(records, "\n") {
+		if f := strings.Fields(r); len(f) > 3 && strings.EqualFold(f[3], "DS") {
+			ret = append(ret, strings.TrimSpace(r))
+		}
+	}
+	slices.Sort(ret)
+	return ret
+}
+
+// dsSyncer publishes DS records of the environment zone to its parent every
+// time they change, which happens when environment DNS server rolls its key
+// signing key over. Old key is retired only once DS of the new one shows up
+// in the parent zone.
+type dsSyncer struct {
+	fetcher   installer.ZoneStatusFetcher
+	addr      string
+	updater   DNSUpdater
+	zone      string
+	published []string
+}
+
+func (s *dsSyncer) sync() error {
+	records, err := s.fetcher.Fetch(s.addr)
+	if err != nil {
+		return err
+	}
+	ds := dsRecords(records)
+	if len(ds) == 0 || slices.Equal(ds, s.published) {
+		return nil
+	}
+	if err := s.updater.Update(s.zone, ds); err != nil {
+		return err
+	}
+	s.published = ds
+	return nil
+}
+
+func (s *dsSyncer) Run(tick <-chan time.Time) {
+	for range tick {
+		if err := s.sync(); err != nil {
+			log.Printf("Could not publish DS records of %s: %s\n", s.addr, err)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package welcome
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/libdns/cloudflare"
+	"github.com/libdns/libdns"
+	"github.com/miekg/dns"
+)
+
+const cloudflareAPIAddr = "https://api.cloudflare.com/client/v4"
+
+// cloudflareProvider replaces record sets on top of libdns Cloudflare
+// provider, which updates records one by one and fails on sets with multiple
+// records, such as NS. Provider can not express DS records either, so these
+// are created through the API directly.
+type cloudflareProvider struct {
+	*cloudflare.Provider
+	apiAddr string
+	client  *http.Client
+}
+
+func NewCloudflareProvider(apiToken string) libdns.RecordSetter {
+	return newCloudflareProvider(cloudflareAPIAddr, apiToken)
+}
+
+func newCloudflareProvider(apiAddr, apiToken string) *cloudflareProvider {
+	return &cloudflareProvider{&cloudflare.Provider{APIToken: apiToken}, apiAddr, &http.Client{}}
+}
+
+type cloudflareError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type cloudflareResponse struct {
+	Success bool              `json:"success"`
+	Errors  []cloudflareError `json:"errors"`
+	Result  json.RawMessage   `json:"result"`
+}
+
+type cloudflareZone struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type cloudflareDSData struct {
+	KeyTag     uint16 `json:"key_tag"`
+	Algorithm  uint8  `json:"algorithm"`
+	DigestType uint8  `json:"digest_type"`
+	Digest     string `json:"digest"`
+}
+
+type cloudflareRecord struct {
+	ID      string            `json:"id,omitempty"`
+	Type    string            `json:"type"`
+	Name    string            `json:"name"`
+	Content string            `json:"content,omitempty"`
+	Data    *cloudflareDSData `json:"data,omitempty"`
+	TTL     int               `json:"ttl"`
+}
+
+// SetRecords replaces record sets with given records, other records of the
+// zone are left untouched. Missing records are created before stale ones get
+// deleted, so that delegation never disappears from the parent zone.
+func (p *cloudflareProvider) SetRecords(ctx context.Context, zone string, records []libdns.Record) ([]libdns.Record, error) {
+	zone = strings.TrimSuffix(zone, ".")
+	existing, err := p.GetRecords(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	var ret []libdns.Record
+	for _, set := range groupRecordSets(records) {
+		current := map[string]libdns.Record{}
+		for _, r := range existing {
+			if strings.EqualFold(r.Name, set.Name) && strings.EqualFold(r.Type, set.Type) {
+				current[cloudflareValue(r.Type, r.Value)] = r
+			}
+		}
+		keep := map[string]struct{}{}
+		for _, r := range set.Records {
+			v := cloudflareValue(set.Type, r.Value)
+			keep[v] = struct{}{}
+			if c, ok := current[v]; ok {
+				ret = append(ret, c)
+				continue
+			}
+			created, err := p.createRecord(ctx, zone, r)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, created)
+		}
+		var stale []libdns.Record
+		for v, r := range current {
+			if _, ok := keep[v]; !ok {
+				stale = append(stale, r)
+			}
+		}
+		if _, err := p.DeleteRecords(ctx, zone, stale); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+func (p *cloudflareProvider) createRecord(ctx context.Context, zone string, r libdns.Record) (libdns.Record, error) {
+	if r.Name == "" {
+		r.Name = "@"
+	}
+	if r.TTL < time.Minute {
+		// Automatic TTL
+		r.TTL = time.Second
+	}
+	switch strings.ToUpper(r.Type) {
+	case "NS", "CNAME":
+		r.Value = strings.TrimSuffix(r.Value, ".")
+	case "DS":
+		return p.createDS(ctx, zone, r)
+	}
+	created, err := p.AppendRecords(ctx, zone, []libdns.Record{r})
+	if err != nil {
+		return libdns.Record{}, err
+	}
+	return created[0], nil
+}
+
+func (p *cloudflareProvider) createDS(ctx context.Context, zone string, r libdns.Record) (libdns.Record, error) {
+	zoneID, err := p.zoneID(ctx, zone)
+	if err != nil {
+		return libdns.Record{}, err
+	}
+	req, err := toCloudflareRecord(zone, r)
+	if err != nil {
+		return libdns.Record{}, err
+	}
+	var created cloudflareRecord
+	if err := p.do(ctx, http.MethodPost, fmt.Sprintf("/zones/%s/dns_records", zoneID), req, &created); err != nil {
+		return libdns.Record{}, err
+	}
+	r.ID = created.ID
+	return r, nil
+}
+
+// cloudflareValue normalizes record value, so that the one returned by
+// Cloudflare can be compared with the requested one.
+func cloudflareValue(rrtype, value string) string {
+	switch strings.ToUpper(rrtype) {
+	case "NS", "CNAME":
+		return strings.ToLower(strings.TrimSuffix(value, "."))
+	case "DS":
+		return strings.ToUpper(strings.Join(strings.Fields(value), " "))
+	default:
+		return value
+	}
+}
+
+func (p *cloudflareProvider) zoneID(ctx context.Context, zone string) (string, error) {
+	var zones []cloudflareZone
+	q := url.Values{}
+	q.Set("name", strings.TrimSuffix(zone, "."))
+	if err := p.do(ctx, http.MethodGet, fmt.Sprintf("/zones?%s", q.Encode()), nil, &zones); err != nil {
+		return "", err
+	}
+	if len(zones) == 0 {
+		return "", fmt.Errorf("cloudflare: zone %s not found", zone)
+	}
+	return zones[0].ID, nil
+}
+
+func (p *cloudflareProvider) do(ctx context.Context, method, path string, body any, result any) error {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, p.apiAddr+path, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", p.APIToken))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var cr cloudflareResponse
+	if err := json.NewDecoder(resp.Body).Decode(&cr); err != nil {
+		return fmt.Errorf("cloudflare: %s %s: %s", method, path, resp.Status)
+	}
+	if !cr.Success {
+		var msgs []string
+		for _, e := range cr.Errors {
+			msgs = append(msgs, fmt.Sprintf("%d %s", e.Code, e.Message))
+		}
+		return fmt.Errorf("cloudflare: %s %s: %s", method, path, strings.Join(msgs, ", "))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(cr.Result, result)
+}
+
+func toCloudflareRecord(zone string, r libdns.Record) (cloudflareRecord, error) {
+	rr, err := dns.NewRR(fmt.Sprintf(". DS %s", r.Value))
+	if err != nil {
+		return cloudflareRecord{}, err
+	}
+	ds := rr.(*dns.DS)
+	return cloudflareRecord{
+		Type: "DS",
+		Name: strings.TrimSuffix(libdns.AbsoluteName(r.Name, zone), "."),
+		Data: &cloudflareDSData{ds.KeyTag, ds.Algorithm, ds.DigestType, ds.Digest},
+		TTL:  int(r.TTL / time.Second),
+	}, nil
+}
@@ -0,0 +1,80 @@
+package welcome
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/libdns/libdns"
+)
+
+const (
+	deSECAPIAddr = "https://desec.io/api/v1"
+	// deSEC rejects TTLs lower than that.
+	deSECMinTTL = 3600
+)
+
+type deSECProvider struct {
+	apiAddr  string
+	apiToken string
+	client   *http.Client
+}
+
+func NewDeSECProvider(apiToken string) libdns.RecordSetter {
+	return newDeSECProvider(deSECAPIAddr, apiToken)
+}
+
+func newDeSECProvider(apiAddr, apiToken string) *deSECProvider {
+	return &deSECProvider{apiAddr, apiToken, &http.Client{}}
+}
+
+type deSECRRSet struct {
+	Subname string   `json:"subname"`
+	Type    string   `json:"type"`
+	TTL     int      `json:"ttl"`
+	Records []string `json:"records"`
+}
+
+// SetRecords replaces record sets with given records in a single bulk
+// request, so either all of them are changed or none.
+func (p *deSECProvider) SetRecords(ctx context.Context, zone string, records []libdns.Record) ([]libdns.Record, error) {
+	var rrsets []deSECRRSet
+	for _, set := range groupRecordSets(records) {
+		rrset := deSECRRSet{set.Name, set.Type, deSECMinTTL, []string{}}
+		for _, r := range set.Records {
+			if ttl := int(r.TTL / time.Second); ttl > rrset.TTL {
+				rrset.TTL = ttl
+			}
+			rrset.Records = append(rrset.Records, r.Value)
+		}
+		rrsets = append(rrsets, rrset)
+	}
+	body, err := json.Marshal(rrsets)
+	if err != nil {
+		return nil, err
+	}
+	addr := fmt.Sprintf("%s/domains/%s/rrsets/", p.apiAddr, url.PathEscape(strings.TrimSuffix(zone, ".")))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, addr, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Token %s", p.apiToken))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var buf bytes.Buffer
+		io.Copy(&buf, resp.Body)
+		return nil, fmt.Errorf("desec: %s: %s", resp.Status, buf.String())
+	}
+	return records, nil
+}
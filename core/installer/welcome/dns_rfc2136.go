@@ -0,0 +1,75 @@
+package welcome
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/libdns/libdns"
+	"github.com/miekg/dns"
+)
+
+// rfc2136Provider publishes records to any primary nameserver accepting TSIG
+// signed dynamic updates, such as BIND, Knot or PowerDNS.
+type rfc2136Provider struct {
+	server    string
+	keyName   string
+	algorithm string
+	secret    string
+}
+
+func NewRFC2136Provider(server, keyName, algorithm, secret string) (libdns.RecordSetter, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	if algorithm == "" {
+		algorithm = dns.HmacSHA256
+	}
+	algorithm = dns.Fqdn(strings.ToLower(algorithm))
+	switch algorithm {
+	case dns.HmacSHA1, dns.HmacSHA224, dns.HmacSHA256, dns.HmacSHA384, dns.HmacSHA512:
+	default:
+		return nil, fmt.Errorf("unsupported TSIG algorithm: %s", algorithm)
+	}
+	if _, err := base64.StdEncoding.DecodeString(secret); err != nil {
+		return nil, fmt.Errorf("TSIG secret must be base64 encoded: %w", err)
+	}
+	return &rfc2136Provider{server, dns.Fqdn(keyName), algorithm, secret}, nil
+}
+
+// SetRecords replaces record sets with given records in a single UPDATE
+// message, which nameserver applies atomically.
+func (p *rfc2136Provider) SetRecords(ctx context.Context, zone string, records []libdns.Record) ([]libdns.Record, error) {
+	zone = dns.Fqdn(zone)
+	m := new(dns.Msg)
+	m.SetUpdate(zone)
+	for _, set := range groupRecordSets(records) {
+		name := dns.Fqdn(libdns.AbsoluteName(set.Name, zone))
+		m.RemoveRRset([]dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: name, Rrtype: dns.StringToType[set.Type]}}})
+		var rrs []dns.RR
+		for _, r := range set.Records {
+			rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", name, int(r.TTL/time.Second), set.Type, r.Value))
+			if err != nil {
+				return nil, err
+			}
+			rrs = append(rrs, rr)
+		}
+		m.Insert(rrs)
+	}
+	m.SetTsig(p.keyName, p.algorithm, 300, time.Now().Unix())
+	c := &dns.Client{
+		Net:        "tcp",
+		TsigSecret: map[string]string{p.keyName: p.secret},
+	}
+	resp, _, err := c.ExchangeContext(ctx, m, p.server)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return nil, fmt.Errorf("rfc2136: update rejected: %s", dns.RcodeToString[resp.Rcode])
+	}
+	return records, nil
+}
@@ -1,8 +1,21 @@
 package welcome
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/libdns/libdns"
+	"github.com/miekg/dns"
 )
 
 const rec = `
@@ -23,3 +36,353 @@ func TestParse(t *testing.T) {
 		t.Fatalf("Expected 5 records, got %d", len(recs))
 	}
 }
+
+type fakeRecordSetter struct {
+	zone    string
+	records []libdns.Record
+}
+
+func (f *fakeRecordSetter) SetRecords(ctx context.Context, zone string, records []libdns.Record) ([]libdns.Record, error) {
+	f.zone = zone
+	f.records = append(f.records, records...)
+	return records, nil
+}
+
+func TestDNSUpdater(t *testing.T) {
+	fake := &fakeRecordSetter{}
+	dnsProviders = append(dnsProviders, DNSProvider{
+		Name:   "fake",
+		Title:  "Fake",
+		Params: []DNSProviderParam{{Name: "api-token", Title: "API Token"}},
+		newProvider: func(params map[string]string) (libdns.RecordSetter, error) {
+			if params["api-token"] != "secret" {
+				t.Fatalf("Unexpected token: %s", params["api-token"])
+			}
+			return fake, nil
+		},
+	})
+	defer func() {
+		dnsProviders = dnsProviders[:len(dnsProviders)-1]
+	}()
+	if _, err := NewDNSUpdater("unknown", nil); err == nil {
+		t.Fatal("Expected unknown provider to be rejected")
+	}
+	if _, err := NewDNSUpdater("fake", map[string]string{}); err == nil {
+		t.Fatal("Expected missing api token to be rejected")
+	}
+	if _, err := NewDNSUpdater("rfc2136", map[string]string{"server": "127.0.0.1", "tsig-key-name": "k", "tsig-secret": "c2VjcmV0", "tsig-algorithm": "md5"}); err == nil {
+		t.Fatal("Expected unsupported algorithm to be rejected")
+	}
+	u, err := NewDNSUpdater("fake", map[string]string{"api-token": "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Update("lekva.me", strings.Split(rec, "\n")); err != nil {
+		t.Fatal(err)
+	}
+	if fake.zone != "lekva.me" || len(fake.records) != 5 {
+		t.Fatalf("Unexpected records for %s: %+v", fake.zone, fake.records)
+	}
+	if fake.records[0].Name != "t40" || fake.records[0].Type != "DS" {
+		t.Fatalf("Unexpected record: %+v", fake.records[0])
+	}
+}
+
+// fakeCloudflare implements subset of Cloudflare API updater relies on.
+type fakeCloudflare struct {
+	mu      sync.Mutex
+	nextID  int
+	records map[string]cloudflareRecord
+	calls   []string
+}
+
+func (f *fakeCloudflare) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	respond := func(result any) {
+		d, _ := json.Marshal(result)
+		json.NewEncoder(w).Encode(cloudflareResponse{Success: true, Result: d})
+	}
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(cloudflareResponse{Errors: []cloudflareError{{10000, "Authentication error"}}})
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/client/v4")
+	switch {
+	case r.Method == http.MethodGet && path == "/zones":
+		respond([]cloudflareZone{{"z1", r.URL.Query().Get("name")}})
+	case r.Method == http.MethodGet && path == "/zones/z1/dns_records":
+		ret := []cloudflareRecord{}
+		for _, rec := range f.records {
+			if q := r.URL.Query(); q.Has("name") && (rec.Name != q.Get("name") || rec.Type != q.Get("type")) {
+				continue
+			}
+			ret = append(ret, rec)
+		}
+		respond(ret)
+	case r.Method == http.MethodPost && path == "/zones/z1/dns_records":
+		var rec cloudflareRecord
+		if err := json.NewDecoder(r.Body).Decode(&rec); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// Like Cloudflare, accept names relative to the zone.
+		if rec.Name == "@" {
+			rec.Name = "lekva.me"
+		} else if !strings.HasSuffix(rec.Name, "lekva.me") {
+			rec.Name = rec.Name + ".lekva.me"
+		}
+		f.nextID++
+		rec.ID = fmt.Sprintf("r%d", f.nextID)
+		f.records[rec.ID] = rec
+		f.calls = append(f.calls, fmt.Sprintf("create %s %s", rec.Type, rec.Content))
+		respond(rec)
+	case r.Method == http.MethodDelete && strings.HasPrefix(path, "/zones/z1/dns_records/"):
+		id := strings.TrimPrefix(path, "/zones/z1/dns_records/")
+		f.calls = append(f.calls, fmt.Sprintf("delete %s %s", f.records[id].Type, f.records[id].Content))
+		delete(f.records, id)
+		respond(map[string]string{})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(cloudflareResponse{Errors: []cloudflareError{{7003, "Not found"}}})
+	}
+}
+
+// redirectTransport sends requests libdns Cloudflare provider makes to its
+// hardcoded API address to the fake server.
+type redirectTransport struct {
+	addr *url.URL
+}
+
+func (t redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = t.addr.Scheme
+	req.URL.Host = t.addr.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestCloudflareProvider(t *testing.T) {
+	fake := &fakeCloudflare{records: map[string]cloudflareRecord{
+		"old":   {ID: "old", Type: "NS", Name: "t40.lekva.me", Content: "ns.old.me", TTL: 3600},
+		"kept":  {ID: "kept", Type: "NS", Name: "t40.lekva.me", Content: "ns1.t40.lekva.me", TTL: 10800},
+		"other": {ID: "other", Type: "A", Name: "lekva.me", Content: "1.2.3.4", TTL: 3600},
+	}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	addr, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaultTransport := http.DefaultClient.Transport
+	http.DefaultClient.Transport = redirectTransport{addr}
+	defer func() {
+		http.DefaultClient.Transport = defaultTransport
+	}()
+	u := &libdnsUpdater{newCloudflareProvider(srv.URL, "token")}
+	if err := u.Update("lekva.me", strings.Split(rec, "\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.records["old"]; ok {
+		t.Fatal("Expected old NS record to be replaced")
+	}
+	if _, ok := fake.records["kept"]; !ok {
+		t.Fatal("Expected up to date NS record to be kept")
+	}
+	if _, ok := fake.records["other"]; !ok {
+		t.Fatal("Expected unrelated record to be kept")
+	}
+	if len(fake.records) != 6 {
+		t.Fatalf("Expected 6 records, got %+v", fake.records)
+	}
+	for _, r := range fake.records {
+		switch r.Type {
+		case "DS":
+			if r.Name != "t40.lekva.me" || r.Data == nil || r.Data.KeyTag != 43870 || r.Data.Algorithm != 13 {
+				t.Fatalf("Unexpected DS record: %+v", r)
+			}
+		case "NS":
+			if r.Name != "t40.lekva.me" || (r.Content != "ns1.t40.lekva.me" && r.Content != "ns2.t40.lekva.me") {
+				t.Fatalf("Unexpected NS record: %+v", r)
+			}
+		}
+	}
+	expected := []string{
+		"create DS ",
+		"create A 135.181.48.180",
+		"create A 65.108.39.172",
+		"create NS ns2.t40.lekva.me",
+		"delete NS ns.old.me",
+	}
+	if !reflect.DeepEqual(fake.calls, expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, fake.calls)
+	}
+	if err := (&libdnsUpdater{newCloudflareProvider(srv.URL, "invalid")}).Update("lekva.me", strings.Split(rec, "\n")); err == nil {
+		t.Fatal("Expected invalid token to be rejected")
+	}
+}
+
+func TestDeSECProvider(t *testing.T) {
+	var rrsets []deSECRRSet
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch || r.URL.Path != "/domains/lekva.me/rrsets/" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("Authorization") != "Token token" {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&rrsets); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(rrsets)
+	}))
+	defer srv.Close()
+	u := &libdnsUpdater{newDeSECProvider(srv.URL, "token")}
+	if err := u.Update("lekva.me.", strings.Split(rec, "\n")); err != nil {
+		t.Fatal(err)
+	}
+	expected := []deSECRRSet{
+		{"t40", "DS", 3600, []string{"43870 13 2 9ADA4E046EC0473383035B7BDB6443B8D869A9C8B35D000B8038ABF3F3864621"}},
+		{"ns1.t40", "A", 10800, []string{"135.181.48.180"}},
+		{"ns2.t40", "A", 10800, []string{"65.108.39.172"}},
+		{"t40", "NS", 10800, []string{"ns1.t40.lekva.me.", "ns2.t40.lekva.me."}},
+	}
+	if !reflect.DeepEqual(expected, rrsets) {
+		t.Fatalf("Expected %+v, got %+v", expected, rrsets)
+	}
+	if err := (&libdnsUpdater{newDeSECProvider(srv.URL, "invalid")}).Update("lekva.me", strings.Split(rec, "\n")); err == nil {
+		t.Fatal("Expected invalid token to be rejected")
+	}
+}
+
+// fakeNameserver accepts TSIG signed updates and keeps zone in memory.
+type fakeNameserver struct {
+	mu      sync.Mutex
+	records map[string]dns.RR
+}
+
+func (f *fakeNameserver) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resp := new(dns.Msg)
+	resp.SetReply(r)
+	if tsig := r.IsTsig(); tsig == nil || w.TsigStatus() != nil {
+		resp.Rcode = dns.RcodeNotAuth
+	} else {
+		resp.SetTsig(tsig.Hdr.Name, tsig.Algorithm, 300, time.Now().Unix())
+		for _, rr := range r.Ns {
+			hdr := rr.Header()
+			switch hdr.Class {
+			case dns.ClassANY:
+				for k, e := range f.records {
+					if e.Header().Name == hdr.Name && e.Header().Rrtype == hdr.Rrtype {
+						delete(f.records, k)
+					}
+				}
+			case dns.ClassINET:
+				f.records[rr.String()] = rr
+			}
+		}
+	}
+	w.WriteMsg(resp)
+}
+
+func TestRFC2136Provider(t *testing.T) {
+	const keyName = "update.lekva.me."
+	const secret = "c2VjcmV0LWtleS1mb3ItdGVzdHM="
+	old, err := dns.NewRR("t40.lekva.me. 3600 IN NS ns.old.me.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeNameserver{records: map[string]dns.RR{old.String(): old}}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	srv := &dns.Server{
+		Listener:          l,
+		Handler:           fake,
+		TsigSecret:        map[string]string{keyName: secret},
+		MsgAcceptFunc:     func(dh dns.Header) dns.MsgAcceptAction { return dns.MsgAccept },
+		NotifyStartedFunc: func() { close(started) },
+	}
+	go srv.ActivateAndServe()
+	defer srv.Shutdown()
+	<-started
+	u, err := NewDNSUpdater("rfc2136", map[string]string{
+		"server":        l.Addr().String(),
+		"tsig-key-name": "update.lekva.me",
+		"tsig-secret":   secret,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Update("lekva.me", strings.Split(rec, "\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.records[old.String()]; ok {
+		t.Fatal("Expected old NS record to be replaced")
+	}
+	if len(fake.records) != 5 {
+		t.Fatalf("Expected 5 records, got %+v", fake.records)
+	}
+	u, err = NewDNSUpdater("rfc2136", map[string]string{
+		"server":        l.Addr().String(),
+		"tsig-key-name": "update.lekva.me",
+		"tsig-secret":   "aW52YWxpZA==",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Update("lekva.me", strings.Split(rec, "\n")); err == nil {
+		t.Fatal("Expected invalid TSIG secret to be rejected")
+	}
+}
+
+type fakeZoneFetcher struct {
+	records string
+}
+
+func (f *fakeZoneFetcher) Fetch(addr string) (string, error) {
+	return f.records, nil
+}
+
+type fakeDNSUpdater struct {
+	updates [][]string
+}
+
+func (f *fakeDNSUpdater) Update(zone string, records []string) error {
+	f.updates = append(f.updates, records)
+	return nil
+}
+
+func TestDSSyncer(t *testing.T) {
+	fetcher := &fakeZoneFetcher{rec}
+	updater := &fakeDNSUpdater{}
+	s := &dsSyncer{fetcher, "http://dns-api/records-to-publish", updater, "lekva.me", dsRecords(rec)}
+	if err := s.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if len(updater.updates) != 0 {
+		t.Fatalf("Expected already published DS not to be republished, got %+v", updater.updates)
+	}
+	// KSK rollover introduces DS of the new key.
+	newDS := "t40.lekva.me.\t3600\tIN\tDS\t12345 13 2 0ADA4E046EC0473383035B7BDB6443B8D869A9C8B35D000B8038ABF3F3864621"
+	fetcher.records = rec + newDS + "\n"
+	if err := s.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if len(updater.updates) != 1 || len(updater.updates[0]) != 2 || updater.updates[0][0] != newDS {
+		t.Fatalf("Expected both DS records to be published, got %+v", updater.updates)
+	}
+	if err := s.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if len(updater.updates) != 1 {
+		t.Fatalf("Expected unchanged DS not to be republished, got %+v", updater.updates)
+	}
+}
@@ -862,7 +862,9 @@ func (s *DodoAppServer) handleCreateApp(w http.ResponseWriter, r *http.Request)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	if ok, err := s.client.UserExists(user); err != nil {
+	if ok, err := s.client.UserExists(user); errors.Is(err, soft.ErrorNotSupported) {
+		// Backend does not manage users, nothing to wait for.
+	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	} else if !ok {
@@ -982,7 +984,7 @@ func (s *DodoAppServer) handleAPICreateApp(w http.ResponseWriter, r *http.Reques
 		return
 	}
 	user, err := s.client.FindUser(req.AdminPublicKey)
-	if err != nil {
+	if err != nil && !errors.Is(err, soft.ErrorNotSupported) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -991,7 +993,7 @@ func (s *DodoAppServer) handleAPICreateApp(w http.ResponseWriter, r *http.Reques
 		return
 	}
 	user = appName
-	if err := s.client.AddUser(user, req.AdminPublicKey); err != nil {
+	if err := s.client.AddUser(user, req.AdminPublicKey); err != nil && !errors.Is(err, soft.ErrorNotSupported) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -1097,7 +1099,7 @@ func (s *DodoAppServer) deleteBranch(appName string, branch string) error {
 		if err != nil {
 			return err
 		}
-		m, err := installer.NewAppManager(repo, s.nsc, s.jc, hf, s.vpnKeyGen, s.cnc, "/.dodo")
+		m, err := installer.NewAppManager(repo, s.nsc, s.jc, hf, s.vpnKeyGen, s.cnc, nil, "/.dodo")
 		if err != nil {
 			return err
 		}
@@ -1109,17 +1111,18 @@ func (s *DodoAppServer) deleteBranch(appName string, branch string) error {
 	if err != nil {
 		return err
 	}
-	m, err := installer.NewAppManager(configRepo, s.nsc, s.jc, hf, s.vpnKeyGen, s.cnc, "/")
+	m, err := installer.NewAppManager(configRepo, s.nsc, s.jc, hf, s.vpnKeyGen, s.cnc, nil, "/")
 	if err != nil {
 		return err
 	}
 	appPath := fmt.Sprintf("%s/%s", appName, branch)
+	// NOTE(gio): AppManager.Remove locks the repository itself.
 	if _, err := configRepo.Do(func(fs soft.RepoFS) (string, error) {
 		if err := m.Remove(appPath); err != nil {
 			return "", err
 		}
 		return fmt.Sprintf("Uninstalled app branch: %s %s", appName, branch), nil
-	}); err != nil {
+	}, soft.WithNoLock()); err != nil {
 		return err
 	}
 	if err := s.client.DeleteRepoBranch(appName, appBranch); err != nil {
@@ -1209,7 +1212,7 @@ func (s *DodoAppServer) createAppForBranch(
 		return err
 	}
 	hf := installer.NewGitHelmFetcher()
-	m, err := installer.NewAppManager(configRepo, s.nsc, s.jc, hf, s.vpnKeyGen, s.cnc, "/")
+	m, err := installer.NewAppManager(configRepo, s.nsc, s.jc, hf, s.vpnKeyGen, s.cnc, nil, "/")
 	if err != nil {
 		return err
 	}
@@ -1236,6 +1239,7 @@ func (s *DodoAppServer) createAppForBranch(
 			},
 			installer.WithConfig(&s.env),
 			installer.WithNoNetworks(),
+			installer.WithNoPull(),
 			installer.WithNoPublish(),
 			installer.WithNoLock(),
 		); err != nil {
@@ -1262,29 +1266,12 @@ func (s *DodoAppServer) initAppACLs(m *installer.AppManager, path, appName, bran
 	if !ok {
 		return fmt.Errorf("Fluxcd keys not found")
 	}
-	if ok, err := s.client.UserExists("fluxcd"); err != nil {
+	if err := initRepoAccess(s.client, appName, branch, user, fluxPublicKey.(string), fmt.Sprintf("http://%s/update", s.self)); err != nil {
 		return err
-	} else if ok {
-		if err := s.client.AddPublicKey("fluxcd", fluxPublicKey.(string)); err != nil {
-			return err
-		}
-	} else {
-		if err := s.client.AddUser("fluxcd", fluxPublicKey.(string)); err != nil {
-			return err
-		}
 	}
 	if branch != "master" {
 		return nil
 	}
-	if err := s.client.AddReadOnlyCollaborator(appName, "fluxcd"); err != nil {
-		return err
-	}
-	if err := s.client.AddReadWriteCollaborator(appName, user); err != nil {
-		return err
-	}
-	if err := s.client.AddWebhook(appName, fmt.Sprintf("http://%s/update", s.self), "--active=true", "--events=push", "--content-type=json"); err != nil {
-		return err
-	}
 	if !s.external {
 		go func() {
 			users, err := s.client.GetAllUsers()
@@ -1305,6 +1292,39 @@ func (s *DodoAppServer) initAppACLs(m *installer.AppManager, path, appName, bran
 	return nil
 }
 
+// initRepoAccess lets fluxcd read and user modify the app repository, and
+// registers the webhook notifying about pushes. Backends which do not manage
+// users or webhooks leave access control to the host, so these steps are
+// skipped for them.
+func initRepoAccess(client soft.Client, appName, branch, user, fluxPublicKey, webhook string) error {
+	if ok, err := client.UserExists("fluxcd"); errors.Is(err, soft.ErrorNotSupported) {
+		return nil
+	} else if err != nil {
+		return err
+	} else if ok {
+		if err := client.AddPublicKey("fluxcd", fluxPublicKey); err != nil {
+			return err
+		}
+	} else {
+		if err := client.AddUser("fluxcd", fluxPublicKey); err != nil {
+			return err
+		}
+	}
+	if branch != "master" {
+		return nil
+	}
+	if err := client.AddReadOnlyCollaborator(appName, "fluxcd"); err != nil {
+		return err
+	}
+	if err := client.AddReadWriteCollaborator(appName, user); err != nil {
+		return err
+	}
+	if err := client.AddWebhook(appName, webhook, "--active=true", "--events=push", "--content-type=json"); err != nil && !errors.Is(err, soft.ErrorNotSupported) {
+		return err
+	}
+	return nil
+}
+
 type apiAddAdminKeyReq struct {
 	User      string `json:"user"`
 	PublicKey string `json:"publicKey"`
@@ -1357,7 +1377,7 @@ func (s *DodoAppServer) updateDodoApp(
 		return installer.ReleaseResources{}, err
 	}
 	hf := installer.NewGitHelmFetcher()
-	m, err := installer.NewAppManager(repo, s.nsc, s.jc, hf, s.vpnKeyGen, s.cnc, "/.dodo")
+	m, err := installer.NewAppManager(repo, s.nsc, s.jc, hf, s.vpnKeyGen, s.cnc, nil, "/.dodo")
 	if err != nil {
 		return installer.ReleaseResources{}, err
 	}
@@ -1423,6 +1443,8 @@ func (s *DodoAppServer) updateDodoApp(
 		}
 		return "install app", nil
 	},
+		// NOTE(gio): dodo_ branch is regenerated from scratch on top of the
+		// app branch every time, so it is expected to be rewritten.
 		soft.WithCommitToBranch(fmt.Sprintf("dodo_%s", branch)),
 		soft.WithForce(),
 	); err != nil {
@@ -1645,7 +1667,16 @@ func (s *DodoAppServer) syncUsers() {
 		validUsernames[u.Username] = u
 	}
 	allClientUsers, err := s.client.GetAllUsers()
-	if err != nil {
+	if errors.Is(err, soft.ErrorNotSupported) {
+		// Backend does not manage users, access is governed by the host.
+		for _, u := range users {
+			if err := s.st.CreateUser(u.Username, nil, ""); err != nil && !errors.Is(err, ErrorAlreadyExists) {
+				fmt.Println(err)
+				return
+			}
+		}
+		return
+	} else if err != nil {
 		fmt.Println(err)
 		return
 	}
@@ -2,6 +2,8 @@ package welcome
 
 import (
 	"testing"
+
+	"github.com/giolekva/pcloud/core/installer/soft"
 )
 
 func TestCreateDevBranch(t *testing.T) {
@@ -25,3 +27,16 @@ func TestCreateDevBranch(t *testing.T) {
 	t.Log(network)
 	t.Log(string(newCfg))
 }
+
+func TestInitRepoAccessWithoutAccessControl(t *testing.T) {
+	c, err := soft.NewFSClient(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddRepository("app"); err != nil {
+		t.Fatal(err)
+	}
+	if err := initRepoAccess(c, "app", "master", "foo", "fluxkey", "http://dodo-app/update"); err != nil {
+		t.Fatal(err)
+	}
+}
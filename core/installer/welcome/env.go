@@ -12,7 +12,9 @@ import (
 	"net"
 	"net/http"
 	"net/netip"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/gomarkdown/markdown"
 	"github.com/gorilla/mux"
@@ -24,6 +26,9 @@ import (
 	"github.com/giolekva/pcloud/core/installer/tasks"
 )
 
+// envDNSConfigsPath stores DNS providers environment zones are published to.
+const envDNSConfigsPath = "env-dns.yaml"
+
 //go:embed env-manager-tmpl/*
 var tmpls embed.FS
 
@@ -130,6 +135,9 @@ func NewEnvServer(
 }
 
 func (s *EnvServer) Start() {
+	if err := s.resumeDSSync(); err != nil {
+		log.Printf("Could not resume publishing DS records: %s\n", err)
+	}
 	r := mux.NewRouter()
 	r.PathPrefix("/stat/").Handler(cachingHandler{http.FileServer(http.FS(statAssets))})
 	r.Path("/env/{key}").Methods("GET").HandlerFunc(s.monitorTask)
@@ -165,9 +173,10 @@ func (s *EnvServer) monitorTask(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	data := map[string]any{
-		"Root":       t,
-		"EnvInfo":    s.envInfo[key],
-		"DNSRecords": dnsRecords,
+		"Root":         t,
+		"EnvInfo":      s.envInfo[key],
+		"DNSRecords":   dnsRecords,
+		"DNSProviders": DNSProviders(),
 	}
 	if err := tmplsParsed.status.Execute(w, data); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -193,22 +202,99 @@ func (s *EnvServer) publishDNSRecords(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	r.ParseForm()
-	if apiToken, err := getFormValue(r.PostForm, "api-token"); err != nil {
+	if provider, err := getFormValue(r.PostForm, "provider"); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	} else {
-		p := NewGandiUpdater(apiToken)
-		zone := strings.Join(strings.Split(dnsRef.Zone, ".")[1:], ".") // TODO(gio): this is not gonna work with no subdomain case
+		// Provider params are prefixed with the provider name, so that
+		// forms of different providers do not clash.
+		params := map[string]string{}
+		prefix := fmt.Sprintf("%s-", provider)
+		for k := range r.PostForm {
+			if name, ok := strings.CutPrefix(k, prefix); ok {
+				params[name] = strings.TrimSpace(r.PostForm.Get(k))
+			}
+		}
+		p, err := NewDNSUpdater(provider, params)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		zone := parentZone(dnsRef.Zone)
 		if err := p.Update(zone, strings.Split(records, "\n")); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		cfg := installer.EnvDNSConfig{
+			Zone:     dnsRef.Zone,
+			Address:  dnsRef.Address,
+			Provider: provider,
+			Params:   params,
+		}
+		if err := s.saveDNSConfig(cfg); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		ds := &dsSyncer{s.dnsFetcher, dnsRef.Address, p, zone, dsRecords(records)}
+		go ds.Run(time.Tick(dsSyncInterval))
 	}
 	s.envInfo[key] = "Successfully published DNS records, waiting to propagate."
 	s.dnsPublished[key] = struct{}{}
 	http.Redirect(w, r, fmt.Sprintf("/env/%s", key), http.StatusSeeOther)
 }
 
+// parentZone returns zone records of the environment zone are published to.
+func parentZone(zone string) string {
+	return strings.Join(strings.Split(zone, ".")[1:], ".") // TODO(gio): this is not gonna work with no subdomain case
+}
+
+func (s *EnvServer) readDNSConfigs() (installer.EnvDNSConfigs, error) {
+	var ret installer.EnvDNSConfigs
+	if err := soft.ReadYaml(s.repo, envDNSConfigsPath, &ret); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return installer.EnvDNSConfigs{}, nil
+		}
+		return nil, err
+	}
+	return ret, nil
+}
+
+// saveDNSConfig persists DNS provider environment zone is published to,
+// replacing the previous choice if any.
+func (s *EnvServer) saveDNSConfig(cfg installer.EnvDNSConfig) error {
+	cfgs, err := s.readDNSConfigs()
+	if err != nil {
+		return err
+	}
+	cfgs = slices.DeleteFunc(cfgs, func(c installer.EnvDNSConfig) bool {
+		return c.Zone == cfg.Zone
+	})
+	cfgs = append(cfgs, cfg)
+	if err := soft.WriteYaml(s.repo, envDNSConfigsPath, cfgs); err != nil {
+		return err
+	}
+	_, err = s.repo.CommitAndPush(fmt.Sprintf("Publish DNS records of %s through %s", cfg.Zone, cfg.Provider))
+	return err
+}
+
+// resumeDSSync restarts publishing DS records of the environments, which had
+// their records published before.
+func (s *EnvServer) resumeDSSync() error {
+	cfgs, err := s.readDNSConfigs()
+	if err != nil {
+		return err
+	}
+	for _, cfg := range cfgs {
+		p, err := NewDNSUpdater(cfg.Provider, cfg.Params)
+		if err != nil {
+			return err
+		}
+		ds := &dsSyncer{s.dnsFetcher, cfg.Address, p, parentZone(cfg.Zone), nil}
+		go ds.Run(time.Tick(dsSyncInterval))
+	}
+	return nil
+}
+
 func (s *EnvServer) createEnvForm(w http.ResponseWriter, r *http.Request) {
 	if err := tmplsParsed.form.Execute(w, nil); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -243,6 +329,7 @@ type createEnvReq struct {
 	ContactEmail            string `json:"contactEmail"`
 	Domain                  string `json:"domain"`
 	PrivateNetworkSubdomain string `json:"privateNetworkSubdomain"`
+	SecondaryNameservers    string `json:"secondaryNameservers"`
 	AdminPublicKey          string `json:"adminPublicKey"`
 	SecretToken             string `json:"secretToken"`
 }
@@ -303,6 +390,7 @@ func extractRequest(r *http.Request) (createEnvReq, error) {
 		if req.ContactEmail, err = getFormValue(r.PostForm, "contact-email"); err != nil {
 			return err
 		}
+		req.SecondaryNameservers = r.PostForm.Get("secondary-nameservers")
 		if req.AdminPublicKey, err = getFormValue(r.PostForm, "admin-public-key"); err != nil {
 			return err
 		}
@@ -394,17 +482,30 @@ func (s *EnvServer) createEnv(w http.ResponseWriter, r *http.Request) {
 	if req.PrivateNetworkSubdomain != "" {
 		privateDomain = fmt.Sprintf("%s.%s", req.PrivateNetworkSubdomain, req.Domain)
 	}
+	var secondaryNameservers []net.IP
+	for _, a := range strings.Split(req.SecondaryNameservers, ",") {
+		if a = strings.TrimSpace(a); a == "" {
+			continue
+		}
+		ip := net.ParseIP(a)
+		if ip == nil {
+			http.Error(w, fmt.Sprintf("invalid secondary nameserver address: %s", a), http.StatusBadRequest)
+			return
+		}
+		secondaryNameservers = append(secondaryNameservers, ip)
+	}
 	env := installer.EnvConfig{
-		Id:              req.Name,
-		InfraName:       infra.Name,
-		Domain:          req.Domain,
-		PrivateDomain:   privateDomain,
-		ContactEmail:    req.ContactEmail,
-		AdminPublicKey:  req.AdminPublicKey,
-		PublicIP:        infra.PublicIP,
-		NameserverIP:    infra.PublicIP,
-		NamespacePrefix: fmt.Sprintf("%s-", req.Name),
-		Network:         envNetwork,
+		Id:                    req.Name,
+		InfraName:             infra.Name,
+		Domain:                req.Domain,
+		PrivateDomain:         privateDomain,
+		ContactEmail:          req.ContactEmail,
+		AdminPublicKey:        req.AdminPublicKey,
+		PublicIP:              infra.PublicIP,
+		NameserverIP:          infra.PublicIP,
+		SecondaryNameserverIP: secondaryNameservers,
+		NamespacePrefix:       fmt.Sprintf("%s-", req.Name),
+		Network:               envNetwork,
 	}
 	key := func() string {
 		for {
@@ -9,6 +9,9 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
 	"strings"
 	"sync"
 	"testing"
@@ -20,6 +23,8 @@ import (
 	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/memfs"
 	"github.com/go-git/go-billy/v5/util"
+	"github.com/gorilla/mux"
+	"github.com/libdns/libdns"
 
 	"github.com/giolekva/pcloud/core/installer"
 	"github.com/giolekva/pcloud/core/installer/soft"
@@ -388,6 +393,57 @@ func TestCreateNewEnv(t *testing.T) {
 	}
 }
 
+func TestPublishDNSRecordsPersistsProvider(t *testing.T) {
+	fake := &fakeRecordSetter{}
+	dnsProviders = append(dnsProviders, DNSProvider{
+		Name:   "fake",
+		Title:  "Fake",
+		Params: []DNSProviderParam{{Name: "api-token", Title: "API Token"}},
+		newProvider: func(params map[string]string) (libdns.RecordSetter, error) {
+			return fake, nil
+		},
+	})
+	defer func() {
+		dnsProviders = dnsProviders[:len(dnsProviders)-1]
+	}()
+	repo := soft.NewMockRepoIO(soft.NewBillyRepoFS(memfs.New()), "foo.bar", t)
+	s := NewEnvServer(0, nil, repo, nil, nil, nil, nil, &fakeZoneFetcher{rec}, nil, nil, nil, tasks.NewTaskMap())
+	s.dns["key"] = installer.EnvDNS{
+		Zone:    "t40.lekva.me",
+		Address: "http://dns-api.t40-dns.svc.cluster.local/records-to-publish",
+	}
+	form := url.Values{}
+	form.Set("provider", "fake")
+	form.Set("fake-api-token", "secret")
+	req := httptest.NewRequest("POST", "/env/key", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp := httptest.NewRecorder()
+	s.publishDNSRecords(resp, mux.SetURLVars(req, map[string]string{"key": "key"}))
+	if resp.Code != http.StatusSeeOther {
+		t.Fatalf("Publishing failed: %d %s", resp.Code, resp.Body.String())
+	}
+	if fake.zone != "lekva.me" || len(fake.records) != 5 {
+		t.Fatalf("Unexpected records published to %s: %+v", fake.zone, fake.records)
+	}
+	cfgs, err := s.readDNSConfigs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := installer.EnvDNSConfigs{{
+		Zone:     "t40.lekva.me",
+		Address:  "http://dns-api.t40-dns.svc.cluster.local/records-to-publish",
+		Provider: "fake",
+		Params:   map[string]string{"api-token": "secret"},
+	}}
+	if !reflect.DeepEqual(cfgs, expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, cfgs)
+	}
+	// Restarted server finds the provider to publish DS records to.
+	if err := NewEnvServer(0, nil, repo, nil, nil, nil, nil, &fakeZoneFetcher{rec}, nil, nil, nil, tasks.NewTaskMap()).resumeDSSync(); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func debugFS(bfs billy.Filesystem, t *testing.T, files ...string) {
 	f := map[string]struct{}{}
 	for _, i := range files {
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -12,14 +13,18 @@ import (
 	"math/rand"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/giolekva/pcloud/core/installer/kube"
 	"github.com/giolekva/pcloud/core/installer/soft"
 
 	"golang.org/x/crypto/ssh"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 const (
@@ -34,12 +39,34 @@ var sshKey = flag.String("ssh-key", "", "Path to SHH key used to connect with Gi
 var ingressNginxPath = flag.String("ingress-nginx-path", "", "Path to the ingress-nginx Helm release")
 var minPreOpenPorts = flag.Int("min-pre-open-ports", 5, "Minimum number of pre-open ports to keep in reserve")
 var preOpenPortsBatchSize = flag.Int("pre-open-ports-batch-size", 10, "Number of new ports to open at a time")
+var reservationTTL = flag.Duration("reservation-ttl", 30*time.Minute, "How long reserved port is kept unless its lease is renewed")
+var gcInterval = flag.Duration("gc-interval", 10*time.Minute, "How often to remove forwarded ports whose target service does not exist anymore. Zero disables the collection.")
+var gcGracePeriod = flag.Duration("gc-grace-period", 30*time.Minute, "How long newly forwarded port is kept even if its target service does not exist yet")
+
+// How often expired reservations are released.
+const leaseCheckInterval = time.Minute
 
 type client interface {
-	ReservePort() (int, string, error)
+	ReservePort(owner string, ttl time.Duration) (int, string, time.Time, error)
+	RenewReservation(port int, secret string, ttl time.Duration) (time.Time, error)
+	ReleaseReservation(port int, secret string) error
 	ReleaseReservedPort(port ...int)
-	AddPortForwarding(protocol string, port int, secret, dest string) error
+	ReleaseExpiredReservations(now time.Time)
+	AddPortForwarding(protocol string, port int, secret, dest, owner string) error
 	RemovePortForwarding(protocol string, port int) error
+	RemoveOrphanedPortForwardings(exists ServiceExists, allocatedBefore time.Time) error
+	List() (portList, error)
+}
+
+// ServiceExists reports if service with given namespace and name exists.
+type ServiceExists func(namespace, name string) (bool, error)
+
+// lease keeps reserved port out of the pool until it either gets allocated,
+// released or expires.
+type lease struct {
+	Secret    string    `json:"secret"`
+	Owner     string    `json:"owner,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt"`
 }
 
 type repoClient struct {
@@ -51,7 +78,9 @@ type repoClient struct {
 	preOpenPortsBatchSize int
 	preOpenPorts          []int
 	blocklist             map[int]struct{}
-	reserve               map[int]string
+	leases                map[int]lease
+	owners                map[string]string
+	allocatedAt           map[string]time.Time
 	availablePorts        []int
 }
 
@@ -71,7 +100,9 @@ func newRepoClient(
 		preOpenPortsBatchSize: preOpenPortsBatchSize,
 		preOpenPorts:          []int{},
 		blocklist:             map[int]struct{}{},
-		reserve:               map[int]string{},
+		leases:                map[int]lease{},
+		owners:                map[string]string{},
+		allocatedAt:           map[string]time.Time{},
 		availablePorts:        []int{},
 	}
 	st, err := ret.readState(repo)
@@ -82,7 +113,20 @@ func newRepoClient(
 	} else {
 		ret.preOpenPorts = st.PreOpenPorts
 		ret.blocklist = st.Blocklist
-		ret.reserve = st.Reserve
+		if st.Leases != nil {
+			ret.leases = st.Leases
+		}
+		if st.Owners != nil {
+			ret.owners = st.Owners
+		}
+		if st.AllocatedAt != nil {
+			ret.allocatedAt = st.AllocatedAt
+		}
+		// NOTE(gio): Older versions kept reservations without expiration
+		// time, give them the default one.
+		for p, secret := range st.Reserve {
+			ret.leases[p] = lease{Secret: secret, ExpiresAt: time.Now().Add(*reservationTTL)}
+		}
 	}
 	for i := start; i < end; i++ {
 		if _, ok := ret.blocklist[i]; !ok {
@@ -92,48 +136,112 @@ func newRepoClient(
 	if err := ret.preOpenNewPorts(); err != nil {
 		return nil, err
 	}
-	var reservedPorts []int
-	for k := range ret.reserve {
-		reservedPorts = append(reservedPorts, k)
-	}
-	go func() {
-		time.Sleep(30 * time.Minute)
-		ret.ReleaseReservedPort(reservedPorts...)
-	}()
 	return ret, nil
 }
 
-func (c *repoClient) ReservePort() (int, string, error) {
+func (c *repoClient) ReservePort(owner string, ttl time.Duration) (int, string, time.Time, error) {
 	c.l.Lock()
 	defer c.l.Unlock()
 	if len(c.preOpenPorts) == 0 {
-		return -1, "", fmt.Errorf("no pre-open ports are available")
+		return -1, "", time.Time{}, fmt.Errorf("no pre-open ports are available")
 	}
-	port := c.preOpenPorts[0]
-	c.preOpenPorts = c.preOpenPorts[1:]
 	secret, err := c.secretGenerator()
 	if err != nil {
-		return -1, "", err
+		return -1, "", time.Time{}, err
+	}
+	port := c.preOpenPorts[0]
+	c.preOpenPorts = c.preOpenPorts[1:]
+	l := lease{secret, owner, time.Now().Add(ttl)}
+	c.leases[port] = l
+	if _, err := c.repo.Do(func(fs soft.RepoFS) (string, error) {
+		if err := c.writeState(fs); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Reserved port %d", port), nil
+	}); err != nil {
+		delete(c.leases, port)
+		c.preOpenPorts = append([]int{port}, c.preOpenPorts...)
+		return -1, "", time.Time{}, err
+	}
+	return port, secret, l.ExpiresAt, nil
+}
+
+func (c *repoClient) RenewReservation(port int, secret string, ttl time.Duration) (time.Time, error) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	l, ok := c.leases[port]
+	if !ok || l.Secret != secret {
+		return time.Time{}, fmt.Errorf("wrong secret")
+	}
+	prev := l
+	l.ExpiresAt = time.Now().Add(ttl)
+	c.leases[port] = l
+	if _, err := c.repo.Do(func(fs soft.RepoFS) (string, error) {
+		if err := c.writeState(fs); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Renewed port %d reservation", port), nil
+	}); err != nil {
+		c.leases[port] = prev
+		return time.Time{}, err
 	}
-	c.reserve[port] = secret
-	return port, secret, nil
+	return l.ExpiresAt, nil
 }
 
+func (c *repoClient) ReleaseReservation(port int, secret string) error {
+	if err := func() error {
+		c.l.Lock()
+		defer c.l.Unlock()
+		if l, ok := c.leases[port]; !ok || l.Secret != secret {
+			return fmt.Errorf("wrong secret")
+		}
+		return nil
+	}(); err != nil {
+		return err
+	}
+	c.ReleaseReservedPort(port)
+	return nil
+}
+
+func (c *repoClient) ReleaseExpiredReservations(now time.Time) {
+	var expired []int
+	func() {
+		c.l.Lock()
+		defer c.l.Unlock()
+		for p, l := range c.leases {
+			if !l.ExpiresAt.After(now) {
+				expired = append(expired, p)
+			}
+		}
+	}()
+	c.ReleaseReservedPort(expired...)
+}
+
+// ReleaseReservedPort returns reserved ports back to the pool of pre-open
+// ones. Ports which are not reserved anymore, for example because they got
+// allocated meanwhile, are ignored.
 func (c *repoClient) ReleaseReservedPort(port ...int) {
 	if len(port) == 0 {
 		return
 	}
 	c.l.Lock()
 	defer c.l.Unlock()
-	if _, err := c.repo.Do(func(fs soft.RepoFS) (string, error) {
-		for _, p := range port {
-			delete(c.reserve, p)
+	var released []int
+	for _, p := range port {
+		if _, ok := c.leases[p]; ok {
+			delete(c.leases, p)
 			c.preOpenPorts = append(c.preOpenPorts, p)
+			released = append(released, p)
 		}
+	}
+	if len(released) == 0 {
+		return
+	}
+	if _, err := c.repo.Do(func(fs soft.RepoFS) (string, error) {
 		if err := c.writeState(fs); err != nil {
 			return "", err
 		}
-		return fmt.Sprintf("Released port reservations: %+v", port), nil
+		return fmt.Sprintf("Released port reservations: %+v", released), nil
 	}); err != nil {
 		panic(err)
 	}
@@ -142,7 +250,19 @@ func (c *repoClient) ReleaseReservedPort(port ...int) {
 type state struct {
 	PreOpenPorts []int            `json:"preOpenPorts"`
 	Blocklist    map[int]struct{} `json:"blocklist"`
-	Reserve      map[int]string   `json:"reserve"`
+	// Deprecated: Replaced by Leases, only read to migrate old state.
+	Reserve map[int]string `json:"reserve,omitempty"`
+	Leases  map[int]lease  `json:"leases"`
+	// Owning app instances of forwarded ports, keyed by protocol/port.
+	Owners map[string]string `json:"owners"`
+	// When ports got forwarded, keyed by protocol/port. Protects freshly
+	// forwarded ports from being collected before their target service gets
+	// created.
+	AllocatedAt map[string]time.Time `json:"allocatedAt"`
+}
+
+func ownerKey(protocol string, port int) string {
+	return fmt.Sprintf("%s/%d", protocol, port)
 }
 
 func (c *repoClient) preOpenNewPorts() error {
@@ -185,26 +305,22 @@ func (c *repoClient) preOpenNewPorts() error {
 			if err != nil {
 				return "", err
 			}
-			fmt.Printf("%+v\n", tcp)
-			fmt.Printf("%+v\n", udp)
 			for _, p := range ports {
 				ps := strconv.Itoa(p)
 				tcp[ps] = p
 				udp[ps] = p
 			}
-			fmt.Printf("%+v\n", tcp)
-			fmt.Printf("%+v\n", udp)
 			if err := c.writeRelease(fs, rel); err != nil {
 				return "", err
 			}
 		}
-		fmt.Printf("Pre opened new ports: %+v\n", ports)
+		log.Printf("Pre opened new ports: %+v\n", ports)
 		return "preopen new ports", nil
 	})
 	return err
 }
 
-func (c *repoClient) AddPortForwarding(protocol string, port int, secret, dest string) error {
+func (c *repoClient) AddPortForwarding(protocol string, port int, secret, dest, owner string) error {
 	protocol = strings.ToLower(protocol)
 	defer func() {
 		if err := c.preOpenNewPorts(); err != nil {
@@ -213,10 +329,14 @@ func (c *repoClient) AddPortForwarding(protocol string, port int, secret, dest s
 	}()
 	c.l.Lock()
 	defer c.l.Unlock()
-	if sec, ok := c.reserve[port]; !ok || sec != secret {
+	if l, ok := c.leases[port]; !ok || l.Secret != secret {
 		return fmt.Errorf("wrong secret")
 	}
-	delete(c.reserve, port)
+	delete(c.leases, port)
+	if owner != "" {
+		c.owners[ownerKey(protocol, port)] = owner
+	}
+	c.allocatedAt[ownerKey(protocol, port)] = time.Now()
 	_, err := c.repo.Do(func(fs soft.RepoFS) (string, error) {
 		if err := c.writeState(fs); err != nil {
 			return "", err
@@ -259,52 +379,282 @@ func (c *repoClient) RemovePortForwarding(protocol string, port int) error {
 		if err != nil {
 			return "", err
 		}
-		switch protocol {
-		case "tcp":
-			tcp, err := extractPorts(rel, "spec.values.tcp")
-			if err != nil {
-				return "", err
-			}
-			if err := removePort(tcp, port); err != nil {
-				return "", err
-			}
-		case "udp":
-			udp, err := extractPorts(rel, "spec.values.udp")
+		if err := c.removePortForwarding(rel, protocol, port); err != nil {
+			return "", err
+		}
+		if err := c.writeRelease(fs, rel); err != nil {
+			return "", err
+		}
+		if err := c.writeState(fs); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("ingress: remove %s port map %d", protocol, port), nil
+	})
+	return err
+}
+
+// RemoveOrphanedPortForwardings removes forwarded ports whose target service
+// does not exist anymore, for example because app instance owning them was
+// removed without closing them. Only ports forwarded before allocatedBefore
+// are considered, as target services of the fresh ones might not have been
+// created yet. Ports without known allocation time, forwarded by older
+// versions, start their grace period now. Opened ports which are not used
+// anymore are returned back to the pool.
+func (c *repoClient) RemoveOrphanedPortForwardings(exists ServiceExists, allocatedBefore time.Time) error {
+	c.l.Lock()
+	defer c.l.Unlock()
+	_, err := c.repo.Do(func(fs soft.RepoFS) (string, error) {
+		rel, err := c.readRelease(fs)
+		if err != nil {
+			return "", err
+		}
+		var removed []string
+		tracked := false
+		for _, protocol := range []string{"tcp", "udp"} {
+			ports, err := extractPorts(rel, fmt.Sprintf("spec.values.%s", protocol))
 			if err != nil {
 				return "", err
 			}
-			if err := removePort(udp, port); err != nil {
-				return "", err
+			for p, dest := range ports {
+				port, err := strconv.Atoi(p)
+				if err != nil {
+					return "", err
+				}
+				if t, ok := c.allocatedAt[ownerKey(protocol, port)]; !ok {
+					c.allocatedAt[ownerKey(protocol, port)] = time.Now()
+					tracked = true
+					continue
+				} else if !t.Before(allocatedBefore) {
+					continue
+				}
+				namespace, name, err := parseTarget(fmt.Sprint(dest))
+				if err != nil {
+					return "", err
+				}
+				if ok, err := exists(namespace, name); err != nil {
+					return "", err
+				} else if ok {
+					continue
+				}
+				if err := c.removePortForwarding(rel, protocol, port); err != nil {
+					return "", err
+				}
+				removed = append(removed, fmt.Sprintf("%s/%d", protocol, port))
 			}
-		default:
-			panic("MUST NOT REACH")
 		}
-		svcType, err := extractString(rel, "spec.values.controller.service.type")
+		freed, stale, err := c.releaseUnusedPorts(rel)
 		if err != nil {
 			return "", err
 		}
-		if svcType == "NodePort" {
-			svcTCP, err := extractPorts(rel, "spec.values.controller.service.nodePorts.tcp")
-			if err != nil {
-				return "", err
+		if len(removed) == 0 && len(freed) == 0 {
+			if tracked || stale {
+				if err := c.writeState(fs); err != nil {
+					return "", err
+				}
 			}
-			svcUDP, err := extractPorts(rel, "spec.values.controller.service.nodePorts.udp")
+			// NOTE(gio): Unless allocation times got tracked or stale records
+			// dropped, nothing changed so nothing gets committed.
+			return "ingress: no orphaned port maps", nil
+		}
+		if err := c.writeRelease(fs, rel); err != nil {
+			return "", err
+		}
+		if err := c.writeState(fs); err != nil {
+			return "", err
+		}
+		log.Printf("Removed orphaned port forwardings: %+v, released unused ports: %+v\n", removed, freed)
+		return fmt.Sprintf("ingress: remove orphaned port maps %s, release unused ports %v", strings.Join(removed, " "), freed), nil
+	})
+	return err
+}
+
+// releaseUnusedPorts returns ports which are opened but neither forwarded,
+// pre-opened nor reserved anymore, for example because their forwarding got
+// removed, back to the pool and closes their node ports. Drops owner and
+// allocation time records of ports which are not forwarded anymore as well,
+// reporting if there were any.
+// Expects client state to be locked by caller.
+func (c *repoClient) releaseUnusedPorts(rel map[string]any) ([]int, bool, error) {
+	used := map[int]struct{}{}
+	forwarded := map[string]struct{}{}
+	for _, protocol := range []string{"tcp", "udp"} {
+		ports, err := extractPorts(rel, fmt.Sprintf("spec.values.%s", protocol))
+		if err != nil {
+			return nil, false, err
+		}
+		for p := range ports {
+			port, err := strconv.Atoi(p)
 			if err != nil {
-				return "", err
+				return nil, false, err
 			}
-			if err := removePort(svcTCP, port); err != nil {
-				return "", err
+			used[port] = struct{}{}
+			forwarded[ownerKey(protocol, port)] = struct{}{}
+		}
+	}
+	for _, p := range c.preOpenPorts {
+		used[p] = struct{}{}
+	}
+	for p := range c.leases {
+		used[p] = struct{}{}
+	}
+	stale := false
+	for k := range c.owners {
+		if _, ok := forwarded[k]; !ok {
+			delete(c.owners, k)
+			stale = true
+		}
+	}
+	for k := range c.allocatedAt {
+		if _, ok := forwarded[k]; !ok {
+			delete(c.allocatedAt, k)
+			stale = true
+		}
+	}
+	var freed []int
+	for p := range c.blocklist {
+		if _, ok := used[p]; !ok {
+			freed = append(freed, p)
+		}
+	}
+	if len(freed) == 0 {
+		return nil, stale, nil
+	}
+	sort.Ints(freed)
+	svcType, err := extractString(rel, "spec.values.controller.service.type")
+	if err != nil {
+		return nil, false, err
+	}
+	for _, p := range freed {
+		if svcType == "NodePort" {
+			for _, protocol := range []string{"tcp", "udp"} {
+				nodePorts, err := extractPorts(rel, fmt.Sprintf("spec.values.controller.service.nodePorts.%s", protocol))
+				if err != nil {
+					return nil, false, err
+				}
+				delete(nodePorts, strconv.Itoa(p))
 			}
-			if err := removePort(svcUDP, port); err != nil {
-				return "", err
+		}
+		delete(c.blocklist, p)
+		c.availablePorts = append(c.availablePorts, p)
+	}
+	return freed, stale, nil
+}
+
+// Expects client state to be locked by caller.
+func (c *repoClient) removePortForwarding(rel map[string]any, protocol string, port int) error {
+	switch protocol {
+	case "tcp":
+		tcp, err := extractPorts(rel, "spec.values.tcp")
+		if err != nil {
+			return err
+		}
+		if err := removePort(tcp, port); err != nil {
+			return err
+		}
+	case "udp":
+		udp, err := extractPorts(rel, "spec.values.udp")
+		if err != nil {
+			return err
+		}
+		if err := removePort(udp, port); err != nil {
+			return err
+		}
+	default:
+		panic("MUST NOT REACH")
+	}
+	delete(c.owners, ownerKey(protocol, port))
+	delete(c.allocatedAt, ownerKey(protocol, port))
+	svcType, err := extractString(rel, "spec.values.controller.service.type")
+	if err != nil {
+		return err
+	}
+	if svcType == "NodePort" {
+		svcTCP, err := extractPorts(rel, "spec.values.controller.service.nodePorts.tcp")
+		if err != nil {
+			return err
+		}
+		svcUDP, err := extractPorts(rel, "spec.values.controller.service.nodePorts.udp")
+		if err != nil {
+			return err
+		}
+		if err := removePort(svcTCP, port); err != nil {
+			return err
+		}
+		if err := removePort(svcUDP, port); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type allocatedPort struct {
+	Protocol string `json:"protocol"`
+	Port     int    `json:"port"`
+	Target   string `json:"target"`
+	Owner    string `json:"owner,omitempty"`
+}
+
+type reservedPort struct {
+	Port      int       `json:"port"`
+	Owner     string    `json:"owner,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type portList struct {
+	Allocated []allocatedPort `json:"allocated"`
+	Reserved  []reservedPort  `json:"reserved"`
+}
+
+func (c *repoClient) List() (portList, error) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	rel, err := c.readRelease(c.repo)
+	if err != nil {
+		return portList{}, err
+	}
+	ret := portList{[]allocatedPort{}, []reservedPort{}}
+	for _, protocol := range []string{"tcp", "udp"} {
+		ports, err := extractPorts(rel, fmt.Sprintf("spec.values.%s", protocol))
+		if err != nil {
+			return portList{}, err
+		}
+		for p, dest := range ports {
+			port, err := strconv.Atoi(p)
+			if err != nil {
+				return portList{}, err
 			}
+			ret.Allocated = append(ret.Allocated, allocatedPort{
+				Protocol: protocol,
+				Port:     port,
+				Target:   fmt.Sprint(dest),
+				Owner:    c.owners[ownerKey(protocol, port)],
+			})
 		}
-		if err := c.writeRelease(fs, rel); err != nil {
-			return "", err
+	}
+	for p, l := range c.leases {
+		ret.Reserved = append(ret.Reserved, reservedPort{p, l.Owner, l.ExpiresAt})
+	}
+	sort.Slice(ret.Allocated, func(i, j int) bool {
+		if ret.Allocated[i].Port != ret.Allocated[j].Port {
+			return ret.Allocated[i].Port < ret.Allocated[j].Port
 		}
-		return fmt.Sprintf("ingress: remove %s port map %d", protocol, port), nil
+		return ret.Allocated[i].Protocol < ret.Allocated[j].Protocol
 	})
-	return err
+	sort.Slice(ret.Reserved, func(i, j int) bool {
+		return ret.Reserved[i].Port < ret.Reserved[j].Port
+	})
+	return ret, nil
+}
+
+// parseTarget extracts service namespace and name from ingress-nginx port
+// mapping in namespace/name:port[:PROXY][:PROXY] format.
+func parseTarget(dest string) (string, string, error) {
+	svc, _, _ := strings.Cut(dest, ":")
+	namespace, name, ok := strings.Cut(svc, "/")
+	if !ok || namespace == "" || name == "" {
+		return "", "", fmt.Errorf("invalid port mapping target: %s", dest)
+	}
+	return namespace, name, nil
 }
 
 func (c *repoClient) readState(fs soft.RepoFS) (state, error) {
@@ -326,7 +676,7 @@ func (c *repoClient) writeState(fs soft.RepoFS) error {
 		return err
 	}
 	defer w.Close()
-	if err := json.NewEncoder(w).Encode(state{c.preOpenPorts, c.blocklist, c.reserve}); err != nil {
+	if err := json.NewEncoder(w).Encode(state{c.preOpenPorts, c.blocklist, nil, c.leases, c.owners, c.allocatedAt}); err != nil {
 		return err
 	}
 	return err
@@ -345,24 +695,28 @@ func (c *repoClient) writeRelease(fs soft.RepoFS, rel map[string]any) error {
 }
 
 type server struct {
-	s      *http.Server
-	r      *http.ServeMux
-	client client
+	s              *http.Server
+	r              *http.ServeMux
+	client         client
+	reservationTTL time.Duration
 }
 
-func newServer(port int, client client) *server {
+func newServer(port int, client client, reservationTTL time.Duration) *server {
 	r := http.NewServeMux()
 	s := &http.Server{
 		Addr:    fmt.Sprintf(":%d", port),
 		Handler: r,
 	}
-	return &server{s, r, client}
+	return &server{s, r, client, reservationTTL}
 }
 
 func (s *server) Start() error {
 	s.r.HandleFunc("/api/reserve", s.handleReserve)
 	s.r.HandleFunc("/api/allocate", s.handleAllocate)
 	s.r.HandleFunc("/api/remove", s.handleRemove)
+	s.r.HandleFunc("/api/renew", s.handleRenew)
+	s.r.HandleFunc("/api/release", s.handleRelease)
+	s.r.HandleFunc("/api/list", s.handleList)
 	if err := s.s.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		return err
 	}
@@ -379,6 +733,7 @@ type allocateReq struct {
 	TargetService string `json:"targetService"`
 	TargetPort    int    `json:"targetPort"`
 	Secret        string `json:"secret"`
+	Owner         string `json:"owner,omitempty"`
 }
 
 type removeReq struct {
@@ -412,11 +767,46 @@ func extractRemoveReq(r io.Reader) (removeReq, error) {
 	return req, nil
 }
 
+type reserveReq struct {
+	Owner string `json:"owner,omitempty"`
+	TTL   string `json:"ttl,omitempty"`
+}
+
 type reserveResp struct {
+	Port      int       `json:"port"`
+	Secret    string    `json:"secret"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type renewReq struct {
+	Port   int    `json:"port"`
+	Secret string `json:"secret"`
+	TTL    string `json:"ttl,omitempty"`
+}
+
+type renewResp struct {
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type releaseReq struct {
 	Port   int    `json:"port"`
 	Secret string `json:"secret"`
 }
 
+func (s *server) parseTTL(ttl string) (time.Duration, error) {
+	if ttl == "" {
+		return s.reservationTTL, nil
+	}
+	ret, err := time.ParseDuration(ttl)
+	if err != nil {
+		return 0, err
+	}
+	if ret <= 0 {
+		return 0, fmt.Errorf("ttl must be positive: %s", ttl)
+	}
+	return ret, nil
+}
+
 func extractField(data map[string]any, path string) (any, error) {
 	var val any = data
 	for _, i := range strings.Split(path, ".") {
@@ -490,6 +880,7 @@ func (s *server) handleAllocate(w http.ResponseWriter, r *http.Request) {
 		req.SourcePort,
 		req.Secret,
 		fmt.Sprintf("%s:%d", req.TargetService, req.TargetPort),
+		req.Owner,
 	); err != nil {
 		fmt.Println(err.Error())
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -501,19 +892,24 @@ func (s *server) handleReserve(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "only post method is supported", http.StatusBadRequest)
 		return
 	}
-	var port int
-	var secret string
-	var err error
-	if port, secret, err = s.client.ReservePort(); err != nil {
+	// NOTE(gio): Body is optional, older clients send none.
+	var req reserveReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ttl, err := s.parseTTL(req.TTL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	port, secret, expiresAt, err := s.client.ReservePort(req.Owner, ttl)
+	if err != nil {
 		fmt.Println(err.Error())
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	go func() {
-		time.Sleep(30 * time.Minute)
-		s.client.ReleaseReservedPort(port)
-	}()
-	if err := json.NewEncoder(w).Encode(reserveResp{port, secret}); err != nil {
+	if err := json.NewEncoder(w).Encode(reserveResp{port, secret, expiresAt}); err != nil {
 		fmt.Println(err.Error())
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -538,6 +934,86 @@ func (s *server) handleRemove(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (s *server) handleRenew(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only post method is supported", http.StatusBadRequest)
+		return
+	}
+	var req renewReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ttl, err := s.parseTTL(req.TTL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	expiresAt, err := s.client.RenewReservation(req.Port, req.Secret, ttl)
+	if err != nil {
+		fmt.Println(err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(renewResp{expiresAt}); err != nil {
+		fmt.Println(err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *server) handleRelease(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only post method is supported", http.StatusBadRequest)
+		return
+	}
+	var req releaseReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.client.ReleaseReservation(req.Port, req.Secret); err != nil {
+		fmt.Println(err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+func (s *server) handleList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "only get method is supported", http.StatusBadRequest)
+		return
+	}
+	ports, err := s.client.List()
+	if err != nil {
+		fmt.Println(err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(ports); err != nil {
+		fmt.Println(err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func newServiceExists() (ServiceExists, error) {
+	client, err := kube.NewKubeClient(kube.KubeConfigOpts{})
+	if err != nil {
+		return nil, err
+	}
+	return func(namespace, name string) (bool, error) {
+		_, err := client.CoreV1().Services(namespace).Get(context.Background(), name, metav1.GetOptions{})
+		if err == nil {
+			return true, nil
+		} else if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}, nil
+}
+
 // TODO(gio): deduplicate
 func createRepoClient(addr string, keyPath string) (soft.RepoIO, error) {
 	sshKey, err := os.ReadFile(keyPath)
@@ -586,6 +1062,26 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	s := newServer(*port, c)
+	go func() {
+		for {
+			time.Sleep(leaseCheckInterval)
+			c.ReleaseExpiredReservations(time.Now())
+		}
+	}()
+	if *gcInterval > 0 {
+		exists, err := newServiceExists()
+		if err != nil {
+			log.Fatal(err)
+		}
+		go func() {
+			for {
+				time.Sleep(*gcInterval)
+				if err := c.RemoveOrphanedPortForwardings(exists, time.Now().Add(-*gcGracePeriod)); err != nil {
+					log.Printf("Failed to remove orphaned port forwardings: %s\n", err)
+				}
+			}
+		}()
+	}
+	s := newServer(*port, c, *reservationTTL)
 	log.Fatal(s.Start())
 }
@@ -3,8 +3,10 @@ package main
 import (
 	"fmt"
 	"reflect"
+	"slices"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/giolekva/pcloud/core/installer/soft"
 
@@ -57,12 +59,12 @@ func TestAllocateSucceeds(t *testing.T) {
 	}
 	for i := 0; i < 500; i++ {
 		for _, protocol := range []string{"tcp", "udp"} {
-			port, secret, err := c.ReservePort()
+			port, secret, _, err := c.ReservePort("", time.Minute)
 			if err != nil {
 				t.Fatal(err)
 			}
 			target := fmt.Sprintf("%s/bar:%d", protocol, port)
-			if err := c.AddPortForwarding("tcp", port, secret, target); err != nil {
+			if err := c.AddPortForwarding("tcp", port, secret, target, ""); err != nil {
 				t.Fatal(err)
 			}
 			tcp[strconv.Itoa(port)] = target
@@ -77,6 +79,184 @@ func TestAllocateSucceeds(t *testing.T) {
 	}
 }
 
+func newTestRepoClient(t *testing.T) (client, soft.RepoIO) {
+	ingressPath := "/ingress.yaml"
+	repo := soft.NewMockRepoIO(soft.NewBillyRepoFS(memfs.New()), "foo.bar", t)
+	if err := soft.WriteYaml(repo, ingressPath, map[string]any{
+		"spec": map[string]any{
+			"values": map[string]any{
+				"controller": map[string]any{
+					"service": map[string]any{
+						"type": "ClusterIP",
+					},
+				},
+				"tcp": map[string]any{},
+				"udp": map[string]any{},
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := newRepoClient(repo, ingressPath, 5, 10, fakeSecretGenerator("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, repo
+}
+
+func TestReservationLease(t *testing.T) {
+	c, _ := newTestRepoClient(t)
+	expired, _, _, err := c.ReservePort("foo", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	renewed, secret, _, err := c.ReservePort("bar", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.RenewReservation(renewed, "wrong", time.Hour); err == nil {
+		t.Fatal("Expected renewal with wrong secret to fail")
+	}
+	if _, err := c.RenewReservation(renewed, secret, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	c.ReleaseExpiredReservations(time.Now().Add(30 * time.Minute))
+	ports, err := c.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ports.Reserved) != 1 || ports.Reserved[0].Port != renewed || ports.Reserved[0].Owner != "bar" {
+		t.Fatalf("Expected only %d to stay reserved, got %+v", renewed, ports.Reserved)
+	}
+	if err := c.AddPortForwarding("tcp", expired, "test", "foo/bar:22", "foo"); err == nil {
+		t.Fatal("Expected expired reservation to be rejected")
+	}
+	if err := c.ReleaseReservation(renewed, secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ReleaseReservation(renewed, secret); err == nil {
+		t.Fatal("Expected released reservation to be gone")
+	}
+}
+
+func TestRenewedReservationSurvivesReload(t *testing.T) {
+	c, repo := newTestRepoClient(t)
+	port, secret, _, err := c.ReservePort("foo", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiresAt, err := c.RenewReservation(port, secret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := newRepoClient(repo, "/ingress.yaml", 5, 10, fakeSecretGenerator("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ports, err := reloaded.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ports.Reserved) != 1 || ports.Reserved[0].Port != port || ports.Reserved[0].Owner != "foo" || !ports.Reserved[0].ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("Expected renewed reservation of %d to be reloaded, got %+v", port, ports.Reserved)
+	}
+	reloaded.ReleaseExpiredReservations(time.Now().Add(30 * time.Minute))
+	if err := reloaded.AddPortForwarding("tcp", port, secret, "foo/ssh:22", "foo"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRemoveOrphanedReleasesUnusedPorts(t *testing.T) {
+	c, _ := newTestRepoClient(t)
+	port, secret, _, err := c.ReservePort("foo", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddPortForwarding("tcp", port, secret, "foo/ssh:22", "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RemovePortForwarding("tcp", port); err != nil {
+		t.Fatal(err)
+	}
+	rc := c.(*repoClient)
+	if _, ok := rc.blocklist[port]; !ok {
+		t.Fatalf("Expected %d to stay opened until collected", port)
+	}
+	exists := func(namespace, name string) (bool, error) {
+		return true, nil
+	}
+	if err := c.RemoveOrphanedPortForwardings(exists, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rc.blocklist[port]; ok {
+		t.Fatalf("Expected %d to be released", port)
+	}
+	if !slices.Contains(rc.availablePorts, port) {
+		t.Fatalf("Expected %d to be available again", port)
+	}
+	for _, p := range rc.preOpenPorts {
+		if _, ok := rc.blocklist[p]; !ok {
+			t.Fatalf("Expected pre-opened %d to stay opened", p)
+		}
+	}
+}
+
+func TestListAndRemoveOrphaned(t *testing.T) {
+	c, _ := newTestRepoClient(t)
+	for _, i := range []struct {
+		protocol string
+		target   string
+		owner    string
+	}{
+		{"tcp", "foo/ssh:22", "foo"},
+		{"udp", "bar/game:27015", "bar"},
+	} {
+		port, secret, _, err := c.ReservePort(i.owner, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.AddPortForwarding(i.protocol, port, secret, i.target, i.owner); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ports, err := c.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ports.Allocated) != 2 || len(ports.Reserved) != 0 {
+		t.Fatalf("Unexpected ports: %+v", ports)
+	}
+	for _, p := range ports.Allocated {
+		if p.Owner != map[string]string{"tcp": "foo", "udp": "bar"}[p.Protocol] {
+			t.Fatalf("Unexpected owner: %+v", p)
+		}
+	}
+	exists := func(namespace, name string) (bool, error) {
+		return namespace == "foo" && name == "ssh", nil
+	}
+	// Target services of freshly forwarded ports might not exist yet.
+	if err := c.RemoveOrphanedPortForwardings(exists, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	ports, err = c.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ports.Allocated) != 2 {
+		t.Fatalf("Expected ports within grace period to stay, got %+v", ports.Allocated)
+	}
+	if err := c.RemoveOrphanedPortForwardings(exists, time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	ports, err = c.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ports.Allocated) != 1 || ports.Allocated[0].Target != "foo/ssh:22" {
+		t.Fatalf("Expected only foo/ssh:22 to stay, got %+v", ports.Allocated)
+	}
+}
+
 func TestGenerateSecret(t *testing.T) {
 	secret, err := generateSecret()
 	if err != nil {